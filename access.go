@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// caller is a client of serve, known by its token.
+type caller struct {
+	Name  string
+	Token string
+	// Allow are the binaries it may grab: path.Match patterns, or
+	// directories ending in "/" to allow everything beneath them.
+	Allow []string
+}
+
+// loadCallers reads the callers of serve from filename, one per line as
+// "name token pattern...". Blank lines and lines starting with # are
+// skipped.
+func loadCallers(filename string) ([]*caller, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var callers []*caller
+	tokens := map[string]bool{}
+	scanner := bufio.NewScanner(fd)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("%s:%d: want a name, a token and the paths it may grab", filename, n)
+		}
+		for _, pattern := range fields[2:] {
+			if _, err := path.Match(pattern, ""); err != nil || !path.IsAbs(pattern) {
+				return nil, fmt.Errorf("%s:%d: %q is not an absolute path pattern", filename, n, pattern)
+			}
+		}
+		if tokens[fields[1]] {
+			return nil, fmt.Errorf("%s:%d: %s shares its token with another caller", filename, n, fields[0])
+		}
+		tokens[fields[1]] = true
+		callers = append(callers, &caller{Name: fields[0], Token: fields[1], Allow: fields[2:]})
+	}
+	return callers, scanner.Err()
+}
+
+// allows reports whether c may grab the binary at p.
+func (c *caller) allows(p string) bool {
+	p = path.Clean(p)
+	for _, pattern := range c.Allow {
+		if strings.HasSuffix(pattern, "/") {
+			if strings.HasPrefix(p, pattern) {
+				return true
+			}
+		} else if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate returns the caller among callers whose token req bears, as
+// "Authorization: Bearer <token>", or false if none does.
+func authenticate(callers []*caller, req *http.Request) (*caller, bool) {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return nil, false
+	}
+	for _, c := range callers {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(c.Token)) == 1 {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// isLoopback reports whether the listen address addr only accepts
+// connections from this host.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// auditLog appends a JSON line to a file for each request to serve, which
+// is opened for appending only. It is safe for concurrent use.
+type auditLog struct {
+	mu sync.Mutex
+	fd *os.File
+}
+
+// auditRecord is a line of the audit log.
+type auditRecord struct {
+	Time     time.Time `json:"time"`
+	Caller   string    `json:"caller,omitempty"`
+	Remote   string    `json:"remote"`
+	Binaries []string  `json:"binaries"`
+	Status   int       `json:"status"`
+	Bytes    int64     `json:"bytes"`
+	Error    string    `json:"error,omitempty"`
+}
+
+func openAuditLog(filename string) (*auditLog, error) {
+	fd, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{fd: fd}, nil
+}
+
+// record appends rec to the log. A nil log records nothing.
+func (l *auditLog) record(rec auditRecord) error {
+	if l == nil {
+		return nil
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.fd.Write(append(data, '\n'))
+	return err
+}
@@ -5,11 +5,12 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
-	"strconv"
+	"runtime"
+	"sort"
 	"strings"
 	"unicode"
 )
@@ -18,8 +19,14 @@ const cacheMagic = "ld.so-1.7.0\x00"
 
 // Load returns a *DLCache loaded from /etc/ld.so.cache.
 func Load() (*DLCache, error) {
+	return LoadRoot("/")
+}
+
+// LoadRoot returns a *DLCache loaded from <root>/etc/ld.so.cache, for looking
+// up libraries in the filesystem tree at root.
+func LoadRoot(root string) (*DLCache, error) {
 
-	fd, err := os.Open("/etc/ld.so.cache")
+	fd, err := os.Open(filepath.Join(root, "/etc/ld.so.cache"))
 	if err != nil {
 		return nil, err
 	}
@@ -29,26 +36,113 @@ func Load() (*DLCache, error) {
 	if err != nil {
 		return nil, err
 	}
+	dc.Root = root
+
+	return dc, nil
+}
+
+// LoadFS returns a *DLCache loaded from etc/ld.so.cache in fsys, a root
+// filesystem, for looking up libraries in it.
+func LoadFS(fsys fs.FS) (*DLCache, error) {
+	fd, err := fsys.Open("etc/ld.so.cache")
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	dc, err := ReadDLCache(fd)
+	if err != nil {
+		return nil, err
+	}
+	dc.FS = fsys
 
 	return dc, nil
 }
 
 // DLCache represents the contents of ld.so.cache.
 type DLCache struct {
-	FileEntries []fileEntry
+	FileEntries []Entry
+	// Generator names the ldconfig which wrote a new format cache, if
+	// recorded.
+	Generator string
+	// Root is the filesystem tree the cache describes. Paths returned by
+	// Lookup are relative to it. Empty means /.
+	Root string
+	// FS, if set, is the root filesystem the cache describes, in place of
+	// Root.
+	FS fs.FS
+}
+
+// Entries returns a copy of the entries of the cache, in its order: by
+// descending soname, as ld.so bisects them.
+func (dc *DLCache) Entries() []Entry {
+	return append([]Entry{}, dc.FileEntries...)
 }
 
-// Lookup bisects the DLCache searching for library.
+// FindAll returns the entries whose soname starts with prefix, in cache
+// order. An empty prefix matches every entry.
+func (dc *DLCache) FindAll(prefix string) []Entry {
+	var found []Entry
+	for _, entry := range dc.FileEntries {
+		if strings.HasPrefix(entry.Key, prefix) {
+			found = append(found, entry)
+		}
+	}
+	return found
+}
+
+// Lookup searches $LD_LIBRARY_PATH and then the DLCache for library, built
+// for the running architecture.
 func (dc *DLCache) Lookup(library string) (string, bool) {
+	return dc.LookupArch(library, "")
+}
+
+// LookupArch searches $LD_LIBRARY_PATH and then the DLCache for library,
+// built for arch (see LookupCacheArch).
+func (dc *DLCache) LookupArch(library, arch string) (string, bool) {
+	if path, ok := dc.LookupLibraryPath(library); ok {
+		return path, true
+	}
+	return dc.LookupCacheArch(library, arch)
+}
+
+// LookupLibraryPath searches the directories in $LD_LIBRARY_PATH for library.
+func (dc *DLCache) LookupLibraryPath(library string) (string, bool) {
 	if ldPath := os.Getenv("LD_LIBRARY_PATH"); ldPath != "" {
 		paths := strings.Split(ldPath, ":")
 		for _, path := range paths {
 			maybePath := filepath.Join(path, library)
-			if _, err := os.Stat(maybePath); err == nil {
+			if dc.exists(maybePath) {
 				return maybePath, true
 			}
 		}
 	}
+	return "", false
+}
+
+// exists reports whether the path p, within the tree the cache describes,
+// exists.
+func (dc *DLCache) exists(p string) bool {
+	if dc.FS != nil {
+		_, err := fs.Stat(dc.FS, strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+p)), "/"))
+		return err == nil
+	}
+	_, err := os.Stat(filepath.Join(dc.Root, p))
+	return err == nil
+}
+
+// LookupCache bisects the DLCache searching for library, built for the
+// running architecture.
+func (dc *DLCache) LookupCache(library string) (string, bool) {
+	return dc.LookupCacheArch(library, "")
+}
+
+// LookupCacheArch bisects the DLCache searching for library, built for arch:
+// a GOARCH-style name from Arches, or empty for the running architecture.
+func (dc *DLCache) LookupCacheArch(library, arch string) (string, bool) {
+	if arch == "" {
+		arch = runtime.GOARCH
+	}
 
 	lo, hi := 0, len(dc.FileEntries)
 	for lo < hi {
@@ -61,8 +155,8 @@ func (dc *DLCache) Lookup(library string) (string, bool) {
 		switch x {
 		case 0:
 			// case key == library:
-			if dc.FileEntries[mid].Is64() {
-				return dc.FileEntries[mid].Value, true
+			if path, ok := dc.bestEntry(mid, arch); ok {
+				return path, true
 			}
 			// Ignore wrong platform.
 			hi = mid
@@ -75,13 +169,10 @@ func (dc *DLCache) Lookup(library string) (string, bool) {
 		}
 	}
 
-	for i, entry := range dc.FileEntries {
-		if entry.Key == library {
-			log.Printf("Found the slow way at %d: %q", i, entry.Key)
-
-			for j := i - 10; j < i+10; j++ {
-				log.Printf("  %d, %q", j, dc.FileEntries[j].Key)
-			}
+	// The cache may not be sorted as ld.so expects, if it was not written
+	// by ldconfig.
+	for _, entry := range dc.FileEntries {
+		if entry.Key == library && entry.ForArch(arch) {
 			return entry.Value, true
 		}
 	}
@@ -89,104 +180,466 @@ func (dc *DLCache) Lookup(library string) (string, bool) {
 	return "", false
 }
 
-type _fileEntry struct {
-	Flags      int32
-	Key, Value uint32
+// bestEntry returns the path of the entry for arch among those around i with
+// the same key, preferring the baseline library over glibc-hwcaps variants
+// since those need the CPU features of this machine.
+func (dc *DLCache) bestEntry(i int, arch string) (string, bool) {
+	key := dc.FileEntries[i].Key
+	lo, hi := i, i+1
+	for lo > 0 && dc.FileEntries[lo-1].Key == key {
+		lo--
+	}
+	for hi < len(dc.FileEntries) && dc.FileEntries[hi].Key == key {
+		hi++
+	}
+
+	path, found := "", false
+	for _, entry := range dc.FileEntries[lo:hi] {
+		switch {
+		case !entry.ForArch(arch):
+		case entry.HWCaps == "":
+			return entry.Value, true
+		case !found:
+			path, found = entry.Value, true
+		}
+	}
+	return path, found
 }
 
-// FileEntry represents a
-type fileEntry struct {
+// Entry is a library in the cache: the path (Value) of a file with the
+// soname (Key), and the kind and ABI of library it is (Flags).
+type Entry struct {
 	Flags      int
 	Key, Value string
+	// HWCaps is the glibc-hwcaps subdirectory the library was found in, such
+	// as x86-64-v3, if it is an optimised variant rather than the baseline.
+	HWCaps string
 }
 
-func (fe fileEntry) String() string {
+func (fe Entry) String() string {
 	return fmt.Sprintf(
-		"fileEntry{%x, %q, %q, is64=%t}",
-		fe.Flags, fe.Key, fe.Value, fe.Is64(),
+		"Entry{%x, %q, %q, arch=%s, hwcaps=%q}",
+		fe.Flags, fe.Key, fe.Value, fe.Arch(), fe.HWCaps,
 	)
 }
 
-func (fe fileEntry) Is64() bool {
+// Entry flags, from glibc's ldconfig.h. The low byte is the kind of library
+// and the next the ABI it was built for, which ld.so requires to match its
+// own.
+const (
+	FlagTypeMask     = 0x00ff
+	FlagELF          = 0x0001 // Any ELF library, accepted on every ABI
+	FlagELFLibc6     = 0x0003
+	FlagRequiredMask = 0xff00
+
+	FlagSPARCLib64              = 0x0100
+	FlagIA64Lib64               = 0x0200
+	FlagX8664Lib64              = 0x0300
+	FlagS390Lib64               = 0x0400
+	FlagPowerPCLib64            = 0x0500
+	FlagMIPS64LibN32            = 0x0600
+	FlagMIPS64LibN64            = 0x0700
+	FlagX8664LibX32             = 0x0800
+	FlagARMLibHF                = 0x0900
+	FlagAArch64Lib64            = 0x0a00
+	FlagARMLibSF                = 0x0b00
+	FlagMIPSLib32NaN2008        = 0x0c00
+	FlagMIPS64LibN32NaN2008     = 0x0d00
+	FlagMIPS64LibN64NaN2008     = 0x0e00
+	FlagRISCVFloatABISoft       = 0x0f00
+	FlagRISCVFloatABIDouble     = 0x1000
+	FlagLoongArchFloatABISoft   = 0x1100
+	FlagLoongArchFloatABIDouble = 0x1200
+)
+
+// Arches maps GOARCH-style architecture names, as grab.ArchName gives, to
+// the ABI flags ldconfig gives their libraries. 32-bit x86, ARM and MIPS
+// libraries carry none. Where an architecture has several ABIs the common
+// one is assumed; armel is the soft-float ARM ABI.
+var Arches = map[string]int{
+	"386":      0,
+	"amd64":    FlagX8664Lib64,
+	"amd64p32": FlagX8664LibX32,
+	"arm":      FlagARMLibHF,
+	"armel":    FlagARMLibSF,
+	"arm64":    FlagAArch64Lib64,
+	"loong64":  FlagLoongArchFloatABIDouble,
+	"mips":     0,
+	"mipsle":   0,
+	"mips64":   FlagMIPS64LibN64,
+	"mips64le": FlagMIPS64LibN64,
+	"ppc":      0,
+	"ppc64":    FlagPowerPCLib64,
+	"ppc64le":  FlagPowerPCLib64,
+	"riscv64":  FlagRISCVFloatABIDouble,
+	"s390x":    FlagS390Lib64,
+	"sparc64":  FlagSPARCLib64,
+}
+
+// Type returns the kind of library the entry is, such as FlagELFLibc6.
+func (fe Entry) Type() int {
+	return fe.Flags & FlagTypeMask
+}
+
+// ABI returns the ABI the entry was built for, such as FlagX8664Lib64, or 0
+// if it needs none.
+func (fe Entry) ABI() int {
+	return fe.Flags & FlagRequiredMask
+}
+
+// typeNames and abiNames are how ldconfig -p describes entries' flags.
+var (
+	typeNames = map[int]string{
+		0x0000:       "libc4",
+		FlagELF:      "ELF",
+		0x0002:       "libc5",
+		FlagELFLibc6: "libc6",
+	}
+	abiNames = map[int]string{
+		FlagSPARCLib64:              "64bit",
+		FlagIA64Lib64:               "IA-64",
+		FlagX8664Lib64:              "x86-64",
+		FlagS390Lib64:               "64bit",
+		FlagPowerPCLib64:            "64bit",
+		FlagMIPS64LibN32:            "N32",
+		FlagMIPS64LibN64:            "64bit",
+		FlagX8664LibX32:             "x32",
+		FlagARMLibHF:                "hard-float",
+		FlagAArch64Lib64:            "AArch64",
+		FlagARMLibSF:                "soft-float",
+		FlagMIPSLib32NaN2008:        "nan2008",
+		FlagMIPS64LibN32NaN2008:     "N32,nan2008",
+		FlagMIPS64LibN64NaN2008:     "64bit,nan2008",
+		FlagRISCVFloatABISoft:       "soft-float",
+		FlagRISCVFloatABIDouble:     "double-float",
+		FlagLoongArchFloatABISoft:   "soft-float",
+		FlagLoongArchFloatABIDouble: "double-float",
+	}
+)
+
+// Describe returns the entry's flags and glibc-hwcaps subdirectory as
+// ldconfig -p prints them, such as libc6,x86-64.
+func (fe Entry) Describe() string {
+	desc, ok := typeNames[fe.Type()]
+	if !ok {
+		desc = "unknown"
+	}
+	if abi := fe.ABI(); abi != 0 {
+		name, ok := abiNames[abi]
+		if !ok {
+			name = fmt.Sprintf("%#x", abi)
+		}
+		desc += "," + name
+	}
+	if fe.HWCaps != "" {
+		desc += fmt.Sprintf(", hwcap: %q", fe.HWCaps)
+	}
+	return desc
+}
+
+// ForArch reports whether ld.so on arch, one of Arches, would use the entry:
+// its flags must be FlagELF, or FlagELFLibc6 with arch's ABI.
+func (fe Entry) ForArch(arch string) bool {
+	abi, ok := Arches[arch]
+	return ok && (fe.Flags == FlagELF || fe.Flags == FlagELFLibc6|abi)
+}
+
+// Is64 reports whether the entry is for a 64-bit ABI such as x86-64.
+//
+// Deprecated: the flags of other ABIs overlap; use ForArch.
+func (fe Entry) Is64() bool {
 	return (fe.Flags & 0x300) == 0x300
 }
 
-// ReadDLCache loads a DL Cache from r
-func ReadDLCache(r io.Reader) (*DLCache, error) {
+// Arch returns the name in Arches of the ABI the entry was built for,
+// choosing the first alphabetically where several share it, or its flags in
+// hex if none match.
+func (fe Entry) Arch() string {
+	var names []string
+	for name, abi := range Arches {
+		if fe.Flags&FlagRequiredMask == abi {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Sprintf("%#x", fe.Flags&FlagRequiredMask)
+	}
+	sort.Strings(names)
+	return names[0]
+}
 
-	magic := [len(cacheMagic)]byte{}
+const (
+	// cacheMagicNew and cacheVersionNew begin the format written by glibc
+	// 2.32 and later, either alone or following an old format cache.
+	cacheMagicNew   = "glibc-ld.so.cache"
+	cacheVersionNew = "1.1"
+
+	oldHeaderSize = len(cacheMagic) + 4     // magic, nlibs
+	oldEntrySize  = 12                      // flags, key, value
+	newHeaderSize = len(cacheMagicNew) + 31 // magic, version, nlibs, len_strings, flags, padding, extension_offset, unused
+	newEntrySize  = 24                      // flags, key, value, osversion, hwcap
+	newAlign      = 8                       // __alignof__ (struct cache_file_new)
+
+	// The byte order values of the flags in a new format cache header.
+	cacheFlagsUnknownEndian = 0
+	cacheFlagsLittleEndian  = 2
+	cacheFlagsBigEndian     = 3
+
+	// extensionMagic begins the extension directory of a new format cache.
+	extensionMagic        = 0xeaa42174
+	extensionTagGenerator = 0
+	extensionTagHWCaps    = 1
+
+	// hwcapExtension in the top half of an entry's hwcap marks the bottom
+	// half as an index into the glibc-hwcaps subdirectory names.
+	hwcapExtension = 1 << 62
+)
 
-	var err error
-	read := func(ptr interface{}) error {
-		err = binary.Read(r, binary.LittleEndian, ptr)
-		return err
+// ReadDLCache loads a DL Cache from r. It reads the old format, the new
+// format with its extensions, and the combined format in which the new format
+// follows the old. The new format is preferred where both are present, as
+// ld.so does.
+func ReadDLCache(r io.Reader) (*DLCache, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
 	}
 
-	read(&magic)
-	if string(magic[:5]) != "ld.so" {
+	if bytes.HasPrefix(data, []byte(cacheMagicNew)) {
+		return readNewCache(data)
+	}
+	if !bytes.HasPrefix(data, []byte(cacheMagic[:5])) {
 		return nil, fmt.Errorf("Magic does not start with ld.so.")
 	}
+	if len(data) < oldHeaderSize {
+		return nil, io.ErrUnexpectedEOF
+	}
 
-	var nlibs uint32
-	if err := read(&nlibs); err != nil {
-		return nil, err
+	// The old format is in the byte order of the machine ldconfig ran on,
+	// which it does not record.
+	order := guessOrder(data, len(cacheMagic), oldHeaderSize, oldEntrySize, true)
+	count := order.Uint32(data[len(cacheMagic):])
+	if !within(uint64(oldHeaderSize), count, oldEntrySize, len(data)) {
+		return nil, fmt.Errorf("%d entries overrun the %d byte cache", count, len(data))
+	}
+	nlibs := int(count)
+	stringsStart := oldHeaderSize + nlibs*oldEntrySize
+
+	newStart := (stringsStart + newAlign - 1) &^ (newAlign - 1)
+	if newStart <= len(data) && bytes.HasPrefix(data[newStart:], []byte(cacheMagicNew)) {
+		return readNewCache(data[newStart:])
 	}
 
-	fileEntries := make([]_fileEntry, nlibs)
-	for i := range fileEntries {
-		if err := read(&fileEntries[i]); err != nil {
+	// Old format offsets are relative to the string table after the entries.
+	stringTable := data[stringsStart:]
+	dlCache := &DLCache{}
+	for i := 0; i < nlibs; i++ {
+		entry := data[oldHeaderSize+i*oldEntrySize:]
+		fe, err := readEntry(order, entry, stringTable)
+		if err != nil {
 			return nil, err
 		}
+		dlCache.FileEntries = append(dlCache.FileEntries, fe)
 	}
+	return dlCache, nil
+}
 
-	stringTable, err := ioutil.ReadAll(r)
-	if err != nil {
-		log.Fatal(err)
+// guessOrder returns the byte order of a cache which does not record it: the
+// one in which the count of entries at countOffset in data, each entrySize
+// bytes from entriesOffset, fits in data, and the first entry's string
+// offsets lie within it. They are relative to the end of the entries if
+// stringsAfterEntries, as in the old format, else to the start of data.
+// Little-endian is assumed if both or neither order fit.
+func guessOrder(
+	data []byte, countOffset, entriesOffset, entrySize int, stringsAfterEntries bool,
+) binary.ByteOrder {
+	plausible := func(order binary.ByteOrder) bool {
+		nlibs := order.Uint32(data[countOffset:])
+		if !within(uint64(entriesOffset), nlibs, uint64(entrySize), len(data)) {
+			return false
+		}
+		end := int64(entriesOffset) + int64(nlibs)*int64(entrySize)
+		if nlibs == 0 {
+			return true
+		}
+		var base int64
+		if stringsAfterEntries {
+			base = end
+		}
+		entry := data[entriesOffset:]
+		for _, offset := range []uint32{order.Uint32(entry[4:]), order.Uint32(entry[8:])} {
+			if base+int64(offset) >= int64(len(data)) {
+				return false
+			}
+		}
+		return true
+	}
+	if !plausible(binary.LittleEndian) && plausible(binary.BigEndian) {
+		return binary.BigEndian
 	}
+	return binary.LittleEndian
+}
 
-	readString := func(index int) string {
-		l := bytes.IndexByte(stringTable[index:], 0)
-		return string(stringTable[index : index+l])
+// readNewCache reads the new format cache at the start of data. Its string
+// and extension offsets are relative to that start.
+func readNewCache(data []byte) (*DLCache, error) {
+	if len(data) < newHeaderSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+	version := string(data[len(cacheMagicNew) : len(cacheMagicNew)+3])
+	if version != cacheVersionNew {
+		return nil, fmt.Errorf("unsupported cache version %q", version)
 	}
 
-	dlCache := &DLCache{}
+	// The flags byte records the byte order ldconfig wrote the cache in,
+	// except in caches from before glibc 2.33.
+	var order binary.ByteOrder
+	switch data[28] {
+	case cacheFlagsLittleEndian:
+		order = binary.LittleEndian
+	case cacheFlagsBigEndian:
+		order = binary.BigEndian
+	case cacheFlagsUnknownEndian:
+		order = guessOrder(data, 20, newHeaderSize, newEntrySize, false)
+	default:
+		return nil, fmt.Errorf("unsupported cache flags %#x", data[28])
+	}
+	count := order.Uint32(data[20:])
+	if !within(uint64(newHeaderSize), count, newEntrySize, len(data)) {
+		return nil, fmt.Errorf("%d entries overrun the %d byte cache", count, len(data))
+	}
+	nlibs := int(count)
+	extensionOffset := order.Uint32(data[32:])
 
-	for _, entry := range fileEntries {
-		dlCache.FileEntries = append(dlCache.FileEntries, fileEntry{
-			Flags: int(entry.Flags),
-			Key:   readString(int(entry.Key)),
-			Value: readString(int(entry.Value)),
-		})
-	}
-	//
-	// for i, e := range dlCache.FileEntries {
-	// 	log.Printf("%s", e)
-	// 	if i > 100 {
-	// 		break
-	// 	}
-	// }
+	dlCache := &DLCache{}
+	var hwcaps []string
+	if extensionOffset != 0 {
+		var err error
+		dlCache.Generator, hwcaps, err = readExtensions(order, data, extensionOffset)
+		if err != nil {
+			return nil, err
+		}
+	}
 
+	for i := 0; i < nlibs; i++ {
+		entry := data[newHeaderSize+i*newEntrySize:]
+		fe, err := readEntry(order, entry, data)
+		if err != nil {
+			return nil, err
+		}
+		hwcap := order.Uint64(entry[16:])
+		if hwcap>>32 == hwcapExtension>>32 {
+			index := uint32(hwcap)
+			if uint64(index) >= uint64(len(hwcaps)) {
+				return nil, fmt.Errorf("%s: glibc-hwcaps index %d out of range", fe.Key, index)
+			}
+			fe.HWCaps = hwcaps[index]
+		}
+		dlCache.FileEntries = append(dlCache.FileEntries, fe)
+	}
 	return dlCache, nil
 }
 
+// readExtensions reads the extension directory at offset in the new format
+// cache data, returning the generator and the glibc-hwcaps subdirectory
+// names. Unknown sections are skipped, as ld.so does.
+func readExtensions(
+	order binary.ByteOrder, data []byte, extensionOffset uint32,
+) (
+	generator string, hwcaps []string, err error,
+) {
+	if !within(uint64(extensionOffset), 1, 8, len(data)) {
+		return "", nil, fmt.Errorf("extension offset %d beyond the %d byte cache", extensionOffset, len(data))
+	}
+	offset := int(extensionOffset)
+	if order.Uint32(data[offset:]) != extensionMagic {
+		return "", nil, fmt.Errorf("bad extension magic at offset %d", offset)
+	}
+	count := order.Uint32(data[offset+4:])
+	if !within(uint64(offset+8), count, 16, len(data)) {
+		return "", nil, fmt.Errorf("%d extension sections overrun the cache", count)
+	}
+
+	for i := 0; i < int(count); i++ {
+		section := data[offset+8+i*16:]
+		tag := order.Uint32(section)
+		start, size := order.Uint32(section[8:]), order.Uint32(section[12:])
+		if !within(uint64(start), size, 1, len(data)) {
+			return "", nil, fmt.Errorf("extension section %d overruns the cache", tag)
+		}
+		body := data[start : start+size]
+
+		switch tag {
+		case extensionTagGenerator:
+			generator = string(body)
+		case extensionTagHWCaps:
+			for j := 0; j+4 <= len(body); j += 4 {
+				name, err := readString(data, order.Uint32(body[j:]))
+				if err != nil {
+					return "", nil, err
+				}
+				hwcaps = append(hwcaps, name)
+			}
+		}
+	}
+	return generator, hwcaps, nil
+}
+
+// within reports whether n items of size bytes from offset lie within a
+// cache of length bytes. It cannot overflow, whatever n a corrupt cache
+// gives.
+func within(offset uint64, n uint32, size uint64, length int) bool {
+	return offset+uint64(n)*size <= uint64(length)
+}
+
+// readEntry reads the flags, key and value common to old and new format
+// entries, with the key and value offsets into stringTable.
+func readEntry(order binary.ByteOrder, entry, stringTable []byte) (Entry, error) {
+	key, err := readString(stringTable, order.Uint32(entry[4:]))
+	if err != nil {
+		return Entry{}, err
+	}
+	value, err := readString(stringTable, order.Uint32(entry[8:]))
+	if err != nil {
+		return Entry{}, err
+	}
+	return Entry{
+		Flags: int(int32(order.Uint32(entry))),
+		Key:   key,
+		Value: value,
+	}, nil
+}
+
+// readString returns the NUL-terminated string at offset in stringTable.
+func readString(stringTable []byte, offset uint32) (string, error) {
+	if int64(offset) >= int64(len(stringTable)) {
+		return "", fmt.Errorf("string offset %d beyond the %d byte table", offset, len(stringTable))
+	}
+	s := stringTable[offset:]
+	l := bytes.IndexByte(s, 0)
+	if l < 0 {
+		return "", fmt.Errorf("string at offset %d is not terminated", offset)
+	}
+	return string(s[:l]), nil
+}
+
 func _dl_cache_libcmp(p1, p2 string) int {
 	// log.Printf("Compare %q and %q", p1, p2)
 	l := len(p1)
 	if len(p2) < l {
 		l = len(p2)
 	}
-	parseLeadingNum := func(s string) int {
+	// leadingNum returns the digits s starts with, without leading zeros,
+	// which compare numerically however many there are by length and then
+	// as strings.
+	leadingNum := func(s string) string {
 		nonDigit := func(r rune) bool { return !unicode.IsDigit(r) }
 		firstNonDigit := strings.IndexFunc(s, nonDigit)
 		if firstNonDigit == -1 {
 			// No numbers before end of string
 			firstNonDigit = len(s)
 		}
-		n, err := strconv.ParseInt(s[:firstNonDigit], 10, 32)
-		if err != nil {
-			panic(err)
-		}
-		return int(n)
+		return strings.TrimLeft(s[:firstNonDigit], "0")
 	}
 
 	for i := 0; i < l; i++ {
@@ -194,12 +647,12 @@ func _dl_cache_libcmp(p1, p2 string) int {
 		switch {
 		case unicode.IsDigit(rune(p1c)) && unicode.IsDigit(rune(p2c)):
 			// Must do a numerical compare.
-			n1 := parseLeadingNum(p1[i:])
-			n2 := parseLeadingNum(p2[i:])
+			n1 := leadingNum(p1[i:])
+			n2 := leadingNum(p2[i:])
 			switch {
-			case n1 < n2:
+			case len(n1) < len(n2), len(n1) == len(n2) && n1 < n2:
 				return -1
-			case n1 > n2:
+			case len(n1) > len(n2), n1 > n2:
 				return 1
 			}
 		case unicode.IsDigit(rune(p1c)):
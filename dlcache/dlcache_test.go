@@ -1,6 +1,11 @@
 package dlcache
 
-import "testing"
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
 
 func Test_dl_cache_libcmp(t *testing.T) {
 
@@ -12,4 +17,297 @@ func Test_dl_cache_libcmp(t *testing.T) {
 	t.Log(_dl_cache_libcmp("a-10.so", "a-1.so"))
 	t.Log(_dl_cache_libcmp("a-10.so", "a-10.so"))
 	t.Log(_dl_cache_libcmp("libm.so.6", "libm.so"))
+
+	// Numbers too large for an int compare without panicking.
+	if c := _dl_cache_libcmp("lib99999999999999999999.so", "lib100.so"); c != 1 {
+		t.Errorf("_dl_cache_libcmp(huge, 100) = %d; want 1", c)
+	}
+	if c := _dl_cache_libcmp("lib0100.so", "lib99.so"); c != 1 {
+		t.Errorf("_dl_cache_libcmp(0100, 99) = %d; want 1", c)
+	}
+}
+
+// byteOrder is binary.LittleEndian or binary.BigEndian.
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// newCache builds a new format cache of entries, each a key, value and
+// glibc-hwcaps subdirectory, with the generator and hwcaps extensions, in
+// the byte order le with the header flags.
+func newCache(le byteOrder, flags byte, generator string, entries [][3]string) []byte {
+	var hwcaps []string
+	hwcapIndex := map[string]int{}
+	for _, e := range entries {
+		if _, ok := hwcapIndex[e[2]]; e[2] != "" && !ok {
+			hwcapIndex[e[2]] = len(hwcaps)
+			hwcaps = append(hwcaps, e[2])
+		}
+	}
+
+	stringsStart := newHeaderSize + len(entries)*newEntrySize
+	var stringTable []byte
+	addString := func(s string) uint32 {
+		offset := uint32(stringsStart + len(stringTable))
+		stringTable = append(stringTable, s+"\x00"...)
+		return offset
+	}
+
+	var body []byte
+	for _, e := range entries {
+		entry := make([]byte, newEntrySize)
+		le.PutUint32(entry, 0x303)
+		le.PutUint32(entry[4:], addString(e[0]))
+		le.PutUint32(entry[8:], addString(e[1]))
+		if e[2] != "" {
+			le.PutUint64(entry[16:], hwcapExtension|uint64(hwcapIndex[e[2]]))
+		}
+		body = append(body, entry...)
+	}
+	var hwcapOffsets []byte
+	for _, name := range hwcaps {
+		hwcapOffsets = le.AppendUint32(hwcapOffsets, addString(name))
+	}
+	for len(stringTable)%4 != 0 {
+		stringTable = append(stringTable, 0)
+	}
+
+	extensionOffset := stringsStart + len(stringTable)
+	sectionsStart := extensionOffset + 8 + 2*16
+	ext := le.AppendUint32(nil, extensionMagic)
+	ext = le.AppendUint32(ext, 2)
+	for _, section := range []struct {
+		tag  uint32
+		data []byte
+	}{
+		{extensionTagGenerator, []byte(generator)},
+		{extensionTagHWCaps, hwcapOffsets},
+	} {
+		ext = le.AppendUint32(ext, section.tag)
+		ext = le.AppendUint32(ext, 0)
+		ext = le.AppendUint32(ext, uint32(sectionsStart))
+		ext = le.AppendUint32(ext, uint32(len(section.data)))
+		sectionsStart += len(section.data)
+	}
+	ext = append(ext, generator...)
+	ext = append(ext, hwcapOffsets...)
+
+	header := append([]byte(cacheMagicNew+cacheVersionNew), make([]byte, 28)...)
+	le.PutUint32(header[20:], uint32(len(entries)))
+	le.PutUint32(header[24:], uint32(len(stringTable)))
+	header[28] = flags
+	le.PutUint32(header[32:], uint32(extensionOffset))
+
+	data := append(header, body...)
+	data = append(data, stringTable...)
+	return append(data, ext...)
+}
+
+func TestReadDLCacheNew(t *testing.T) {
+	entries := [][3]string{
+		{"libz.so.1", "/lib/x86_64-linux-gnu/libz.so.1", ""},
+		{"libc.so.6", "/lib/glibc-hwcaps/x86-64-v3/libc.so.6", "x86-64-v3"},
+		{"libc.so.6", "/lib/x86_64-linux-gnu/libc.so.6", ""},
+	}
+	data := newCache(binary.LittleEndian, cacheFlagsLittleEndian, "ldconfig (GNU libc) 2.36", entries)
+
+	// The combined format has an old format cache, here empty, in front.
+	old := append([]byte(cacheMagic), 0, 0, 0, 0)
+	old = append(old, make([]byte, (newAlign-len(old)%newAlign)%newAlign)...)
+
+	for name, data := range map[string][]byte{
+		"new":      data,
+		"combined": append(old, data...),
+		"big-endian": newCache(binary.BigEndian, cacheFlagsBigEndian,
+			"ldconfig (GNU libc) 2.36", entries),
+		"big-endian unrecorded": newCache(binary.BigEndian, cacheFlagsUnknownEndian,
+			"ldconfig (GNU libc) 2.36", entries),
+	} {
+		dc, err := ReadDLCache(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if dc.Generator != "ldconfig (GNU libc) 2.36" {
+			t.Errorf("%s: Generator = %q", name, dc.Generator)
+		}
+		if len(dc.FileEntries) != len(entries) || dc.FileEntries[1].HWCaps != "x86-64-v3" {
+			t.Errorf("%s: FileEntries = %v", name, dc.FileEntries)
+		}
+		if path, ok := dc.LookupCacheArch("libc.so.6", "amd64"); path != entries[2][1] || !ok {
+			t.Errorf("%s: LookupCacheArch(libc.so.6) = %q, %v; want baseline %q",
+				name, path, ok, entries[2][1])
+		}
+	}
+
+	if _, err := ReadDLCache(bytes.NewReader(data[:len(data)-10])); err == nil {
+		t.Errorf("truncated cache read without error")
+	}
+}
+
+// oldCache builds an old format cache of entries, each a key and value, in
+// the byte order order.
+func oldCache(order binary.ByteOrder, entries [][2]string) []byte {
+	var stringTable []byte
+	addString := func(s string) uint32 {
+		offset := uint32(len(stringTable))
+		stringTable = append(stringTable, s+"\x00"...)
+		return offset
+	}
+
+	data := append([]byte(cacheMagic), make([]byte, 4)...)
+	order.PutUint32(data[len(cacheMagic):], uint32(len(entries)))
+	for _, e := range entries {
+		entry := make([]byte, oldEntrySize)
+		order.PutUint32(entry, FlagELFLibc6|FlagS390Lib64)
+		order.PutUint32(entry[4:], addString(e[0]))
+		order.PutUint32(entry[8:], addString(e[1]))
+		data = append(data, entry...)
+	}
+	return append(data, stringTable...)
+}
+
+func TestReadDLCacheOld(t *testing.T) {
+	entries := [][2]string{
+		{"libz.so.1", "/lib/s390x-linux-gnu/libz.so.1"},
+		{"libc.so.6", "/lib/s390x-linux-gnu/libc.so.6"},
+	}
+	for name, order := range map[string]binary.ByteOrder{
+		"little-endian": binary.LittleEndian,
+		"big-endian":    binary.BigEndian,
+	} {
+		dc, err := ReadDLCache(bytes.NewReader(oldCache(order, entries)))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(dc.FileEntries) != len(entries) {
+			t.Fatalf("%s: FileEntries = %v", name, dc.FileEntries)
+		}
+		if fe := dc.FileEntries[1]; fe.Key != entries[1][0] || fe.Value != entries[1][1] || fe.Arch() != "s390x" {
+			t.Errorf("%s: FileEntries[1] = %v", name, fe)
+		}
+	}
+}
+
+func TestFileEntryForArch(t *testing.T) {
+	for _, tc := range []struct {
+		flags int
+		arch  string
+		want  bool
+	}{
+		{FlagELFLibc6 | FlagX8664Lib64, "amd64", true},
+		{FlagELFLibc6 | FlagX8664Lib64, "386", false},
+		{FlagELFLibc6, "386", true},
+		{FlagELFLibc6, "amd64", false},
+		{FlagELFLibc6 | FlagX8664LibX32, "amd64p32", true},
+		{FlagELFLibc6 | FlagARMLibHF, "arm", true},
+		{FlagELFLibc6 | FlagARMLibHF, "armel", false},
+		{FlagELFLibc6 | FlagAArch64Lib64, "arm64", true},
+		{FlagELF, "arm64", true},
+		{FlagELFLibc6 | FlagAArch64Lib64, "unknown", false},
+	} {
+		fe := Entry{Flags: tc.flags}
+		if got := fe.ForArch(tc.arch); got != tc.want {
+			t.Errorf("Entry{Flags: %#x}.ForArch(%s) = %t; want %t", tc.flags, tc.arch, got, tc.want)
+		}
+	}
+	if got := (Entry{Flags: FlagELFLibc6 | FlagAArch64Lib64}).Arch(); got != "arm64" {
+		t.Errorf("Arch() = %s; want arm64", got)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	const libc6 = FlagELFLibc6 | FlagX8664Lib64
+	entries := []Entry{
+		{Flags: libc6, Key: "libc.so.6", Value: "/lib/x86_64-linux-gnu/libc.so.6"},
+		{Flags: libc6, Key: "libz.so.1", Value: "/lib/x86_64-linux-gnu/libz.so.1"},
+		{Flags: libc6, Key: "libc.so.6", Value: "/lib/glibc-hwcaps/x86-64-v3/libc.so.6", HWCaps: "x86-64-v3"},
+		{Flags: FlagELFLibc6, Key: "libc.so.6", Value: "/lib/i386-linux-gnu/libc.so.6"},
+		{Flags: libc6, Key: "libm.so.6", Value: "/lib/x86_64-linux-gnu/libm.so.6"},
+		{Flags: libc6, Key: "libz.so.10", Value: "/opt/lib/libz.so.10"},
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	dc, err := ReadDLCache(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dc.FileEntries) != len(entries) {
+		t.Fatalf("FileEntries = %v", dc.FileEntries)
+	}
+	for _, tc := range []struct{ library, arch, want string }{
+		{"libc.so.6", "amd64", "/lib/x86_64-linux-gnu/libc.so.6"},
+		{"libc.so.6", "386", "/lib/i386-linux-gnu/libc.so.6"},
+		{"libm.so.6", "amd64", "/lib/x86_64-linux-gnu/libm.so.6"},
+		{"libz.so.1", "amd64", "/lib/x86_64-linux-gnu/libz.so.1"},
+		{"libz.so.10", "amd64", "/opt/lib/libz.so.10"},
+		{"libz.so.2", "amd64", ""},
+	} {
+		if got, _ := dc.LookupCacheArch(tc.library, tc.arch); got != tc.want {
+			t.Errorf("LookupCacheArch(%s, %s) = %q; want %q", tc.library, tc.arch, got, tc.want)
+		}
+	}
+}
+
+func TestFindAll(t *testing.T) {
+	const libc6 = FlagELFLibc6 | FlagX8664Lib64
+	var buf bytes.Buffer
+	if err := Write(&buf, []Entry{
+		{Flags: libc6, Key: "libc.so.6", Value: "/lib/x86_64-linux-gnu/libc.so.6"},
+		{Flags: libc6, Key: "libcrypt.so.1", Value: "/lib/x86_64-linux-gnu/libcrypt.so.1"},
+		{Flags: libc6, Key: "libc.so.6", Value: "/lib/glibc-hwcaps/x86-64-v3/libc.so.6", HWCaps: "x86-64-v3"},
+		{Flags: FlagELFLibc6, Key: "libc.so.6", Value: "/lib/i386-linux-gnu/libc.so.6"},
+		{Flags: libc6, Key: "libm.so.6", Value: "/lib/x86_64-linux-gnu/libm.so.6"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	dc, err := ReadDLCache(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(dc.Entries()); n != 5 {
+		t.Errorf("len(Entries()) = %d; want 5", n)
+	}
+	var got []string
+	for _, entry := range dc.FindAll("libc") {
+		got = append(got, entry.Key+" ("+entry.Describe()+")")
+	}
+	want := []string{
+		`libcrypt.so.1 (libc6,x86-64)`,
+		`libc.so.6 (libc6,x86-64, hwcap: "x86-64-v3")`,
+		`libc.so.6 (libc6,x86-64)`,
+		`libc.so.6 (libc6)`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("FindAll(libc) = %q; want %q", got, want)
+	}
+}
+
+func FuzzReadDLCache(f *testing.F) {
+	f.Add(newCache(binary.LittleEndian, cacheFlagsLittleEndian, "ldconfig", [][3]string{
+		{"libc.so.6", "/lib/glibc-hwcaps/x86-64-v3/libc.so.6", "x86-64-v3"},
+		{"libc.so.6", "/lib/libc.so.6", ""},
+	}))
+	f.Add(newCache(binary.BigEndian, cacheFlagsUnknownEndian, "", [][3]string{{"libz.so.1", "/lib/libz.so.1", ""}}))
+	f.Add(oldCache(binary.LittleEndian, [][2]string{{"libc.so.6", "/lib/libc.so.6"}}))
+	f.Add(oldCache(binary.BigEndian, [][2]string{{"libz.so.1", "/lib/libz.so.1"}}))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		dc, err := ReadDLCache(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		// Whatever was read must be usable.
+		for _, entry := range dc.Entries() {
+			dc.LookupCacheArch(entry.Key, entry.Arch())
+			_ = entry.Describe()
+		}
+		var buf bytes.Buffer
+		if err := Write(&buf, dc.Entries()); err != nil {
+			t.Fatal(err)
+		}
+	})
 }
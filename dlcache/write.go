@@ -0,0 +1,108 @@
+package dlcache
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+)
+
+// Write writes entries to w as a new format ld.so.cache, as ldconfig does
+// with -c new, which ld.so reads from glibc 2.32 on. Entries are sorted as
+// ld.so's bisection needs, so may be given in any order. The cache is
+// little-endian; entries with HWCaps are recorded in the glibc-hwcaps
+// extension.
+func Write(w io.Writer, entries []Entry) error {
+	order := binary.LittleEndian
+	entries = append([]Entry{}, entries...)
+	sortEntries(entries)
+
+	var hwcaps []string
+	hwcapIndex := map[string]int{}
+	for _, e := range entries {
+		if _, ok := hwcapIndex[e.HWCaps]; e.HWCaps != "" && !ok {
+			hwcapIndex[e.HWCaps] = len(hwcaps)
+			hwcaps = append(hwcaps, e.HWCaps)
+		}
+	}
+
+	// String offsets in the new format are relative to the start of the
+	// file, and the strings follow the entries.
+	stringsStart := newHeaderSize + len(entries)*newEntrySize
+	var stringTable []byte
+	offsets := map[string]uint32{}
+	addString := func(s string) uint32 {
+		if offset, ok := offsets[s]; ok {
+			return offset
+		}
+		offset := uint32(stringsStart + len(stringTable))
+		offsets[s] = offset
+		stringTable = append(stringTable, s+"\x00"...)
+		return offset
+	}
+
+	body := make([]byte, len(entries)*newEntrySize)
+	for i, e := range entries {
+		entry := body[i*newEntrySize:]
+		order.PutUint32(entry, uint32(int32(e.Flags)))
+		order.PutUint32(entry[4:], addString(e.Key))
+		order.PutUint32(entry[8:], addString(e.Value))
+		if e.HWCaps != "" {
+			order.PutUint64(entry[16:], hwcapExtension|uint64(hwcapIndex[e.HWCaps]))
+		}
+	}
+
+	var ext []byte
+	if len(hwcaps) > 0 {
+		var hwcapOffsets []byte
+		for _, name := range hwcaps {
+			hwcapOffsets = order.AppendUint32(hwcapOffsets, addString(name))
+		}
+		for len(stringTable)%4 != 0 {
+			stringTable = append(stringTable, 0)
+		}
+		// The directory holds one section, whose data follows it.
+		extensionOffset := stringsStart + len(stringTable)
+		ext = order.AppendUint32(ext, extensionMagic)
+		ext = order.AppendUint32(ext, 1)
+		ext = order.AppendUint32(ext, extensionTagHWCaps)
+		ext = order.AppendUint32(ext, 0)
+		ext = order.AppendUint32(ext, uint32(extensionOffset+8+16))
+		ext = order.AppendUint32(ext, uint32(len(hwcapOffsets)))
+		ext = append(ext, hwcapOffsets...)
+	}
+
+	header := make([]byte, newHeaderSize)
+	copy(header, cacheMagicNew+cacheVersionNew)
+	order.PutUint32(header[20:], uint32(len(entries)))
+	order.PutUint32(header[24:], uint32(len(stringTable)))
+	header[28] = cacheFlagsLittleEndian
+	if len(ext) > 0 {
+		order.PutUint32(header[32:], uint32(stringsStart+len(stringTable)))
+	}
+
+	for _, b := range [][]byte{header, body, stringTable, ext} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortEntries sorts entries as ldconfig does: by key in descending
+// _dl_cache_libcmp order, then by descending flags, with glibc-hwcaps
+// variants before the baseline library.
+func sortEntries(entries []Entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if c := _dl_cache_libcmp(a.Key, b.Key); c != 0 {
+			return c > 0
+		}
+		if a.Flags != b.Flags {
+			return a.Flags > b.Flags
+		}
+		if (a.HWCaps == "") != (b.HWCaps == "") {
+			return a.HWCaps != ""
+		}
+		return a.HWCaps < b.HWCaps
+	})
+}
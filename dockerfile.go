@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/pwaller/grab-ld-binaries/grab"
+)
+
+// dockerfile implements `grab-ld-binaries dockerfile <context-dir>
+// <binary>...`, writing a build context holding the bundle as bundle.tar and
+// a Dockerfile for a FROM scratch image whose entrypoint is the first binary.
+func dockerfile(args []string) {
+	if len(args) < 2 {
+		log.Fatal("usage: grab-binaries dockerfile <context-dir> <binary>...")
+	}
+	dir, filenames := args[0], args[1:]
+
+	r, err := newResolver()
+	if err != nil {
+		log.Fatal(err)
+	}
+	g, err := r.ResolveAll(filenames)
+	if err != nil {
+		log.Fatalf("resolve: %v", err)
+	}
+	for _, lib := range g.Missing() {
+		log.Println(lib, "(not found)")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	fd, err := os.Create(filepath.Join(dir, "bundle.tar"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	files := g.Files()
+	total, err := grab.WriteFiles(fd, files, nil)
+	if err != nil {
+		log.Fatalf("dockerfile: %v", err)
+	}
+	if err := fd.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	entrypoint, err := json.Marshal([]string{"/" + files[0].Name})
+	if err != nil {
+		log.Fatal(err)
+	}
+	contents := fmt.Sprintf(`FROM scratch
+ADD bundle.tar /
+ENV LD_LIBRARY_PATH=/
+ENTRYPOINT %s
+`, entrypoint)
+	if err := os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(contents), 0644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Wrote %s (%.2f MiB)", dir, mib(total))
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// graph implements `grab-ld-binaries graph <binary>...`, writing the full
+// dependency graph to stdout in Graphviz DOT format.
+func graph(args []string) {
+	if len(args) < 1 {
+		log.Fatal("usage: grab-binaries graph <binary>...")
+	}
+
+	r, err := newResolver()
+	if err != nil {
+		log.Fatal(err)
+	}
+	g, err := r.ResolveAll(args)
+	if err != nil {
+		log.Fatalf("resolve: %v", err)
+	}
+	if err := g.WriteDOT(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/pwaller/grab-ld-binaries/grab"
+)
+
+// extract implements `grab-ld-binaries extract [-C dir] [-strip-components N]
+// bundle.tar`, unpacking a bundle without relying on the target's tar. The
+// bundle may be compressed, and is read from stdin if it is "-".
+func extract(args []string) {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	dest := fs.String("C", ".", "extract into this directory")
+	strip := fs.Int("strip-components", 0,
+		"remove this many leading components from each name")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		log.Fatal("usage: grab-binaries extract [-C dir] [-strip-components N] <bundle.tar>")
+	}
+
+	in := os.Stdin
+	if fs.Arg(0) != "-" {
+		fd, err := os.Open(fs.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer fd.Close()
+		in = fd
+	}
+
+	r, err := grab.Decompress(in)
+	if err != nil {
+		log.Fatalf("extract: %v", err)
+	}
+	defer r.Close()
+
+	opts := &grab.ExtractOptions{StripComponents: *strip}
+	if err := grab.Extract(r, *dest, opts); err != nil {
+		log.Fatalf("extract: %v", err)
+	}
+}
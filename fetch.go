@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// fetchInputs downloads the inputs which are https:// or http:// URLs, or
+// oci:// artifact references (pulled with oras), into dir. It returns the
+// inputs with those replaced by paths within the root, and the overlay
+// mapping those paths to the downloaded files. Since inputs keep their file
+// names in the archive, it fails if two have the same one.
+func fetchInputs(dir string, inputs []string) ([]string, map[string]string, error) {
+	overlay := map[string]string{}
+	fetchedFrom := map[string]string{} // By name
+	var out []string
+	for _, input := range inputs {
+		var (
+			host string
+			err  error
+		)
+		switch {
+		case strings.HasPrefix(input, "https://"), strings.HasPrefix(input, "http://"):
+			host, err = fetchURL(dir, input)
+		case strings.HasPrefix(input, "oci://"):
+			host, err = fetchArtifact(dir, strings.TrimPrefix(input, "oci://"))
+		default:
+			out = append(out, input)
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", input, err)
+		}
+		if err := os.Chmod(host, 0755); err != nil {
+			return nil, nil, err
+		}
+
+		// Inputs keep their own names in the archive.
+		name := "/" + filepath.Base(host)
+		if other, ok := fetchedFrom[name]; ok {
+			return nil, nil, fmt.Errorf("%s and %s are both named %s", other, input, name[1:])
+		}
+		fetchedFrom[name] = input
+		overlay[name] = host
+		out = append(out, name)
+		log.Printf("Fetched %s", input)
+	}
+	return out, overlay, nil
+}
+
+// fetchURL downloads url into a new directory under dir.
+func fetchURL(dir, url string) (string, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s", resp.Status)
+	}
+
+	name := path.Base(resp.Request.URL.Path)
+	if name == "/" || name == "." {
+		return "", fmt.Errorf("URL does not name a file")
+	}
+	sub, err := os.MkdirTemp(dir, "url-")
+	if err != nil {
+		return "", err
+	}
+	host := filepath.Join(sub, name)
+	fd, err := os.Create(host)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(fd, resp.Body); err != nil {
+		fd.Close()
+		return "", err
+	}
+	return host, fd.Close()
+}
+
+// fetchArtifact pulls the OCI artifact ref into a new directory under dir
+// with oras. The artifact must hold exactly one file.
+func fetchArtifact(dir, ref string) (string, error) {
+	sub, err := os.MkdirTemp(dir, "oci-")
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command("oras", "pull", "--output", sub, ref)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("oras pull: %v", err)
+	}
+
+	var files []string
+	err = filepath.Walk(sub, func(p string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			files = append(files, p)
+		}
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(files) != 1 {
+		return "", fmt.Errorf("artifact holds %d files, want 1", len(files))
+	}
+	return files[0], nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFetchInputs(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.URL.Path))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	inputs, overlay, err := fetchInputs(dir, []string{ts.URL + "/v1/tool", "/bin/ls", ts.URL + "/v1/helper"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/tool", "/bin/ls", "/helper"}; !reflect.DeepEqual(inputs, want) {
+		t.Errorf("inputs %q; want %q", inputs, want)
+	}
+	for name, want := range map[string]string{"/tool": "/v1/tool", "/helper": "/v1/helper"} {
+		data, err := ioutil.ReadFile(overlay[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%s holds %q; want %q", name, data, want)
+		}
+	}
+
+	_, _, err = fetchInputs(dir, []string{ts.URL + "/v1/tool", ts.URL + "/v2/tool"})
+	if err == nil || !strings.Contains(err.Error(), "both named tool") {
+		t.Errorf("fetching two tools: %v", err)
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import "strings"
+
+// stringsFlag is a flag which may be given more than once, collecting each
+// value in order.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
@@ -0,0 +1,150 @@
+package grab
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FilterRule is an rsync-style include or exclude rule for AddPath. A pattern
+// containing a slash is matched against the path below the added directory
+// (anchored if it starts with a slash), otherwise against the final
+// component. A pattern ending in a slash only matches directories.
+type FilterRule struct {
+	Include bool
+	Pattern string
+}
+
+// ParseFilterRule parses "+ PATTERN", "- PATTERN", "include PATTERN" or
+// "exclude PATTERN".
+func ParseFilterRule(s string) (FilterRule, error) {
+	kind, pattern, ok := strings.Cut(strings.TrimSpace(s), " ")
+	pattern = strings.TrimSpace(pattern)
+	if ok && pattern != "" {
+		switch kind {
+		case "+", "include":
+			return FilterRule{Include: true, Pattern: pattern}, nil
+		case "-", "exclude":
+			return FilterRule{Include: false, Pattern: pattern}, nil
+		}
+	}
+	return FilterRule{}, fmt.Errorf("filter rule %q is not of the form '+ PATTERN' or '- PATTERN'", s)
+}
+
+// matches reports whether the rule's pattern matches rel, a slash separated
+// path relative to the added directory.
+func (rule FilterRule) matches(rel string, isDir bool) bool {
+	pattern := rule.Pattern
+	if strings.HasSuffix(pattern, "/") {
+		if !isDir {
+			return false
+		}
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+	if strings.HasPrefix(pattern, "/") {
+		ok, _ := path.Match(pattern, "/"+rel)
+		return ok
+	}
+	// Unanchored patterns may match any trailing portion of the path.
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		if ok, _ := path.Match(pattern, strings.Join(parts[i:], "/")); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// included applies rules in order; the first match decides, and paths which
+// match no rule are included.
+func included(rules []FilterRule, rel string, isDir bool) bool {
+	for _, rule := range rules {
+		if rule.matches(rel, isDir) {
+			return rule.Include
+		}
+	}
+	return true
+}
+
+// AddPath returns the files to archive for p, a file or directory within
+// r.Root. Directories are walked, with rules deciding which files are kept and
+// which subdirectories are descended into. Files are named by their full path
+// within the root so that they extract to the same place. Symlinks to
+// directories are archived as symlinks. FIFOs, sockets and device nodes are
+// handled according to r.SpecialFiles.
+func (r *Resolver) AddPath(p string, rules []FilterRule) ([]File, error) {
+	if err := checkSpecialFiles(r.SpecialFiles); err != nil {
+		return nil, err
+	}
+	p = path.Join("/", p)
+
+	var files []File
+	err := r.walkDir(p, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel := strings.TrimPrefix(strings.TrimPrefix(file, p), "/")
+		if rel == "" {
+			rel = "."
+		}
+		if rel != "." && !included(rules, rel, d.IsDir()) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		// Symlinks are archived as what they point to, except those to
+		// directories, which are not walked, and so are archived as
+		// symlinks.
+		name := strings.TrimPrefix(file, "/")
+		st, err := r.stat(file)
+		if err != nil {
+			r.diagnose(NewDiagnostic(DiagSkippedFile, r.host(file), "dangling symlink"))
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 && st.IsDir() {
+			// Symlinks are archived from the host, so those of an FS
+			// cannot be.
+			if r.FS != nil {
+				r.diagnose(NewDiagnostic(DiagSkippedFile, r.host(file), "symlink to a directory"))
+				return nil
+			}
+			// r.host resolves the symlink itself.
+			host := filepath.Join(r.host(path.Dir(file)), path.Base(file))
+			target, err := os.Readlink(host)
+			if err != nil {
+				return err
+			}
+			files = append(files, File{Path: host, Name: name, Link: target})
+			return nil
+		}
+		if !st.Mode().IsRegular() {
+			special, err := r.addSpecial(r.host(file), name, st.Mode())
+			if special != nil {
+				files = append(files, *special)
+			}
+			return err
+		}
+
+		host, err := r.localPath(file)
+		if err != nil {
+			return err
+		}
+		files = append(files, File{Path: host, Name: name})
+		return nil
+	})
+	return files, err
+}
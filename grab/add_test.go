@@ -0,0 +1,175 @@
+package grab
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestAddPathFilters(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"etc/app/app.conf",
+		"etc/app/app.log",
+		"etc/app/conf.d/extra.conf",
+		"etc/app/cache/blob.conf",
+		"etc/app/README",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var rules []FilterRule
+	for _, s := range []string{"- cache/", "+ *.conf", "+ */", "exclude *"} {
+		rule, err := ParseFilterRule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, rule)
+	}
+
+	r := &Resolver{Root: dir}
+	files, err := r.AddPath("/etc/app", rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+	want := []string{"etc/app/app.conf", "etc/app/conf.d/extra.conf"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got %q, want %q", names, want)
+	}
+
+	if _, err := ParseFilterRule("* *.conf"); err == nil {
+		t.Errorf("invalid rule parsed without error")
+	}
+}
+
+func TestAddPathSpecialFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "app.conf"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(filepath.Join(dir, "control"), 0600); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+
+	r := &Resolver{Root: dir, SpecialFiles: "skip"}
+	files, err := r.AddPath("/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || len(r.Diagnostics) != 1 || r.Diagnostics[0].Message != "skipped: is a fifo" {
+		t.Errorf("skip: got files %v, diagnostics %v", files, r.Diagnostics)
+	}
+
+	r = &Resolver{Root: dir, SpecialFiles: "error"}
+	if _, err := r.AddPath("/", nil); err == nil {
+		t.Errorf("error: added a fifo without error")
+	}
+
+	r = &Resolver{Root: dir, SpecialFiles: "archive"}
+	files, err = r.AddPath("/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := WriteFiles(&buf, files, nil); err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(&buf)
+	types := map[string]byte{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		types[hdr.Name] = hdr.Typeflag
+	}
+	want := map[string]byte{"app.conf": tar.TypeReg, "control": tar.TypeFifo}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("archive: got entry types %q, want %q", types, want)
+	}
+}
+
+func TestAddPathDirSymlink(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"opt/app/share/data/app.dat", "opt/app/lib/libapp.so"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, target := range map[string]string{
+		"opt/app/data":   "share/data",
+		"opt/app/lib64":  "/opt/app/lib",
+		"opt/app/lib.so": "lib/libapp.so",
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Whatever the policy for special files, symlinks to directories are
+	// not special.
+	for _, policy := range SpecialFilePolicies {
+		r := &Resolver{Root: dir, SpecialFiles: policy}
+		files, err := r.AddPath("/opt/app", nil)
+		if err != nil {
+			t.Fatalf("%s: %v", policy, err)
+		}
+		if len(r.Diagnostics) > 0 {
+			t.Errorf("%s: diagnostics %v", policy, r.Diagnostics)
+		}
+
+		var buf bytes.Buffer
+		if _, err := WriteFiles(&buf, files, nil); err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(&buf)
+		got := map[string]string{}
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got[hdr.Name] = string(hdr.Typeflag)
+			if hdr.Typeflag == tar.TypeSymlink {
+				got[hdr.Name] += " -> " + hdr.Linkname
+			}
+		}
+		want := map[string]string{
+			"opt/app/data":               string(tar.TypeSymlink) + " -> share/data",
+			"opt/app/lib64":              string(tar.TypeSymlink) + " -> /opt/app/lib",
+			"opt/app/lib.so":             string(tar.TypeReg),
+			"opt/app/lib/libapp.so":      string(tar.TypeReg),
+			"opt/app/share/data/app.dat": string(tar.TypeReg),
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: archived %q; want %q", policy, got, want)
+		}
+	}
+}
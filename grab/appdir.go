@@ -0,0 +1,91 @@
+package grab
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// AppDirFiles returns files, as archived for g, arranged as an AppImage
+// AppDir: the roots in usr/bin and the other files named by their base
+// name, the libraries, in usr/lib. Files named by a path, such as
+// interpreters, keep it.
+func (g *Graph) AppDirFiles(files []File) []File {
+	roots := map[string]bool{}
+	for _, root := range g.Roots {
+		roots[filepath.Base(root)] = true
+	}
+	var out []File
+	for _, file := range files {
+		switch {
+		case strings.Contains(file.Name, "/"):
+		case roots[file.Name]:
+			file.Name = path.Join("usr/bin", file.Name)
+		default:
+			file.Name = path.Join("usr/lib", file.Name)
+		}
+		out = append(out, file)
+	}
+	return out
+}
+
+// WriteAppDir materializes files into dest as an AppImage AppDir for g, as
+// appimagetool takes, and returns the total bytes read from disk. Beside
+// AppDirFiles, it writes an AppRun which runs the first root through its
+// archived interpreter with usr/lib on the library path and, unless dest
+// already has them, a stub desktop entry and icon for it. opts is as for
+// WriteDir.
+func (g *Graph) WriteAppDir(dest string, files []File, opts *TarOptions) (int64, error) {
+	if len(g.Roots) == 0 {
+		return 0, fmt.Errorf("no binary to run")
+	}
+	total, err := WriteDir(dest, g.AppDirFiles(files), opts)
+	if err != nil {
+		return total, err
+	}
+
+	name := filepath.Base(g.Roots[0])
+	exe := `"$HERE/usr/bin/` + name + `"`
+	fd, err := os.Open(g.host(g.Roots[0]))
+	if err != nil {
+		return total, err
+	}
+	obj, err := readObject(fd)
+	fd.Close()
+	if err != nil {
+		return total, err
+	}
+	if interp := strings.TrimPrefix(obj.interp, "/"); interp != "" && hasName(files, interp) {
+		// The archived libc works only with its own interpreter.
+		exe = `"$HERE/` + interp + `" ` + exe
+	}
+	appRun := "#!/bin/sh\n" +
+		`HERE="$(dirname "$(readlink -f "$0")")"` + "\n" +
+		`export LD_LIBRARY_PATH="$HERE/usr/lib${LD_LIBRARY_PATH:+:$LD_LIBRARY_PATH}"` + "\n" +
+		"exec " + exe + ` "$@"` + "\n"
+
+	for _, f := range []struct {
+		name, contents string
+		mode           os.FileMode
+	}{
+		{"AppRun", appRun, 0755},
+		{name + ".desktop", "[Desktop Entry]\nType=Application\nName=" + name +
+			"\nExec=" + name + "\nIcon=" + name + "\nTerminal=true\nCategories=Utility;\n", 0644},
+		{name + ".svg", `<svg xmlns="http://www.w3.org/2000/svg" width="256" height="256"/>` + "\n", 0644},
+	} {
+		p := filepath.Join(dest, f.name)
+		if _, err := os.Lstat(p); err == nil && f.name != "AppRun" {
+			continue
+		}
+		if err := ioutil.WriteFile(p, []byte(f.contents), f.mode); err != nil {
+			return total, err
+		}
+		if err := os.Chmod(p, f.mode); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
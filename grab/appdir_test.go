@@ -0,0 +1,56 @@
+package grab
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pwaller/grab-ld-binaries/internal/elftest"
+)
+
+func TestWriteAppDir(t *testing.T) {
+	root := t.TempDir()
+	const interp = "/lib64/ld-linux-x86-64.so.2"
+	objects := map[string]elftest.Object{
+		"/bin/app":       {Interp: interp, Needed: []string{"libc.so.6"}},
+		"/lib/libc.so.6": {Soname: "libc.so.6"},
+		interp:           {Soname: "ld-linux-x86-64.so.2"},
+	}
+	for p, obj := range objects {
+		if err := obj.Write(filepath.Join(root, p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g := &Graph{Roots: []string{"/bin/app"}, Sysroot: root}
+	files := []File{
+		{Path: filepath.Join(root, "bin/app"), Name: "app"},
+		{Path: filepath.Join(root, interp), Name: strings.TrimPrefix(interp, "/")},
+		{Path: filepath.Join(root, "lib/libc.so.6"), Name: "libc.so.6"},
+	}
+
+	dest := t.TempDir()
+	if _, err := g.WriteAppDir(dest, files, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		"usr/bin/app", "usr/lib/libc.so.6", "lib64/ld-linux-x86-64.so.2",
+		"app.desktop", "app.svg",
+	} {
+		if _, err := os.Stat(filepath.Join(dest, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	appRun, err := ioutil.ReadFile(filepath.Join(dest, "AppRun"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `exec "$HERE/lib64/ld-linux-x86-64.so.2" "$HERE/usr/bin/app" "$@"`
+	if !strings.Contains(string(appRun), want) {
+		t.Errorf("AppRun does not %s:\n%s", want, appRun)
+	}
+	if fi, err := os.Stat(filepath.Join(dest, "AppRun")); err != nil || fi.Mode()&0111 == 0 {
+		t.Errorf("AppRun is not executable")
+	}
+}
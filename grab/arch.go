@@ -0,0 +1,100 @@
+package grab
+
+import (
+	"debug/elf"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ArchName returns a GOARCH-style name (as used by OCI platforms) for an ELF
+// file's machine, class and byte order.
+func ArchName(f *elf.File) string {
+	le := f.Data == elf.ELFDATA2LSB
+	switch f.Machine {
+	case elf.EM_X86_64:
+		if f.Class == elf.ELFCLASS32 {
+			return "amd64p32"
+		}
+		return "amd64"
+	case elf.EM_386:
+		return "386"
+	case elf.EM_AARCH64:
+		return "arm64"
+	case elf.EM_ARM:
+		return "arm"
+	case elf.EM_PPC64:
+		if le {
+			return "ppc64le"
+		}
+		return "ppc64"
+	case elf.EM_S390:
+		return "s390x"
+	case elf.EM_RISCV:
+		return "riscv64"
+	case elf.EM_LOONGARCH:
+		return "loong64"
+	case elf.EM_MIPS:
+		name := "mips"
+		if f.Class == elf.ELFCLASS64 {
+			name = "mips64"
+		}
+		if le {
+			name += "le"
+		}
+		return name
+	}
+	return fmt.Sprintf("%v-%v", f.Machine, f.Class)
+}
+
+// FileArch returns the architecture of the ELF file at path, or "" if it is
+// not an ELF file.
+func FileArch(path string) (string, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+
+	f, err := elf.NewFile(fd)
+	if err != nil {
+		if _, ok := err.(*elf.FormatError); ok || err == io.EOF {
+			return "", nil
+		}
+		return "", err
+	}
+	defer f.Close()
+
+	return ArchName(f), nil
+}
+
+// SplitByArch groups files by ELF architecture. Files which are not ELF (data
+// files, compressed modules, directories and special files) are placed in
+// every group.
+func SplitByArch(files []File) (map[string][]File, error) {
+	groups := map[string][]File{}
+	var common []File
+	for _, file := range files {
+		if file.Dir || file.Special {
+			// Opening a FIFO would block.
+			common = append(common, file)
+			continue
+		}
+		arch, err := FileArch(file.Path)
+		if err != nil {
+			return nil, err
+		}
+		if arch == "" {
+			common = append(common, file)
+			continue
+		}
+		groups[arch] = append(groups[arch], file)
+	}
+	for arch := range groups {
+		groups[arch] = append(groups[arch], common...)
+	}
+	if len(groups) == 0 && len(common) > 0 {
+		return nil, fmt.Errorf("no ELF files to determine architecture from")
+	}
+	return groups, nil
+}
@@ -0,0 +1,112 @@
+package grab
+
+import (
+	"debug/elf"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pwaller/grab-ld-binaries/internal/elftest"
+)
+
+func TestSplitByArch(t *testing.T) {
+	dir := t.TempDir()
+	for name, o := range map[string]elftest.Object{
+		"app-amd64":  {},
+		"lib-amd64":  {Soname: "libfoo.so.1"},
+		"app-arm64":  {Machine: elf.EM_AARCH64},
+		"app-386":    {Class: elf.ELFCLASS32, Machine: elf.EM_386},
+		"app-ppc64":  {Machine: elf.EM_PPC64, Data: elf.ELFDATA2MSB},
+		"app-ppc64l": {Machine: elf.EM_PPC64},
+	} {
+		if err := o.Write(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, data := range map[string]string{
+		"config": "key=value\n",
+		"empty":  "",
+		"module": "\x28\xb5\x2f\xfd", // A zstd-compressed kernel module
+		"notelf": "\x7fELF",          // Truncated
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := func(name string) File {
+		return File{Path: filepath.Join(dir, name), Name: name}
+	}
+
+	for _, test := range []struct {
+		name  string
+		files []File
+		want  map[string][]string
+		err   bool
+	}{
+		{
+			name:  "one arch",
+			files: []File{file("app-amd64"), file("lib-amd64")},
+			want:  map[string][]string{"amd64": {"app-amd64", "lib-amd64"}},
+		},
+		{
+			name: "mixed arches",
+			files: []File{file("app-amd64"), file("app-arm64"), file("app-386"),
+				file("app-ppc64"), file("app-ppc64l"), file("lib-amd64")},
+			want: map[string][]string{
+				"amd64":   {"app-amd64", "lib-amd64"},
+				"arm64":   {"app-arm64"},
+				"386":     {"app-386"},
+				"ppc64":   {"app-ppc64"},
+				"ppc64le": {"app-ppc64l"},
+			},
+		},
+		{
+			name: "non-ELF files in every group",
+			files: []File{file("config"), file("app-amd64"), file("empty"), file("app-arm64"),
+				file("module"), file("notelf"), {Path: dir, Name: "dir", Dir: true},
+				{Path: "/nonexistent/fifo", Name: "fifo", Special: true}},
+			want: map[string][]string{
+				"amd64": {"app-amd64", "config", "empty", "module", "notelf", "dir", "fifo"},
+				"arm64": {"app-arm64", "config", "empty", "module", "notelf", "dir", "fifo"},
+			},
+		},
+		{
+			name:  "only non-ELF files",
+			files: []File{file("config"), file("module")},
+			err:   true,
+		},
+		{
+			name:  "missing file",
+			files: []File{file("app-amd64"), file("nonexistent")},
+			err:   true,
+		},
+		{
+			name: "nothing",
+			want: map[string][]string{},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			groups, err := SplitByArch(test.files)
+			if test.err {
+				if err == nil {
+					t.Fatalf("no error; groups %v", groups)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string][]string{}
+			for arch, group := range groups {
+				got[arch] = []string{}
+				for _, file := range group {
+					got[arch] = append(got[arch], file.Name)
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("groups %v; want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package grab
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Base describes the files and libraries already present in the image a
+// bundle will be layered on, which therefore need not be bundled.
+type Base struct {
+	Paths   map[string]bool // Absolute paths
+	Sonames map[string]bool
+}
+
+// LoadBase reads a base manifest. Each line is either an absolute path present
+// in the base, or a soname it provides. Blank lines and lines starting with
+// '#' are ignored.
+func LoadBase(filename string) (*Base, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	return readBase(fd)
+}
+
+// readBase reads a base manifest from r.
+func readBase(r io.Reader) (*Base, error) {
+	b := &Base{Paths: map[string]bool{}, Sonames: map[string]bool{}}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "/"):
+			b.Paths[filepath.Clean(line)] = true
+		default:
+			b.Sonames[line] = true
+		}
+	}
+	return b, scanner.Err()
+}
+
+// OpenBase returns the Base described by spec, which is a manifest as read
+// by LoadBase, a root filesystem directory, a root filesystem tarball
+// (compressed or not), or, if no such file exists, a container image such as
+// debian:bookworm, read by ScanBaseImage.
+func OpenBase(spec string) (*Base, error) {
+	fi, err := os.Stat(spec)
+	switch {
+	case os.IsNotExist(err) && !strings.HasPrefix(spec, "/") && !strings.HasPrefix(spec, "."):
+		return ScanBaseImage(spec)
+	case err != nil:
+		return nil, err
+	case fi.IsDir():
+		return ScanBase(spec)
+	}
+
+	fd, err := os.Open(spec)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	dr, err := Decompress(fd)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+
+	br := bufio.NewReader(dr)
+	// Tar headers have "ustar" at offset 257.
+	if header, _ := br.Peek(262); len(header) == 262 && string(header[257:]) == "ustar" {
+		return ScanBaseTar(br)
+	}
+	return readBase(br)
+}
+
+// ScanBaseImage builds the Base for the container image ref, exporting the
+// filesystem of a container created from it, but never started, with
+// docker, or podman if docker is not installed. The image is pulled if need
+// be.
+func ScanBaseImage(ref string) (*Base, error) {
+	engine := "docker"
+	if _, err := exec.LookPath(engine); err != nil {
+		engine = "podman"
+	}
+
+	// A command is given for images which have none, such as those built
+	// from scratch, since creating a container needs one.
+	create := exec.Command(engine, "create", ref, "true")
+	create.Stderr = os.Stderr
+	out, err := create.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s create %s: %v", engine, ref, err)
+	}
+	id := strings.TrimSpace(string(out))
+	defer exec.Command(engine, "rm", id).Run()
+
+	export := exec.Command(engine, "export", id)
+	export.Stderr = os.Stderr
+	stdout, err := export.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := export.Start(); err != nil {
+		return nil, fmt.Errorf("%s export: %v", engine, err)
+	}
+	b, err := ScanBaseTar(stdout)
+	// Drain the padding after the end of the tar, so that export finishes.
+	io.Copy(ioutil.Discard, stdout)
+	if waitErr := export.Wait(); err == nil && waitErr != nil {
+		err = fmt.Errorf("%s export: %v", engine, waitErr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", ref, err)
+	}
+	return b, nil
+}
+
+// Provides reports whether the base has the library soname, or the file at
+// path.
+func (b *Base) Provides(soname, path string) bool {
+	return b.Sonames[soname] || (path != "" && b.Paths[filepath.Clean(path)])
+}
+
+// ApplyBase marks the libraries, interpreters and extra files which b provides
+// so that Files leaves them out. They remain in the graph, and are not
+// reported as missing.
+func (g *Graph) ApplyBase(b *Base) {
+	if g.Provided == nil {
+		g.Provided = map[string]bool{}
+	}
+	for lib := range g.Libraries() {
+		if b.Provides(lib, g.Resolved[lib]) {
+			g.Provided[lib] = true
+		}
+	}
+	for _, interp := range g.Interps {
+		if b.Provides(filepath.Base(interp), interp) {
+			g.Provided[interp] = true
+		}
+	}
+
+	var extra []File
+	for _, file := range g.Extra {
+		if !b.Paths["/"+file.Name] {
+			extra = append(extra, file)
+		}
+	}
+	g.Extra = extra
+}
+
+// Exclude marks the libraries, and the interpreters by file name, matching
+// any of the glob patterns as Provided, for those the target is known to
+// have. They are still traversed, so their own dependencies are archived
+// unless excluded too. It returns the ones excluded.
+func (g *Graph) Exclude(patterns []string) ([]string, error) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %v", pattern, err)
+		}
+	}
+	matches := func(name string) bool {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	if g.Provided == nil {
+		g.Provided = map[string]bool{}
+	}
+	var excluded []string
+	for _, lib := range SortedSet(g.Libraries()) {
+		if matches(lib) {
+			g.Provided[lib] = true
+			excluded = append(excluded, lib)
+		}
+	}
+	for _, interp := range g.Interps {
+		if matches(filepath.Base(interp)) {
+			g.Provided[interp] = true
+			excluded = append(excluded, interp)
+		}
+	}
+	return excluded, nil
+}
+
+// ScanBase builds the Base for the filesystem tree at root, recording every
+// file and symlink and the soname of every shared library.
+func ScanBase(root string) (*Base, error) {
+	b := &Base{Paths: map[string]bool{}, Sonames: map[string]bool{}}
+	err := filepath.Walk(root, func(host string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, host)
+		if err != nil {
+			return err
+		}
+		b.Paths["/"+filepath.ToSlash(rel)] = true
+
+		if fi.Mode().IsRegular() {
+			fd, err := os.Open(host)
+			if err != nil {
+				return err
+			}
+			defer fd.Close()
+			b.addSoname(fd)
+		}
+		return nil
+	})
+	return b, err
+}
+
+// ScanBaseTar builds the Base for a root filesystem tarball, such as the
+// output of `docker export`.
+func ScanBaseTar(r io.Reader) (*Base, error) {
+	b := &Base{Paths: map[string]bool{}, Sonames: map[string]bool{}}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return b, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeSymlink, tar.TypeLink:
+		default:
+			continue
+		}
+		b.Paths[path.Clean("/"+hdr.Name)] = true
+
+		if hdr.Typeflag == tar.TypeReg {
+			data, err := readELF(tr)
+			if err != nil {
+				return nil, err
+			}
+			if data != nil {
+				b.addSoname(bytes.NewReader(data))
+			}
+		}
+	}
+}
+
+// readELF returns the rest of r if it starts with the ELF magic, or nil.
+func readELF(r io.Reader) ([]byte, error) {
+	magic := make([]byte, len(elf.ELFMAG))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, nil
+	}
+	if string(magic) != elf.ELFMAG {
+		return nil, nil
+	}
+	rest, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return append(magic, rest...), nil
+}
+
+// addSoname records the soname of r if it is a shared library.
+func (b *Base) addSoname(r io.ReaderAt) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	sonames, err := f.DynString(elf.DT_SONAME)
+	if err != nil {
+		return
+	}
+	for _, soname := range sonames {
+		b.Sonames[soname] = true
+	}
+}
+
+// WriteTo writes b in the manifest format read by LoadBase: sorted paths,
+// then sorted sonames.
+func (b *Base) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "# paths")
+	for _, p := range sortedKeys(b.Paths) {
+		fmt.Fprintln(&buf, p)
+	}
+	fmt.Fprintln(&buf, "# sonames")
+	for _, soname := range sortedKeys(b.Sonames) {
+		fmt.Fprintln(&buf, soname)
+	}
+	return buf.WriteTo(w)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
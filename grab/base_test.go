@@ -0,0 +1,150 @@
+package grab
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestScanBaseRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "lib/libc-2.31.so"), []byte("not elf"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("libc-2.31.so", filepath.Join(dir, "lib/libc.so.6")); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ScanBase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Sonames["libc.so.6"] = true
+
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	manifest := filepath.Join(t.TempDir(), "manifest")
+	if err := ioutil.WriteFile(manifest, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadBase(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]bool{"/lib/libc-2.31.so": true, "/lib/libc.so.6": true}
+	if !reflect.DeepEqual(loaded.Paths, want) {
+		t.Errorf("paths: got %v, want %v", loaded.Paths, want)
+	}
+	if !reflect.DeepEqual(loaded.Sonames, b.Sonames) {
+		t.Errorf("sonames: got %v, want %v", loaded.Sonames, b.Sonames)
+	}
+}
+
+func TestCheckLibc(t *testing.T) {
+	g := &Graph{
+		Roots:   []string{"app"},
+		Edges:   map[string][]string{"app": {"libc.so.6"}},
+		Interps: []string{"/lib64/ld-linux-x86-64.so.2"},
+	}
+	musl := &Base{Sonames: map[string]bool{"libc.musl-x86_64.so.1": true}}
+	glibc := &Base{Paths: map[string]bool{"/lib/x86_64-linux-gnu/libc.so.6": true}}
+
+	if err := g.CheckLibc(musl); err == nil {
+		t.Errorf("glibc binary on musl base: no error")
+	}
+	if err := g.CheckLibc(glibc); err != nil {
+		t.Errorf("glibc binary on glibc base: %v", err)
+	}
+	if err := g.CheckLibc(&Base{}); err != nil {
+		t.Errorf("glibc binary on unknown base: %v", err)
+	}
+}
+
+func TestExclude(t *testing.T) {
+	g := &Graph{
+		Roots:   []string{"/bin/app"},
+		Interps: []string{"/lib64/ld-linux-x86-64.so.2"},
+		Edges: map[string][]string{
+			"/bin/app":    {"libc.so.6", "libfoo.so.1"},
+			"libfoo.so.1": {"libbar.so.2", "libc.so.6"},
+		},
+		Resolved: map[string]string{
+			"libc.so.6":   "/lib/libc.so.6",
+			"libfoo.so.1": "/lib/libfoo.so.1",
+			"libbar.so.2": "/lib/libbar.so.2",
+		},
+	}
+	excluded, err := g.Exclude([]string{"libc.so*", "ld-linux*", "libfoo*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"libc.so.6", "libfoo.so.1", "/lib64/ld-linux-x86-64.so.2"}
+	if !reflect.DeepEqual(excluded, want) {
+		t.Errorf("Exclude() = %q; want %q", excluded, want)
+	}
+	// libbar is only needed by an excluded library, but is still archived.
+	var archived []string
+	for _, file := range g.Files() {
+		archived = append(archived, file.Name)
+	}
+	if want := []string{"app", "libbar.so.2"}; !reflect.DeepEqual(archived, want) {
+		t.Errorf("Files() = %q; want %q", archived, want)
+	}
+
+	if _, err := g.Exclude([]string{"lib["}); err == nil {
+		t.Errorf("Exclude(lib[) succeeded")
+	}
+}
+
+func TestOpenBase(t *testing.T) {
+	dir := t.TempDir()
+	rootfs := filepath.Join(dir, "rootfs")
+	if err := os.MkdirAll(filepath.Join(rootfs, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(rootfs, "lib/libz.so.1"), []byte("not elf"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var tarball bytes.Buffer
+	files := []File{{Path: filepath.Join(rootfs, "lib/libz.so.1"), Name: "lib/libz.so.1"}}
+	if _, err := WriteFiles(&tarball, files, nil); err != nil {
+		t.Fatal(err)
+	}
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write(tarball.Bytes())
+	gz.Close()
+	var manifest bytes.Buffer
+	(&Base{Paths: map[string]bool{"/lib/libz.so.1": true}}).WriteTo(&manifest)
+	for name, data := range map[string][]byte{
+		"rootfs.tar":    tarball.Bytes(),
+		"rootfs.tar.gz": gzipped.Bytes(),
+		"manifest":      manifest.Bytes(),
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"rootfs", "rootfs.tar", "rootfs.tar.gz", "manifest"} {
+		b, err := OpenBase(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !b.Provides("libz.so.1", "/lib/libz.so.1") {
+			t.Errorf("%s: got paths %v, want /lib/libz.so.1", name, b.Paths)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package grab
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"path"
+	"regexp"
+)
+
+// ChecklistItem is an assumption the bundled binaries make about the system
+// they run on, for the user to verify before deploying.
+type ChecklistItem struct {
+	// Kind is what is assumed: "file", "dlopen", "capability", "device",
+	// "group" or "library".
+	Kind    string `json:"kind"`
+	Subject string `json:"subject"`
+	Reason  string `json:"reason"`
+}
+
+func (item ChecklistItem) String() string {
+	return fmt.Sprintf("[%s] %s: %s", item.Kind, item.Subject, item.Reason)
+}
+
+// symbolHints are imported functions which imply files or plugins the
+// bundle does not carry.
+var symbolHints = []struct {
+	symbols []string
+	ChecklistItem
+}{
+	{
+		[]string{"getaddrinfo", "getnameinfo", "gethostbyname", "gethostbyname2", "gethostbyname_r", "res_query", "res_search"},
+		ChecklistItem{"file", "/etc/resolv.conf, /etc/hosts, /etc/nsswitch.conf",
+			"resolves host names; glibc dlopens the libnss_* modules nsswitch.conf names, which are not bundled without -nss"},
+	},
+	{
+		[]string{"getpwnam", "getpwnam_r", "getpwuid", "getpwuid_r", "getgrnam", "getgrgid", "getgrouplist", "initgroups"},
+		ChecklistItem{"file", "/etc/passwd, /etc/group, /etc/nsswitch.conf",
+			"looks up users and groups through NSS"},
+	},
+	{
+		[]string{"dlopen", "dlmopen"},
+		ChecklistItem{"dlopen", "plugins",
+			"calls dlopen; libraries loaded by name at runtime are not bundled"},
+	},
+	{
+		[]string{"setlocale", "newlocale"},
+		ChecklistItem{"file", "/usr/lib/locale",
+			"loads locales; without them it falls back to the C locale"},
+	},
+	{
+		[]string{"tzset", "localtime", "localtime_r"},
+		ChecklistItem{"file", "/etc/localtime, /usr/share/zoneinfo",
+			"converts to local time; without them it uses UTC"},
+	},
+	{
+		[]string{"iconv_open"},
+		ChecklistItem{"dlopen", "gconv modules",
+			"converts character sets with iconv, which dlopens gconv modules"},
+	},
+}
+
+// libraryHints are libraries which imply files the bundle does not carry.
+var libraryHints = []struct {
+	pattern string
+	ChecklistItem
+}{
+	{"libssl.so.*", ChecklistItem{"file", "/etc/ssl/certs", "uses OpenSSL, which verifies peers against the system CA certificates"}},
+	{"libgnutls.so.*", ChecklistItem{"file", "/etc/ssl/certs", "uses GnuTLS, which verifies peers against the system CA certificates"}},
+}
+
+// pathString matches absolute paths to data and configuration which
+// binaries embed as C strings, such as /var/lib/app or /etc/app.conf.
+var pathString = regexp.MustCompile(`^/(?:etc|var|run|srv|opt|usr/share)/[A-Za-z0-9._+\-/]*[A-Za-z0-9_]$`)
+
+// maxChecklistPaths bounds the embedded paths listed per binary.
+const maxChecklistPaths = 8
+
+// Checklist compiles the runtime assumptions of the bundle for g: the files
+// and plugins its binaries' imported functions and libraries imply, the
+// paths the roots embed, the capabilities, devices and groups found by
+// AnalyzeSecurity and DeviceRequirements, and the libraries in diags which
+// could not be found.
+func (g *Graph) Checklist(diags []Diagnostic) ([]ChecklistItem, error) {
+	var items []ChecklistItem
+	seen := map[ChecklistItem]bool{}
+	add := func(item ChecklistItem) {
+		if !seen[item] {
+			seen[item] = true
+			items = append(items, item)
+		}
+	}
+
+	objects := append([]string{}, g.Roots...)
+	for _, lib := range SortedSet(g.Libraries()) {
+		if p, ok := g.Resolved[lib]; ok && !g.Provided[lib] {
+			objects = append(objects, p)
+		}
+	}
+	imported := map[string]bool{}
+	for _, p := range objects {
+		f, err := elf.Open(g.host(p))
+		if err != nil {
+			return nil, err
+		}
+		symbols, _ := f.ImportedSymbols()
+		for _, sym := range symbols {
+			imported[sym.Name] = true
+		}
+		f.Close()
+	}
+	for _, hint := range symbolHints {
+		for _, sym := range hint.symbols {
+			if imported[sym] {
+				add(hint.ChecklistItem)
+				break
+			}
+		}
+	}
+	for _, lib := range SortedSet(g.Libraries()) {
+		for _, hint := range libraryHints {
+			if ok, _ := path.Match(hint.pattern, lib); ok {
+				add(hint.ChecklistItem)
+			}
+		}
+	}
+
+	for _, root := range g.Roots {
+		paths, err := embeddedPaths(g.host(root))
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range paths {
+			add(ChecklistItem{"file", p, "named by " + path.Base(root)})
+		}
+	}
+
+	security, err := g.AnalyzeSecurity()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range security.NeededCapabilities {
+		add(ChecklistItem{"capability", c, "needed by file capabilities or a privileged port"})
+	}
+	for _, req := range g.DeviceRequirements() {
+		reason := "implied by " + req.Library
+		for _, d := range req.Devices {
+			add(ChecklistItem{"device", d, reason})
+		}
+		for _, group := range req.Groups {
+			add(ChecklistItem{"group", group, reason})
+		}
+		for _, c := range req.Capabilities {
+			add(ChecklistItem{"capability", c, reason})
+		}
+	}
+
+	for _, d := range diags {
+		if d.ID == DiagMissingLibrary {
+			add(ChecklistItem{"library", d.Subject, "not found, so not bundled; the target must provide it"})
+		}
+	}
+	return items, nil
+}
+
+// embeddedPaths returns the sorted absolute paths matching pathString which
+// the ELF file at host holds as NUL-terminated strings in .rodata, up to
+// maxChecklistPaths of them.
+func embeddedPaths(host string) ([]string, error) {
+	f, err := elf.Open(host)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	section := f.Section(".rodata")
+	if section == nil {
+		return nil, nil
+	}
+	data, err := section.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	set := map[string]struct{}{}
+	for _, s := range bytes.Split(data, []byte{0}) {
+		if len(s) > 0 && s[0] == '/' && pathString.Match(s) {
+			set[string(s)] = struct{}{}
+		}
+	}
+	paths := SortedSet(set)
+	if len(paths) > maxChecklistPaths {
+		paths = paths[:maxChecklistPaths]
+	}
+	return paths, nil
+}
@@ -0,0 +1,38 @@
+package grab
+
+import (
+	"os"
+	"testing"
+)
+
+func TestChecklist(t *testing.T) {
+	const root = "/bin/true"
+	if _, err := os.Stat(root); err != nil {
+		t.Skip(err)
+	}
+	g := &Graph{
+		Roots:    []string{root},
+		Edges:    map[string][]string{root: {"libmissing.so.1"}},
+		Resolved: map[string]string{},
+	}
+	items, err := g.Checklist(g.MissingDiagnostics())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[ChecklistItem]bool{
+		// coreutils calls setlocale.
+		{"file", "/usr/lib/locale", "loads locales; without them it falls back to the C locale"}: false,
+		{"library", "libmissing.so.1", "not found, so not bundled; the target must provide it"}:  false,
+	}
+	for _, item := range items {
+		if _, ok := want[item]; ok {
+			want[item] = true
+		}
+	}
+	for item, found := range want {
+		if !found {
+			t.Errorf("checklist %v lacks %v", items, item)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package grab
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"lukechampine.com/blake3"
+)
+
+// Checksums are the supported hash algorithms for recipes.
+var Checksums = []string{"sha256", "sha512", "blake3"}
+
+// checksums construct the hash for each of Checksums. BLAKE3 hashes large
+// writes on all CPUs, so is much faster on big closures.
+var checksums = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"blake3": func() hash.Hash { return blake3.New(32, nil) },
+}
+
+// checksumBufferSize is the size of the reads when hashing files, large
+// enough for BLAKE3 to split each one across CPUs.
+const checksumBufferSize = 1 << 20
+
+// newChecksum returns the hash for checksum, one of Checksums. Empty means
+// sha256.
+func newChecksum(checksum string) (hash.Hash, error) {
+	if checksum == "" {
+		checksum = "sha256"
+	}
+	newHash, ok := checksums[checksum]
+	if !ok {
+		return nil, fmt.Errorf("unknown checksum %q, want one of %s",
+			checksum, strings.Join(Checksums, ", "))
+	}
+	return newHash(), nil
+}
+
+// checksumString returns the hex checksum of s.
+func checksumString(checksum, s string) (string, error) {
+	h, err := newChecksum(checksum)
+	if err != nil {
+		return "", err
+	}
+	io.WriteString(h, s)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checksumFile returns the hex checksum of the contents of filename.
+func checksumFile(checksum, filename string) (string, error) {
+	h, err := newChecksum(checksum)
+	if err != nil {
+		return "", err
+	}
+	fd, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+
+	// Hide fd's WriterTo so that the copy uses the large buffer.
+	buf := make([]byte, checksumBufferSize)
+	if _, err := io.CopyBuffer(h, struct{ io.Reader }{fd}, buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,23 @@
+package grab
+
+import "testing"
+
+func TestChecksumString(t *testing.T) {
+	for checksum, want := range map[string]string{
+		"sha256": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		"sha512": "ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a" +
+			"2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f",
+		"blake3": "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85",
+	} {
+		got, err := checksumString(checksum, "abc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("checksumString(%s, abc) = %s; want %s", checksum, got, want)
+		}
+	}
+	if _, err := checksumString("md5", "abc"); err == nil {
+		t.Errorf("checksumString(md5) succeeded")
+	}
+}
@@ -0,0 +1,131 @@
+package grab
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+)
+
+// Compressions are the supported values for Compress.
+var Compressions = []string{"none", "gzip", "zstd", "xz"}
+
+// compressors are the external programs used for compressions the standard
+// library lacks. Each reads stdin and writes stdout.
+var compressors = map[string][]string{
+	"zstd": {"zstd", "-q", "-c", "-T0"},
+	"xz":   {"xz", "-c", "-T0"},
+}
+
+// CompressionFor infers the compression from filename's extension, returning
+// "none" if there is no recognised one.
+func CompressionFor(filename string) string {
+	switch {
+	case strings.HasSuffix(filename, ".gz"), strings.HasSuffix(filename, ".tgz"):
+		return "gzip"
+	case strings.HasSuffix(filename, ".zst"), strings.HasSuffix(filename, ".tzst"):
+		return "zstd"
+	case strings.HasSuffix(filename, ".xz"), strings.HasSuffix(filename, ".txz"):
+		return "xz"
+	}
+	return "none"
+}
+
+// Compress returns a writer which compresses to w with the given compression.
+// Closing it flushes the compressed stream and then closes w.
+func Compress(w io.WriteCloser, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case "", "none":
+		return w, nil
+	case "gzip":
+		return &compressWriter{WriteCloser: gzip.NewWriter(w), under: w}, nil
+	}
+
+	args, ok := compressors[compression]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression %q, want one of %s",
+			compression, strings.Join(Compressions, ", "))
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = w
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%s: %v", compression, err)
+	}
+	return &compressWriter{WriteCloser: stdin, under: w, cmd: cmd}, nil
+}
+
+// compressWriter is a compressing stream over under, optionally fed to an
+// external compressor process.
+type compressWriter struct {
+	io.WriteCloser
+	under io.WriteCloser
+	cmd   *exec.Cmd
+}
+
+func (c *compressWriter) Close() error {
+	err := c.WriteCloser.Close()
+	if c.cmd != nil {
+		if waitErr := c.cmd.Wait(); err == nil && waitErr != nil {
+			err = fmt.Errorf("%s: %v", c.cmd.Path, waitErr)
+		}
+	}
+	if closeErr := c.under.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Decompress returns a reader of the decompressed contents of r, detecting
+// the compression from its magic number. Uncompressed input is passed
+// through.
+func Decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(6)
+
+	var compression string
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return gz, nil
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		compression = "zstd"
+	case bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		compression = "xz"
+	default:
+		return ioutil.NopCloser(br), nil
+	}
+
+	args := append(compressors[compression], "-d")
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = br
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%s: %v", compression, err)
+	}
+	return &decompressReader{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+// decompressReader reads the output of an external decompressor process.
+type decompressReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (d *decompressReader) Close() error {
+	d.ReadCloser.Close()
+	return d.cmd.Wait()
+}
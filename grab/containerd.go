@@ -0,0 +1,256 @@
+package grab
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Docker image media types, which images pulled from Docker registries
+// keep in containerd's content store.
+const (
+	dockerManifestType     = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerManifestListType = "application/vnd.docker.distribution.manifest.list.v2+json"
+	dockerLayerType        = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+)
+
+// containerdImageName is the annotation by which ctr import names an image.
+const containerdImageName = "io.containerd.image.name"
+
+// AppendOCILayer adds a layer holding files to the image for arch (see
+// ArchName) in the OCI image layout dir, such as one exported by ctr, and
+// names the result ref in its index.json, for ctr import. The layer's
+// libraries are put first on the image's LD_LIBRARY_PATH, and annotations
+// are added to the manifest. It returns the total bytes read from disk.
+func AppendOCILayer(
+	dir string, files []File, opts *TarOptions, arch, ref string, annotations map[string]string,
+) (int64, error) {
+	blobs := filepath.Join(dir, "blobs", "sha256")
+	var index struct {
+		Manifests []ociDescriptor `json:"manifests"`
+	}
+	if err := readJSON(filepath.Join(dir, "index.json"), &index); err != nil {
+		return 0, err
+	}
+	desc, err := findManifest(blobReader(blobs), index.Manifests, arch)
+	if err != nil {
+		return 0, err
+	}
+
+	var manifest map[string]interface{}
+	if err := readBlob(blobs, desc.Digest, &manifest); err != nil {
+		return 0, err
+	}
+	var configDesc ociDescriptor
+	if err := remarshal(manifest["config"], &configDesc); err != nil {
+		return 0, fmt.Errorf("manifest config: %v", err)
+	}
+	var config map[string]interface{}
+	if err := readBlob(blobs, configDesc.Digest, &config); err != nil {
+		return 0, err
+	}
+
+	total, layer, diffID, err := writeOCILayer(blobs, files, opts)
+	if err != nil {
+		return total, err
+	}
+	if desc.MediaType == dockerManifestType {
+		layer.MediaType = dockerLayerType
+	}
+	layers, _ := manifest["layers"].([]interface{})
+	manifest["layers"] = append(layers, layer)
+
+	rootfs, _ := config["rootfs"].(map[string]interface{})
+	if rootfs == nil {
+		return total, fmt.Errorf("image config has no rootfs")
+	}
+	diffIDs, _ := rootfs["diff_ids"].([]interface{})
+	rootfs["diff_ids"] = append(diffIDs, diffID)
+	created := time.Now()
+	if opts != nil && opts.Reproducible {
+		created = opts.modTime()
+	}
+	history, _ := config["history"].([]interface{})
+	config["history"] = append(history, map[string]interface{}{
+		"created":    created.UTC().Format(time.RFC3339),
+		"created_by": "grab-ld-binaries",
+		"comment":    fmt.Sprintf("%d files", len(files)),
+	})
+	runtime, _ := config["config"].(map[string]interface{})
+	if runtime == nil {
+		runtime = map[string]interface{}{}
+		config["config"] = runtime
+	}
+	env, _ := runtime["Env"].([]interface{})
+	runtime["Env"] = prependLibraryPath(env, "/")
+
+	newConfig, err := writeOCIBlob(blobs, configDesc.MediaType, config)
+	if err != nil {
+		return total, err
+	}
+	manifest["config"] = newConfig
+	if len(annotations) > 0 {
+		merged, _ := manifest["annotations"].(map[string]interface{})
+		if merged == nil {
+			merged = map[string]interface{}{}
+		}
+		for key, value := range annotations {
+			merged[key] = value
+		}
+		manifest["annotations"] = merged
+	}
+	newManifest, err := writeOCIBlob(blobs, desc.MediaType, manifest)
+	if err != nil {
+		return total, err
+	}
+	newManifest.Platform = &ociPlatform{Architecture: arch, OS: "linux"}
+	newManifest.Annotations = map[string]string{
+		containerdImageName:                 ref,
+		"org.opencontainers.image.ref.name": ref,
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ociIndexType,
+		"manifests":     []ociDescriptor{newManifest},
+	})
+	if err != nil {
+		return total, err
+	}
+	return total, ioutil.WriteFile(filepath.Join(dir, "index.json"), data, 0644)
+}
+
+// findManifest returns the image manifest among descs for arch, looking
+// into image indexes and manifest lists, which readBlob decodes. Single
+// manifests are taken to be for arch.
+func findManifest(readBlob func(digest string, v interface{}) error, descs []ociDescriptor, arch string) (ociDescriptor, error) {
+	for _, desc := range descs {
+		switch desc.MediaType {
+		case ociManifestType, dockerManifestType:
+			if len(descs) == 1 || desc.Platform == nil || desc.Platform.Architecture == arch {
+				return desc, nil
+			}
+		case ociIndexType, dockerManifestListType:
+			var index struct {
+				Manifests []ociDescriptor `json:"manifests"`
+			}
+			if err := readBlob(desc.Digest, &index); err != nil {
+				return desc, err
+			}
+			if found, err := findManifest(readBlob, index.Manifests, arch); err == nil {
+				return found, nil
+			}
+		}
+	}
+	return ociDescriptor{}, fmt.Errorf("no image manifest for %s", arch)
+}
+
+// prependLibraryPath returns env, a list of KEY=value, with dir first on
+// LD_LIBRARY_PATH.
+func prependLibraryPath(env []interface{}, dir string) []interface{} {
+	const key = "LD_LIBRARY_PATH="
+	for i, v := range env {
+		if s, ok := v.(string); ok && strings.HasPrefix(s, key) {
+			env[i] = key + dir + ":" + strings.TrimPrefix(s, key)
+			return env
+		}
+	}
+	return append(env, key+dir)
+}
+
+// blobDigest matches the digests blobs are named by: that of their sha256.
+var blobDigest = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// blobHex returns the hex sha256 of digest, by which blobs are named. Other
+// digests are an error, so that a digest from an untrusted index or manifest
+// cannot name a file outside of the image layout.
+func blobHex(digest string) (string, error) {
+	if !blobDigest.MatchString(digest) {
+		return "", fmt.Errorf("unsupported blob digest %q", digest)
+	}
+	return strings.TrimPrefix(digest, "sha256:"), nil
+}
+
+// blobPath returns the path in blobs of the blob with digest.
+func blobPath(blobs, digest string) (string, error) {
+	hex, err := blobHex(digest)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(blobs, hex), nil
+}
+
+// checkDigest returns an error unless data, the blob with digest, hashes to
+// it.
+func checkDigest(digest string, data []byte) error {
+	if sum := "sha256:" + sha256String(string(data)); sum != digest {
+		return fmt.Errorf("blob %s has digest %s", digest, sum)
+	}
+	return nil
+}
+
+// readBlob decodes the JSON blob with digest in the directory blobs into v,
+// checking its contents against the digest.
+func readBlob(blobs, digest string, v interface{}) error {
+	filename, err := blobPath(blobs, digest)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if err := checkDigest(digest, data); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	return nil
+}
+
+// blobReader returns a function decoding the JSON blob with a digest from
+// the directory blobs.
+func blobReader(blobs string) func(digest string, v interface{}) error {
+	return func(digest string, v interface{}) error {
+		return readBlob(blobs, digest, v)
+	}
+}
+
+// readJSON decodes the JSON file filename into v.
+func readJSON(filename string, v interface{}) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	return nil
+}
+
+// remarshal converts the decoded JSON value v into out.
+func remarshal(v, out interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// Ctr runs the containerd CLI, ctr, in namespace with args.
+func Ctr(namespace string, args ...string) error {
+	cmd := exec.Command("ctr", append([]string{"--namespace", namespace}, args...)...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ctr %s: %v", strings.Join(args, " "), err)
+	}
+	return nil
+}
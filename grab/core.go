@@ -0,0 +1,234 @@
+package grab
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Note types, from the kernel's and binutils' elf.h.
+const (
+	ntGNUBuildID = 3          // NT_GNU_BUILD_ID, named "GNU"
+	ntFile       = 0x46494c45 // NT_FILE, named "CORE"
+)
+
+// CoreMapping is a file which was mapped into a process when it dumped
+// core.
+type CoreMapping struct {
+	Path string
+	// Start is the address the file was mapped at, in its first mapping.
+	Start uint64
+	// BuildID is the hex GNU build ID of an ELF object, from the copy of its
+	// headers in the core, if it holds one.
+	BuildID string
+}
+
+// ReadCore returns the files mapped in the core dump filename, from its
+// NT_FILE note, in order of address, so that the executable comes first.
+func ReadCore(filename string) ([]CoreMapping, error) {
+	f, err := elf.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if f.Type != elf.ET_CORE {
+		return nil, fmt.Errorf("%s is not a core dump", filename)
+	}
+
+	var mappings []CoreMapping
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_NOTE {
+			continue
+		}
+		notes, err := readNotes(prog.Open(), f.ByteOrder)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		for _, n := range notes {
+			if n.name == "CORE" && n.typ == ntFile {
+				if mappings, err = parseNTFile(n.desc, f.Class, f.ByteOrder); err != nil {
+					return nil, fmt.Errorf("%s: NT_FILE: %v", filename, err)
+				}
+			}
+		}
+	}
+	if mappings == nil {
+		return nil, fmt.Errorf("%s has no NT_FILE note of mapped files", filename)
+	}
+	for i := range mappings {
+		mappings[i].BuildID = coreBuildID(f, mappings[i].Start)
+	}
+	return mappings, nil
+}
+
+// CheckBuildIDs diagnoses the mapped files, within r.Root, whose build IDs
+// differ from those in the core dump they are from, so are not the files
+// which crashed, as after an upgrade.
+func (r *Resolver) CheckBuildIDs(mappings []CoreMapping) {
+	for _, m := range mappings {
+		if m.BuildID == "" {
+			continue
+		}
+		f, err := r.openELF(m.Path)
+		if err != nil {
+			continue
+		}
+		id := buildID(f.File)
+		f.Close()
+		if id != m.BuildID {
+			r.diagnose(NewDiagnostic(DiagBuildIDMismatch, m.Path, id, m.BuildID))
+		}
+	}
+}
+
+// parseNTFile decodes an NT_FILE note: a count and page size, then the
+// start, end and page offset of each mapping, then their paths, each a word
+// wide for class. Files mapped more than once are returned once.
+func parseNTFile(desc []byte, class elf.Class, order binary.ByteOrder) ([]CoreMapping, error) {
+	size := 8
+	word := func(b []byte) uint64 { return order.Uint64(b) }
+	if class == elf.ELFCLASS32 {
+		size = 4
+		word = func(b []byte) uint64 { return uint64(order.Uint32(b)) }
+	}
+	if len(desc) < 2*size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	count := word(desc)
+	table := desc[2*size:]
+	if count > uint64(len(table)/(3*size)) {
+		return nil, fmt.Errorf("%d mappings do not fit", count)
+	}
+	paths := bytes.Split(table[count*uint64(3*size):], []byte{0})
+	if uint64(len(paths)) < count {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	var mappings []CoreMapping
+	seen := map[string]bool{}
+	for i := uint64(0); i < count; i++ {
+		p := string(paths[i])
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		start := word(table[i*uint64(3*size):])
+		mappings = append(mappings, CoreMapping{Path: p, Start: start})
+	}
+	return mappings, nil
+}
+
+// coreBuildID returns the build ID of the ELF object mapped at start, from
+// the first page of it which core holds, or "" if that was not dumped.
+func coreBuildID(core *elf.File, start uint64) string {
+	const pageSize = 4096
+	for _, prog := range core.Progs {
+		if prog.Type != elf.PT_LOAD || start < prog.Vaddr || start >= prog.Vaddr+prog.Filesz {
+			continue
+		}
+		page := make([]byte, pageSize)
+		n, _ := prog.ReadAt(page, int64(start-prog.Vaddr))
+		page = page[:n]
+		if !bytes.HasPrefix(page, []byte(elf.ELFMAG)) {
+			return ""
+		}
+		// Only the program headers and notes, near the start of the file,
+		// are in the page; the section headers debug/elf would read are not.
+		f, err := elf.NewFile(bytes.NewReader(withoutSections(page)))
+		if err != nil {
+			return ""
+		}
+		return buildID(f)
+	}
+	return ""
+}
+
+// withoutSections returns a copy of the ELF header and what follows in
+// data with the section header table removed from the header.
+func withoutSections(data []byte) []byte {
+	data = append([]byte{}, data...)
+	if len(data) < 64 {
+		return data
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if elf.Data(data[elf.EI_DATA]) == elf.ELFDATA2MSB {
+		order = binary.BigEndian
+	}
+	// e_shoff, then e_shnum and e_shstrndx.
+	shoff, shnum := 40, 60
+	if elf.Class(data[elf.EI_CLASS]) == elf.ELFCLASS32 {
+		shoff, shnum = 32, 48
+		order.PutUint32(data[shoff:], 0)
+	} else {
+		order.PutUint64(data[shoff:], 0)
+	}
+	order.PutUint16(data[shnum:], 0)
+	order.PutUint16(data[shnum+2:], 0)
+	return data
+}
+
+// buildID returns the hex GNU build ID of f, from its PT_NOTE segments, or
+// "" if it has none which can be read.
+func buildID(f *elf.File) string {
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_NOTE {
+			continue
+		}
+		notes, err := readNotes(prog.Open(), f.ByteOrder)
+		if err != nil {
+			continue
+		}
+		for _, n := range notes {
+			if n.name == "GNU" && n.typ == ntGNUBuildID {
+				return hex.EncodeToString(n.desc)
+			}
+		}
+	}
+	return ""
+}
+
+// FileBuildID returns the hex GNU build ID of the ELF object at path, or ""
+// if it has none.
+func FileBuildID(path string) (string, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return buildID(f), nil
+}
+
+type note struct {
+	name string
+	typ  uint32
+	desc []byte
+}
+
+// readNotes decodes the ELF notes in r: a name size, description size and
+// type, then the name and description, each padded to four bytes.
+func readNotes(r io.Reader, order binary.ByteOrder) ([]note, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	pad := func(n uint32) uint64 { return (uint64(n) + 3) &^ 3 }
+	var notes []note
+	for len(data) >= 12 {
+		namesz, descsz := order.Uint32(data), order.Uint32(data[4:])
+		n := note{typ: order.Uint32(data[8:])}
+		data = data[12:]
+		if pad(namesz)+pad(descsz) > uint64(len(data)) {
+			return notes, io.ErrUnexpectedEOF
+		}
+		n.name = string(bytes.TrimRight(data[:namesz], "\x00"))
+		data = data[pad(namesz):]
+		n.desc = data[:descsz]
+		data = data[pad(descsz):]
+		notes = append(notes, n)
+	}
+	return notes, nil
+}
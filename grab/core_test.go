@@ -0,0 +1,67 @@
+package grab
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// appendNote appends an ELF note, as a kernel or linker writes it.
+func appendNote(b []byte, name string, typ uint32, desc []byte) []byte {
+	order := binary.LittleEndian
+	b = order.AppendUint32(b, uint32(len(name)+1))
+	b = order.AppendUint32(b, uint32(len(desc)))
+	b = order.AppendUint32(b, typ)
+	b = append(b, name...)
+	for b = append(b, 0); len(b)%4 != 0; {
+		b = append(b, 0)
+	}
+	for b = append(b, desc...); len(b)%4 != 0; {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func TestParseNTFile(t *testing.T) {
+	order := binary.LittleEndian
+	var desc []byte
+	desc = order.AppendUint64(desc, 4)      // Count
+	desc = order.AppendUint64(desc, 0x1000) // Page size
+	for _, m := range [][3]uint64{
+		{0x5500_0000, 0x5500_2000, 0},
+		{0x5500_2000, 0x5500_4000, 2},
+		{0x7f00_0000, 0x7f01_0000, 0},
+		{0x7f10_0000, 0x7f10_1000, 0},
+	} {
+		for _, v := range m {
+			desc = order.AppendUint64(desc, v)
+		}
+	}
+	desc = append(desc, "/usr/bin/app\x00/usr/bin/app\x00/lib/libc.so.6\x00/lib/ld-linux-x86-64.so.2\x00"...)
+
+	notes, err := readNotes(bytes.NewReader(appendNote(appendNote(nil, "CORE", 1, []byte("prstatus")), "CORE", ntFile, desc)), order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 2 || notes[1].name != "CORE" || notes[1].typ != ntFile {
+		t.Fatalf("notes %+v", notes)
+	}
+	got, err := parseNTFile(notes[1].desc, elf.ELFCLASS64, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []CoreMapping{
+		{Path: "/usr/bin/app", Start: 0x5500_0000},
+		{Path: "/lib/libc.so.6", Start: 0x7f00_0000},
+		{Path: "/lib/ld-linux-x86-64.so.2", Start: 0x7f10_0000},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mappings %+v; want %+v", got, want)
+	}
+
+	if _, err := parseNTFile(desc[:40], elf.ELFCLASS64, order); err == nil {
+		t.Errorf("parsed a truncated note")
+	}
+}
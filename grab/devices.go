@@ -0,0 +1,80 @@
+package grab
+
+import (
+	"path"
+	"strings"
+)
+
+// DeviceRequirement is the device access which a library implies the
+// binaries need at runtime, for example to pass on as --device and
+// --group-add flags.
+type DeviceRequirement struct {
+	Library      string   `json:"library"`
+	Devices      []string `json:"devices,omitempty"`
+	Groups       []string `json:"groups,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// deviceLibraries maps soname patterns to the access they imply.
+var deviceLibraries = []struct {
+	pattern string
+	DeviceRequirement
+}{
+	{"libdrm.so.*", DeviceRequirement{Devices: []string{"/dev/dri"}, Groups: []string{"video", "render"}}},
+	{"libgbm.so.*", DeviceRequirement{Devices: []string{"/dev/dri"}, Groups: []string{"video", "render"}}},
+	{"libva.so.*", DeviceRequirement{Devices: []string{"/dev/dri"}, Groups: []string{"video", "render"}}},
+	{"libcuda.so.*", DeviceRequirement{Devices: []string{"/dev/nvidiactl", "/dev/nvidia-uvm", "/dev/nvidia0"}}},
+	{"libnvidia-ml.so.*", DeviceRequirement{Devices: []string{"/dev/nvidiactl", "/dev/nvidia0"}}},
+	{"libusb-1.0.so.*", DeviceRequirement{Devices: []string{"/dev/bus/usb"}, Groups: []string{"plugdev"}}},
+	{"libusb-0.1.so.*", DeviceRequirement{Devices: []string{"/dev/bus/usb"}, Groups: []string{"plugdev"}}},
+	{"libpcap.so.*", DeviceRequirement{Capabilities: []string{"CAP_NET_ADMIN", "CAP_NET_RAW"}}},
+	{"libasound.so.*", DeviceRequirement{Devices: []string{"/dev/snd"}, Groups: []string{"audio"}}},
+	{"libv4l2.so.*", DeviceRequirement{Devices: []string{"/dev/video0"}, Groups: []string{"video"}}},
+	{"libinput.so.*", DeviceRequirement{Devices: []string{"/dev/input"}, Groups: []string{"input"}}},
+	{"libfuse.so.*", DeviceRequirement{Devices: []string{"/dev/fuse"}, Capabilities: []string{"CAP_SYS_ADMIN"}}},
+	{"libfuse3.so.*", DeviceRequirement{Devices: []string{"/dev/fuse"}, Capabilities: []string{"CAP_SYS_ADMIN"}}},
+	{"libserialport.so.*", DeviceRequirement{Devices: []string{"/dev/ttyS0", "/dev/ttyUSB0"}, Groups: []string{"dialout"}}},
+}
+
+// DeviceRequirements returns the device access implied by the libraries g
+// needs, in soname order.
+func (g *Graph) DeviceRequirements() []DeviceRequirement {
+	var reqs []DeviceRequirement
+	for _, lib := range SortedSet(g.Libraries()) {
+		for _, dl := range deviceLibraries {
+			if ok, _ := path.Match(dl.pattern, lib); ok {
+				req := dl.DeviceRequirement
+				req.Library = lib
+				reqs = append(reqs, req)
+				break
+			}
+		}
+	}
+	return reqs
+}
+
+// DeviceAnnotations summarises reqs as OCI annotations: comma-separated
+// device nodes, groups and capabilities.
+func DeviceAnnotations(reqs []DeviceRequirement) map[string]string {
+	union := func(get func(DeviceRequirement) []string) string {
+		set := map[string]struct{}{}
+		for _, req := range reqs {
+			for _, s := range get(req) {
+				set[s] = struct{}{}
+			}
+		}
+		return strings.Join(SortedSet(set), ",")
+	}
+
+	annotations := map[string]string{}
+	for key, value := range map[string]string{
+		AnnotationPrefix + "devices":      union(func(r DeviceRequirement) []string { return r.Devices }),
+		AnnotationPrefix + "groups":       union(func(r DeviceRequirement) []string { return r.Groups }),
+		AnnotationPrefix + "capabilities": union(func(r DeviceRequirement) []string { return r.Capabilities }),
+	} {
+		if value != "" {
+			annotations[key] = value
+		}
+	}
+	return annotations
+}
@@ -0,0 +1,87 @@
+package grab
+
+import "fmt"
+
+// DiagID is the stable identifier of a kind of diagnostic. IDs never change
+// meaning or get reused, so tools and suppression rules can match on them
+// rather than on message wording.
+type DiagID string
+
+const (
+	DiagMissingLibrary  DiagID = "GLB0001" // A needed library was not found
+	DiagArchMismatch    DiagID = "GLB0002" // A candidate library was skipped for its ELF class or machine
+	DiagLibcMismatch    DiagID = "GLB0003" // The binaries and the base use different libcs
+	DiagSkippedFile     DiagID = "GLB0004" // A file given to -add is not a regular file, so was skipped
+	DiagVersionConflict DiagID = "GLB0005" // The library found lacks symbol versions its dependents need
+	DiagUnreadable      DiagID = "GLB0006" // An object could not be read, so its dependencies came from package metadata
+	DiagGlibcTooNew     DiagID = "GLB0007" // An object needs newer glibc symbol versions than the target has
+	DiagBuildIDMismatch DiagID = "GLB0008" // A file differs from the one a core dump mapped
+	DiagUndefinedSymbol DiagID = "GLB0009" // No object defines a symbol another needs
+	DiagSonameConflict  DiagID = "GLB0010" // Binaries resolve one soname to different files
+)
+
+// diagMessages holds the message format for each DiagID, taking the
+// diagnostic's arguments. Translations would be further catalogs of the same
+// shape.
+var diagMessages = map[DiagID]string{
+	DiagMissingLibrary:  "library not found",
+	DiagArchMismatch:    "skipped: is %v %v, but %s needs %v %v",
+	DiagLibcMismatch:    "%s",
+	DiagSkippedFile:     "skipped: is a %s",
+	DiagVersionConflict: "%s lacks version %s; %s",
+	DiagUnreadable:      "unreadable, so the libraries it needs are from its package's metadata",
+	DiagGlibcTooNew:     "needs %s, newer than the target's glibc %s",
+	DiagBuildIDMismatch: "has build ID %s, but the core dump mapped build ID %s",
+	DiagUndefinedSymbol: "undefined symbol %s, which no object defines",
+	DiagSonameConflict:  "%s (needed by %s) conflicts with %s (needed by %s), which is kept",
+}
+
+// Diagnostic is a problem found while grabbing, about Subject (a library
+// or path).
+type Diagnostic struct {
+	ID      DiagID `json:"id"`
+	Subject string `json:"subject"`
+	Message string `json:"message"`
+}
+
+// NewDiagnostic formats the message for id with args.
+func NewDiagnostic(id DiagID, subject string, args ...interface{}) Diagnostic {
+	return Diagnostic{ID: id, Subject: subject, Message: fmt.Sprintf(diagMessages[id], args...)}
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s %s: %s", d.ID, d.Subject, d.Message)
+}
+
+// Error makes a Diagnostic usable as an error.
+func (d Diagnostic) Error() string {
+	return d.String()
+}
+
+// diagnose records d in r.Diagnostics, once, and logs it.
+func (r *Resolver) diagnose(d Diagnostic) {
+	if r.diagnosed(d) {
+		return
+	}
+	r.Diagnostics = append(r.Diagnostics, d)
+	r.logf("%s", d)
+}
+
+// diagnosed reports whether d has already been recorded.
+func (r *Resolver) diagnosed(d Diagnostic) bool {
+	for _, seen := range r.Diagnostics {
+		if seen == d {
+			return true
+		}
+	}
+	return false
+}
+
+// MissingDiagnostics returns a DiagMissingLibrary for each of g.Missing().
+func (g *Graph) MissingDiagnostics() []Diagnostic {
+	var diags []Diagnostic
+	for _, lib := range g.Missing() {
+		diags = append(diags, NewDiagnostic(DiagMissingLibrary, lib))
+	}
+	return diags
+}
@@ -0,0 +1,93 @@
+package grab
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// WriteDir materializes files into the directory dest, as extracting the tar
+// WriteFiles produces would, and returns the total bytes read from disk.
+// Parent directories are created, and modes, modification times, symlinks
+// and extended attributes are preserved. Existing files are replaced. Of
+// opts, which may be nil, only Progress and SELinux apply.
+func WriteDir(dest string, files []File, opts *TarOptions) (int64, error) {
+	var total int64
+	for _, file := range files {
+		n, err := writeDirFile(dest, file, opts != nil && opts.SELinux)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if opts != nil && opts.Progress != nil {
+			opts.Progress(file, n)
+		}
+	}
+	return total, nil
+}
+
+func writeDirFile(dest string, file File, selinux bool) (int64, error) {
+	target, err := extractPath(dest, file.Name)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return 0, err
+	}
+	if fi, err := os.Lstat(target); err == nil && !fi.IsDir() {
+		if err := os.Remove(target); err != nil {
+			return 0, err
+		}
+	}
+
+	if file.Link != "" {
+		return 0, os.Symlink(file.Link, target)
+	}
+
+	fi, err := file.stat()
+	if err != nil {
+		return 0, err
+	}
+	if file.Special {
+		var major, minor uint32
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			major, minor = unix.Major(uint64(st.Rdev)), unix.Minor(uint64(st.Rdev))
+		}
+		if err := mknod(target, fi.Mode(), major, minor); err != nil {
+			return 0, err
+		}
+		return 0, os.Chtimes(target, fi.ModTime(), fi.ModTime())
+	}
+	in, err := os.Open(file.Path)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return n, err
+	}
+	if err := out.Close(); err != nil {
+		return n, err
+	}
+	if err := os.Chmod(target, fi.Mode().Perm()); err != nil {
+		return n, err
+	}
+	attrs, err := xattrs(file.Path, selinux)
+	if err != nil {
+		return n, err
+	}
+	if err := setXattrs(target, attrs); err != nil {
+		return n, err
+	}
+	return n, os.Chtimes(target, fi.ModTime(), fi.ModTime())
+}
@@ -0,0 +1,34 @@
+package grab
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteDir(t *testing.T) {
+	src := t.TempDir()
+	lib := filepath.Join(src, "libfoo.so.1.2")
+	if err := ioutil.WriteFile(lib, []byte("foo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := t.TempDir()
+	_, err := WriteDir(dest, []File{
+		{Path: lib, Name: "usr/lib/libfoo.so.1", Link: "libfoo.so.1.2"},
+		{Path: lib, Name: "usr/lib/libfoo.so.1.2"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dest, "usr/lib/libfoo.so.1"))
+	if err != nil || string(data) != "foo" {
+		t.Errorf("libfoo.so.1: got %q, %v", data, err)
+	}
+	fi, err := os.Stat(filepath.Join(dest, "usr/lib/libfoo.so.1.2"))
+	if err != nil || fi.Mode().Perm() != 0755 {
+		t.Errorf("libfoo.so.1.2: got %v, %v", fi, err)
+	}
+}
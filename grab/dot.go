@@ -0,0 +1,55 @@
+package grab
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// WriteDOT writes g to w in Graphviz DOT format. Nodes are the roots and
+// sonames, labelled with the files they resolved to, and edges are DT_NEEDED
+// entries. Interpreters are drawn as ellipses, libraries provided by the base
+// dashed and missing libraries in red.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph dependencies {")
+	fmt.Fprintln(bw, "\tnode [shape=box];")
+
+	var nodes []string
+	for node := range g.Edges {
+		nodes = append(nodes, node)
+	}
+	for lib := range g.Libraries() {
+		if _, ok := g.Edges[lib]; !ok {
+			nodes = append(nodes, lib)
+		}
+	}
+	sort.Strings(nodes)
+
+	for _, node := range nodes {
+		var attrs []string
+		switch path, ok := g.Resolved[node]; {
+		case contains(g.Roots, node):
+			attrs = append(attrs, "style=bold")
+		case contains(g.Interps, node):
+			attrs = append(attrs, "shape=ellipse")
+		case g.Provided[node]:
+			attrs = append(attrs, "style=dashed")
+		case ok:
+			attrs = append(attrs, fmt.Sprintf("label=%q", node+"\n"+path))
+		default:
+			attrs = append(attrs, "color=red")
+		}
+		fmt.Fprintf(bw, "\t%q [%s];\n", node, strings.Join(attrs, " "))
+	}
+	for _, node := range nodes {
+		for _, dep := range g.Edges[node] {
+			fmt.Fprintf(bw, "\t%q -> %q;\n", node, dep)
+		}
+	}
+
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
@@ -0,0 +1,309 @@
+package grab
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ELFReaders are the supported values for Resolver.ELFReader: debug-elf
+// reads objects with debug/elf, which loads their section headers and whole
+// dynamic sections; stream reads only the program headers and the entries
+// of the dynamic segment it needs, keeping memory flat however large the
+// file; auto streams files of streamThreshold bytes or more.
+var ELFReaders = []string{"auto", "debug-elf", "stream"}
+
+// streamThreshold is the size from which the auto ELF reader streams.
+const streamThreshold = 256 << 20
+
+// CheckELFReader returns an error if reader is not one of ELFReaders. Empty
+// means auto.
+func CheckELFReader(reader string) error {
+	if reader == "" {
+		return nil
+	}
+	for _, r := range ELFReaders {
+		if reader == r {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown ELF reader %q, want one of %s",
+		reader, strings.Join(ELFReaders, ", "))
+}
+
+// streams reports whether r.ELFReader chooses to stream a file of size
+// bytes.
+func (r *Resolver) streams(size int64) bool {
+	switch r.ELFReader {
+	case "stream":
+		return true
+	case "debug-elf":
+		return false
+	}
+	return size >= streamThreshold
+}
+
+// maxStringLen bounds the strings read from a streamed dynamic string table.
+const maxStringLen = 64 << 10
+
+// dynamicReader reads an ELF file's dynamic segment piecemeal with ReadAt.
+type dynamicReader struct {
+	r     io.ReaderAt
+	order binary.ByteOrder
+	is64  bool
+	loads []elf.ProgHeader // PT_LOAD, to map addresses to file offsets
+
+	strtab, strsz uint64 // File offset and size of the string table
+}
+
+// readDynamic reads the object in r as loadObject does with debug/elf, but
+// touching only the ELF header, the program headers, the dynamic segment
+// and the strings and version requirements it refers to. The search paths
+// are returned as in the file, without $ORIGIN expanded.
+func readDynamic(r io.ReaderAt) (*object, error) {
+	var ident [elf.EI_NIDENT]byte
+	if _, err := r.ReadAt(ident[:], 0); err != nil {
+		return nil, err
+	}
+	if string(ident[:4]) != elf.ELFMAG {
+		return nil, fmt.Errorf("bad magic number %q", ident[:4])
+	}
+	d := &dynamicReader{r: r}
+	switch elf.Data(ident[elf.EI_DATA]) {
+	case elf.ELFDATA2LSB:
+		d.order = binary.LittleEndian
+	case elf.ELFDATA2MSB:
+		d.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("unknown ELF data encoding %d", ident[elf.EI_DATA])
+	}
+	class := elf.Class(ident[elf.EI_CLASS])
+	switch class {
+	case elf.ELFCLASS32:
+	case elf.ELFCLASS64:
+		d.is64 = true
+	default:
+		return nil, fmt.Errorf("unknown ELF class %d", class)
+	}
+
+	header := make([]byte, 64)
+	if _, err := r.ReadAt(header, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	machine := elf.Machine(d.order.Uint16(header[18:]))
+	var phoff uint64
+	var phentsize, phnum int
+	if d.is64 {
+		phoff = d.order.Uint64(header[32:])
+		phentsize, phnum = int(d.order.Uint16(header[54:])), int(d.order.Uint16(header[56:]))
+	} else {
+		phoff = uint64(d.order.Uint32(header[28:]))
+		phentsize, phnum = int(d.order.Uint16(header[42:])), int(d.order.Uint16(header[44:]))
+	}
+	if phnum == 0xffff {
+		return nil, fmt.Errorf("too many program headers to stream")
+	}
+
+	obj := &object{class: class, machine: machine}
+	obj.arch = ArchName(&elf.File{FileHeader: elf.FileHeader{
+		Class: class, Data: elf.Data(ident[elf.EI_DATA]), Machine: machine,
+	}})
+
+	var progs []elf.ProgHeader
+	phdrs := make([]byte, phentsize*phnum)
+	if _, err := r.ReadAt(phdrs, int64(phoff)); err != nil {
+		return nil, fmt.Errorf("program headers: %v", err)
+	}
+	for i := 0; i < phnum; i++ {
+		progs = append(progs, d.progHeader(phdrs[i*phentsize:]))
+	}
+
+	var dynamic *elf.ProgHeader
+	for i, prog := range progs {
+		switch prog.Type {
+		case elf.PT_LOAD:
+			d.loads = append(d.loads, prog)
+		case elf.PT_DYNAMIC:
+			dynamic = &progs[i]
+		case elf.PT_INTERP:
+			interp := make([]byte, prog.Filesz)
+			if _, err := r.ReadAt(interp, int64(prog.Off)); err != nil {
+				return nil, fmt.Errorf("PT_INTERP: %v", err)
+			}
+			obj.interp = strings.TrimRight(string(interp), "\x00")
+		}
+	}
+	if dynamic == nil {
+		// Statically linked.
+		return obj, nil
+	}
+
+	var needed, rpath, runpath []uint64
+	var verneed, verneednum uint64
+	entsize := 8
+	if d.is64 {
+		entsize = 16
+	}
+	entry := make([]byte, entsize)
+	for off := uint64(0); off+uint64(entsize) <= dynamic.Filesz; off += uint64(entsize) {
+		if _, err := r.ReadAt(entry, int64(dynamic.Off+off)); err != nil {
+			return nil, fmt.Errorf("PT_DYNAMIC: %v", err)
+		}
+		var tag elf.DynTag
+		var val uint64
+		if d.is64 {
+			tag, val = elf.DynTag(d.order.Uint64(entry)), d.order.Uint64(entry[8:])
+		} else {
+			tag, val = elf.DynTag(int32(d.order.Uint32(entry))), uint64(d.order.Uint32(entry[4:]))
+		}
+		switch tag {
+		case elf.DT_NULL:
+			off = dynamic.Filesz
+		case elf.DT_NEEDED:
+			needed = append(needed, val)
+		case elf.DT_RPATH:
+			rpath = append(rpath, val)
+		case elf.DT_RUNPATH:
+			runpath = append(runpath, val)
+		case elf.DT_STRTAB:
+			strtab, err := d.offset(val)
+			if err != nil {
+				return nil, fmt.Errorf("DT_STRTAB: %v", err)
+			}
+			d.strtab = strtab
+		case elf.DT_STRSZ:
+			d.strsz = val
+		case elf.DT_VERNEED:
+			verneed = val
+		case elf.DT_VERNEEDNUM:
+			verneednum = val
+		}
+	}
+
+	strs := func(offsets []uint64) ([]string, error) {
+		var out []string
+		for _, offset := range offsets {
+			s, err := d.string(offset)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, s)
+		}
+		return out, nil
+	}
+	var err error
+	if obj.needed, err = strs(needed); err != nil {
+		return nil, err
+	}
+	if obj.rpath, err = strs(rpath); err != nil {
+		return nil, err
+	}
+	if obj.runpath, err = strs(runpath); err != nil {
+		return nil, err
+	}
+	if verneed != 0 {
+		if obj.versionNeeds, err = d.versionNeeds(verneed, verneednum); err != nil {
+			return nil, err
+		}
+	}
+	return obj, nil
+}
+
+// progHeader decodes the program header at the start of b.
+func (d *dynamicReader) progHeader(b []byte) elf.ProgHeader {
+	if d.is64 {
+		return elf.ProgHeader{
+			Type:   elf.ProgType(d.order.Uint32(b)),
+			Off:    d.order.Uint64(b[8:]),
+			Vaddr:  d.order.Uint64(b[16:]),
+			Filesz: d.order.Uint64(b[32:]),
+		}
+	}
+	return elf.ProgHeader{
+		Type:   elf.ProgType(d.order.Uint32(b)),
+		Off:    uint64(d.order.Uint32(b[4:])),
+		Vaddr:  uint64(d.order.Uint32(b[8:])),
+		Filesz: uint64(d.order.Uint32(b[16:])),
+	}
+}
+
+// offset returns the file offset of the virtual address addr.
+func (d *dynamicReader) offset(addr uint64) (uint64, error) {
+	for _, load := range d.loads {
+		if addr >= load.Vaddr && addr-load.Vaddr < load.Filesz {
+			return addr - load.Vaddr + load.Off, nil
+		}
+	}
+	return 0, fmt.Errorf("address %#x is in no PT_LOAD segment", addr)
+}
+
+// string returns the NUL-terminated string at offset in the string table.
+func (d *dynamicReader) string(offset uint64) (string, error) {
+	if offset >= d.strsz {
+		return "", fmt.Errorf("string offset %d beyond the %d byte table", offset, d.strsz)
+	}
+	var s []byte
+	buf := make([]byte, 256)
+	for pos := d.strtab + offset; len(s) < maxStringLen; pos += uint64(len(buf)) {
+		n, err := d.r.ReadAt(buf, int64(pos))
+		if i := bytes.IndexByte(buf[:n], 0); i >= 0 {
+			return string(append(s, buf[:i]...)), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		s = append(s, buf[:n]...)
+	}
+	return "", fmt.Errorf("string at offset %d is longer than %d bytes", offset, maxStringLen)
+}
+
+// versionNeeds reads the count Verneed entries at the address addr, as
+// versionNeeds does from debug/elf.
+func (d *dynamicReader) versionNeeds(addr, count uint64) (map[string][]string, error) {
+	off, err := d.offset(addr)
+	if err != nil {
+		return nil, fmt.Errorf("DT_VERNEED: %v", err)
+	}
+	byLib := map[string][]string{}
+	need := make([]byte, 16) // Elf32_Verneed and Elf64_Verneed are alike
+	for i := uint64(0); i < count; i++ {
+		if _, err := d.r.ReadAt(need, int64(off)); err != nil {
+			return nil, fmt.Errorf("DT_VERNEED: %v", err)
+		}
+		cnt := d.order.Uint16(need[2:])
+		lib, err := d.string(uint64(d.order.Uint32(need[4:])))
+		if err != nil {
+			return nil, err
+		}
+
+		aux := make([]byte, 16) // As are the Vernaux entries
+		auxOff := off + uint64(d.order.Uint32(need[8:]))
+		for j := uint16(0); j < cnt; j++ {
+			if _, err := d.r.ReadAt(aux, int64(auxOff)); err != nil {
+				return nil, fmt.Errorf("DT_VERNEED: %v", err)
+			}
+			if d.order.Uint16(aux[4:])&uint16(elf.VER_FLG_WEAK) == 0 {
+				version, err := d.string(uint64(d.order.Uint32(aux[8:])))
+				if err != nil {
+					return nil, err
+				}
+				byLib[lib] = append(byLib[lib], version)
+			}
+			next := d.order.Uint32(aux[12:])
+			if next == 0 {
+				break
+			}
+			auxOff += uint64(next)
+		}
+
+		next := d.order.Uint32(need[12:])
+		if next == 0 {
+			break
+		}
+		off += uint64(next)
+	}
+	return byLib, nil
+}
@@ -0,0 +1,60 @@
+package grab
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadDynamic(t *testing.T) {
+	paths, _ := filepath.Glob("/lib/x86_64-linux-gnu/lib[cmz].so.*")
+	paths = append(paths, "/bin/true", "/bin/ls", "/lib64/ld-linux-x86-64.so.2")
+	if exe, err := os.Executable(); err == nil {
+		paths = append(paths, exe)
+	}
+
+	for _, path := range paths {
+		fd, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		want, err := readObject(fd)
+		if err != nil {
+			fd.Close()
+			t.Errorf("readObject(%s): %v", path, err)
+			continue
+		}
+		got, err := readDynamic(fd)
+		fd.Close()
+		if err != nil {
+			t.Errorf("readDynamic(%s): %v", path, err)
+			continue
+		}
+		// Compared as printed, since empty and nil slices and maps are
+		// alike to the resolver.
+		if fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", want) {
+			t.Errorf("readDynamic(%s) = %+v; want %+v", path, got, want)
+		}
+	}
+}
+
+func TestResolveStreaming(t *testing.T) {
+	graphs := map[string]*Graph{}
+	for _, reader := range []string{"debug-elf", "stream"} {
+		r, err := NewResolver()
+		if err != nil {
+			t.Skip(err)
+		}
+		r.ELFReader = reader
+		if graphs[reader], err = r.Resolve("/bin/ls"); err != nil {
+			t.Fatalf("%s: %v", reader, err)
+		}
+	}
+	if !reflect.DeepEqual(graphs["stream"], graphs["debug-elf"]) {
+		t.Errorf("streamed graph %+v differs from %+v", graphs["stream"], graphs["debug-elf"])
+	}
+}
@@ -0,0 +1,174 @@
+package grab
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ExtractOptions control Extract. The zero value is the default behaviour.
+type ExtractOptions struct {
+	// StripComponents removes this many leading path components from each
+	// entry name, skipping entries with no more than that.
+	StripComponents int
+}
+
+// Extract unpacks the tar stream r into the directory dest, which is created
+// if need be. Entries may not escape dest: names containing "..", and writes
+// through symlinks which lead outside of dest, are rejected, as are hard
+// links to files outside of it. Modes, modification times, symlinks, hard
+// links and extended attributes (including file capabilities) are preserved.
+func Extract(r io.Reader, dest string, opts *ExtractOptions) error {
+	if opts == nil {
+		opts = &ExtractOptions{}
+	}
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name, err := entryName(hdr.Name, opts.StripComponents)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			continue
+		}
+		target, err := extractPath(dest, name)
+		if err != nil {
+			return err
+		}
+
+		if err := extractEntry(tr, hdr, dest, name, target, opts); err != nil {
+			return fmt.Errorf("%s: %v", hdr.Name, err)
+		}
+	}
+}
+
+// entryName returns the cleaned relative name of a tar entry with strip
+// leading components removed, or "" if nothing remains of it. Names which
+// would escape the destination are an error.
+func entryName(name string, strip int) (string, error) {
+	for _, component := range strings.Split(name, "/") {
+		if component == ".." {
+			return "", fmt.Errorf("%s: path traversal in archive entry", name)
+		}
+	}
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+
+	parts := strings.Split(name, "/")
+	if name == "" || len(parts) <= strip {
+		return "", nil
+	}
+	return path.Join(parts[strip:]...), nil
+}
+
+// extractPath returns the host path for name within dest, resolving any
+// symlinks in its parent directories inside dest.
+func extractPath(dest, name string) (string, error) {
+	dir, err := InRoot(dest, path.Dir("/"+name))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, path.Base(name)), nil
+}
+
+// extractEntry extracts the entry hdr, named name within dest, to the host
+// path target.
+func extractEntry(tr *tar.Reader, hdr *tar.Header, dest, name, target string, opts *ExtractOptions) error {
+	mode := os.FileMode(hdr.Mode).Perm()
+
+	if hdr.Typeflag == tar.TypeDir {
+		// An earlier entry may have put a symlink here, which MkdirAll and
+		// Chmod would follow out of dest.
+		fi, err := os.Lstat(target)
+		switch {
+		case os.IsNotExist(err):
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Mkdir(target, 0755); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case !fi.IsDir():
+			return fmt.Errorf("%s exists and is not a directory", name)
+		}
+		return os.Chmod(target, mode)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	// Replace whatever is there, rather than writing through a symlink.
+	if fi, err := os.Lstat(target); err == nil && !fi.IsDir() {
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeRegA:
+		fd, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(fd, tr); err != nil {
+			fd.Close()
+			return err
+		}
+		if err := fd.Close(); err != nil {
+			return err
+		}
+		// The umask applies at creation.
+		if err := os.Chmod(target, mode); err != nil {
+			return err
+		}
+
+	case tar.TypeSymlink:
+		return os.Symlink(hdr.Linkname, target)
+
+	case tar.TypeLink:
+		linked, err := entryName(hdr.Linkname, opts.StripComponents)
+		if err != nil {
+			return err
+		}
+		if linked == "" {
+			return fmt.Errorf("hard link target %s is stripped", hdr.Linkname)
+		}
+		source, err := extractPath(dest, linked)
+		if err != nil {
+			return err
+		}
+		if err := os.Link(source, target); err != nil {
+			return err
+		}
+
+	case tar.TypeFifo, tar.TypeChar, tar.TypeBlock:
+		if err := mknod(target, hdr.FileInfo().Mode(), uint32(hdr.Devmajor), uint32(hdr.Devminor)); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("unsupported entry type %q", hdr.Typeflag)
+	}
+
+	if err := setXattrs(target, xattrRecords(hdr)); err != nil {
+		return err
+	}
+	return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+}
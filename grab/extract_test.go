@@ -0,0 +1,203 @@
+package grab
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testTar(t *testing.T, headers ...*tar.Header) *bytes.Buffer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range headers {
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(hdr.Name))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte(hdr.Name))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestExtract(t *testing.T) {
+	dest := t.TempDir()
+	outside := t.TempDir()
+
+	err := Extract(testTar(t,
+		&tar.Header{Typeflag: tar.TypeReg, Name: "bundle/app", Mode: 0755},
+		&tar.Header{Typeflag: tar.TypeSymlink, Name: "bundle/escape", Linkname: outside},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "bundle/escape/passwd", Mode: 0644},
+		&tar.Header{Typeflag: tar.TypeLink, Name: "bundle/app2", Linkname: "bundle/app"},
+	), dest, &ExtractOptions{StripComponents: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := ioutil.ReadFile(filepath.Join(dest, "app2")); err != nil || string(data) != "bundle/app" {
+		t.Errorf("app2: got %q, %v", data, err)
+	}
+	if fi, err := os.Stat(filepath.Join(dest, "app")); err != nil || fi.Mode().Perm() != 0755 {
+		t.Errorf("app: got %v, %v", fi, err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "passwd")); err == nil {
+		t.Errorf("wrote through a symlink outside of dest")
+	}
+	if _, err := os.Stat(filepath.Join(dest, outside, "passwd")); err != nil {
+		t.Errorf("symlinked write was not kept within dest: %v", err)
+	}
+
+	err = Extract(testTar(t,
+		&tar.Header{Typeflag: tar.TypeReg, Name: "../evil", Mode: 0644},
+	), dest, nil)
+	if err == nil {
+		t.Errorf("path traversal extracted without error")
+	}
+}
+
+func TestExtractDirOverSymlink(t *testing.T) {
+	dest := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Chmod(outside, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	err := Extract(testTar(t,
+		&tar.Header{Typeflag: tar.TypeSymlink, Name: "escape", Linkname: outside},
+		&tar.Header{Typeflag: tar.TypeDir, Name: "escape/", Mode: 0777},
+	), dest, nil)
+	if err == nil {
+		t.Error("extracted a directory over a symlink")
+	}
+	if fi, err := os.Stat(outside); err != nil || fi.Mode().Perm() != 0700 {
+		t.Errorf("directory outside of dest: got %v, %v; want mode 0700", fi, err)
+	}
+}
+
+func TestExtractEscapes(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		// headers name the directory outside of dest, which holds the
+		// file secret, as $OUTSIDE.
+		headers []*tar.Header
+		wantErr bool
+		// want maps names within dest to what they hold: file contents, or
+		// "-> target" for symlinks.
+		want map[string]string
+	}{
+		{
+			name:    "dotdot",
+			headers: []*tar.Header{{Typeflag: tar.TypeReg, Name: "../secret", Mode: 0644}},
+			wantErr: true,
+		},
+		{
+			name:    "inner dotdot",
+			headers: []*tar.Header{{Typeflag: tar.TypeReg, Name: "a/../../secret", Mode: 0644}},
+			wantErr: true,
+		},
+		{
+			name:    "absolute",
+			headers: []*tar.Header{{Typeflag: tar.TypeReg, Name: "$OUTSIDE/secret", Mode: 0644}},
+			want:    map[string]string{"$OUTSIDE/secret": "$OUTSIDE/secret"},
+		},
+		{
+			name: "relative symlink",
+			headers: []*tar.Header{
+				{Typeflag: tar.TypeSymlink, Name: "a/up", Linkname: "../../../.."},
+				{Typeflag: tar.TypeReg, Name: "a/up/secret", Mode: 0644},
+			},
+			want: map[string]string{"a/up": "-> ../../../..", "secret": "a/up/secret"},
+		},
+		{
+			name:    "hard link outside",
+			headers: []*tar.Header{{Typeflag: tar.TypeLink, Name: "secret", Linkname: "$OUTSIDE/secret"}},
+			wantErr: true,
+		},
+		{
+			name: "hard link through symlink",
+			headers: []*tar.Header{
+				{Typeflag: tar.TypeSymlink, Name: "escape", Linkname: "$OUTSIDE"},
+				{Typeflag: tar.TypeLink, Name: "secret", Linkname: "escape/secret"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "file twice",
+			headers: []*tar.Header{
+				{Typeflag: tar.TypeReg, Name: "f", Mode: 0644},
+				{Typeflag: tar.TypeReg, Name: "./f", Mode: 0644},
+			},
+			want: map[string]string{"f": "./f"},
+		},
+		{
+			name: "file replaces symlink",
+			headers: []*tar.Header{
+				{Typeflag: tar.TypeSymlink, Name: "secret", Linkname: "$OUTSIDE/secret"},
+				{Typeflag: tar.TypeReg, Name: "secret", Mode: 0644},
+			},
+			want: map[string]string{"secret": "secret"},
+		},
+		{
+			name: "symlink replaces file",
+			headers: []*tar.Header{
+				{Typeflag: tar.TypeReg, Name: "f", Mode: 0644},
+				{Typeflag: tar.TypeSymlink, Name: "f", Linkname: "g"},
+			},
+			want: map[string]string{"f": "-> g"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dest := t.TempDir()
+			outside := t.TempDir()
+			secret := filepath.Join(outside, "secret")
+			if err := ioutil.WriteFile(secret, []byte("secret"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			var headers []*tar.Header
+			for _, hdr := range test.headers {
+				hdr := *hdr
+				hdr.Name = strings.ReplaceAll(hdr.Name, "$OUTSIDE", outside)
+				hdr.Linkname = strings.ReplaceAll(hdr.Linkname, "$OUTSIDE", outside)
+				headers = append(headers, &hdr)
+			}
+			err := Extract(testTar(t, headers...), dest, nil)
+			if test.wantErr != (err != nil) {
+				t.Errorf("error %v; want error %v", err, test.wantErr)
+			}
+			if data, err := ioutil.ReadFile(secret); err != nil || string(data) != "secret" {
+				t.Errorf("file outside of dest: got %q, %v", data, err)
+			}
+			if entries, _ := os.ReadDir(outside); len(entries) != 1 {
+				t.Errorf("wrote %d files outside of dest", len(entries)-1)
+			}
+			for name, want := range test.want {
+				name = strings.ReplaceAll(name, "$OUTSIDE", outside)
+				want = strings.ReplaceAll(want, "$OUTSIDE", outside)
+				p := filepath.Join(dest, name)
+				var got string
+				if target, err := os.Readlink(p); err == nil {
+					got = "-> " + target
+				} else if data, err := ioutil.ReadFile(p); err == nil {
+					got = string(data)
+				} else {
+					t.Errorf("%s: %v", name, err)
+					continue
+				}
+				if got != want {
+					t.Errorf("%s: got %q; want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package grab
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pwaller/grab-ld-binaries/dlcache"
+)
+
+// NewFSResolver returns a Resolver which reads binaries, libraries, its
+// ld.so.cache and ld.so.conf from fsys rather than the host, with
+// DefaultIgnore. fsys is a root filesystem, such as os.DirFS of an unpacked
+// image or an fstest.MapFS fixture; symlinks in it are resolved within it if
+// it implements fs.ReadLinkFS.
+func NewFSResolver(fsys fs.FS) (*Resolver, error) {
+	dc, err := dlcache.LoadFS(fsys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ld.so.cache: %v", err)
+	}
+	return &Resolver{
+		FS:     fsys,
+		Cache:  dc,
+		Ignore: DefaultIgnore,
+	}, nil
+}
+
+// fsName returns the name in fsys of the path p, within the root
+// filesystem fsys holds, with the symlinks along it resolved.
+func fsName(fsys fs.FS, p string) (string, error) {
+	resolved, err := resolveLinks(p,
+		func(p string) (fs.FileInfo, error) { return fs.Lstat(fsys, fsRel(p)) },
+		func(p string) (string, error) { return fs.ReadLink(fsys, fsRel(p)) })
+	if err != nil {
+		return "", err
+	}
+	return fsRel(resolved), nil
+}
+
+// fsRel returns the fs.FS name of the absolute path p.
+func fsRel(p string) string {
+	if rel := strings.TrimPrefix(path.Clean("/"+p), "/"); rel != "" {
+		return rel
+	}
+	return "."
+}
+
+// open opens the file at p, within r.Root, from r.FS if set.
+func (r *Resolver) open(p string) (fs.File, error) {
+	if r.FS == nil {
+		return os.Open(r.host(p))
+	}
+	name, err := fsName(r.FS, p)
+	if err != nil {
+		return nil, err
+	}
+	return r.FS.Open(name)
+}
+
+// stat is os.Stat of the file at p, within r.Root, from r.FS if set.
+func (r *Resolver) stat(p string) (fs.FileInfo, error) {
+	if r.FS == nil {
+		return os.Stat(r.host(p))
+	}
+	name, err := fsName(r.FS, p)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(r.FS, name)
+}
+
+// readFile returns the contents of the file at p, within r.Root, from r.FS
+// if set.
+func (r *Resolver) readFile(p string) ([]byte, error) {
+	fd, err := r.open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	return ioutil.ReadAll(fd)
+}
+
+// globDir returns the names of the files in the directory dir, within
+// r.Root, which match pattern, in sorted order.
+func (r *Resolver) globDir(dir, pattern string) ([]string, error) {
+	var matches []string
+	var err error
+	if r.FS == nil {
+		matches, err = filepath.Glob(filepath.Join(r.host(dir), pattern))
+	} else {
+		var name string
+		if name, err = fsName(r.FS, dir); err != nil {
+			return nil, err
+		}
+		matches, err = fs.Glob(r.FS, path.Join(name, pattern))
+	}
+	for i, match := range matches {
+		matches[i] = path.Base(filepath.ToSlash(match))
+	}
+	return matches, err
+}
+
+// walkDir walks the directory tree at dir, within r.Root, from r.FS if set,
+// calling fn with the path within r.Root of each file, as fs.WalkDir does.
+// Symlinks are not followed.
+func (r *Resolver) walkDir(dir string, fn fs.WalkDirFunc) error {
+	dir = path.Join("/", dir)
+	if r.FS == nil {
+		top := r.host(dir)
+		return filepath.WalkDir(top, func(host string, d fs.DirEntry, err error) error {
+			rel, relErr := filepath.Rel(top, host)
+			if relErr != nil {
+				return relErr
+			}
+			return fn(path.Join(dir, filepath.ToSlash(rel)), d, err)
+		})
+	}
+	// Like filepath.WalkDir, a symlink at dir itself is not followed.
+	name, err := fsName(r.FS, path.Dir(dir))
+	if err != nil {
+		return fn(dir, nil, err)
+	}
+	if name = path.Join(name, path.Base(dir)); dir == "/" {
+		name = "."
+	}
+	return fs.WalkDir(r.FS, name, func(p string, d fs.DirEntry, err error) error {
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, name), "/")
+		if name == "." {
+			rel = p
+		}
+		return fn(path.Join(dir, rel), d, err)
+	})
+}
+
+// isELF reports whether the file at p, within r.Root, starts as an ELF
+// object does.
+func (r *Resolver) isELF(p string) bool {
+	fd, err := r.open(p)
+	if err != nil {
+		return false
+	}
+	defer fd.Close()
+	magic := make([]byte, len(elf.ELFMAG))
+	if _, err := io.ReadFull(fd, magic); err != nil {
+		return false
+	}
+	return bytes.Equal(magic, []byte(elf.ELFMAG))
+}
+
+// localPath returns the host path of the file at p, within r.Root, to
+// archive it from. A file of r.FS is read first, so that one which mirrors
+// what it reads into r.Root, as SSHFS does, holds it there.
+func (r *Resolver) localPath(p string) (string, error) {
+	if r.FS != nil {
+		fd, err := r.open(p)
+		if err != nil {
+			return "", err
+		}
+		fd.Close()
+	}
+	return r.host(p), nil
+}
+
+// elfFile is an ELF object opened by openELF.
+type elfFile struct {
+	*elf.File
+	fd fs.File
+}
+
+// Close closes f and its file.
+func (f *elfFile) Close() error {
+	f.File.Close()
+	return f.fd.Close()
+}
+
+// openELF opens the ELF object at p, within r.Root, from r.FS if set.
+func (r *Resolver) openELF(p string) (*elfFile, error) {
+	fd, err := r.open(p)
+	if err != nil {
+		return nil, err
+	}
+	ra, _, err := readerAt(fd)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	f, err := elf.NewFile(ra)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return &elfFile{f, fd}, nil
+}
+
+// readerAt returns fd, and its size, for random access, reading it into
+// memory if it does not support it itself, as files of an fs.FS need not.
+func readerAt(fd fs.File) (io.ReaderAt, int64, error) {
+	fi, err := fd.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	if ra, ok := fd.(io.ReaderAt); ok {
+		return ra, fi.Size(), nil
+	}
+	data, err := ioutil.ReadAll(fd)
+	if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(data), int64(len(data)), nil
+}
@@ -0,0 +1,261 @@
+package grab
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/pwaller/grab-ld-binaries/internal/cachetest"
+	"github.com/pwaller/grab-ld-binaries/internal/elftest"
+)
+
+func TestFSResolver(t *testing.T) {
+	t.Setenv("LD_LIBRARY_PATH", "/env/lib")
+	cache, err := cachetest.Build("amd64", map[string]string{
+		"libbar.so.2": "/srv/lib/libbar.so.2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{
+		"etc/ld.so.cache": {Data: cache},
+		// An absolute symlink must resolve within fsys, not the host.
+		"lib64":               {Data: []byte("/usr/lib64"), Mode: fs.ModeSymlink},
+		"opt/lib/libfoo.so.1": {Data: []byte("libfoo.so.1.2.3"), Mode: fs.ModeSymlink},
+	}
+	for name, o := range map[string]elftest.Object{
+		"bin/app": {
+			Interp:  "/lib64/ld-linux-x86-64.so.2",
+			Needed:  []string{"libfoo.so.1", "libbaz.so.3", "libmissing.so.9"},
+			RunPath: "$ORIGIN/../opt/lib",
+		},
+		"usr/lib64/ld-linux-x86-64.so.2": {Soname: "ld-linux-x86-64.so.2"},
+		"opt/lib/libfoo.so.1.2.3":        {Soname: "libfoo.so.1", Needed: []string{"libbar.so.2"}},
+		"srv/lib/libbar.so.2":            {Soname: "libbar.so.2"},
+		"env/lib/libbaz.so.3":            {Soname: "libbaz.so.3"},
+	} {
+		fsys[name] = &fstest.MapFile{Data: o.Bytes(), Mode: 0755}
+	}
+
+	r, err := NewFSResolver(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := r.Resolve("/bin/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantResolved := map[string]string{
+		"libfoo.so.1": "/opt/lib/libfoo.so.1",
+		"libbar.so.2": "/srv/lib/libbar.so.2",
+		"libbaz.so.3": "/env/lib/libbaz.so.3",
+	}
+	if !reflect.DeepEqual(g.Resolved, wantResolved) {
+		t.Errorf("resolved %v; want %v", g.Resolved, wantResolved)
+	}
+	if missing := g.Missing(); !reflect.DeepEqual(missing, []string{"libmissing.so.9"}) {
+		t.Errorf("missing %q; want libmissing.so.9", missing)
+	}
+	if got := r.realPath("/lib64/ld-linux-x86-64.so.2"); got != "/usr/lib64/ld-linux-x86-64.so.2" {
+		t.Errorf("realPath of the interpreter = %s", got)
+	}
+}
+
+func TestSonameConflict(t *testing.T) {
+	cache, err := cachetest.Build("amd64", map[string]string{
+		"libcrypto.so.3": "/usr/lib/libcrypto.so.3",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{
+		"etc/ld.so.cache":    {Data: cache},
+		"lib/libcrypto.so.3": {Data: []byte("/usr/lib/libcrypto.so.3"), Mode: fs.ModeSymlink},
+		// A symlink to the file kept is not a conflict.
+		"vendor/libcrypto.so.3": {Data: []byte("/opt/app/lib/libcrypto.so.3"), Mode: fs.ModeSymlink},
+	}
+	for name, o := range map[string]elftest.Object{
+		"opt/app/bin/app":            {Needed: []string{"libcrypto.so.3"}, RPath: "$ORIGIN/../lib"},
+		"opt/app/lib/libcrypto.so.3": {Soname: "libcrypto.so.3"},
+		"bin/tool":                   {Needed: []string{"libcrypto.so.3"}},
+		"bin/same":                   {Needed: []string{"libcrypto.so.3"}, RunPath: "/vendor"},
+		"bin/other":                  {Needed: []string{"libcrypto.so.3"}, RunPath: "/lib"},
+		"usr/lib/libcrypto.so.3":     {Soname: "libcrypto.so.3"},
+	} {
+		fsys[name] = &fstest.MapFile{Data: o.Bytes(), Mode: 0755}
+	}
+
+	r, err := NewFSResolver(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := r.ResolveAll([]string{"/opt/app/bin/app", "/bin/tool", "/bin/same", "/bin/other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := g.Resolved["libcrypto.so.3"]; got != "/opt/app/lib/libcrypto.so.3" {
+		t.Errorf("resolved libcrypto.so.3 to %s; want the first binary's", got)
+	}
+	want := []Diagnostic{
+		NewDiagnostic(DiagSonameConflict, "libcrypto.so.3",
+			"/usr/lib/libcrypto.so.3", "/bin/tool", "/opt/app/lib/libcrypto.so.3", "/opt/app/bin/app"),
+		NewDiagnostic(DiagSonameConflict, "libcrypto.so.3",
+			"/lib/libcrypto.so.3", "/bin/other", "/opt/app/lib/libcrypto.so.3", "/bin/same, /opt/app/bin/app"),
+	}
+	if !reflect.DeepEqual(r.Diagnostics, want) {
+		t.Errorf("diagnostics %v; want %v", r.Diagnostics, want)
+	}
+}
+
+// TestFSResolverExtras resolves every extra the command line can ask for
+// against a MapFS, with Root a host directory of decoys at the same paths,
+// so that anything read from the host rather than the FS shows up.
+func TestFSResolverExtras(t *testing.T) {
+	cache, err := cachetest.Build("amd64", map[string]string{
+		"libc.so.6":   "/usr/lib/libc.so.6",
+		"libfoo.so.1": "/usr/lib/libfoo.so.1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{
+		"etc/ld.so.cache":                         {Data: cache},
+		"etc/os-release":                          {Data: []byte("ID=mapfs\n")},
+		"etc/nsswitch.conf":                       {Data: []byte("hosts: files\n")},
+		"etc/default/app":                         {Data: []byte("DEBUG=1\n")},
+		"etc/ssl/certs/ca-certificates.crt":       {Data: []byte("certs")},
+		"etc/systemd/system/app.service":          {Data: []byte("[Service]\nExecStart=/bin/app\nEnvironmentFile=/etc/default/app\n")},
+		"etc/systemd/system/app.service.d/a.conf": {Data: []byte("[Service]\nExecStartPre=/bin/app --check\n")},
+		"usr/lib/gconv/gconv-modules":             {Data: []byte("module")},
+		"usr/lib/locale/C.utf8/LC_CTYPE":          {Data: []byte("ctype")},
+		"usr/share/zoneinfo/UTC":                  {Data: []byte("TZif")},
+		"usr/share/doc/libfoo1/copyright":         {Data: []byte("copyright")},
+		"usr/share/licenses/libc6/COPYING":        {Data: []byte("LGPL")},
+		"lib/modules/6.1.0-test/modules.dep":      {Data: []byte("kernel/dummy.ko:\n")},
+		"lib/modules/6.1.0-test/kernel/dummy.ko":  {Data: []byte("module")},
+		"var/lib/dpkg/status": {Data: []byte("Package: libfoo1\nVersion: 1.0\nArchitecture: amd64\nDepends: libc6\n\n" +
+			"Package: libc6\nVersion: 2.36\nArchitecture: amd64\n")},
+		"var/lib/dpkg/info/libfoo1:amd64.list": {Data: []byte("/usr/lib/libfoo.so.1\n")},
+		"var/lib/dpkg/info/libc6:amd64.list":   {Data: []byte("/usr/lib/libc.so.6\n")},
+		"var/lib/dpkg/info/libc6:amd64.shlibs": {Data: []byte("libc 6 libc6\n")},
+	}
+	for name, o := range map[string]elftest.Object{
+		"bin/app":             {Needed: []string{"libfoo.so.1", "libc.so.6"}},
+		"usr/lib/libfoo.so.1": {Soname: "libfoo.so.1", Needed: []string{"libc.so.6"}},
+		"usr/lib/libc.so.6":   {Soname: "libc.so.6"},
+	} {
+		fsys[name] = &fstest.MapFile{Data: o.Bytes(), Mode: 0755}
+	}
+
+	decoys := t.TempDir()
+	for _, p := range []string{
+		"etc/os-release",
+		"etc/systemd/system/app.service.d/decoy.conf",
+		"usr/lib/gconv/decoy.so",
+		"usr/lib/locale/C.utf8/decoy",
+		"usr/share/zoneinfo/decoy",
+		"usr/share/licenses/libc6/decoy",
+		"lib/modules/0.0.0-decoy/modules.dep",
+		"var/lib/dpkg/status",
+		"var/lib/dpkg/info/decoy.list",
+	} {
+		host := filepath.Join(decoys, p)
+		if err := os.MkdirAll(filepath.Dir(host), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(host, []byte("ID=decoy\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	modules := filepath.Join(t.TempDir(), "modules")
+	if err := ioutil.WriteFile(modules, []byte("dummy\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewFSResolver(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Root = decoys
+	g, err := r.Resolve("/bin/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	add := func(files []File, err error) {
+		t.Helper()
+		if err != nil {
+			t.Error(err)
+		}
+		for _, f := range files {
+			names = append(names, f.Name)
+		}
+	}
+	unit, err := r.LoadUnit("app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/bin/app"}; !reflect.DeepEqual(unit.Binaries, want) {
+		t.Errorf("unit binaries %v; want %v", unit.Binaries, want)
+	}
+	for _, p := range append(unit.Files, unit.EnvironmentFiles...) {
+		add(r.AddPath(p, nil))
+	}
+	add(r.AddPath(NSSConfig, nil))
+	add(r.GconvModules(g))
+	add(r.LocaleFiles("C.UTF-8"))
+	add(r.ProfileFiles("tls"))
+	add(r.ProfileFiles("tz"))
+	add(r.KernelModules(modules, ""))
+	db, err := r.Packages("auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	add(r.LicenseFiles(g, db))
+	sort.Strings(names)
+
+	want := []string{
+		"etc/default/app",
+		"etc/nsswitch.conf",
+		"etc/ssl/certs/ca-certificates.crt",
+		"etc/systemd/system/app.service",
+		"etc/systemd/system/app.service.d/a.conf",
+		"lib/modules/6.1.0-test/kernel/dummy.ko",
+		"lib/modules/6.1.0-test/modules.dep",
+		"licenses/libc6/COPYING",
+		"licenses/libfoo1/copyright",
+		"usr/lib/gconv/gconv-modules",
+		"usr/lib/locale/C.utf8/LC_CTYPE",
+		"usr/share/zoneinfo/UTC",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("archived %s; want %s", strings.Join(names, " "), strings.Join(want, " "))
+	}
+	if pkg, ok := db.Lookup("/usr/lib/libfoo.so.1"); !ok || pkg.Version != "1.0" {
+		t.Errorf("libfoo.so.1 owned by %v", pkg)
+	}
+	deps, err := r.PackageDeps("auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if needed, _ := deps.Needed("/usr/lib/libfoo.so.1"); !reflect.DeepEqual(needed, []string{"libc.so.6"}) {
+		t.Errorf("libfoo.so.1 needs %v from its package; want libc.so.6", needed)
+	}
+	if id := r.OSRelease()["ID"]; id != "mapfs" {
+		t.Errorf("os-release ID %q", id)
+	}
+	binaries, err := r.FindBinaries("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/bin/app", "/usr/lib/libc.so.6", "/usr/lib/libfoo.so.1"}; !reflect.DeepEqual(binaries, want) {
+		t.Errorf("found binaries %v; want %v", binaries, want)
+	}
+}
@@ -0,0 +1,69 @@
+package grab
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// localeDir is where glibc looks for compiled locales.
+const localeDir = "/usr/lib/locale"
+
+// GconvModules returns the files of glibc's gconv modules, which iconv
+// dlopens to convert character sets, from the gconv directory beside the
+// libc.so.6 of g, named by their paths within r.Root. It returns nil if g
+// has no glibc.
+func (r *Resolver) GconvModules(g *Graph) ([]File, error) {
+	libc, ok := g.Resolved["libc.so.6"]
+	if !ok {
+		return nil, nil
+	}
+	dir := path.Join(path.Dir(r.realPath(libc)), "gconv")
+	if _, err := r.stat(dir); err != nil {
+		return nil, fmt.Errorf("no gconv modules beside %s: %v", libc, err)
+	}
+	return r.AddPath(dir, nil)
+}
+
+// LocaleFiles returns the files of the compiled locale name, such as
+// C.UTF-8, from /usr/lib/locale within r.Root: its own directory if it has
+// one, or else the locale archive, which holds every locale built into it.
+func (r *Resolver) LocaleFiles(name string) ([]File, error) {
+	for _, candidate := range []string{name, normalizeLocale(name)} {
+		dir := path.Join(localeDir, candidate)
+		if fi, err := r.stat(dir); err == nil && fi.IsDir() {
+			return r.AddPath(dir, nil)
+		}
+	}
+	archive := path.Join(localeDir, "locale-archive")
+	if _, err := r.stat(archive); err != nil {
+		return nil, fmt.Errorf("locale %s is not in %s", name, localeDir)
+	}
+	r.logf("Locale %s has no directory of its own; archiving %s", name, archive)
+	return r.AddPath(archive, nil)
+}
+
+// normalizeLocale normalizes the codeset of a locale name as glibc does:
+// C.UTF-8 and C.utf-8 become C.utf8, and en_US.8859-1 en_US.iso88591.
+func normalizeLocale(name string) string {
+	lang, codeset, ok := strings.Cut(name, ".")
+	if !ok {
+		return name
+	}
+	codeset, modifier, _ := strings.Cut(codeset, "@")
+	var b strings.Builder
+	for _, c := range strings.ToLower(codeset) {
+		if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' {
+			b.WriteRune(c)
+		}
+	}
+	codeset = b.String()
+	if strings.Trim(codeset, "0123456789") == "" {
+		codeset = "iso" + codeset
+	}
+	normalized := lang + "." + codeset
+	if modifier != "" {
+		normalized += "@" + modifier
+	}
+	return normalized
+}
@@ -0,0 +1,56 @@
+package grab
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizeLocale(t *testing.T) {
+	for name, want := range map[string]string{
+		"C.UTF-8":            "C.utf8",
+		"en_US.ISO-8859-1":   "en_US.iso88591",
+		"de_DE.8859-15@euro": "de_DE.iso885915@euro",
+		"POSIX":              "POSIX",
+	} {
+		if got := normalizeLocale(name); got != want {
+			t.Errorf("normalizeLocale(%s) = %s; want %s", name, got, want)
+		}
+	}
+}
+
+func TestLocaleFiles(t *testing.T) {
+	root := t.TempDir()
+	write := func(p string) {
+		host := filepath.Join(root, p)
+		if err := os.MkdirAll(filepath.Dir(host), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(host, []byte(p), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("usr/lib/locale/C.utf8/LC_CTYPE")
+	r := &Resolver{Root: root}
+
+	files, err := r.LocaleFiles("C.UTF-8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name != "usr/lib/locale/C.utf8/LC_CTYPE" {
+		t.Errorf("C.UTF-8 archived as %v", files)
+	}
+	if _, err := r.LocaleFiles("en_GB.UTF-8"); err == nil {
+		t.Errorf("found en_GB.UTF-8 without a locale archive")
+	}
+
+	write("usr/lib/locale/locale-archive")
+	files, err = r.LocaleFiles("en_GB.UTF-8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name != "usr/lib/locale/locale-archive" {
+		t.Errorf("en_GB.UTF-8 archived as %v", files)
+	}
+}
@@ -0,0 +1,348 @@
+// Package grab resolves the shared library closure of ELF binaries and
+// archives it, so that the binaries can run on a system without those
+// libraries installed.
+package grab
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Graph is the DT_NEEDED dependency graph of one or more binaries. Nodes are
+// the root filenames and the sonames of the libraries they transitively need.
+type Graph struct {
+	Roots []string
+	// Sysroot is the filesystem tree which Roots and the paths in Resolved
+	// are relative to. Empty means /.
+	Sysroot string
+	// Edges maps each visited node to the libraries it needs, in the order
+	// they appear in its dynamic section.
+	Edges map[string][]string
+	// Interps are the program interpreters (dynamic loaders) named by the
+	// roots' PT_INTERP. Their own dependencies appear in Edges.
+	Interps []string
+	// Resolved maps each library to the file it was found at. Libraries
+	// which could not be found are absent.
+	Resolved map[string]string
+	// Provided holds the libraries (and interpreters) which the target base
+	// image already has, so are not archived. See ApplyBase.
+	Provided map[string]bool
+	// Extra holds further files to archive alongside the libraries, such as
+	// kernel modules. Their paths are host paths.
+	Extra []File
+	// Overlay maps paths within Sysroot to the host files standing in for
+	// them. See Resolver.Overlay.
+	Overlay map[string]string
+	// Placed holds the roots which Extra archives at their own paths, as
+	// ScanDir's are, so which are not also archived by their base names.
+	Placed map[string]bool
+}
+
+// Libraries returns the set of all libraries reachable from the roots.
+func (g *Graph) Libraries() map[string]struct{} {
+	libs := map[string]struct{}{}
+	for _, deps := range g.Edges {
+		for _, dep := range deps {
+			libs[dep] = struct{}{}
+		}
+	}
+	return libs
+}
+
+// NeededBy returns the sorted roots and libraries whose DT_NEEDED name lib.
+func (g *Graph) NeededBy(lib string) []string {
+	var parents []string
+	for node, deps := range g.Edges {
+		for _, dep := range deps {
+			if dep == lib {
+				parents = append(parents, node)
+				break
+			}
+		}
+	}
+	sort.Strings(parents)
+	return parents
+}
+
+// Missing returns the sorted libraries which could not be resolved, and which
+// are not provided by the base.
+func (g *Graph) Missing() []string {
+	var missing []string
+	for _, lib := range SortedSet(g.Libraries()) {
+		if _, ok := g.Resolved[lib]; !ok && !g.Provided[lib] {
+			missing = append(missing, lib)
+		}
+	}
+	return missing
+}
+
+// addLoaded adds lib, found at path, to g as needed by parent, with sub, the
+// graph of lib's own dependencies. It is for libraries which parent loads
+// with dlopen, so which are not in its dynamic section.
+func (g *Graph) addLoaded(parent, lib, path string, sub *Graph) {
+	g.Edges[parent] = append(g.Edges[parent], lib)
+	g.Resolved[lib] = path
+	for node, deps := range sub.Edges {
+		if node == path {
+			node = lib
+		}
+		if _, ok := g.Edges[node]; !ok {
+			g.Edges[node] = deps
+		}
+	}
+	for lib, path := range sub.Resolved {
+		if _, ok := g.Resolved[lib]; !ok {
+			g.Resolved[lib] = path
+		}
+	}
+}
+
+// Files returns the files to archive: the roots, the interpreters, each
+// resolved library in soname order, then any extra files. Roots and
+// libraries are named by their base names, but roots sharing one with
+// another root by their full paths; interpreters are named by their full
+// path, since the kernel looks for them there.
+//
+// Libraries and interpreters are archived with their symlink chains, so that
+// for example libm.so.6 is a symlink to libm-2.31.so as on disk.
+func (g *Graph) Files() []File {
+	return g.files(false)
+}
+
+// PathFiles returns the files to archive as Files does, but with the roots
+// and libraries too named by their full paths within Sysroot, so that the
+// archive lays them out as on disk.
+func (g *Graph) PathFiles() []File {
+	return g.files(true)
+}
+
+func (g *Graph) files(fullPath bool) []File {
+	var files []File
+	seen := map[string]bool{}
+	roots := map[string]map[string]bool{}
+	for _, root := range g.Roots {
+		base := filepath.Base(root)
+		if roots[base] == nil {
+			roots[base] = map[string]bool{}
+		}
+		roots[base][filepath.Clean(root)] = true
+	}
+	for _, root := range g.Roots {
+		name := filepath.Base(root)
+		if fullPath || len(roots[name]) > 1 {
+			name = strings.TrimPrefix(filepath.Clean(root), "/")
+		}
+		file := File{Path: g.host(root), Name: name}
+		if !seen[file.Name] && !g.Placed[root] {
+			seen[file.Name] = true
+			files = append(files, file)
+		}
+	}
+
+	add := func(chain []File) {
+		for _, file := range chain {
+			if !seen[file.Name] {
+				seen[file.Name] = true
+				files = append(files, file)
+			}
+		}
+	}
+
+	for _, interp := range g.Interps {
+		if !g.Provided[interp] {
+			add(symlinkChain(g.Sysroot, interp, true))
+		}
+	}
+	for _, lib := range SortedSet(g.Libraries()) {
+		if path, ok := g.Resolved[lib]; ok && !g.Provided[lib] {
+			chain := symlinkChain(g.Sysroot, path, fullPath)
+			// A library found under another name, as by a Rename, must be
+			// archived under its soname for ld.so to find it.
+			if !strings.Contains(lib, "/") && !hasBaseName(chain, lib) {
+				last := chain[len(chain)-1]
+				name := lib
+				if fullPath {
+					name = filepath.Join(filepath.Dir(last.Name), lib)
+				}
+				chain = []File{{Path: last.Path, Name: name}}
+			}
+			add(chain)
+		}
+	}
+	return append(files, g.Extra...)
+}
+
+// hasName reports whether one of files is archived as name.
+func hasName(files []File, name string) bool {
+	for _, file := range files {
+		if file.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hasBaseName reports whether one of files is archived with the base name
+// name, in whichever directory.
+func hasBaseName(files []File, name string) bool {
+	for _, file := range files {
+		if filepath.Base(file.Name) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// symlinkChain returns the symlinks leading from p, within root, to a file,
+// followed by the file itself. With fullPath, entries are named by their path
+// within root and keep their link targets. Otherwise they are named by their
+// base name, with link targets flattened to match.
+func symlinkChain(root, p string, fullPath bool) []File {
+	var chain []File
+	for i := 0; i < maxSymlinks; i++ {
+		dir, err := InRoot(root, filepath.Dir(p))
+		if err != nil {
+			break
+		}
+		host := filepath.Join(dir, filepath.Base(p))
+		fi, err := os.Lstat(host)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			break
+		}
+		target, err := os.Readlink(host)
+		if err != nil {
+			break
+		}
+
+		next := target
+		if !filepath.IsAbs(next) {
+			next = filepath.Join(filepath.Dir(p), next)
+		}
+
+		switch {
+		case fullPath:
+			chain = append(chain, File{Path: host, Name: strings.TrimPrefix(p, "/"), Link: target})
+		case filepath.Base(next) != filepath.Base(p):
+			chain = append(chain, File{Path: host, Name: filepath.Base(p), Link: filepath.Base(next)})
+		}
+		p = next
+	}
+
+	name := filepath.Base(p)
+	if fullPath {
+		name = strings.TrimPrefix(p, "/")
+	}
+	return append(chain, File{Path: hostPath(root, p), Name: name})
+}
+
+// Paths returns every chain of DT_NEEDED edges from a root to lib. Each chain
+// starts with a root and ends with lib.
+func (g *Graph) Paths(lib string) [][]string {
+	var (
+		paths   [][]string
+		chain   []string
+		onChain = map[string]bool{}
+	)
+
+	var visit func(node string)
+	visit = func(node string) {
+		if onChain[node] {
+			return // Cycle.
+		}
+		chain = append(chain, node)
+		onChain[node] = true
+
+		if node == lib {
+			paths = append(paths, append([]string(nil), chain...))
+		} else {
+			for _, dep := range g.Edges[node] {
+				visit(dep)
+			}
+		}
+
+		onChain[node] = false
+		chain = chain[:len(chain)-1]
+	}
+	for _, root := range g.Roots {
+		visit(root)
+	}
+
+	return paths
+}
+
+// Merge adds the roots, edges, interpreters and resolutions of other to g.
+// Where both resolved a library, g's resolution is kept, as ld.so keeps the
+// first object loaded with a given soname.
+func (g *Graph) Merge(other *Graph) {
+	g.Roots = append(g.Roots, other.Roots...)
+	for node, deps := range other.Edges {
+		if _, ok := g.Edges[node]; !ok {
+			g.Edges[node] = deps
+		}
+	}
+	for _, interp := range other.Interps {
+		if !contains(g.Interps, interp) {
+			g.Interps = append(g.Interps, interp)
+		}
+	}
+	for lib, path := range other.Resolved {
+		if _, ok := g.Resolved[lib]; !ok {
+			g.Resolved[lib] = path
+		}
+	}
+	g.Extra = append(g.Extra, other.Extra...)
+	for root := range other.Placed {
+		if g.Placed == nil {
+			g.Placed = map[string]bool{}
+		}
+		g.Placed[root] = true
+	}
+	for p, host := range other.Overlay {
+		if g.Overlay == nil {
+			g.Overlay = map[string]string{}
+		}
+		g.Overlay[p] = host
+	}
+}
+
+// RootDigests returns the hex sha256 of each root, by root.
+func (g *Graph) RootDigests() (map[string]string, error) {
+	digests := map[string]string{}
+	for _, root := range g.Roots {
+		sum, err := sha256File(g.host(root))
+		if err != nil {
+			return nil, err
+		}
+		digests[root] = sum
+	}
+	return digests, nil
+}
+
+// host returns the host path of p, which is within g.Sysroot.
+func (g *Graph) host(p string) string {
+	if host, ok := g.Overlay[p]; ok {
+		return host
+	}
+	return hostPath(g.Sysroot, p)
+}
+
+func contains(list []string, s string) bool {
+	for _, element := range list {
+		if element == s {
+			return true
+		}
+	}
+	return false
+}
+
+// SortedSet takes a string set and returns it as a sorted slice.
+func SortedSet(set map[string]struct{}) []string {
+	var out []string
+	for element := range set {
+		out = append(out, element)
+	}
+	sort.Strings(out)
+	return out
+}
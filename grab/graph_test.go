@@ -0,0 +1,275 @@
+package grab
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGraphPaths(t *testing.T) {
+	g := &Graph{
+		Roots: []string{"/bin/bash"},
+		Edges: map[string][]string{
+			"/bin/bash":        {"libtinfo.so.6", "libreadline.so.8", "libc.so.6"},
+			"libreadline.so.8": {"libtinfo.so.6", "libc.so.6"},
+			"libtinfo.so.6":    {"libc.so.6"},
+			"libc.so.6":        {"ld-linux-x86-64.so.2"},
+		},
+	}
+
+	got := g.Paths("libtinfo.so.6")
+	want := [][]string{
+		{"/bin/bash", "libtinfo.so.6"},
+		{"/bin/bash", "libreadline.so.8", "libtinfo.so.6"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Paths(libtinfo.so.6) = %q, want %q", got, want)
+	}
+
+	if got := g.Paths("libm.so.6"); len(got) != 0 {
+		t.Errorf("Paths(libm.so.6) = %q, want none", got)
+	}
+
+	if n := len(g.Libraries()); n != 4 {
+		t.Errorf("got %d libraries, want 4", n)
+	}
+
+	want2 := []string{"/bin/bash", "libreadline.so.8"}
+	if got := g.NeededBy("libtinfo.so.6"); !reflect.DeepEqual(got, want2) {
+		t.Errorf("NeededBy(libtinfo.so.6) = %q, want %q", got, want2)
+	}
+}
+
+func TestReportRequiredBy(t *testing.T) {
+	g := &Graph{
+		Roots: []string{"/bin/bash"},
+		Edges: map[string][]string{
+			"/bin/bash":        {"libreadline.so.8", "libc.so.6"},
+			"libreadline.so.8": {"libtinfo.so.6", "libc.so.6"},
+			"libtinfo.so.6":    {"libc.so.6"},
+		},
+	}
+	report, err := g.Report()
+	if err != nil {
+		t.Fatal(err)
+	}
+	requiredBy := map[string][]string{}
+	for _, lib := range report.Libraries {
+		requiredBy[lib.Soname] = lib.RequiredBy
+	}
+	want := map[string][]string{
+		"libc.so.6":        {"/bin/bash", "libreadline.so.8", "libtinfo.so.6"},
+		"libreadline.so.8": {"/bin/bash"},
+		"libtinfo.so.6":    {"/bin/bash", "libreadline.so.8"},
+	}
+	if !reflect.DeepEqual(requiredBy, want) {
+		t.Errorf("RequiredBy = %q, want %q", requiredBy, want)
+	}
+
+	// The graph rebuilt from the report answers the same questions.
+	got := report.Graph().Paths("libtinfo.so.6")
+	if paths := g.Paths("libtinfo.so.6"); !reflect.DeepEqual(got, paths) {
+		t.Errorf("Report().Graph().Paths(libtinfo.so.6) = %q, want %q", got, paths)
+	}
+}
+
+func TestFilesSymlinkChains(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"bin", "lib/x86_64-linux-gnu", "lib64"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{
+		"bin/tool",
+		"lib/x86_64-linux-gnu/libm-2.31.so",
+		"lib/x86_64-linux-gnu/ld-2.31.so",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(root, file), nil, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"lib/x86_64-linux-gnu/libm.so.6":            "libm-2.31.so",
+		"lib/x86_64-linux-gnu/ld-linux-x86-64.so.2": "ld-2.31.so",
+		"lib64/ld-linux-x86-64.so.2":                "/lib/x86_64-linux-gnu/ld-linux-x86-64.so.2",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	g := &Graph{
+		Roots:   []string{"/bin/tool"},
+		Sysroot: root,
+		Interps: []string{"/lib64/ld-linux-x86-64.so.2"},
+		Edges: map[string][]string{
+			"/bin/tool": {"libm.so.6"},
+			"libm.so.6": {"ld-linux-x86-64.so.2"},
+		},
+		Resolved: map[string]string{
+			"libm.so.6":            "/lib/x86_64-linux-gnu/libm.so.6",
+			"ld-linux-x86-64.so.2": "/lib/x86_64-linux-gnu/ld-linux-x86-64.so.2",
+		},
+	}
+
+	names := func(files []File) []string {
+		var got []string
+		for _, file := range files {
+			if file.Link != "" {
+				got = append(got, file.Name+" -> "+file.Link)
+			} else {
+				got = append(got, file.Name)
+			}
+		}
+		return got
+	}
+	got := names(g.Files())
+	want := []string{
+		"tool",
+		"lib64/ld-linux-x86-64.so.2 -> /lib/x86_64-linux-gnu/ld-linux-x86-64.so.2",
+		"lib/x86_64-linux-gnu/ld-linux-x86-64.so.2 -> ld-2.31.so",
+		"lib/x86_64-linux-gnu/ld-2.31.so",
+		"ld-linux-x86-64.so.2 -> ld-2.31.so",
+		"ld-2.31.so",
+		"libm.so.6 -> libm-2.31.so",
+		"libm-2.31.so",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n\t%s\nwant\n\t%s", strings.Join(got, "\n\t"), strings.Join(want, "\n\t"))
+	}
+
+	// By full path, the interpreter needed as a library is archived once.
+	got = names(g.PathFiles())
+	want = []string{
+		"bin/tool",
+		"lib64/ld-linux-x86-64.so.2 -> /lib/x86_64-linux-gnu/ld-linux-x86-64.so.2",
+		"lib/x86_64-linux-gnu/ld-linux-x86-64.so.2 -> ld-2.31.so",
+		"lib/x86_64-linux-gnu/ld-2.31.so",
+		"lib/x86_64-linux-gnu/libm.so.6 -> libm-2.31.so",
+		"lib/x86_64-linux-gnu/libm-2.31.so",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PathFiles: got\n\t%s\nwant\n\t%s", strings.Join(got, "\n\t"), strings.Join(want, "\n\t"))
+	}
+}
+
+func TestFilesRootCollision(t *testing.T) {
+	g := &Graph{
+		Roots: []string{"/usr/bin/foo", "/opt/bin/foo", "/usr/bin/bar", "/usr/bin/foo"},
+		Edges: map[string][]string{},
+	}
+	var got []string
+	for _, file := range g.Files() {
+		got = append(got, file.Name)
+	}
+	// Roots sharing a base name are named by their paths, so that neither
+	// replaces the other.
+	want := []string{"usr/bin/foo", "opt/bin/foo", "bar"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("archived roots %q; want %q", got, want)
+	}
+}
+
+func TestGraphMerge(t *testing.T) {
+	g := &Graph{
+		Roots:    []string{"/bin/a"},
+		Edges:    map[string][]string{"/bin/a": {"libc.so.6"}, "libc.so.6": nil},
+		Resolved: map[string]string{"libc.so.6": "/lib/libc.so.6"},
+	}
+	g.Merge(&Graph{
+		Roots: []string{"/bin/b"},
+		Edges: map[string][]string{
+			"/bin/b":    {"libz.so.1", "libc.so.6"},
+			"libz.so.1": {"libc.so.6"},
+			"libc.so.6": nil,
+		},
+		Resolved: map[string]string{
+			"libc.so.6": "/opt/b/libc.so.6",
+			"libz.so.1": "/lib/libz.so.1",
+		},
+	})
+
+	if want := []string{"/bin/a", "/bin/b"}; !reflect.DeepEqual(g.Roots, want) {
+		t.Errorf("roots %q, want %q", g.Roots, want)
+	}
+	if got := g.Resolved["libc.so.6"]; got != "/lib/libc.so.6" {
+		t.Errorf("libc.so.6 resolved to %q, want the first resolution", got)
+	}
+	if got := g.Paths("libc.so.6"); len(got) != 3 {
+		t.Errorf("got %d paths to libc.so.6, want 3: %q", len(got), got)
+	}
+}
+
+func TestWriteTree(t *testing.T) {
+	g := &Graph{
+		Roots: []string{"/bin/bash"},
+		Edges: map[string][]string{
+			"/bin/bash":     {"libtinfo.so.6", "libc.so.6"},
+			"libtinfo.so.6": {"libc.so.6"},
+			"libc.so.6":     {"libcycle.so"},
+			"libcycle.so":   {"libc.so.6"},
+		},
+		Resolved: map[string]string{
+			"libtinfo.so.6": "/lib/libtinfo.so.6",
+			"libc.so.6":     "/lib/libc.so.6",
+		},
+	}
+
+	var buf strings.Builder
+	if err := g.WriteTree(&buf, false); err != nil {
+		t.Fatal(err)
+	}
+	want := `/bin/bash
+    libtinfo.so.6 => /lib/libtinfo.so.6
+        libc.so.6 => /lib/libc.so.6
+            libcycle.so => not found
+                libc.so.6 => /lib/libc.so.6
+    libc.so.6 => /lib/libc.so.6
+`
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestLoadReport(t *testing.T) {
+	dir := t.TempDir()
+	g := &Graph{
+		Roots: []string{"/bin/ls"},
+		Edges: map[string][]string{"/bin/ls": {"libc.so.6"}},
+	}
+	report, err := g.Report()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reportFile := filepath.Join(dir, "report.json")
+	if err := ioutil.WriteFile(reportFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	binary := filepath.Join(dir, "ls")
+	if err := ioutil.WriteFile(binary, []byte("\x7fELF"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadReport(reportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded == nil || !reflect.DeepEqual(loaded.Paths("libc.so.6"), g.Paths("libc.so.6")) {
+		t.Errorf("LoadReport(%s) = %+v, want the report's graph", reportFile, loaded)
+	}
+	// Binaries, and commands to look up in $PATH, are not reports.
+	for _, filename := range []string{binary, "ls"} {
+		if loaded, err := LoadReport(filename); loaded != nil || err != nil {
+			t.Errorf("LoadReport(%s) = %v, %v; want nil, nil", filename, loaded, err)
+		}
+	}
+}
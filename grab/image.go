@@ -0,0 +1,536 @@
+package grab
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Whiteout entries of image layers: ".wh.NAME" removes NAME from the layers
+// below, and ".wh..wh..opq" in a directory removes all it held.
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// ImageFS is the root filesystem of a container image, as written by `docker
+// save` or an OCI image layout tarball, read from within the archive rather
+// than unpacked: the entries of its layers are indexed, with whiteouts
+// applied, and files are read from the layers where they lie. It implements
+// fs.ReadLinkFS and fs.ReadDirFS, for use as a Resolver's FS.
+//
+// Compressed archives and layers cannot be read at random, so are first
+// decompressed into temporary files, which are unlinked at once and so
+// vanish with Close or the process.
+type ImageFS struct {
+	nodes map[string]*imageNode // By fs.FS name
+	temps []*os.File
+}
+
+// imageNode is a file of an ImageFS.
+type imageNode struct {
+	name     string // In the ImageFS
+	hdr      *tar.Header
+	data     *io.SectionReader // Of regular files
+	layer    int               // The layer holding it
+	children []string          // Sorted names of a directory's entries
+}
+
+// ReadImage indexes the image archive r for arch, such as from ArchName.
+// If r is not a regular file, it is first copied to a temporary one.
+func ReadImage(r io.Reader, arch string) (*ImageFS, error) {
+	ifs := &ImageFS{nodes: map[string]*imageNode{}}
+	archive, err := ifs.seekable(r)
+	if err != nil {
+		ifs.Close()
+		return nil, err
+	}
+	entries, err := tarEntries(archive)
+	if err != nil {
+		ifs.Close()
+		return nil, err
+	}
+	layers, err := imageLayers(entries, arch)
+	if err != nil {
+		ifs.Close()
+		return nil, err
+	}
+	for i, layer := range layers {
+		if err := ifs.addLayer(i, layer.data); err != nil {
+			ifs.Close()
+			return nil, fmt.Errorf("layer %s: %v", layer.hdr.Name, err)
+		}
+	}
+	ifs.link()
+	return ifs, nil
+}
+
+// Close removes the temporary files holding decompressed data.
+func (ifs *ImageFS) Close() error {
+	for _, fd := range ifs.temps {
+		fd.Close()
+	}
+	ifs.temps = nil
+	return nil
+}
+
+// seekable returns the decompressed contents of r for random access, in a
+// temporary file unless r is an uncompressed regular file or section of one.
+func (ifs *ImageFS) seekable(r io.Reader) (*io.SectionReader, error) {
+	var magic [6]byte
+	switch r := r.(type) {
+	case *io.SectionReader:
+		n, _ := r.ReadAt(magic[:], 0)
+		if !isCompressed(magic[:n]) {
+			return r, nil
+		}
+		return ifs.spool(io.NewSectionReader(r, 0, r.Size()))
+	case *os.File:
+		if fi, err := r.Stat(); err == nil && fi.Mode().IsRegular() {
+			return ifs.seekable(io.NewSectionReader(r, 0, fi.Size()))
+		}
+	}
+	return ifs.spool(r)
+}
+
+// spool decompresses r into a temporary file.
+func (ifs *ImageFS) spool(r io.Reader) (*io.SectionReader, error) {
+	dr, err := Decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+	fd, err := os.CreateTemp("", "grab-image-")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(fd.Name())
+	ifs.temps = append(ifs.temps, fd)
+	size, err := io.Copy(fd, dr)
+	if err != nil {
+		return nil, err
+	}
+	return io.NewSectionReader(fd, 0, size), nil
+}
+
+// isCompressed reports whether magic starts a stream Decompress would
+// decompress.
+func isCompressed(magic []byte) bool {
+	for _, prefix := range [][]byte{
+		{0x1f, 0x8b},
+		{0x28, 0xb5, 0x2f, 0xfd},
+		{0xfd, '7', 'z', 'X', 'Z', 0x00},
+	} {
+		if bytes.HasPrefix(magic, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// tarEntry is an entry of a tar archive, and where its contents lie.
+type tarEntry struct {
+	hdr  *tar.Header
+	data *io.SectionReader
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// tarEntries returns the entries of the tar archive ra, in order, with the
+// sections of ra holding their contents. tar reads no further than an
+// entry's header before returning it, so the count read so far is where its
+// contents start.
+func tarEntries(ra *io.SectionReader) ([]tarEntry, error) {
+	cr := &countingReader{r: io.NewSectionReader(ra, 0, ra.Size())}
+	tr := tar.NewReader(cr)
+	var entries []tarEntry
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, tarEntry{hdr, io.NewSectionReader(ra, cr.n, hdr.Size)})
+	}
+}
+
+// imageLayers returns the entries of the image archive holding its layers
+// for arch, bottom first, from the manifest.json of `docker save` or else
+// the OCI index.json.
+func imageLayers(entries []tarEntry, arch string) ([]tarEntry, error) {
+	files := map[string]tarEntry{}
+	for _, e := range entries {
+		if name, err := entryName(e.hdr.Name, 0); err == nil && e.hdr.Typeflag == tar.TypeReg {
+			files[name] = e
+		}
+	}
+	readData := func(name string) ([]byte, error) {
+		e, ok := files[name]
+		if !ok {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		return io.ReadAll(io.NewSectionReader(e.data, 0, e.data.Size()))
+	}
+	decode := func(name string, data []byte, v interface{}) error {
+		if err := json.Unmarshal(data, v); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		return nil
+	}
+	readFile := func(name string, v interface{}) error {
+		data, err := readData(name)
+		if err != nil {
+			return err
+		}
+		return decode(name, data, v)
+	}
+	readBlob := func(digest string, v interface{}) error {
+		hex, err := blobHex(digest)
+		if err != nil {
+			return err
+		}
+		name := path.Join("blobs", "sha256", hex)
+		data, err := readData(name)
+		if err != nil {
+			return err
+		}
+		if err := checkDigest(digest, data); err != nil {
+			return err
+		}
+		return decode(name, data, v)
+	}
+
+	var saved []struct {
+		Layers []string
+	}
+	switch err := readFile("manifest.json", &saved); {
+	case err == nil && len(saved) > 0:
+		var layers []string
+		for _, layer := range saved[0].Layers {
+			layers = append(layers, path.Clean(layer))
+		}
+		return checkLayers(files, layers)
+	case err != nil && !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+
+	var index struct {
+		Manifests []ociDescriptor `json:"manifests"`
+	}
+	if err := readFile("index.json", &index); err != nil {
+		return nil, fmt.Errorf("not a docker save or OCI archive: %v", err)
+	}
+	desc, err := findManifest(readBlob, index.Manifests, arch)
+	if err != nil {
+		return nil, err
+	}
+	var manifest struct {
+		Layers []ociDescriptor `json:"layers"`
+	}
+	if err := readBlob(desc.Digest, &manifest); err != nil {
+		return nil, err
+	}
+	var layers []string
+	for _, layer := range manifest.Layers {
+		hex, err := blobHex(layer.Digest)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, path.Join("blobs", "sha256", hex))
+	}
+	return checkLayers(files, layers)
+}
+
+// checkLayers returns the entries among files of the layers named layers.
+func checkLayers(files map[string]tarEntry, layers []string) ([]tarEntry, error) {
+	var entries []tarEntry
+	for _, layer := range layers {
+		e, ok := files[layer]
+		if !ok {
+			return nil, fmt.Errorf("layer %s is missing from the archive", layer)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// addLayer applies the i'th layer, data, over the layers below it.
+func (ifs *ImageFS) addLayer(i int, data *io.SectionReader) error {
+	layer, err := ifs.seekable(data)
+	if err != nil {
+		return err
+	}
+	entries, err := tarEntries(layer)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name, err := entryName(e.hdr.Name, 0)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			continue
+		}
+		dir, base := path.Dir(name), path.Base(name)
+		switch {
+		case base == whiteoutOpaque:
+			ifs.remove(dir, i, false)
+			continue
+		case strings.HasPrefix(base, whiteoutPrefix):
+			ifs.remove(path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)), i, true)
+			continue
+		}
+
+		node := &imageNode{name: name, hdr: e.hdr, layer: i}
+		switch e.hdr.Typeflag {
+		case tar.TypeReg:
+			node.data = e.data
+		case tar.TypeLink:
+			// Hard links are to files earlier in the same layer.
+			target, err := entryName(e.hdr.Linkname, 0)
+			if err != nil {
+				return err
+			}
+			linked, ok := ifs.nodes[target]
+			if !ok || linked.data == nil {
+				return fmt.Errorf("%s: hard link to missing %s", e.hdr.Name, e.hdr.Linkname)
+			}
+			hdr := *linked.hdr
+			hdr.Name = e.hdr.Name
+			node.hdr, node.data = &hdr, linked.data
+		}
+		if old, ok := ifs.nodes[name]; ok && old.hdr.Typeflag == tar.TypeDir && node.hdr.Typeflag != tar.TypeDir {
+			ifs.remove(name, i+1, false)
+		}
+		for parent := dir; parent != "."; parent = path.Dir(parent) {
+			if old, ok := ifs.nodes[parent]; ok && old.hdr.Typeflag != tar.TypeDir {
+				delete(ifs.nodes, parent)
+			}
+		}
+		ifs.nodes[name] = node
+	}
+	return nil
+}
+
+// remove removes what the layers below the i'th hold within the directory
+// name, and name itself with self.
+func (ifs *ImageFS) remove(name string, i int, self bool) {
+	prefix := name + "/"
+	for p, node := range ifs.nodes {
+		if node.layer < i && (strings.HasPrefix(p, prefix) || self && p == name) {
+			delete(ifs.nodes, p)
+		}
+	}
+}
+
+// link adds the directories the layers imply but lack entries for, and
+// lists the entries of each directory.
+func (ifs *ImageFS) link() {
+	if _, ok := ifs.nodes["."]; !ok {
+		ifs.nodes["."] = &imageNode{name: ".", hdr: &tar.Header{
+			Typeflag: tar.TypeDir, Name: "./", Mode: 0755, ModTime: time.Unix(0, 0),
+		}}
+	}
+	listed := map[string]bool{".": true}
+	names := make([]string, 0, len(ifs.nodes))
+	for name := range ifs.nodes {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		for child := name; !listed[child]; child = path.Dir(child) {
+			listed[child] = true
+			parent, ok := ifs.nodes[path.Dir(child)]
+			if !ok {
+				parent = &imageNode{name: path.Dir(child), hdr: &tar.Header{
+					Typeflag: tar.TypeDir, Name: path.Dir(child) + "/", Mode: 0755, ModTime: time.Unix(0, 0),
+				}}
+				ifs.nodes[path.Dir(child)] = parent
+			}
+			parent.children = append(parent.children, path.Base(child))
+		}
+	}
+	for _, node := range ifs.nodes {
+		sort.Strings(node.children)
+	}
+}
+
+// lstat returns the node at the absolute path p, whose directories have no
+// symlinks.
+func (ifs *ImageFS) lstat(p string) (fs.FileInfo, error) {
+	node, ok := ifs.nodes[fsRel(p)]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return node.info(), nil
+}
+
+// readlink returns the target of the symlink at the absolute path p, whose
+// directories have no symlinks.
+func (ifs *ImageFS) readlink(p string) (string, error) {
+	node, ok := ifs.nodes[fsRel(p)]
+	if !ok {
+		return "", fs.ErrNotExist
+	}
+	if node.hdr.Typeflag != tar.TypeSymlink {
+		return "", syscall.EINVAL
+	}
+	return node.hdr.Linkname, nil
+}
+
+// lookup returns the node named name, resolving symlinks in its directories
+// and, with follow, itself.
+func (ifs *ImageFS) lookup(op, name string, follow bool) (*imageNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	p := path.Join("/", name)
+	resolved, err := resolveLinks(path.Dir(p), ifs.lstat, ifs.readlink)
+	if err == nil {
+		resolved = path.Join(resolved, path.Base(p))
+		if follow {
+			resolved, err = resolveLinks(resolved, ifs.lstat, ifs.readlink)
+		}
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	node, ok := ifs.nodes[fsRel(resolved)]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return node, nil
+}
+
+// Open opens the file name, following symlinks.
+func (ifs *ImageFS) Open(name string) (fs.File, error) {
+	node, err := ifs.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if node.hdr.Typeflag == tar.TypeDir {
+		return &imageDir{ifs: ifs, node: node}, nil
+	}
+	data := node.data
+	if data == nil {
+		data = io.NewSectionReader(bytes.NewReader(nil), 0, 0)
+	}
+	return &imageFile{io.NewSectionReader(data, 0, data.Size()), node.info()}, nil
+}
+
+// Stat returns a FileInfo describing the file name, following symlinks.
+func (ifs *ImageFS) Stat(name string) (fs.FileInfo, error) {
+	node, err := ifs.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return node.info(), nil
+}
+
+// Lstat returns a FileInfo describing the file name, without following a
+// final symlink.
+func (ifs *ImageFS) Lstat(name string) (fs.FileInfo, error) {
+	node, err := ifs.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return node.info(), nil
+}
+
+// ReadLink returns the target of the symlink name.
+func (ifs *ImageFS) ReadLink(name string) (string, error) {
+	node, err := ifs.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if node.hdr.Typeflag != tar.TypeSymlink {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return node.hdr.Linkname, nil
+}
+
+// ReadDir returns the entries of the directory name, sorted by name.
+func (ifs *ImageFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	node, err := ifs.lookup("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if node.hdr.Typeflag != tar.TypeDir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	return ifs.dirEntries(node, node.children), nil
+}
+
+// info returns the FileInfo of node.
+func (node *imageNode) info() fs.FileInfo {
+	return node.hdr.FileInfo()
+}
+
+// dirEntries returns the entries of the directory dir for the files in it
+// named children.
+func (ifs *ImageFS) dirEntries(dir *imageNode, children []string) []fs.DirEntry {
+	entries := make([]fs.DirEntry, len(children))
+	for i, child := range children {
+		entries[i] = fs.FileInfoToDirEntry(ifs.nodes[path.Join(dir.name, child)].info())
+	}
+	return entries
+}
+
+// imageFile is a file opened by ImageFS.Open.
+type imageFile struct {
+	*io.SectionReader
+	fi fs.FileInfo
+}
+
+func (f *imageFile) Stat() (fs.FileInfo, error) { return f.fi, nil }
+func (f *imageFile) Close() error               { return nil }
+
+// imageDir is a directory opened by ImageFS.Open.
+type imageDir struct {
+	ifs    *ImageFS
+	node   *imageNode
+	offset int
+}
+
+func (d *imageDir) Stat() (fs.FileInfo, error) { return d.node.info(), nil }
+func (d *imageDir) Close() error               { return nil }
+
+func (d *imageDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.node.hdr.Name, Err: syscall.EISDIR}
+}
+
+// ReadDir returns the next n entries of the directory, or all the rest if
+// n <= 0.
+func (d *imageDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.node.children[d.offset:]
+	if n > 0 && len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(rest) {
+		rest = rest[:n]
+	}
+	d.offset += len(rest)
+	return d.ifs.dirEntries(d.node, rest), nil
+}
@@ -0,0 +1,101 @@
+package grab
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestReadImage(t *testing.T) {
+	base := testTar(t,
+		&tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0755},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "etc/passwd", Mode: 0644},
+		&tar.Header{Typeflag: tar.TypeDir, Name: "cache/", Mode: 0755},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "cache/old", Mode: 0644},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "usr/lib/libfoo.so.1.0", Mode: 0755},
+		&tar.Header{Typeflag: tar.TypeSymlink, Name: "usr/lib/libfoo.so.1", Linkname: "libfoo.so.1.0"},
+		&tar.Header{Typeflag: tar.TypeLink, Name: "usr/lib/libbar.so.1", Linkname: "usr/lib/libfoo.so.1.0"},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "opt", Mode: 0644},
+	)
+	var top bytes.Buffer
+	zw := gzip.NewWriter(&top)
+	zw.Write(testTar(t,
+		&tar.Header{Typeflag: tar.TypeReg, Name: "etc/.wh.passwd", Mode: 0644},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "cache/.wh..wh..opq", Mode: 0644},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "cache/new", Mode: 0644},
+		&tar.Header{Typeflag: tar.TypeSymlink, Name: "lib", Linkname: "usr/lib"},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "opt/app", Mode: 0755},
+	).Bytes())
+	zw.Close()
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{
+		{"manifest.json", []byte(`[{"Config":"config.json","Layers":["base/layer.tar","top/layer.tar"]}]`)},
+		{"base/layer.tar", base.Bytes()},
+		{"top/layer.tar", top.Bytes()},
+	} {
+		hdr := &tar.Header{Typeflag: tar.TypeReg, Name: f.name, Mode: 0644, Size: int64(len(f.data))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(f.data)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "image.tar")
+	if err := os.WriteFile(filename, archive.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fd, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	ifs, err := ReadImage(fd, "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ifs.Close()
+
+	for name, want := range map[string]bool{
+		"etc":                true,
+		"etc/passwd":         false,
+		"etc/.wh.passwd":     false,
+		"cache/old":          false,
+		"cache/new":          true,
+		"cache/.wh..wh..opq": false,
+		"opt/app":            true,
+		"lib":                true,
+	} {
+		_, err := ifs.Lstat(name)
+		if got := err == nil; got != want {
+			t.Errorf("%s: exists %v, want %v", name, got, want)
+		}
+	}
+	for name, want := range map[string]string{
+		"lib/libfoo.so.1":     "usr/lib/libfoo.so.1.0",
+		"usr/lib/libbar.so.1": "usr/lib/libfoo.so.1.0",
+		"opt/app":             "opt/app",
+	} {
+		data, err := fs.ReadFile(ifs, name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if string(data) != want {
+			t.Errorf("%s: read %q, want %q", name, data, want)
+		}
+	}
+	if err := fstest.TestFS(ifs, "etc", "cache/new", "lib", "usr/lib/libfoo.so.1", "usr/lib/libbar.so.1", "opt/app"); err != nil {
+		t.Error(err)
+	}
+}
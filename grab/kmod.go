@@ -0,0 +1,211 @@
+package grab
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// modulesIndexFiles are the files in /lib/modules/<release> which modprobe
+// consults to find modules and their dependencies.
+var modulesIndexFiles = []string{
+	"modules.alias",
+	"modules.alias.bin",
+	"modules.builtin",
+	"modules.builtin.bin",
+	"modules.builtin.modinfo",
+	"modules.dep",
+	"modules.dep.bin",
+	"modules.devname",
+	"modules.order",
+	"modules.softdep",
+	"modules.symbols",
+	"modules.symbols.bin",
+}
+
+// modulesDir is where modprobe looks for the modules of each kernel release.
+const modulesDir = "/lib/modules"
+
+// KernelModules returns the modules named in listFile, along with the modules
+// they depend on and the modprobe index files, for the kernel release within
+// r.Root, as KernelRelease picks it if release is empty. Files are named by
+// their full path so that they land in /lib/modules/<release> when the
+// archive is extracted at /.
+func (r *Resolver) KernelModules(listFile, release string) ([]File, error) {
+	wanted, err := readModuleList(listFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if release == "" {
+		if release, err = r.KernelRelease(); err != nil {
+			return nil, err
+		}
+	}
+	dir := filepath.Join(modulesDir, release)
+
+	data, err := r.readFile(filepath.Join(dir, "modules.dep"))
+	if err != nil {
+		return nil, err
+	}
+	deps, err := readModulesDep(data)
+	if err != nil {
+		return nil, err
+	}
+	data, err = r.readFile(filepath.Join(dir, "modules.builtin"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	builtin := readModulesBuiltin(data)
+
+	// Map module names to their path relative to dir.
+	byName := map[string]string{}
+	for path := range deps {
+		byName[moduleName(path)] = path
+	}
+
+	seen := map[string]struct{}{}
+	var visit func(path string)
+	visit = func(path string) {
+		if _, ok := seen[path]; ok {
+			return
+		}
+		seen[path] = struct{}{}
+		for _, dep := range deps[path] {
+			visit(dep)
+		}
+	}
+
+	for _, name := range wanted {
+		name = normalizeModuleName(name)
+		path, ok := byName[name]
+		switch {
+		case ok:
+			visit(path)
+		case builtin[name]:
+			r.logf("kernel module %s is built in", name)
+		default:
+			return nil, fmt.Errorf("kernel module %q not found in %s", name, dir)
+		}
+	}
+
+	var paths []string
+	for _, index := range modulesIndexFiles {
+		path := filepath.Join(dir, index)
+		if _, err := r.stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	for _, rel := range SortedSet(seen) {
+		path := filepath.Join(dir, rel)
+		r.logf("%s => %s", moduleName(rel), path)
+		paths = append(paths, path)
+	}
+
+	var files []File
+	for _, path := range paths {
+		host, err := r.localPath(path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, File{Path: host, Name: strings.TrimPrefix(path, "/")})
+	}
+	return files, nil
+}
+
+// KernelRelease returns the kernel release whose modules KernelModules
+// takes by default: the running kernel's for the host's root, and otherwise
+// the only one with modules in /lib/modules within r.Root, as a foreign
+// root's kernel is not the one running.
+func (r *Resolver) KernelRelease() (string, error) {
+	if r.FS == nil && isHostRoot(r.Root) {
+		release, err := ioutil.ReadFile("/proc/sys/kernel/osrelease")
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(release)), nil
+	}
+	releases, err := r.globDir(modulesDir, "*")
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	var dirs []string
+	for _, release := range releases {
+		if fi, err := r.stat(filepath.Join(modulesDir, release)); err == nil && fi.IsDir() {
+			dirs = append(dirs, release)
+		}
+	}
+	switch len(dirs) {
+	case 0:
+		return "", fmt.Errorf("no kernel modules in %s", modulesDir)
+	case 1:
+		return dirs[0], nil
+	}
+	return "", fmt.Errorf("%s has modules for kernels %s; choose one with -kernel-release",
+		modulesDir, strings.Join(dirs, ", "))
+}
+
+// readModuleList reads module names, one per line. Blank lines and lines
+// starting with '#' are ignored.
+func readModuleList(filename string) ([]string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, line)
+	}
+	return names, scanner.Err()
+}
+
+// readModulesDep parses modules.dep, which has lines of the form
+// "kernel/a.ko: kernel/b.ko kernel/c.ko".
+func readModulesDep(data []byte) (map[string][]string, error) {
+	deps := map[string][]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		module, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		deps[module] = strings.Fields(rest)
+	}
+	return deps, scanner.Err()
+}
+
+// readModulesBuiltin returns the set of module names compiled into the
+// kernel, from modules.builtin.
+func readModulesBuiltin(data []byte) map[string]bool {
+	builtin := map[string]bool{}
+	for _, line := range strings.Fields(string(data)) {
+		builtin[moduleName(line)] = true
+	}
+	return builtin
+}
+
+// moduleName returns the normalized module name of a module path, for
+// example "kernel/net/ipv4/udp_tunnel.ko.zst" => "udp_tunnel".
+func moduleName(path string) string {
+	name := filepath.Base(path)
+	if i := strings.Index(name, ".ko"); i >= 0 {
+		name = name[:i]
+	}
+	return normalizeModuleName(name)
+}
+
+// normalizeModuleName maps dashes to underscores, which the kernel treats as
+// equivalent in module names.
+func normalizeModuleName(name string) string {
+	return strings.Replace(name, "-", "_", -1)
+}
@@ -0,0 +1,135 @@
+package grab
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadModulesDep(t *testing.T) {
+	for _, test := range []struct {
+		data string
+		want map[string][]string
+	}{
+		{"", map[string][]string{}},
+		{
+			"kernel/fs/fat/vfat.ko: kernel/fs/fat/fat.ko\nkernel/fs/fat/fat.ko:\n",
+			map[string][]string{
+				"kernel/fs/fat/vfat.ko": {"kernel/fs/fat/fat.ko"},
+				"kernel/fs/fat/fat.ko":  {},
+			},
+		},
+		{
+			"kernel/a.ko.zst: kernel/b.ko.zst kernel/c.ko.zst\nnot a module line\n",
+			map[string][]string{
+				"kernel/a.ko.zst": {"kernel/b.ko.zst", "kernel/c.ko.zst"},
+			},
+		},
+	} {
+		got, err := readModulesDep([]byte(test.data))
+		if err != nil {
+			t.Errorf("readModulesDep(%q): %v", test.data, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("readModulesDep(%q) = %v; want %v", test.data, got, test.want)
+		}
+	}
+}
+
+func TestReadModulesBuiltin(t *testing.T) {
+	for _, test := range []struct {
+		data string
+		want map[string]bool
+	}{
+		{"", map[string]bool{}},
+		{
+			"kernel/fs/ext4/ext4.ko\nkernel/drivers/usb/host/xhci-hcd.ko\n",
+			map[string]bool{"ext4": true, "xhci_hcd": true},
+		},
+	} {
+		if got := readModulesBuiltin([]byte(test.data)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("readModulesBuiltin(%q) = %v; want %v", test.data, got, test.want)
+		}
+	}
+}
+
+func TestKernelModules(t *testing.T) {
+	root := t.TempDir()
+	write := func(p, data string) {
+		host := filepath.Join(root, p)
+		if err := os.MkdirAll(filepath.Dir(host), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(host, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const dir = "lib/modules/6.1.0-test"
+	write(dir+"/modules.dep", "kernel/fs/fat/vfat.ko: kernel/fs/fat/fat.ko\n"+
+		"kernel/fs/fat/fat.ko:\n"+
+		"kernel/net/dummy.ko:\n")
+	write(dir+"/modules.builtin", "kernel/fs/ext4/ext4.ko\n")
+	write(dir+"/modules.alias", "")
+	write(dir+"/kernel/fs/fat/vfat.ko", "vfat")
+	write(dir+"/kernel/fs/fat/fat.ko", "fat")
+	write(dir+"/kernel/net/dummy.ko", "dummy")
+	r := &Resolver{Root: root, Logf: t.Logf}
+
+	list := filepath.Join(t.TempDir(), "modules")
+	for _, test := range []struct {
+		modules string
+		release string
+		want    []string
+		err     string
+	}{
+		{
+			modules: "# filesystems\nvfat\n\next4\n",
+			want: []string{
+				dir + "/modules.alias", dir + "/modules.builtin", dir + "/modules.dep",
+				dir + "/kernel/fs/fat/fat.ko", dir + "/kernel/fs/fat/vfat.ko",
+			},
+		},
+		{
+			modules: "dummy\n",
+			release: "6.1.0-test",
+			want: []string{
+				dir + "/modules.alias", dir + "/modules.builtin", dir + "/modules.dep",
+				dir + "/kernel/net/dummy.ko",
+			},
+		},
+		{modules: "btrfs\n", err: `kernel module "btrfs" not found`},
+		{modules: "vfat\n", release: "5.10.0-other", err: "no such file"},
+	} {
+		if err := ioutil.WriteFile(list, []byte(test.modules), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files, err := r.KernelModules(list, test.release)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("KernelModules(%q, %q) error %v; want %q", test.modules, test.release, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("KernelModules(%q, %q): %v", test.modules, test.release, err)
+			continue
+		}
+		var got []string
+		for _, f := range files {
+			if f.Path != filepath.Join(root, f.Name) {
+				t.Errorf("%s archived from %s", f.Name, f.Path)
+			}
+			got = append(got, f.Name)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("KernelModules(%q, %q) = %v; want %v", test.modules, test.release, got, test.want)
+		}
+	}
+
+	write("lib/modules/6.2.0-test/modules.dep", "")
+	if _, err := r.KernelRelease(); err == nil || !strings.Contains(err.Error(), "-kernel-release") {
+		t.Errorf("KernelRelease with two releases: %v", err)
+	}
+}
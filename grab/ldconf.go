@@ -0,0 +1,90 @@
+package grab
+
+import (
+	"bufio"
+	"path/filepath"
+	"strings"
+)
+
+// ldConfFile is the configuration ldconfig builds ld.so.cache from.
+const ldConfFile = "/etc/ld.so.conf"
+
+// ldConfDirs returns the library directories listed in /etc/ld.so.conf
+// within r.Root, following its include directives, in the order ldconfig
+// would index them. They are read once and kept.
+func (r *Resolver) ldConfDirs() []string {
+	if r.confDirs == nil {
+		r.confDirs = []string{}
+		seenFiles := map[string]bool{}
+		seenDirs := map[string]bool{}
+		r.readLDConf(ldConfFile, seenFiles, seenDirs)
+	}
+	return r.confDirs
+}
+
+// readLDConf appends the directories of the ld.so.conf format file at p,
+// within r.Root, to r.confDirs. Missing and already read files are skipped.
+func (r *Resolver) readLDConf(p string, seenFiles, seenDirs map[string]bool) {
+	if seenFiles[p] {
+		return
+	}
+	seenFiles[p] = true
+
+	fd, err := r.open(p)
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "include":
+			for _, pattern := range fields[1:] {
+				r.includeLDConf(filepath.Dir(p), pattern, seenFiles, seenDirs)
+			}
+		case "hwcap":
+			// Obsolete, and ignored by ldconfig since glibc 2.26.
+		default:
+			// Directories may also be separated by colons or commas, and
+			// may carry an obsolete =TYPE suffix.
+			for _, dir := range strings.FieldsFunc(line, func(c rune) bool {
+				return c == ':' || c == ',' || c == ' ' || c == '\t'
+			}) {
+				if i := strings.IndexByte(dir, '='); i >= 0 {
+					dir = dir[:i]
+				}
+				dir = filepath.Clean(dir)
+				if filepath.IsAbs(dir) && !seenDirs[dir] {
+					seenDirs[dir] = true
+					r.confDirs = append(r.confDirs, dir)
+				}
+			}
+		}
+	}
+}
+
+// includeLDConf reads the files matching pattern, which is relative to dir
+// unless absolute, in sorted order as ldconfig does. Only the final component
+// of pattern may hold wildcards.
+func (r *Resolver) includeLDConf(dir, pattern string, seenFiles, seenDirs map[string]bool) {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+	matches, err := r.globDir(filepath.Dir(pattern), filepath.Base(pattern))
+	if err != nil {
+		return
+	}
+	for _, match := range matches {
+		r.readLDConf(filepath.Join(filepath.Dir(pattern), match), seenFiles, seenDirs)
+	}
+}
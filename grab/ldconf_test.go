@@ -0,0 +1,34 @@
+package grab
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLDConfDirs(t *testing.T) {
+	root := t.TempDir()
+	for name, contents := range map[string]string{
+		"etc/ld.so.conf":                   "include ld.so.conf.d/*.conf\n/opt/lib # trailing comment\n",
+		"etc/ld.so.conf.d/b.conf":          "/usr/local/lib:/opt/b/lib,/opt/lib\n",
+		"etc/ld.so.conf.d/a.conf":          "# comment\nhwcap 0 nosegneg\n/opt/a/lib=libc6\n",
+		"etc/ld.so.conf.d/loop.conf":       "include /etc/ld.so.conf\n",
+		"etc/ld.so.conf.d/ignored.notconf": "/not/included\n",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &Resolver{Root: root}
+	want := []string{"/opt/a/lib", "/usr/local/lib", "/opt/b/lib", "/opt/lib"}
+	if got := r.ldConfDirs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ldConfDirs() = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,78 @@
+package grab
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Libc identifies the C library ABI a binary was linked against, or which a
+// base image provides.
+type Libc string
+
+const (
+	LibcUnknown Libc = ""
+	LibcGlibc   Libc = "glibc"
+	LibcMusl    Libc = "musl"
+)
+
+// libcOf classifies a loader or library by its file name: glibc's
+// ld-linux*.so.N and libc.so.6, or musl's ld-musl-ARCH.so.1 and
+// libc.musl-ARCH.so.1.
+func libcOf(name string) Libc {
+	name = filepath.Base(name)
+	switch {
+	case strings.HasPrefix(name, "ld-musl-"), strings.HasPrefix(name, "libc.musl-"):
+		return LibcMusl
+	case strings.HasPrefix(name, "ld-linux"), name == "libc.so.6":
+		return LibcGlibc
+	}
+	return LibcUnknown
+}
+
+// Libc returns the C library the roots of g need, judged by their
+// interpreters and then their libraries. Static binaries need neither.
+func (g *Graph) Libc() Libc {
+	for _, interp := range g.Interps {
+		if libc := libcOf(interp); libc != LibcUnknown {
+			return libc
+		}
+	}
+	for _, lib := range SortedSet(g.Libraries()) {
+		if libc := libcOf(lib); libc != LibcUnknown {
+			return libc
+		}
+	}
+	return LibcUnknown
+}
+
+// Libc returns the C library b provides, judged by its sonames and the
+// loaders among its paths.
+func (b *Base) Libc() Libc {
+	for _, set := range []map[string]bool{b.Sonames, b.Paths} {
+		for _, name := range sortedKeys(set) {
+			if libc := libcOf(name); libc != LibcUnknown {
+				return libc
+			}
+		}
+	}
+	return LibcUnknown
+}
+
+// CheckLibc returns an error if g needs a different C library from the one b
+// provides. Layering such a bundle on b would resolve the base's libraries
+// against the wrong libc, so it would fail to run.
+func (g *Graph) CheckLibc(b *Base) error {
+	need, have := g.Libc(), b.Libc()
+	if need == LibcUnknown || have == LibcUnknown || need == have {
+		return nil
+	}
+
+	msg := fmt.Sprintf("the binaries need %s but the base provides %s; either", need, have)
+	if need == LibcGlibc {
+		msg += " install gcompat in the base,"
+	}
+	msg += fmt.Sprintf(" use a %s base, or bundle the %s loader and every library"+
+		" regardless of the base with -allow-libc-mismatch", need, need)
+	return NewDiagnostic(DiagLibcMismatch, "base", msg)
+}
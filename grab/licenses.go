@@ -0,0 +1,115 @@
+package grab
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// LicenseFiles returns the copyright and license files of the packages which
+// own the roots, interpreters and libraries archived for g, as found in db,
+// to archive under licenses/<package>/. These are Debian's
+// /usr/share/doc/<package>/copyright and the /usr/share/licenses/<package>/
+// directory other distributions use.
+func (r *Resolver) LicenseFiles(g *Graph, db PackageDB) ([]File, error) {
+	packages := map[string]struct{}{}
+	for _, p := range g.archivedPaths() {
+		if pkg, ok := db.Lookup(p); ok {
+			packages[pkg.Name] = struct{}{}
+		}
+	}
+
+	var licenses []File
+	for _, name := range SortedSet(packages) {
+		var found []string
+		copyright := filepath.Join("/usr/share/doc", name, "copyright")
+		if fi, err := r.stat(copyright); err == nil && fi.Mode().IsRegular() {
+			found = append(found, copyright)
+		}
+		dir := filepath.Join("/usr/share/licenses", name)
+		entries, err := r.globDir(dir, "*")
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, entry := range entries {
+			p := filepath.Join(dir, entry)
+			if fi, err := r.stat(p); err == nil && fi.Mode().IsRegular() {
+				found = append(found, p)
+			}
+		}
+		sort.Strings(found)
+
+		if len(found) == 0 {
+			r.logf("No license files for package %s", name)
+		}
+		for _, p := range found {
+			host, err := r.localPath(p)
+			if err != nil {
+				return nil, err
+			}
+			licenses = append(licenses, File{
+				Path: host,
+				Name: filepath.Join("licenses", name, filepath.Base(p)),
+			})
+		}
+	}
+	return licenses, nil
+}
+
+// archivedPaths returns the paths of the roots, interpreters and libraries
+// archived for g, which the base does not provide.
+func (g *Graph) archivedPaths() []string {
+	paths := append([]string{}, g.Roots...)
+	for _, interp := range g.Interps {
+		if !g.Provided[interp] {
+			paths = append(paths, interp)
+		}
+	}
+	for lib, path := range g.Resolved {
+		if !g.Provided[lib] {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// SourcePackage is a source package which packages owning archived files
+// were built from, as needed to offer their sources, as the GPL asks.
+type SourcePackage struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	// Packages are the binary packages built from it which own archived
+	// files.
+	Packages []string `json:"packages"`
+}
+
+// SourcePackages returns the source packages of the packages, as found in
+// db, which own the roots, interpreters and libraries archived for g,
+// sorted by name and version.
+func SourcePackages(g *Graph, db PackageDB) []SourcePackage {
+	bySource := map[[2]string]map[string]struct{}{}
+	for _, p := range g.archivedPaths() {
+		pkg, ok := db.Lookup(p)
+		if !ok {
+			continue
+		}
+		name, version := pkg.SourcePackage()
+		key := [2]string{name, version}
+		if bySource[key] == nil {
+			bySource[key] = map[string]struct{}{}
+		}
+		bySource[key][pkg.Name] = struct{}{}
+	}
+
+	sources := []SourcePackage{}
+	for key, packages := range bySource {
+		sources = append(sources, SourcePackage{Name: key[0], Version: key[1], Packages: SortedSet(packages)})
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		if sources[i].Name != sources[j].Name {
+			return sources[i].Name < sources[j].Name
+		}
+		return sources[i].Version < sources[j].Version
+	})
+	return sources
+}
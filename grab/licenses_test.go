@@ -0,0 +1,88 @@
+package grab
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLicenseFiles(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{
+		"usr/share/doc/libc6/copyright",
+		"usr/share/doc/libc6/changelog.gz",
+		"usr/share/licenses/zlib/LICENSE",
+		"usr/share/doc/coreutils/copyright",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db := PackageIndex{
+		"/lib/libc.so.6": {Name: "libc6"},
+		"/lib/libz.so.1": {Name: "zlib"},
+		"/lib/libm.so.6": {Name: "libc6"},
+		"/bin/ls":        {Name: "coreutils"},
+	}
+	g := &Graph{
+		Roots: []string{"/usr/bin/app"},
+		Resolved: map[string]string{
+			"libc.so.6": "/lib/libc.so.6",
+			"libz.so.1": "/lib/libz.so.1",
+			"libm.so.6": "/lib/libm.so.6",
+		},
+		Provided: map[string]bool{"libm.so.6": true},
+	}
+
+	r := &Resolver{Root: root}
+	files, err := r.LicenseFiles(g, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []File{
+		{Path: filepath.Join(root, "usr/share/doc/libc6/copyright"), Name: "licenses/libc6/copyright"},
+		{Path: filepath.Join(root, "usr/share/licenses/zlib/LICENSE"), Name: "licenses/zlib/LICENSE"},
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("LicenseFiles = %+v; want %+v", files, want)
+	}
+}
+
+func TestSourcePackages(t *testing.T) {
+	db := PackageIndex{
+		"/lib/libc.so.6":    {Name: "libc6", Version: "2.36-9", Source: "glibc"},
+		"/lib/libm.so.6":    {Name: "libc6", Version: "2.36-9", Source: "glibc"},
+		"/lib/libssl.so.3":  {Name: "libssl3", Version: "3.0.11-1+b1", Source: "openssl", SourceVersion: "3.0.11-1"},
+		"/lib/libcrypto.so": {Name: "libcrypto3", Version: "3.0.11-1+b1", Source: "openssl", SourceVersion: "3.0.11-1"},
+		"/lib/libz.so.1":    {Name: "zlib1g", Version: "1:1.2.13"},
+		"/usr/bin/app":      {Name: "app", Version: "1.0"},
+	}
+	g := &Graph{
+		Roots: []string{"/usr/bin/app"},
+		Resolved: map[string]string{
+			"libc.so.6":     "/lib/libc.so.6",
+			"libm.so.6":     "/lib/libm.so.6",
+			"libssl.so.3":   "/lib/libssl.so.3",
+			"libcrypto.so3": "/lib/libcrypto.so",
+			"libz.so.1":     "/lib/libz.so.1",
+		},
+		Provided: map[string]bool{"libz.so.1": true},
+	}
+
+	want := []SourcePackage{
+		{Name: "app", Version: "1.0", Packages: []string{"app"}},
+		{Name: "glibc", Version: "2.36-9", Packages: []string{"libc6"}},
+		{Name: "openssl", Version: "3.0.11-1", Packages: []string{"libcrypto3", "libssl3"}},
+	}
+	if got := SourcePackages(g, db); !reflect.DeepEqual(got, want) {
+		t.Errorf("SourcePackages = %+v; want %+v", got, want)
+	}
+}
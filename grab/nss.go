@@ -0,0 +1,105 @@
+package grab
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+// NSSConfig is glibc's Name Service Switch configuration, naming the
+// services which look up users, groups and hosts.
+const NSSConfig = "/etc/nsswitch.conf"
+
+// nssDefaults are the services glibc uses without an NSSConfig.
+var nssDefaults = []string{"files", "dns"}
+
+// NSSServices returns the services NSSConfig within r.Root names, in order
+// of first use, or glibc's defaults if there is none.
+func (r *Resolver) NSSServices() ([]string, error) {
+	fd, err := r.open(NSSConfig)
+	if os.IsNotExist(err) {
+		return nssDefaults, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	return parseNSSwitch(fd)
+}
+
+// parseNSSwitch returns the services of lines such as
+// "hosts: files [NOTFOUND=return] dns", in order of first use.
+func parseNSSwitch(r io.Reader) ([]string, error) {
+	var services []string
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		_, list, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		inAction := false
+		for _, word := range strings.Fields(list) {
+			// Actions such as [!UNAVAIL=return] may hold spaces.
+			switch {
+			case strings.HasPrefix(word, "["):
+				inAction = !strings.HasSuffix(word, "]")
+				continue
+			case inAction:
+				inAction = !strings.HasSuffix(word, "]")
+				continue
+			}
+			if !seen[word] {
+				seen[word] = true
+				services = append(services, word)
+			}
+		}
+	}
+	return services, scanner.Err()
+}
+
+// AddNSSModules adds to g the libnss_* modules which glibc dlopens for the
+// services NSSConfig names, such as libnss_files.so.2, with their own
+// dependencies, as needed by libc.so.6. Graphs without glibc are left
+// alone. It returns the modules added; those not found are logged, since
+// recent glibc builds in the files and dns services.
+func (r *Resolver) AddNSSModules(g *Graph) ([]string, error) {
+	libc, ok := g.Resolved["libc.so.6"]
+	if !ok {
+		return nil, nil
+	}
+	f, err := r.openELF(libc)
+	if err != nil {
+		return nil, err
+	}
+	arch := ArchName(f.File)
+	f.Close()
+	services, err := r.NSSServices()
+	if err != nil {
+		return nil, err
+	}
+
+	libs := g.Libraries()
+	var added []string
+	for _, service := range services {
+		module := "libnss_" + service + ".so.2"
+		if _, ok := libs[module]; ok {
+			continue
+		}
+		path, ok := r.Cache.LookupArch(module, r.cacheArch(arch))
+		if !ok {
+			r.logf("%s, for the NSS service %s, was not found", module, service)
+			continue
+		}
+		sub, err := r.recursiveImports(path, nil)
+		if err != nil {
+			return added, err
+		}
+		g.addLoaded("libc.so.6", module, path, sub)
+		libs[module] = struct{}{}
+		added = append(added, module)
+	}
+	return added, nil
+}
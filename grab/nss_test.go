@@ -0,0 +1,84 @@
+package grab
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pwaller/grab-ld-binaries/internal/cachetest"
+	"github.com/pwaller/grab-ld-binaries/internal/elftest"
+)
+
+func TestParseNSSwitch(t *testing.T) {
+	conf := `# /etc/nsswitch.conf
+passwd:         files systemd
+group:          files systemd
+hosts:          files mdns4_minimal [NOTFOUND=return] dns myhostname
+networks:       files
+netgroup:       nis [ !UNAVAIL=return ] files
+`
+	got, err := parseNSSwitch(strings.NewReader(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"files", "systemd", "mdns4_minimal", "dns", "myhostname", "nis"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("services %q; want %q", got, want)
+	}
+}
+
+func TestAddNSSModules(t *testing.T) {
+	root := t.TempDir()
+	for p, o := range map[string]elftest.Object{
+		"/bin/app":                    {Interp: "/lib64/ld-linux-x86-64.so.2", Needed: []string{"libc.so.6"}},
+		"/lib64/ld-linux-x86-64.so.2": {Soname: "ld-linux-x86-64.so.2"},
+		"/lib/libc.so.6":              {Soname: "libc.so.6"},
+		"/lib/libnss_files.so.2":      {Soname: "libnss_files.so.2", Needed: []string{"libc.so.6"}},
+		"/lib/libnss_systemd.so.2":    {Soname: "libnss_systemd.so.2", Needed: []string{"libc.so.6", "libcap.so.2"}},
+		"/lib/libcap.so.2":            {Soname: "libcap.so.2"},
+	} {
+		if err := o.Write(filepath.Join(root, p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	libs := map[string]string{}
+	for _, lib := range []string{"libc.so.6", "libnss_files.so.2", "libnss_systemd.so.2", "libcap.so.2"} {
+		libs[lib] = "/lib/" + lib
+	}
+	if err := cachetest.WriteRoot(root, "amd64", libs); err != nil {
+		t.Fatal(err)
+	}
+	conf := "passwd: files systemd\nhosts: files dns\n"
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, NSSConfig), []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("LD_LIBRARY_PATH", "")
+	r, err := NewRootResolver(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := r.Resolve("/bin/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, err := r.AddNSSModules(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"libnss_files.so.2", "libnss_systemd.so.2"}; !reflect.DeepEqual(added, want) {
+		t.Errorf("added %q; want %q", added, want)
+	}
+	if path := g.Resolved["libcap.so.2"]; path != "/lib/libcap.so.2" {
+		t.Errorf("libcap.so.2, needed by libnss_systemd.so.2, resolved to %q", path)
+	}
+	if by := g.NeededBy("libnss_files.so.2"); !reflect.DeepEqual(by, []string{"libc.so.6"}) {
+		t.Errorf("libnss_files.so.2 needed by %q; want libc.so.6", by)
+	}
+}
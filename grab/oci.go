@@ -0,0 +1,419 @@
+package grab
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// OCI media types, from the image-spec.
+const (
+	ociManifestType = "application/vnd.oci.image.manifest.v1+json"
+	ociConfigType   = "application/vnd.oci.image.config.v1+json"
+	ociLayerType    = "application/vnd.oci.image.layer.v1.tar+gzip"
+	ociIndexType    = "application/vnd.oci.image.index.v1+json"
+)
+
+// AnnotationPrefix namespaces the annotations this package adds to images
+// which have no predefined org.opencontainers.image key.
+const AnnotationPrefix = "io.github.pwaller.grab-ld-binaries."
+
+// Audit records how an image was grabbed, so that it can be traced back from
+// a registry.
+type Audit struct {
+	Created  time.Time // When the grab ran
+	Version  string    // Of this tool
+	Revision string    // The VCS revision this tool was built from
+	// Host is where the grab ran, if it is to be recorded, with Inputs and
+	// Recipe.
+	Host   string
+	Inputs map[string]string // Hex sha256 of each root
+	Recipe string            // Hex Recipe.Digest of the contents
+}
+
+// Annotations returns a as image annotations: the predefined
+// org.opencontainers.image created, version and revision, and source as a
+// file URL of the first input on Host, with the rest under AnnotationPrefix.
+func (a Audit) Annotations(g *Graph) map[string]string {
+	annotations := map[string]string{
+		"org.opencontainers.image.version":  a.Version,
+		"org.opencontainers.image.revision": a.Revision,
+		AnnotationPrefix + "host":           a.Host,
+	}
+	if !a.Created.IsZero() {
+		annotations["org.opencontainers.image.created"] = a.Created.UTC().Format(time.RFC3339)
+	}
+	if a.Host != "" && len(g.Roots) > 0 {
+		source := url.URL{Scheme: "file", Host: a.Host, Path: g.Roots[0]}
+		annotations["org.opencontainers.image.source"] = source.String()
+	}
+	var inputs []string
+	for _, root := range g.Roots {
+		if digest, ok := a.Inputs[root]; ok {
+			inputs = append(inputs, root+"=sha256:"+digest)
+		}
+	}
+	if len(inputs) > 0 {
+		annotations[AnnotationPrefix+"inputs"] = strings.Join(inputs, ",")
+	}
+	if a.Recipe != "" {
+		annotations[AnnotationPrefix+"recipe"] = "sha256:" + a.Recipe
+	}
+	for key, value := range annotations {
+		if value == "" {
+			delete(annotations, key)
+		}
+	}
+	return annotations
+}
+
+// OCIConfig is the runtime configuration of an image written by WriteOCI.
+type OCIConfig struct {
+	Architecture string // GOARCH-style, see ArchName
+	Entrypoint   []string
+	Env          []string
+	// Annotations are set on the manifest, and as labels in the config.
+	Annotations map[string]string
+}
+
+// ociDescriptor is an OCI content descriptor.
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+}
+
+// ociPlatform is the platform of a manifest in an OCI image index.
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// String returns p as os/arch[/variant], as docker's --platform takes it.
+func (p ociPlatform) String() string {
+	if p.Variant != "" {
+		return p.OS + "/" + p.Architecture + "/" + p.Variant
+	}
+	return p.OS + "/" + p.Architecture
+}
+
+// WriteOCI writes an OCI image layout to the directory dir, with a single
+// layer holding files, and returns the total bytes read from disk. opts may
+// be nil. The oci-layout file which marks dir as a layout is written
+// last, so an incomplete layout is not mistaken for an image.
+func WriteOCI(dir string, files []File, opts *TarOptions, config OCIConfig) (int64, error) {
+	blobs := filepath.Join(dir, "blobs", "sha256")
+	if err := os.MkdirAll(blobs, 0755); err != nil {
+		return 0, err
+	}
+
+	total, layer, diffID, err := writeOCILayer(blobs, files, opts)
+	if err != nil {
+		return total, err
+	}
+
+	configBlob := map[string]interface{}{
+		"architecture": config.Architecture,
+		"os":           "linux",
+		"config": map[string]interface{}{
+			"Entrypoint": config.Entrypoint,
+			"Env":        config.Env,
+			"Labels":     config.Annotations,
+		},
+		"rootfs": map[string]interface{}{
+			"type":     "layers",
+			"diff_ids": []string{diffID},
+		},
+	}
+	configDesc, err := writeOCIBlob(blobs, ociConfigType, configBlob)
+	if err != nil {
+		return total, err
+	}
+
+	manifest := map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ociManifestType,
+		"config":        configDesc,
+		"layers":        []ociDescriptor{layer},
+	}
+	if len(config.Annotations) > 0 {
+		manifest["annotations"] = config.Annotations
+	}
+	manifestDesc, err := writeOCIBlob(blobs, ociManifestType, manifest)
+	if err != nil {
+		return total, err
+	}
+
+	index, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ociIndexType,
+		"manifests":     []ociDescriptor{manifestDesc},
+	})
+	if err != nil {
+		return total, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "index.json"), index, 0644); err != nil {
+		return total, err
+	}
+	layout := []byte(`{"imageLayoutVersion":"1.0.0"}`)
+	return total, ioutil.WriteFile(filepath.Join(dir, "oci-layout"), layout, 0644)
+}
+
+// WriteOCIIndex writes an OCI image layout to the directory dir holding the
+// images in srcs, each an image layout as WriteOCI writes for one
+// architecture or an archive of one as WriteOCIArchive writes, under a
+// single image index with the platform of each, so that registries and
+// runtimes pick the image for their own architecture. The images must be
+// for different platforms.
+func WriteOCIIndex(dir string, srcs []string) error {
+	blobs := filepath.Join(dir, "blobs", "sha256")
+	if err := os.MkdirAll(blobs, 0755); err != nil {
+		return err
+	}
+
+	var manifests []ociDescriptor
+	platforms := map[ociPlatform]string{}
+	for _, src := range srcs {
+		desc, err := copyOCIImage(blobs, src)
+		if err != nil {
+			return fmt.Errorf("%s: %v", src, err)
+		}
+		if other, ok := platforms[*desc.Platform]; ok {
+			return fmt.Errorf("%s and %s are both images for %s", other, src, desc.Platform)
+		}
+		platforms[*desc.Platform] = src
+		manifests = append(manifests, desc)
+	}
+
+	indexDesc, err := writeOCIBlob(blobs, ociIndexType, map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ociIndexType,
+		"manifests":     manifests,
+	})
+	if err != nil {
+		return err
+	}
+	index, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ociIndexType,
+		"manifests":     []ociDescriptor{indexDesc},
+	})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "index.json"), index, 0644); err != nil {
+		return err
+	}
+	layout := []byte(`{"imageLayoutVersion":"1.0.0"}`)
+	return ioutil.WriteFile(filepath.Join(dir, "oci-layout"), layout, 0644)
+}
+
+// copyOCIImage copies the blobs of the single image in the OCI image layout
+// src, or archive of one, into blobs, returning its manifest's descriptor
+// with its platform.
+func copyOCIImage(blobs, src string) (ociDescriptor, error) {
+	if fi, err := os.Stat(src); err != nil {
+		return ociDescriptor{}, err
+	} else if !fi.IsDir() {
+		layout, err := ioutil.TempDir("", "grab-oci-")
+		if err != nil {
+			return ociDescriptor{}, err
+		}
+		defer os.RemoveAll(layout)
+		fd, err := os.Open(src)
+		if err != nil {
+			return ociDescriptor{}, err
+		}
+		err = Extract(fd, layout, nil)
+		fd.Close()
+		if err != nil {
+			return ociDescriptor{}, err
+		}
+		src = layout
+	}
+
+	srcBlobs := filepath.Join(src, "blobs", "sha256")
+	var index struct {
+		Manifests []ociDescriptor `json:"manifests"`
+	}
+	if err := readJSON(filepath.Join(src, "index.json"), &index); err != nil {
+		return ociDescriptor{}, err
+	}
+	if len(index.Manifests) != 1 || index.Manifests[0].MediaType != ociManifestType {
+		return ociDescriptor{}, fmt.Errorf("holds %d images, want 1 image manifest", len(index.Manifests))
+	}
+	desc := index.Manifests[0]
+	var manifest struct {
+		Config ociDescriptor   `json:"config"`
+		Layers []ociDescriptor `json:"layers"`
+	}
+	if err := readBlob(srcBlobs, desc.Digest, &manifest); err != nil {
+		return desc, err
+	}
+	var config ociPlatform
+	if err := readBlob(srcBlobs, manifest.Config.Digest, &config); err != nil {
+		return desc, err
+	}
+	if config.Architecture == "" || config.OS == "" {
+		return desc, fmt.Errorf("image config has no architecture and OS")
+	}
+	desc.Platform = &config
+
+	for _, blob := range append([]ociDescriptor{desc, manifest.Config}, manifest.Layers...) {
+		if err := copyBlob(blobs, srcBlobs, blob.Digest); err != nil {
+			return desc, err
+		}
+	}
+	return desc, nil
+}
+
+// copyBlob copies the blob with digest from the directory src to dst,
+// checking its contents against the digest as it goes.
+func copyBlob(dst, src, digest string) error {
+	from, err := blobPath(src, digest)
+	if err != nil {
+		return err
+	}
+	to, err := blobPath(dst, digest)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dst, ".blob-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	_, err = copyFile(io.MultiWriter(tmp, h), from)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if sum := "sha256:" + hex.EncodeToString(h.Sum(nil)); sum != digest {
+		return fmt.Errorf("blob %s has digest %s", digest, sum)
+	}
+	return os.Rename(tmp.Name(), to)
+}
+
+// writeOCILayer writes files as a gzipped tar blob into blobs, returning its
+// descriptor and the digest of the uncompressed tar (the diff ID).
+func writeOCILayer(blobs string, files []File, opts *TarOptions) (
+	total int64, desc ociDescriptor, diffID string, err error,
+) {
+	tmp, err := ioutil.TempFile(blobs, ".layer-")
+	if err != nil {
+		return 0, desc, "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	compressed := sha256.New()
+	uncompressed := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(tmp, compressed))
+	total, err = WriteFiles(io.MultiWriter(gz, uncompressed), files, opts)
+	if err != nil {
+		return total, desc, "", err
+	}
+	if err := gz.Close(); err != nil {
+		return total, desc, "", err
+	}
+
+	fi, err := tmp.Stat()
+	if err != nil {
+		return total, desc, "", err
+	}
+	digest := hex.EncodeToString(compressed.Sum(nil))
+	if err := os.Rename(tmp.Name(), filepath.Join(blobs, digest)); err != nil {
+		return total, desc, "", err
+	}
+
+	desc = ociDescriptor{MediaType: ociLayerType, Digest: "sha256:" + digest, Size: fi.Size()}
+	return total, desc, "sha256:" + hex.EncodeToString(uncompressed.Sum(nil)), nil
+}
+
+// writeOCIBlob writes v as a JSON blob into blobs and returns its descriptor.
+func writeOCIBlob(blobs, mediaType string, v interface{}) (ociDescriptor, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	digest := sha256String(string(data))
+	err = ioutil.WriteFile(filepath.Join(blobs, digest), data, 0644)
+	return ociDescriptor{MediaType: mediaType, Digest: "sha256:" + digest, Size: int64(len(data))}, err
+}
+
+// WriteOCIArchive writes the OCI image layout in dir to w as a tar, the form
+// `podman load` and `skopeo copy oci-archive:` consume.
+func WriteOCIArchive(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		_, err = copyFile(tw, path)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// PushOCI pushes the image in the OCI image layout dir, as WriteOCI writes,
+// to the registry reference ref, such as registry.example.com/app:tag, with
+// oras. Credentials come from the docker config, ~/.docker/config.json, and
+// the credential helpers it names, as for docker push.
+func PushOCI(dir, ref string) error {
+	var index struct {
+		Manifests []ociDescriptor `json:"manifests"`
+	}
+	if err := readJSON(filepath.Join(dir, "index.json"), &index); err != nil {
+		return err
+	}
+	if len(index.Manifests) != 1 {
+		return fmt.Errorf("%s holds %d images, want 1", dir, len(index.Manifests))
+	}
+	args := []string{"cp", "--from-oci-layout", dir + "@" + index.Manifests[0].Digest, ref}
+	cmd := exec.Command("oras", args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("oras %s: %v", strings.Join(args, " "), err)
+	}
+	return nil
+}
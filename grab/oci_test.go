@@ -0,0 +1,288 @@
+package grab
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteOCIDigests(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "app")
+	if err := ioutil.WriteFile(src, []byte("app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	config := OCIConfig{Architecture: "amd64", Entrypoint: []string{"/app"}}
+	if _, err := WriteOCI(dir, []File{{Path: src, Name: "app"}}, nil, config); err != nil {
+		t.Fatal(err)
+	}
+
+	var index struct{ Manifests []ociDescriptor }
+	data, err := ioutil.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatal(err)
+	}
+
+	var manifest struct {
+		Config ociDescriptor
+		Layers []ociDescriptor
+	}
+	checkBlob := func(desc ociDescriptor) []byte {
+		digest := strings.TrimPrefix(desc.Digest, "sha256:")
+		blob, err := ioutil.ReadFile(filepath.Join(dir, "blobs", "sha256", digest))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sha256String(string(blob)); got != digest || int64(len(blob)) != desc.Size {
+			t.Errorf("%s: blob has digest %s and size %d, want size %d",
+				desc.MediaType, got, len(blob), desc.Size)
+		}
+		return blob
+	}
+
+	if len(index.Manifests) != 1 {
+		t.Fatalf("got %d manifests, want 1", len(index.Manifests))
+	}
+	if err := json.Unmarshal(checkBlob(index.Manifests[0]), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	checkBlob(manifest.Config)
+	for _, layer := range manifest.Layers {
+		checkBlob(layer)
+	}
+}
+
+func TestAuditAnnotations(t *testing.T) {
+	g := &Graph{Roots: []string{"/usr/bin/app", "/usr/bin/tool"}}
+	audit := Audit{
+		Created:  time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Version:  "v1.2.0",
+		Revision: "abc123",
+	}
+	want := map[string]string{
+		"org.opencontainers.image.created":  "2020-01-02T03:04:05Z",
+		"org.opencontainers.image.version":  "v1.2.0",
+		"org.opencontainers.image.revision": "abc123",
+	}
+	if got := audit.Annotations(g); !reflect.DeepEqual(got, want) {
+		t.Errorf("Annotations() = %v; want %v", got, want)
+	}
+
+	// The host and inputs are recorded only when asked for.
+	audit.Host = "build1"
+	audit.Inputs = map[string]string{"/usr/bin/app": "aa", "/usr/bin/tool": "bb"}
+	audit.Recipe = "cc"
+	want["org.opencontainers.image.source"] = "file://build1/usr/bin/app"
+	want[AnnotationPrefix+"host"] = "build1"
+	want[AnnotationPrefix+"inputs"] = "/usr/bin/app=sha256:aa,/usr/bin/tool=sha256:bb"
+	want[AnnotationPrefix+"recipe"] = "sha256:cc"
+	if got := audit.Annotations(g); !reflect.DeepEqual(got, want) {
+		t.Errorf("Annotations() with the host = %v; want %v", got, want)
+	}
+}
+
+func TestAppendOCILayer(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "app")
+	if err := ioutil.WriteFile(src, []byte("app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := []File{{Path: src, Name: "app"}}
+
+	dir := t.TempDir()
+	config := OCIConfig{Architecture: "amd64", Env: []string{"LD_LIBRARY_PATH=/opt/lib"}}
+	if _, err := WriteOCI(dir, files, nil, config); err != nil {
+		t.Fatal(err)
+	}
+	annotations := map[string]string{AnnotationPrefix + "host": "build1"}
+	if _, err := AppendOCILayer(dir, files, nil, "amd64", "example.com/app:grabbed", annotations); err != nil {
+		t.Fatal(err)
+	}
+
+	var index struct{ Manifests []ociDescriptor }
+	if err := readJSON(filepath.Join(dir, "index.json"), &index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Manifests) != 1 {
+		t.Fatalf("got %d manifests, want 1", len(index.Manifests))
+	}
+	desc := index.Manifests[0]
+	if got := desc.Annotations[containerdImageName]; got != "example.com/app:grabbed" {
+		t.Errorf("index names the image %q", got)
+	}
+
+	var manifest struct {
+		Config      ociDescriptor
+		Layers      []ociDescriptor
+		Annotations map[string]string
+	}
+	blobs := filepath.Join(dir, "blobs", "sha256")
+	if err := readBlob(blobs, desc.Digest, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Layers) != 2 {
+		t.Errorf("got %d layers, want 2", len(manifest.Layers))
+	}
+	if manifest.Annotations[AnnotationPrefix+"host"] != "build1" {
+		t.Errorf("manifest annotations = %v", manifest.Annotations)
+	}
+
+	var image struct {
+		Config struct{ Env []string }
+		RootFS struct {
+			DiffIDs []string `json:"diff_ids"`
+		} `json:"rootfs"`
+	}
+	if err := readBlob(blobs, manifest.Config.Digest, &image); err != nil {
+		t.Fatal(err)
+	}
+	if len(image.RootFS.DiffIDs) != 2 {
+		t.Errorf("got %d diff_ids, want 2", len(image.RootFS.DiffIDs))
+	}
+	want := []string{"LD_LIBRARY_PATH=/:/opt/lib"}
+	if !reflect.DeepEqual(image.Config.Env, want) {
+		t.Errorf("Env = %q; want %q", image.Config.Env, want)
+	}
+}
+
+func TestWriteOCIIndex(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "app")
+	if err := ioutil.WriteFile(src, []byte("app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := []File{{Path: src, Name: "app"}}
+	var layouts []string
+	for _, arch := range []string{"amd64", "arm64", "arm64"} {
+		dir := t.TempDir()
+		config := OCIConfig{Architecture: arch, Entrypoint: []string{"/app"}}
+		if _, err := WriteOCI(dir, files, nil, config); err != nil {
+			t.Fatal(err)
+		}
+		layouts = append(layouts, dir)
+	}
+
+	dir := t.TempDir()
+	if err := WriteOCIIndex(dir, layouts[:2]); err != nil {
+		t.Fatal(err)
+	}
+	blobs := filepath.Join(dir, "blobs", "sha256")
+	var index struct{ Manifests []ociDescriptor }
+	if err := readJSON(filepath.Join(dir, "index.json"), &index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Manifests) != 1 || index.Manifests[0].MediaType != ociIndexType {
+		t.Fatalf("index.json lists %v, want one image index", index.Manifests)
+	}
+	var images struct{ Manifests []ociDescriptor }
+	if err := readBlob(blobs, index.Manifests[0].Digest, &images); err != nil {
+		t.Fatal(err)
+	}
+	var platforms []string
+	for _, desc := range images.Manifests {
+		platforms = append(platforms, desc.Platform.String())
+		var manifest struct {
+			Config ociDescriptor
+			Layers []ociDescriptor
+		}
+		if err := readBlob(blobs, desc.Digest, &manifest); err != nil {
+			t.Fatal(err)
+		}
+		for _, blob := range append(manifest.Layers, manifest.Config) {
+			p, err := blobPath(blobs, blob.Digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadFile(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if "sha256:"+sha256String(string(data)) != blob.Digest {
+				t.Errorf("blob %s has the wrong digest", blob.Digest)
+			}
+		}
+	}
+	if want := []string{"linux/amd64", "linux/arm64"}; !reflect.DeepEqual(platforms, want) {
+		t.Errorf("index has platforms %v; want %v", platforms, want)
+	}
+	for _, arch := range []string{"amd64", "arm64"} {
+		if _, err := findManifest(blobReader(blobs), index.Manifests, arch); err != nil {
+			t.Errorf("finding the %s image: %v", arch, err)
+		}
+	}
+
+	if err := WriteOCIIndex(t.TempDir(), layouts[1:]); err == nil || !strings.Contains(err.Error(), "both images for linux/arm64") {
+		t.Errorf("two arm64 images combined with error %v", err)
+	}
+}
+
+func TestWriteOCIIndexUntrustedBlobs(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "app")
+	if err := ioutil.WriteFile(src, []byte("app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := []File{{Path: src, Name: "app"}}
+
+	for _, test := range []struct {
+		name   string
+		tamper func(t *testing.T, dir string, manifest ociDescriptor, layer string)
+		err    string
+	}{
+		{
+			name: "traversal",
+			tamper: func(t *testing.T, dir string, manifest ociDescriptor, layer string) {
+				manifest.Digest = "sha256:../../../../outside"
+				data, err := json.Marshal(map[string]interface{}{"manifests": []ociDescriptor{manifest}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(filepath.Join(dir, "index.json"), data, 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: "unsupported blob digest",
+		},
+		{
+			name: "corrupt layer",
+			tamper: func(t *testing.T, dir string, manifest ociDescriptor, layer string) {
+				if err := ioutil.WriteFile(layer, []byte("not the layer"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: "has digest",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if _, err := WriteOCI(dir, files, nil, OCIConfig{Architecture: "amd64"}); err != nil {
+				t.Fatal(err)
+			}
+			blobs := filepath.Join(dir, "blobs", "sha256")
+			var index struct{ Manifests []ociDescriptor }
+			if err := readJSON(filepath.Join(dir, "index.json"), &index); err != nil {
+				t.Fatal(err)
+			}
+			var manifest struct{ Layers []ociDescriptor }
+			if err := readBlob(blobs, index.Manifests[0].Digest, &manifest); err != nil {
+				t.Fatal(err)
+			}
+			layer, err := blobPath(blobs, manifest.Layers[0].Digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.tamper(t, dir, index.Manifests[0], layer)
+
+			out := t.TempDir()
+			err = WriteOCIIndex(out, []string{dir})
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("WriteOCIIndex error %v; want %q", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package grab
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Package is an installed distribution package.
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	Arch    string `json:"arch,omitempty"`
+	// Source and SourceVersion are the source package it was built from,
+	// where they differ from Name and Version.
+	Source        string `json:"source,omitempty"`
+	SourceVersion string `json:"source_version,omitempty"`
+}
+
+// SourcePackage returns the name and version of the source package pkg was
+// built from.
+func (pkg *Package) SourcePackage() (name, version string) {
+	name, version = pkg.Name, pkg.Version
+	if pkg.Source != "" {
+		name = pkg.Source
+	}
+	if pkg.SourceVersion != "" {
+		version = pkg.SourceVersion
+	}
+	return name, version
+}
+
+// dpkgDir is where dpkg keeps its database.
+const dpkgDir = "/var/lib/dpkg"
+
+// PackageDB finds the installed package which owns a path within a root.
+type PackageDB interface {
+	Lookup(p string) (*Package, bool)
+}
+
+// PackageBackends are the package databases Packages can read.
+var PackageBackends = []string{"auto", "dpkg", "rpm"}
+
+// Packages returns the package database of r.Root for backend, one of
+// PackageBackends. "auto" picks whichever the root has, or an empty database
+// if neither.
+func (r *Resolver) Packages(backend string) (PackageDB, error) {
+	if backend == "auto" {
+		switch {
+		case r.exists(filepath.Join(dpkgDir, "status")):
+			backend = "dpkg"
+		case r.exists(rpmDir):
+			backend = "rpm"
+		default:
+			return PackageIndex{}, nil
+		}
+	}
+
+	switch backend {
+	case "dpkg":
+		return r.DpkgPackages()
+	case "rpm":
+		if r.FS != nil {
+			return nil, fmt.Errorf("the rpm backend needs the root on the local filesystem")
+		}
+		return &rpmDB{root: r.Root, owners: map[string]*Package{}}, nil
+	}
+	return nil, fmt.Errorf("unknown package backend %q, want one of %s",
+		backend, strings.Join(PackageBackends, ", "))
+}
+
+// exists reports whether p exists within r.Root.
+func (r *Resolver) exists(p string) bool {
+	_, err := r.stat(p)
+	return err == nil
+}
+
+// PackageIndex maps paths within a root to the packages which own them.
+type PackageIndex map[string]*Package
+
+// Lookup returns the package owning p, a path within the root. Merged-/usr
+// systems own files under /lib by their /usr/lib path or the other way
+// around, so both are tried.
+func (idx PackageIndex) Lookup(p string) (*Package, bool) {
+	if pkg, ok := idx[p]; ok {
+		return pkg, true
+	}
+	alt := "/usr" + p
+	if strings.HasPrefix(p, "/usr/") {
+		alt = strings.TrimPrefix(p, "/usr")
+	}
+	pkg, ok := idx[alt]
+	return pkg, ok
+}
+
+// DpkgPackages reads the dpkg database within r.Root. A root without one
+// yields an empty index.
+func (r *Resolver) DpkgPackages() (PackageIndex, error) {
+	idx := PackageIndex{}
+
+	versions, err := r.dpkgStatus()
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lists, err := r.globDir(filepath.Join(dpkgDir, "info"), "*.list")
+	if err != nil {
+		return nil, err
+	}
+	for _, list := range lists {
+		name := strings.TrimSuffix(list, ".list")
+		pkg, ok := versions[name]
+		if !ok {
+			// Multi-arch packages are listed as name:arch.
+			base, arch, _ := strings.Cut(name, ":")
+			pkg = &Package{Name: base, Arch: arch}
+			if v, ok := versions[base]; ok {
+				pkg.Version, pkg.Source, pkg.SourceVersion = v.Version, v.Source, v.SourceVersion
+			}
+		}
+		if err := r.readDpkgList(filepath.Join(dpkgDir, "info", list), pkg, idx); err != nil {
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
+// dpkgStatus returns the installed packages in the dpkg status file, by
+// name and by name:arch.
+func (r *Resolver) dpkgStatus() (map[string]*Package, error) {
+	fd, err := r.open(filepath.Join(dpkgDir, "status"))
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	packages := map[string]*Package{}
+	pkg := &Package{}
+	flush := func() {
+		if pkg.Name != "" {
+			packages[pkg.Name] = pkg
+			packages[pkg.Name+":"+pkg.Arch] = pkg
+		}
+		pkg = &Package{}
+	}
+
+	scanner := bufio.NewScanner(fd)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Package":
+			pkg.Name = value
+		case "Version":
+			pkg.Version = value
+		case "Architecture":
+			pkg.Arch = value
+		case "Source":
+			// The source version is given if it differs, as
+			// "glibc (2.36-9)".
+			source, version, _ := strings.Cut(value, " (")
+			pkg.Source, pkg.SourceVersion = source, strings.TrimSuffix(version, ")")
+		}
+	}
+	flush()
+	return packages, scanner.Err()
+}
+
+// readDpkgList adds the paths in the dpkg .list file list, within r.Root, to
+// idx as owned by pkg.
+func (r *Resolver) readDpkgList(list string, pkg *Package, idx PackageIndex) error {
+	fd, err := r.open(list)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		if p := scanner.Text(); p != "" && p != "/." {
+			idx[p] = pkg
+		}
+	}
+	return scanner.Err()
+}
+
+// OSRelease returns the fields of os-release(5) within r.Root, or nil if
+// there is none.
+func (r *Resolver) OSRelease() map[string]string {
+	for _, name := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		fd, err := r.open(name)
+		if err != nil {
+			continue
+		}
+		defer fd.Close()
+
+		fields := map[string]string{}
+		scanner := bufio.NewScanner(fd)
+		for scanner.Scan() {
+			key, value, ok := strings.Cut(scanner.Text(), "=")
+			if ok {
+				fields[key] = strings.Trim(value, `"'`)
+			}
+		}
+		return fields
+	}
+	return nil
+}
+
+// rpmDir is where rpm keeps its database.
+const rpmDir = "/var/lib/rpm"
+
+// rpmDB queries the rpm database of root with `rpm -qf`, one path at a time.
+type rpmDB struct {
+	root   string
+	owners map[string]*Package // By path; nil for unowned paths
+}
+
+func (db *rpmDB) Lookup(p string) (*Package, bool) {
+	if pkg, ok := db.owners[p]; ok {
+		return pkg, pkg != nil
+	}
+
+	args := []string{"-qf", "--queryformat", `%{NAME}\t%{EPOCHNUM}:%{VERSION}-%{RELEASE}\t%{ARCH}\t%{SOURCERPM}\n`, p}
+	if !isHostRoot(db.root) {
+		args = append([]string{"--root", db.root}, args...)
+	}
+	out, err := exec.Command("rpm", args...).Output()
+
+	var pkg *Package
+	if err == nil {
+		// Files owned by several packages are listed once per package.
+		line, _, _ := strings.Cut(string(out), "\n")
+		if fields := strings.Split(line, "\t"); len(fields) == 4 {
+			pkg = &Package{
+				Name:    fields[0],
+				Version: strings.TrimPrefix(fields[1], "0:"),
+				Arch:    fields[2],
+			}
+			pkg.Source, pkg.SourceVersion = parseSourceRPM(fields[3])
+			if pkg.Source == pkg.Name {
+				pkg.Source = ""
+			}
+			if pkg.SourceVersion == pkg.Version {
+				pkg.SourceVersion = ""
+			}
+		}
+	}
+	db.owners[p] = pkg
+	return pkg, pkg != nil
+}
+
+// parseSourceRPM returns the name and version-release of a source rpm file
+// name, such as "glibc-2.34-60.el9.src.rpm", or "" for "(none)".
+func parseSourceRPM(srpm string) (name, version string) {
+	nvr := strings.TrimSuffix(srpm, ".src.rpm")
+	if nvr == srpm {
+		return "", ""
+	}
+	release := strings.LastIndex(nvr, "-")
+	if release < 0 {
+		return "", ""
+	}
+	ver := strings.LastIndex(nvr[:release], "-")
+	if ver < 0 {
+		return "", ""
+	}
+	return nvr[:ver], nvr[ver+1:]
+}
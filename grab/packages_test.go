@@ -0,0 +1,134 @@
+package grab
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDpkgPackages(t *testing.T) {
+	root := t.TempDir()
+	for name, contents := range map[string]string{
+		"var/lib/dpkg/status": `Package: libc6
+Status: install ok installed
+Architecture: amd64
+Multi-Arch: same
+Source: glibc
+Version: 2.36-9
+Description: GNU C Library
+ continuation line: not a field
+
+Package: coreutils
+Architecture: amd64
+Version: 9.1-1
+
+Package: libssl3
+Architecture: amd64
+Source: openssl (3.0.11-1)
+Version: 3.0.11-1+b1
+`,
+		"var/lib/dpkg/info/libc6:amd64.list":   "/.\n/lib/x86_64-linux-gnu/libc.so.6\n",
+		"var/lib/dpkg/info/coreutils.list":     "/.\n/bin/ls\n",
+		"var/lib/dpkg/info/libssl3:amd64.list": "/.\n/lib/x86_64-linux-gnu/libssl.so.3\n",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &Resolver{Root: root}
+	idx, err := r.DpkgPackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]Package{
+		"/usr/lib/x86_64-linux-gnu/libc.so.6": {Name: "libc6", Version: "2.36-9", Arch: "amd64", Source: "glibc"},
+		"/bin/ls":                             {Name: "coreutils", Version: "9.1-1", Arch: "amd64"},
+		"/lib/x86_64-linux-gnu/libssl.so.3": {
+			Name: "libssl3", Version: "3.0.11-1+b1", Arch: "amd64", Source: "openssl", SourceVersion: "3.0.11-1",
+		},
+	} {
+		pkg, ok := idx.Lookup(path)
+		if !ok || *pkg != want {
+			t.Errorf("Lookup(%s) = %+v, %v; want %+v", path, pkg, ok, want)
+		}
+	}
+	if _, ok := idx.Lookup("/etc/passwd"); ok {
+		t.Errorf("Lookup(/etc/passwd) found a package")
+	}
+}
+
+func TestParseSourceRPM(t *testing.T) {
+	for srpm, want := range map[string][2]string{
+		"glibc-2.34-60.el9.src.rpm":        {"glibc", "2.34-60.el9"},
+		"perl-Data-Dumper-2.183-1.src.rpm": {"perl-Data-Dumper", "2.183-1"},
+		"(none)":                           {"", ""},
+	} {
+		if name, version := parseSourceRPM(srpm); name != want[0] || version != want[1] {
+			t.Errorf("parseSourceRPM(%s) = %s, %s; want %s, %s", srpm, name, version, want[0], want[1])
+		}
+	}
+}
+
+func TestDpkgNeeds(t *testing.T) {
+	root := t.TempDir()
+	for name, contents := range map[string]string{
+		"var/lib/dpkg/status": `Package: libc6
+Architecture: amd64
+Version: 2.36-9
+
+Package: libfoo1
+Architecture: amd64
+Version: 1.0-1
+Pre-Depends: libc6 (>= 2.34)
+Depends: libbar-1.2 | libbaz, debconf:any
+
+Package: libbar-1.2
+Architecture: amd64
+Version: 1.2-1
+`,
+		"var/lib/dpkg/info/libc6:amd64.list":   "/lib/x86_64-linux-gnu/libc.so.6\n",
+		"var/lib/dpkg/info/libc6:amd64.shlibs": "libc 6 libc6 (>= 2.36)\nudeb: libc 6 libc6-udeb (>= 2.36)\n",
+		"var/lib/dpkg/info/libfoo1.list":       "/usr/lib/libfoo.so.1\n",
+		"var/lib/dpkg/info/libbar-1.2.list":    "/usr/lib/libbar-1.2.so\n",
+		"var/lib/dpkg/info/libbar-1.2.shlibs":  "libbar 1.2 libbar-1.2\n",
+		"var/lib/dpkg/info/libfoo1.shlibs":     "libfoo 1 libfoo1\n",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &Resolver{Root: root}
+	db, err := r.PackageDeps("auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	needed, ok := db.Needed("/usr/lib/libfoo.so.1")
+	if want := []string{"libc.so.6", "libbar-1.2.so"}; !ok || !reflect.DeepEqual(needed, want) {
+		t.Errorf("Needed(libfoo.so.1) = %q, %v; want %q", needed, ok, want)
+	}
+	if _, ok := db.Needed("/usr/lib/unowned.so"); ok {
+		t.Errorf("Needed(unowned.so) found a package")
+	}
+}
+
+func TestParseRPMRequires(t *testing.T) {
+	out := "/bin/sh\nlibc.so.6()(64bit)\nlibc.so.6(GLIBC_2.34)(64bit)\n" +
+		"libz.so.1()(64bit)\nrtld(GNU_HASH)\nconfig(foo) = 1.0\n"
+	want := []string{"libc.so.6", "libz.so.1"}
+	if got := parseRPMRequires(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseRPMRequires() = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,251 @@
+package grab
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// NeededDB recovers the libraries a file needs from the metadata of the
+// installed package owning it, for files which cannot be read.
+type NeededDB interface {
+	// Needed returns the sonames of the libraries which p, a path within
+	// the root, may need, and whether its package is known.
+	Needed(p string) ([]string, bool)
+}
+
+// PackageDeps returns the package dependency metadata of r.Root for
+// backend, one of PackageBackends, as Packages picks the package database.
+func (r *Resolver) PackageDeps(backend string) (NeededDB, error) {
+	if backend == "auto" {
+		switch {
+		case r.exists(filepath.Join(dpkgDir, "status")):
+			backend = "dpkg"
+		case r.exists(rpmDir):
+			backend = "rpm"
+		default:
+			return dpkgNeeds{}, nil
+		}
+	}
+
+	switch backend {
+	case "dpkg":
+		return r.dpkgNeeds()
+	case "rpm":
+		if r.FS != nil {
+			return nil, fmt.Errorf("the rpm backend needs the root on the local filesystem")
+		}
+		return &rpmNeeds{root: r.Root, needed: map[string][]string{}}, nil
+	}
+	return nil, fmt.Errorf("unknown package backend %q, want one of %s",
+		backend, strings.Join(PackageBackends, ", "))
+}
+
+// dpkgNeeds takes the libraries a file needs to be those provided, by
+// their shlibs files, by the packages its own package depends on.
+type dpkgNeeds struct {
+	owners  PackageIndex
+	depends map[string][]string // By name and by name:arch
+	sonames map[string][]string // By name and by name:arch
+}
+
+func (db dpkgNeeds) Needed(p string) ([]string, bool) {
+	pkg, ok := db.owners.Lookup(p)
+	if !ok {
+		return nil, false
+	}
+	deps, ok := db.depends[pkg.Name+":"+pkg.Arch]
+	if !ok {
+		deps = db.depends[pkg.Name]
+	}
+
+	var needed []string
+	seen := map[string]bool{}
+	for _, dep := range deps {
+		for _, soname := range db.sonames[dep] {
+			if !seen[soname] {
+				seen[soname] = true
+				needed = append(needed, soname)
+			}
+		}
+	}
+	return needed, true
+}
+
+// dpkgNeeds reads the dependencies of the packages in the dpkg database
+// within r.Root and the sonames their shlibs files provide.
+func (r *Resolver) dpkgNeeds() (dpkgNeeds, error) {
+	db := dpkgNeeds{depends: map[string][]string{}, sonames: map[string][]string{}}
+	var err error
+	if db.owners, err = r.DpkgPackages(); err != nil {
+		return db, err
+	}
+	if err := r.dpkgDepends(db.depends); err != nil && !os.IsNotExist(err) {
+		return db, err
+	}
+
+	// shlibs files name a library and its version, which are its soname in
+	// one of two forms; the one the package installs is taken.
+	installed := map[string]bool{}
+	for p := range db.owners {
+		installed[filepath.Base(p)] = true
+	}
+	files, err := r.globDir(filepath.Join(dpkgDir, "info"), "*.shlibs")
+	if err != nil {
+		return db, err
+	}
+	for _, file := range files {
+		libs, err := r.readShlibs(filepath.Join(dpkgDir, "info", file))
+		if err != nil {
+			return db, err
+		}
+		name := strings.TrimSuffix(file, ".shlibs")
+		base, _, _ := strings.Cut(name, ":")
+		for _, lib := range libs {
+			soname := lib[0] + ".so." + lib[1]
+			if alt := lib[0] + "-" + lib[1] + ".so"; !installed[soname] && installed[alt] {
+				soname = alt
+			}
+			db.sonames[name] = append(db.sonames[name], soname)
+			if base != name {
+				db.sonames[base] = append(db.sonames[base], soname)
+			}
+		}
+	}
+	return db, nil
+}
+
+// dpkgDepends adds to depends the names of the packages each installed
+// package depends or pre-depends on, taking every alternative.
+func (r *Resolver) dpkgDepends(depends map[string][]string) error {
+	fd, err := r.open(filepath.Join(dpkgDir, "status"))
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	var name, arch string
+	var deps []string
+	flush := func() {
+		if name != "" {
+			depends[name] = deps
+			depends[name+":"+arch] = deps
+		}
+		name, arch, deps = "", "", nil
+	}
+
+	scanner := bufio.NewScanner(fd)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Package":
+			name = value
+		case "Architecture":
+			arch = value
+		case "Depends", "Pre-Depends":
+			deps = append(deps, parseDpkgDepends(value)...)
+		}
+	}
+	flush()
+	return scanner.Err()
+}
+
+// parseDpkgDepends returns the package names in a Depends field, such as
+// "libc6 (>= 2.34), libssl3 | libssl1.1, zlib1g:any", without versions or
+// architecture qualifiers.
+func parseDpkgDepends(field string) []string {
+	var names []string
+	for _, clause := range strings.Split(field, ",") {
+		for _, alt := range strings.Split(clause, "|") {
+			name, _, _ := strings.Cut(strings.TrimSpace(alt), " ")
+			name, _, _ = strings.Cut(name, ":")
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// readShlibs returns the library name and version of each line of the dpkg
+// shlibs file filename, within r.Root. Lines for other package types, such
+// as udeb, are skipped.
+func (r *Resolver) readShlibs(filename string) ([][2]string, error) {
+	fd, err := r.open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var libs [][2]string
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasSuffix(fields[0], ":") {
+			continue
+		}
+		libs = append(libs, [2]string{fields[0], fields[1]})
+	}
+	return libs, scanner.Err()
+}
+
+// rpmNeeds takes the libraries a file needs to be the sonames its package
+// requires, from `rpm -q --requires`.
+type rpmNeeds struct {
+	root   string
+	mu     sync.Mutex
+	needed map[string][]string // By path; nil for unowned paths
+}
+
+func (db *rpmNeeds) Needed(p string) ([]string, bool) {
+	db.mu.Lock()
+	needed, ok := db.needed[p]
+	db.mu.Unlock()
+	if ok {
+		return needed, needed != nil
+	}
+
+	args := []string{"-q", "--requires", "-f", p}
+	if !isHostRoot(db.root) {
+		args = append([]string{"--root", db.root}, args...)
+	}
+	out, err := exec.Command("rpm", args...).Output()
+
+	if err == nil {
+		needed = parseRPMRequires(string(out))
+	}
+	db.mu.Lock()
+	db.needed[p] = needed
+	db.mu.Unlock()
+	return needed, needed != nil
+}
+
+// parseRPMRequires returns the sonames among rpm requirements, one per
+// line, such as "libc.so.6()(64bit)" and "libc.so.6(GLIBC_2.34)(64bit)".
+// The result is non-nil.
+func parseRPMRequires(out string) []string {
+	needed := []string{}
+	seen := map[string]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		soname, _, _ := strings.Cut(strings.TrimSpace(line), "(")
+		if !strings.Contains(soname, ".so") || strings.ContainsAny(soname, "/ ") || seen[soname] {
+			continue
+		}
+		seen[soname] = true
+		needed = append(needed, soname)
+	}
+	return needed
+}
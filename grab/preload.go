@@ -0,0 +1,29 @@
+package grab
+
+import (
+	"os"
+	"strings"
+)
+
+// ldPreloadFile lists libraries ld.so loads into every program.
+const ldPreloadFile = "/etc/ld.so.preload"
+
+// PreloadLibraries returns the libraries ld.so would preload, in the order it
+// loads them: those in env, the value of $LD_PRELOAD, and then those listed in
+// /etc/ld.so.preload within r.Root. Each is a path or a soname to search for
+// as if the program needed it.
+func (r *Resolver) PreloadLibraries(env string) ([]string, error) {
+	libs := splitPreload(env)
+	data, err := r.readFile(ldPreloadFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return append(libs, splitPreload(string(data))...), nil
+}
+
+// splitPreload splits a preload list on whitespace and colons, as ld.so does.
+func splitPreload(s string) []string {
+	return strings.FieldsFunc(s, func(c rune) bool {
+		return c == ':' || c == ' ' || c == '\t' || c == '\n'
+	})
+}
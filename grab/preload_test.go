@@ -0,0 +1,30 @@
+package grab
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPreloadLibraries(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	preload := "/usr/lib/libjemalloc.so.2\nlibfakeroot.so libasan.so.8\n"
+	if err := ioutil.WriteFile(filepath.Join(root, "etc/ld.so.preload"), []byte(preload), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Resolver{Root: root}
+	got, err := r.PreloadLibraries("libtrace.so:/opt/shim.so ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"libtrace.so", "/opt/shim.so", "/usr/lib/libjemalloc.so.2", "libfakeroot.so", "libasan.so.8"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PreloadLibraries = %q; want %q", got, want)
+	}
+}
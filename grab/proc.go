@@ -0,0 +1,128 @@
+package grab
+
+import (
+	"bufio"
+	"debug/elf"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ProcessMaps returns the executable of the running process pid and the
+// other files it has mapped, in order of first mapping, from /proc/PID/exe
+// and /proc/PID/maps. Files deleted or replaced since they were mapped are
+// left out. Paths are as the process sees them, so are within
+// /proc/PID/root for a process in a container.
+func ProcessMaps(pid int) (exe string, mapped []string, err error) {
+	proc := fmt.Sprintf("/proc/%d", pid)
+	exe, err = os.Readlink(filepath.Join(proc, "exe"))
+	if err != nil {
+		return "", nil, err
+	}
+	if strings.HasSuffix(exe, " (deleted)") {
+		return "", nil, fmt.Errorf("process %d runs %s", pid, exe)
+	}
+	fd, err := os.Open(filepath.Join(proc, "maps"))
+	if err != nil {
+		return "", nil, err
+	}
+	defer fd.Close()
+	paths, err := parseMaps(fd)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, p := range paths {
+		if p != exe {
+			mapped = append(mapped, p)
+		}
+	}
+	return exe, mapped, nil
+}
+
+// parseMaps returns the distinct files mapped in a /proc/PID/maps listing,
+// whose lines are such as
+//
+//	7f2c1a000000-7f2c1a028000 r--p 00000000 08:01 1835080 /usr/lib/libc.so.6
+//
+// Anonymous and special mappings, such as [heap] and [vdso], and deleted
+// files are skipped.
+func parseMaps(r io.Reader) ([]string, error) {
+	var paths []string
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 6)
+		if len(fields) < 6 {
+			continue
+		}
+		p := strings.TrimLeft(fields[5], " ")
+		if !strings.HasPrefix(p, "/") || strings.HasSuffix(p, " (deleted)") || seen[p] {
+			continue
+		}
+		seen[p] = true
+		paths = append(paths, p)
+	}
+	return paths, scanner.Err()
+}
+
+// AddMapped adds to g the ELF objects among mapped, paths within r.Root
+// such as from ProcessMaps, which it does not already hold, with their own
+// dependencies, as loaded by the root at exe: they are plugins and the like
+// loaded with dlopen. Each is named by its soname, or else its file name.
+// It returns the names of the objects added.
+func (r *Resolver) AddMapped(g *Graph, exe string, mapped []string) ([]string, error) {
+	have := map[string]bool{}
+	for _, p := range g.Interps {
+		have[r.realPath(p)] = true
+	}
+	for _, p := range g.Resolved {
+		have[r.realPath(p)] = true
+	}
+	parent := g.Roots[0]
+	for _, root := range g.Roots {
+		have[r.realPath(root)] = true
+		if r.realPath(root) == r.realPath(exe) {
+			parent = root
+		}
+	}
+
+	var added []string
+	for _, p := range mapped {
+		if have[r.realPath(p)] || !r.isELF(p) {
+			continue
+		}
+		have[r.realPath(p)] = true
+		name, err := r.soname(p)
+		if err != nil {
+			return added, fmt.Errorf("%s: %v", p, err)
+		}
+		if _, ok := g.Resolved[name]; ok {
+			r.logf("%s is mapped, but %s is already %s", p, name, g.Resolved[name])
+			continue
+		}
+		sub, err := r.recursiveImports(p, nil)
+		if err != nil {
+			return added, err
+		}
+		g.addLoaded(parent, name, p, sub)
+		added = append(added, name)
+	}
+	return added, nil
+}
+
+// soname returns the DT_SONAME of the object at p, within r.Root, or its
+// file name if it has none.
+func (r *Resolver) soname(p string) (string, error) {
+	f, err := r.openELF(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sonames, err := f.DynString(elf.DT_SONAME)
+	if err != nil || len(sonames) == 0 {
+		return filepath.Base(p), nil
+	}
+	return sonames[0], nil
+}
@@ -0,0 +1,80 @@
+package grab
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pwaller/grab-ld-binaries/internal/cachetest"
+	"github.com/pwaller/grab-ld-binaries/internal/elftest"
+)
+
+func TestParseMaps(t *testing.T) {
+	maps := `55d0c8a00000-55d0c8a02000 r--p 00000000 08:01 1835001                    /usr/bin/app
+7f2c1a000000-7f2c1a028000 r--p 00000000 08:01 1835080                    /usr/lib/libc.so.6
+7f2c1a028000-7f2c1a1bd000 r-xp 00028000 08:01 1835080                    /usr/lib/libc.so.6
+7f2c1a200000-7f2c1a210000 r-xp 00000000 08:01 1835099                    /usr/lib/plugins/old.so (deleted)
+7f2c1a300000-7f2c1a321000 rw-p 00000000 00:00 0                          [heap]
+7f2c1a400000-7f2c1a401000 rw-p 00000000 00:00 0 
+7f2c1a500000-7f2c1a600000 r--p 00000000 08:01 1835100                    /usr/lib/plugins/a b.so
+7ffd3b5f2000-7ffd3b5f4000 r-xp 00000000 00:00 0                          [vdso]
+`
+	got, err := parseMaps(strings.NewReader(maps))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/usr/bin/app", "/usr/lib/libc.so.6", "/usr/lib/plugins/a b.so"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mapped %q; want %q", got, want)
+	}
+}
+
+func TestAddMapped(t *testing.T) {
+	root := t.TempDir()
+	for p, o := range map[string]elftest.Object{
+		"/bin/app":                    {Interp: "/lib64/ld-linux-x86-64.so.2", Needed: []string{"libc.so.6"}},
+		"/lib64/ld-linux-x86-64.so.2": {Soname: "ld-linux-x86-64.so.2"},
+		"/lib/libc.so.6":              {Soname: "libc.so.6"},
+		"/opt/plugins/foo.so":         {Needed: []string{"libbar.so.1"}},
+		"/lib/libbar.so.1":            {Soname: "libbar.so.1"},
+	} {
+		if err := o.Write(filepath.Join(root, p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "lib/locale-archive"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cachetest.WriteRoot(root, "amd64", map[string]string{
+		"libc.so.6":   "/lib/libc.so.6",
+		"libbar.so.1": "/lib/libbar.so.1",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LD_LIBRARY_PATH", "")
+	r, err := NewRootResolver(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := r.Resolve("/bin/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mapped := []string{"/lib/libc.so.6", "/lib/locale-archive", "/opt/plugins/foo.so", "/lib64/ld-linux-x86-64.so.2"}
+	added, err := r.AddMapped(g, "/bin/app", mapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(added, []string{"foo.so"}) {
+		t.Errorf("added %q; want foo.so alone", added)
+	}
+	if g.Resolved["libbar.so.1"] != "/lib/libbar.so.1" {
+		t.Errorf("libbar.so.1, needed by the plugin, resolved to %q", g.Resolved["libbar.so.1"])
+	}
+	if by := g.NeededBy("foo.so"); !reflect.DeepEqual(by, []string{"/bin/app"}) {
+		t.Errorf("foo.so needed by %q", by)
+	}
+}
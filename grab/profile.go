@@ -0,0 +1,50 @@
+package grab
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Profiles are the supported values for ProfileFiles.
+var Profiles = []string{"tls", "curses", "tz"}
+
+// profilePaths are where distributions keep each profile's data. Those
+// present within the root are archived.
+var profilePaths = map[string][]string{
+	// CA certificate bundles, for Debian, Fedora, Alpine and SUSE.
+	"tls": {
+		"/etc/ssl/certs/ca-certificates.crt",
+		"/etc/pki/tls/certs/ca-bundle.crt",
+		"/etc/ssl/cert.pem",
+		"/etc/ssl/ca-bundle.pem",
+	},
+	"curses": {"/etc/terminfo", "/lib/terminfo", "/usr/share/terminfo"},
+	"tz":     {"/usr/share/zoneinfo"},
+}
+
+// ProfileFiles returns the runtime data files for profile, one of Profiles,
+// which binaries commonly need beside their libraries: the CA certificates
+// for tls, the terminfo database for curses and the time zone database for
+// tz. They are named by their paths within r.Root, as AddPath names them.
+func (r *Resolver) ProfileFiles(profile string) ([]File, error) {
+	paths, ok := profilePaths[profile]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q, want one of %s",
+			profile, strings.Join(Profiles, ", "))
+	}
+	var files []File
+	for _, p := range paths {
+		if _, err := r.stat(p); err != nil {
+			continue
+		}
+		added, err := r.AddPath(p, nil)
+		if err != nil {
+			return files, err
+		}
+		files = append(files, added...)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("profile %s: none of %s exist", profile, strings.Join(paths, ", "))
+	}
+	return files, nil
+}
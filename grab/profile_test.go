@@ -0,0 +1,49 @@
+package grab
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProfileFiles(t *testing.T) {
+	root := t.TempDir()
+	for _, p := range []string{
+		"etc/pki/tls/certs/ca-bundle.crt",
+		"usr/share/zoneinfo/UTC",
+		"usr/share/zoneinfo/Europe/London",
+	} {
+		host := filepath.Join(root, p)
+		if err := os.MkdirAll(filepath.Dir(host), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(host, []byte(p), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := &Resolver{Root: root}
+
+	for profile, want := range map[string][]string{
+		"tls": {"etc/pki/tls/certs/ca-bundle.crt"},
+		"tz":  {"usr/share/zoneinfo/Europe/London", "usr/share/zoneinfo/UTC"},
+	} {
+		files, err := r.ProfileFiles(profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, file := range files {
+			names = append(names, file.Name)
+		}
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("%s: archived %q; want %q", profile, names, want)
+		}
+	}
+	for _, profile := range []string{"curses", "fonts"} {
+		if _, err := r.ProfileFiles(profile); err == nil {
+			t.Errorf("%s: no error", profile)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package grab
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A Recipe records everything which determines the bytes of the output tar:
+// the inputs, the ld.so.cache they were resolved against, and the header
+// fields and content hash of every archived file. Equal recipes produce
+// identical archives.
+type Recipe struct {
+	Inputs []string `json:"inputs"`
+	// Checksum is the algorithm of LDCache and each file's Sum, one of
+	// Checksums.
+	Checksum string `json:"checksum"`
+	LDCache  string `json:"ld_cache"`
+	// NoPAX, Reproducible, SELinux and Dirs are the TarOptions the archive
+	// was written with, which decide its header formats and entries beyond
+	// what Files records.
+	NoPAX        bool         `json:"no_pax,omitempty"`
+	Reproducible bool         `json:"reproducible,omitempty"`
+	SELinux      bool         `json:"selinux,omitempty"`
+	Dirs         bool         `json:"dirs,omitempty"`
+	Files        []RecipeFile `json:"files"`
+}
+
+type RecipeFile struct {
+	Name    string `json:"name"`
+	Mode    int64  `json:"mode"`
+	UID     int    `json:"uid"`
+	GID     int    `json:"gid"`
+	Uname   string `json:"uname,omitempty"`
+	Gname   string `json:"gname,omitempty"`
+	ModTime int64  `json:"mtime"`
+	Size    int64  `json:"size"`
+	Sum     string `json:"sum"`
+	// Xattrs are the extended attributes archived with the file.
+	Xattrs map[string]string `json:"xattrs,omitempty"`
+}
+
+// MakeRecipe hashes files and the ld.so.cache at cacheFile with checksum, one
+// of Checksums, to build the recipe for an archive of files written with opts.
+func MakeRecipe(
+	inputs []string, cacheFile string, files []File, opts *TarOptions,
+	checksum string,
+) (
+	*Recipe, error,
+) {
+	if checksum == "" {
+		checksum = "sha256"
+	}
+	ldCache, err := checksumFile(checksum, cacheFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts == nil {
+		opts = &TarOptions{}
+	}
+	r := &Recipe{
+		Inputs: inputs, Checksum: checksum, LDCache: ldCache,
+		NoPAX: opts.NoPAX, Reproducible: opts.Reproducible, SELinux: opts.SELinux, Dirs: opts.Dirs,
+	}
+	for _, file := range opts.ordered(files) {
+		hdr, err := TarHeader(file, opts)
+		if err != nil {
+			return nil, err
+		}
+		var sum string
+		switch {
+		case file.Dir:
+			// Directories have no contents to hash.
+		case file.Link != "":
+			sum, err = checksumString(checksum, file.Link)
+		case file.Special:
+			sum, err = checksumString(checksum, fmt.Sprintf("%d:%d", hdr.Devmajor, hdr.Devminor))
+		default:
+			sum, err = checksumFile(checksum, file.Path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.Files = append(r.Files, RecipeFile{
+			Name:    hdr.Name,
+			Mode:    hdr.Mode,
+			UID:     hdr.Uid,
+			GID:     hdr.Gid,
+			Uname:   hdr.Uname,
+			Gname:   hdr.Gname,
+			ModTime: hdr.ModTime.Round(time.Second).Unix(), // As archive/tar writes it
+			Size:    hdr.Size,
+			Sum:     sum,
+			Xattrs:  xattrRecords(hdr),
+		})
+	}
+	return r, nil
+}
+
+// Digest returns the hex sha256 of the recipe's JSON encoding.
+func (r *Recipe) Digest() string {
+	data, err := json.Marshal(r)
+	if err != nil {
+		panic(err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// WriteFile writes r as indented JSON to filename.
+func (r *Recipe) WriteFile(filename string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(data, '\n'), 0666)
+}
+
+// WriteCachedTar writes the archive for r to out. If cacheDir already holds
+// an archive for the recipe it is copied verbatim and hit is true, otherwise
+// the archive is written from files with opts and stored in cacheDir for next
+// time. It returns the total bytes read from disk.
+func WriteCachedTar(
+	out io.Writer, cacheDir string, r *Recipe, files []File, opts *TarOptions,
+) (
+	total int64, hit bool, err error,
+) {
+	cached := r.CachePath(cacheDir)
+
+	fd, err := os.Open(cached)
+	if err == nil {
+		defer fd.Close()
+		total, err = io.Copy(out, fd)
+		return total, true, err
+	}
+	if !os.IsNotExist(err) {
+		return 0, false, err
+	}
+
+	if err := os.MkdirAll(cacheDir, 0777); err != nil {
+		return 0, false, err
+	}
+	tmp, err := ioutil.TempFile(cacheDir, ".tmp-*.tar")
+	if err != nil {
+		return 0, false, err
+	}
+	defer os.Remove(tmp.Name())
+
+	total, err = WriteFiles(io.MultiWriter(out, tmp), files, opts)
+	if err != nil {
+		tmp.Close()
+		return total, false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return total, false, err
+	}
+	return total, false, os.Rename(tmp.Name(), cached)
+}
+
+// CachePath returns where the archive for r is stored in cacheDir.
+func (r *Recipe) CachePath(cacheDir string) string {
+	return filepath.Join(cacheDir, r.Digest()+".tar")
+}
+
+// sha256String returns the hex sha256 of s.
+func sha256String(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// sha256File returns the hex sha256 of the contents of filename.
+func sha256File(filename string) (string, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, fd); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
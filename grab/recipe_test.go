@@ -0,0 +1,66 @@
+package grab
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecipeDigest(t *testing.T) {
+	dir := t.TempDir()
+	cacheFile := filepath.Join(dir, "ld.so.cache")
+	lib := filepath.Join(dir, "lib")
+	for _, p := range []string{cacheFile, lib} {
+		if err := ioutil.WriteFile(p, []byte(p), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := []File{{Path: lib, Name: "usr/lib/libünïcødé.so.2"}}
+
+	digest := func(opts *TarOptions) string {
+		r, err := MakeRecipe([]string{"app"}, cacheFile, files, opts, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.Digest()
+	}
+	base := digest(&TarOptions{})
+	if again := digest(&TarOptions{Prefetch: "on"}); again != base {
+		t.Errorf("digest changed with an option which does not change the archive")
+	}
+	for name, opts := range map[string]*TarOptions{
+		"NoPAX":        {NoPAX: true},
+		"Reproducible": {Reproducible: true},
+		"SELinux":      {SELinux: true},
+		"Dirs":         {Dirs: true},
+	} {
+		if digest(opts) == base {
+			t.Errorf("%s: digest unchanged", name)
+		}
+	}
+
+	// An archive written without PAX records is not served for one with.
+	cacheDir := t.TempDir()
+	for _, test := range []struct {
+		opts    *TarOptions
+		wantHit bool
+	}{
+		{&TarOptions{NoPAX: true}, false},
+		{&TarOptions{}, false},
+		{&TarOptions{}, true},
+	} {
+		r, err := MakeRecipe([]string{"app"}, cacheFile, files, test.opts, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		_, hit, err := WriteCachedTar(&buf, cacheDir, r, files, test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hit != test.wantHit {
+			t.Errorf("NoPAX %t: hit %t; want %t", test.opts.NoPAX, hit, test.wantHit)
+		}
+	}
+}
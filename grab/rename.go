@@ -0,0 +1,64 @@
+package grab
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Rename is a rule substituting another library for the needed libraries
+// whose sonames match Pattern, a glob, such as an organization's fork of a
+// library kept under a different name.
+type Rename struct {
+	Pattern string
+	// Target is the soname to search for instead, or, if it contains a
+	// slash, the path within the root of the file to use.
+	Target string
+}
+
+// LoadRenames reads a rules file of lines "PATTERN -> TARGET", such as
+//
+//	libcrypto.so.1.1 -> /opt/acme/lib/libcrypto-acme.so.1.1
+//	libjpeg.so.8 -> libturbojpeg.so.0
+//
+// Blank lines and lines starting with '#' are ignored. The first rule
+// matching a library applies.
+func LoadRenames(filename string) ([]Rename, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var renames []Rename
+	scanner := bufio.NewScanner(fd)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern, target, ok := strings.Cut(line, "->")
+		pattern, target = strings.TrimSpace(pattern), strings.TrimSpace(target)
+		if !ok || pattern == "" || target == "" {
+			return nil, fmt.Errorf("%s:%d: want PATTERN -> TARGET", filename, n)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%s:%d: bad pattern %q: %v", filename, n, pattern, err)
+		}
+		renames = append(renames, Rename{Pattern: pattern, Target: target})
+	}
+	return renames, scanner.Err()
+}
+
+// rename returns what to search for in place of lib under r.Renames: the
+// target of the first rule matching it, or lib itself.
+func (r *Resolver) rename(lib string) string {
+	for _, rule := range r.Renames {
+		if ok, _ := path.Match(rule.Pattern, lib); ok {
+			return rule.Target
+		}
+	}
+	return lib
+}
@@ -0,0 +1,97 @@
+package grab
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pwaller/grab-ld-binaries/dlcache"
+)
+
+func TestLoadRenames(t *testing.T) {
+	rules := filepath.Join(t.TempDir(), "renames")
+	err := ioutil.WriteFile(rules, []byte(`# Vendored forks
+libcrypto.so.1.1 -> /opt/acme/lib/libcrypto-acme.so.1.1
+
+libjpeg.so.* -> libturbojpeg.so.0
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renames, err := LoadRenames(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Rename{
+		{"libcrypto.so.1.1", "/opt/acme/lib/libcrypto-acme.so.1.1"},
+		{"libjpeg.so.*", "libturbojpeg.so.0"},
+	}
+	if !reflect.DeepEqual(renames, want) {
+		t.Errorf("LoadRenames() = %v; want %v", renames, want)
+	}
+
+	r := &Resolver{Renames: renames}
+	for lib, want := range map[string]string{
+		"libjpeg.so.8": "libturbojpeg.so.0",
+		"libz.so.1":    "libz.so.1",
+	} {
+		if got := r.rename(lib); got != want {
+			t.Errorf("rename(%s) = %s; want %s", lib, got, want)
+		}
+	}
+
+	if err := ioutil.WriteFile(rules, []byte("libfoo.so.1 libbar.so.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRenames(rules); err == nil {
+		t.Errorf("LoadRenames accepted a line without ->")
+	}
+}
+
+func TestResolveRenamed(t *testing.T) {
+	const libDir = "/lib/x86_64-linux-gnu"
+	root := t.TempDir()
+	for dst, src := range map[string]string{
+		"/bin/true":                   "/bin/true",
+		"/opt/acme/libc-acme.so.6":    libDir + "/libc.so.6",
+		"/lib64/ld-linux-x86-64.so.2": "/lib64/ld-linux-x86-64.so.2",
+	} {
+		data, err := ioutil.ReadFile(src)
+		if err != nil {
+			t.Skipf("needs an x86-64 glibc host: %v", err)
+		}
+		path := filepath.Join(root, dst)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, data, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &Resolver{
+		Root:    root,
+		Cache:   &dlcache.DLCache{Root: root},
+		Renames: []Rename{{"libc.so.6", "/opt/acme/libc-acme.so.6"}},
+	}
+	g, err := r.Resolve("/bin/true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := g.Resolved["libc.so.6"]; got != "/opt/acme/libc-acme.so.6" {
+		t.Fatalf("libc.so.6 resolved to %q, want the fork", got)
+	}
+
+	var names []string
+	for _, file := range g.Files() {
+		names = append(names, file.Name)
+	}
+	want := []string{
+		"true", "lib64/ld-linux-x86-64.so.2", "ld-linux-x86-64.so.2", "libc.so.6",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Files() = %q; want %q", names, want)
+	}
+}
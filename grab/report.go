@@ -0,0 +1,168 @@
+package grab
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"sort"
+)
+
+// Report is the machine-readable result of a resolution.
+type Report struct {
+	Binaries     []string        `json:"binaries"`
+	Interpreters []string        `json:"interpreters,omitempty"`
+	Libraries    []LibraryReport `json:"libraries"`
+	// Devices is the device access the libraries imply the binaries need.
+	Devices []DeviceRequirement `json:"devices,omitempty"`
+	// Diagnostics are the problems found, by their stable IDs. Report
+	// includes the missing libraries; callers add the rest.
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+}
+
+// LibraryReport describes one library in a Report.
+type LibraryReport struct {
+	Soname string `json:"soname"`
+	// Path is where the library was found; empty if it is missing.
+	Path string `json:"path,omitempty"`
+	// Size is the size in bytes of the file at Path.
+	Size int64 `json:"size,omitempty"`
+	// NeededBy are the binaries and libraries whose DT_NEEDED names it.
+	NeededBy []string `json:"neededBy"`
+	// RequiredBy are all the binaries and libraries which need it directly
+	// or through others, so would fail to load without it.
+	RequiredBy []string `json:"requiredBy"`
+	Provided   bool     `json:"providedByBase,omitempty"`
+	Missing    bool     `json:"missing,omitempty"`
+	// Versions are the highest symbol versions of each family, such as
+	// GLIBC_2.34, which the binaries and libraries require of it. See
+	// Graph.RequiredVersions.
+	Versions []string `json:"requiredVersions,omitempty"`
+	// Package owns Path, if looked up with AddPackages.
+	Package *Package `json:"package,omitempty"`
+}
+
+// AddPackages looks up the package owning each resolved library in db.
+func (report *Report) AddPackages(db PackageDB) {
+	for i, lib := range report.Libraries {
+		if lib.Path != "" {
+			report.Libraries[i].Package, _ = db.Lookup(lib.Path)
+		}
+	}
+}
+
+// Report summarises g, with libraries in soname order.
+func (g *Graph) Report() (*Report, error) {
+	neededBy := map[string][]string{}
+	for node, deps := range g.Edges {
+		for _, dep := range deps {
+			neededBy[dep] = append(neededBy[dep], node)
+		}
+	}
+
+	report := &Report{
+		Binaries:     g.Roots,
+		Interpreters: g.Interps,
+		Libraries:    []LibraryReport{},
+		Devices:      g.DeviceRequirements(),
+		Diagnostics:  g.MissingDiagnostics(),
+	}
+	required, err := g.RequiredVersions()
+	if err != nil {
+		return nil, err
+	}
+	for _, lib := range SortedSet(g.Libraries()) {
+		parents := neededBy[lib]
+		sort.Strings(parents)
+
+		path, ok := g.Resolved[lib]
+		lr := LibraryReport{
+			Soname:     lib,
+			Path:       path,
+			NeededBy:   parents,
+			RequiredBy: ancestors(neededBy, lib),
+			Provided:   g.Provided[lib],
+			Missing:    !ok && !g.Provided[lib],
+			Versions:   required[lib],
+		}
+		if ok {
+			fi, err := os.Stat(g.host(path))
+			if err != nil {
+				return nil, err
+			}
+			lr.Size = fi.Size()
+		}
+		report.Libraries = append(report.Libraries, lr)
+	}
+	return report, nil
+}
+
+// ancestors returns the sorted nodes from which lib is reachable, given the
+// direct dependents of each node.
+func ancestors(neededBy map[string][]string, lib string) []string {
+	seen := map[string]struct{}{}
+	queue := []string{lib}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, parent := range neededBy[node] {
+			if _, ok := seen[parent]; !ok && parent != lib {
+				seen[parent] = struct{}{}
+				queue = append(queue, parent)
+			}
+		}
+	}
+	return SortedSet(seen)
+}
+
+// Graph rebuilds the dependency graph report was made from, as far as it
+// records it, so questions such as Graph.Paths can be answered offline. The
+// libraries each node needs are in soname order rather than the order of
+// its dynamic section, and Sysroot is unknown.
+func (report *Report) Graph() *Graph {
+	g := &Graph{
+		Roots:    report.Binaries,
+		Interps:  report.Interpreters,
+		Edges:    map[string][]string{},
+		Resolved: map[string]string{},
+		Provided: map[string]bool{},
+	}
+	for _, lib := range report.Libraries {
+		for _, parent := range lib.NeededBy {
+			g.Edges[parent] = append(g.Edges[parent], lib.Soname)
+		}
+		if lib.Path != "" {
+			g.Resolved[lib.Soname] = lib.Path
+		}
+		if lib.Provided {
+			g.Provided[lib.Soname] = true
+		}
+	}
+	return g
+}
+
+// LoadReport returns the graph recorded in the JSON report at filename, or
+// nil if it is not one, such as when it is a binary or a command to look up
+// in $PATH rather than a file.
+func LoadReport(filename string) (*Graph, error) {
+	fd, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	br := bufio.NewReader(fd)
+	head, _ := br.Peek(512)
+	if !bytes.HasPrefix(bytes.TrimSpace(head), []byte("{")) {
+		return nil, nil
+	}
+
+	var report Report
+	if err := json.NewDecoder(br).Decode(&report); err != nil {
+		return nil, err
+	}
+	return report.Graph(), nil
+}
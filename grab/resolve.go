@@ -0,0 +1,433 @@
+package grab
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pwaller/grab-ld-binaries/dlcache"
+)
+
+// DefaultIgnore are the virtual libraries provided by the kernel, which some
+// toolchains record as DT_NEEDED but which never exist on disk.
+var DefaultIgnore = []string{
+	"linux-vdso.so.1",
+	"linux-vdso32.so.1",
+	"linux-vdso64.so.1",
+	"linux-gate.so.1",
+}
+
+// Resolver computes dependency graphs by looking libraries up in an
+// ld.so.cache.
+type Resolver struct {
+	// Root is the filesystem tree binaries and libraries are resolved in.
+	// Paths in graphs are relative to it. Empty means /.
+	Root string
+	// CacheFile is the host path of the ld.so.cache that Cache was loaded
+	// from.
+	CacheFile string
+	Cache     *dlcache.DLCache
+	// Ignore holds glob patterns of libraries which are provided by the host
+	// and so are neither resolved nor reported.
+	Ignore []string
+	// Logf, if non-nil, is called with progress messages.
+	Logf func(format string, args ...interface{})
+	// Overlay maps paths within Root to host files which stand in for them,
+	// such as inputs downloaded from elsewhere to be resolved against Root.
+	Overlay map[string]string
+	// FS, if set, is the root filesystem binaries and libraries are read
+	// from when resolving, in place of the host tree at Root and Overlay.
+	// Files are still archived from Root. See NewFSResolver.
+	FS fs.FS
+	// SpecialFiles is what AddPath does with FIFOs, sockets and device
+	// nodes, one of SpecialFilePolicies. Empty means skip.
+	SpecialFiles string
+	// Arch, if set, selects the ld.so.cache entries for this architecture
+	// (see ArchName and dlcache.Arches), rather than for the architecture of
+	// the object needing each library, or of Root's shell for binaries
+	// named by soname.
+	Arch string
+	// Preload are libraries loaded into each binary ahead of the ones it
+	// needs, such as from PreloadLibraries. They are resolved as if each
+	// binary needed them.
+	Preload []string
+	// ELFReader is how binaries and libraries are read, one of ELFReaders.
+	// Empty means auto.
+	ELFReader string
+	// PackageNeeds, if set, stands in for objects which cannot be read, as
+	// for EACCES on hardened hosts: the libraries they need are taken from
+	// their packages' metadata, so the graph and its missing libraries are
+	// complete even though their bytes cannot be copied. This errs towards
+	// too many libraries, as dependencies often provide more than is used.
+	PackageNeeds NeededDB
+	// Renames substitute other libraries for needed ones, such as vendored
+	// forks. The graph keeps the needed soname, resolved to the substitute,
+	// which Files archives under that soname alone. See LoadRenames.
+	Renames []Rename
+	// Jobs, if above one, is how many of its inputs ResolveAll resolves at
+	// once. PackageNeeds and FS must then be safe for concurrent use.
+	Jobs int
+	// Diagnostics are the problems found so far.
+	Diagnostics []Diagnostic
+
+	confDirs []string     // See ldConfDirs
+	objects  *objectCache // Set during ResolveAll
+}
+
+// NewResolver returns a Resolver using the system ld.so.cache and
+// DefaultIgnore.
+func NewResolver() (*Resolver, error) {
+	return NewRootResolver("/")
+}
+
+// NewRootResolver returns a Resolver for the filesystem tree at root, such as
+// an unpacked container image or a cross-compilation sysroot, using its
+// ld.so.cache and DefaultIgnore.
+func NewRootResolver(root string) (*Resolver, error) {
+	dc, err := dlcache.LoadRoot(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ld.so.cache: %v", err)
+	}
+	return &Resolver{
+		Root:      root,
+		CacheFile: filepath.Join(root, "/etc/ld.so.cache"),
+		Cache:     dc,
+		Ignore:    DefaultIgnore,
+	}, nil
+}
+
+// Resolve returns the dependency graph of the binaries at paths using the
+// system ld.so.cache.
+func Resolve(paths ...string) (*Graph, error) {
+	r, err := NewResolver()
+	if err != nil {
+		return nil, err
+	}
+	return r.ResolveAll(paths)
+}
+
+// ResolveAll returns the union of the dependency graphs of filenames. Where
+// two of them resolve a library to different files, such as a vendored copy
+// found by one's RPATH and the system's by another, only the first is kept,
+// so a DiagSonameConflict is recorded. Objects are read once however many
+// of filenames need them, and r.Jobs of filenames are resolved at once, with
+// the same result as one at a time.
+func (r *Resolver) ResolveAll(filenames []string) (*Graph, error) {
+	r.objects = &objectCache{objects: map[string]objectResult{}}
+	defer func() { r.objects = nil }()
+
+	subs, err := r.resolveEach(filenames)
+	if err != nil {
+		return nil, err
+	}
+
+	var g *Graph
+	// keptBy holds the objects which need each library and found the file
+	// kept for it.
+	keptBy := map[string]map[string]struct{}{}
+	for _, sub := range subs {
+		if g == nil {
+			g = sub
+		} else {
+			r.checkConflicts(g, sub, keptBy)
+			g.Merge(sub)
+		}
+		for lib, path := range sub.Resolved {
+			if r.realPath(path) != r.realPath(g.Resolved[lib]) {
+				continue
+			}
+			if keptBy[lib] == nil {
+				keptBy[lib] = map[string]struct{}{}
+			}
+			for _, parent := range sub.NeededBy(lib) {
+				keptBy[lib][parent] = struct{}{}
+			}
+		}
+	}
+	if g == nil {
+		return nil, fmt.Errorf("no binaries to resolve")
+	}
+	return g, nil
+}
+
+// resolveEach returns the dependency graph of each of filenames, resolving
+// r.Jobs of them at once. Their progress messages and diagnostics are
+// passed on in the order of filenames, as if resolved one at a time.
+func (r *Resolver) resolveEach(filenames []string) ([]*Graph, error) {
+	subs := make([]*Graph, len(filenames))
+	if r.Jobs <= 1 || len(filenames) <= 1 {
+		for i, filename := range filenames {
+			sub, err := r.Resolve(filename)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", filename, err)
+			}
+			subs[i] = sub
+		}
+		return subs, nil
+	}
+
+	// Each filename is resolved by a copy of r, which must not read
+	// ld.so.conf itself.
+	r.ldConfDirs()
+	type result struct {
+		logs        []string
+		diagnostics []Diagnostic
+		err         error
+	}
+	results := make([]result, len(filenames))
+	jobs := make(chan struct{}, r.Jobs)
+	var wg sync.WaitGroup
+	for i, filename := range filenames {
+		wg.Add(1)
+		go func(i int, filename string) {
+			defer wg.Done()
+			jobs <- struct{}{}
+			defer func() { <-jobs }()
+
+			res := &results[i]
+			job := *r
+			job.Diagnostics = append([]Diagnostic{}, r.Diagnostics...)
+			job.Logf = func(format string, args ...interface{}) {
+				res.logs = append(res.logs, fmt.Sprintf(format, args...))
+			}
+			subs[i], res.err = job.Resolve(filename)
+			res.diagnostics = job.Diagnostics[len(r.Diagnostics):]
+		}(i, filename)
+	}
+	wg.Wait()
+
+	for i, res := range results {
+		for _, msg := range res.logs {
+			r.logf("%s", msg)
+		}
+		for _, d := range res.diagnostics {
+			if !r.diagnosed(d) {
+				r.Diagnostics = append(r.Diagnostics, d)
+			}
+		}
+		if res.err != nil {
+			return nil, fmt.Errorf("%s: %v", filenames[i], res.err)
+		}
+	}
+	return subs, nil
+}
+
+// checkConflicts diagnoses the libraries which sub resolves to a different
+// file than g, as Merge would silently drop sub's. keptBy holds what needs
+// each of g's.
+func (r *Resolver) checkConflicts(g, sub *Graph, keptBy map[string]map[string]struct{}) {
+	for _, lib := range SortedSet(sub.Libraries()) {
+		kept, ok := g.Resolved[lib]
+		other, subOK := sub.Resolved[lib]
+		if !ok || !subOK || r.realPath(kept) == r.realPath(other) {
+			continue
+		}
+		r.diagnose(NewDiagnostic(DiagSonameConflict, lib,
+			other, strings.Join(sub.NeededBy(lib), ", "),
+			kept, strings.Join(SortedSet(keptBy[lib]), ", ")))
+	}
+}
+
+// Resolve locates the binary named by filename (which may be a path, a
+// command in $PATH or a library in the cache) and returns its dependency
+// graph with every library resolved to a file where possible.
+func (r *Resolver) Resolve(filename string) (*Graph, error) {
+	filename, err := r.resolveBinary(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := r.toolchainImports(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	g.Sysroot = r.Root
+	g.Overlay = r.Overlay
+	return g, nil
+}
+
+// host returns the host path of p, which is relative to r.Root.
+func (r *Resolver) host(p string) string {
+	if host, ok := r.Overlay[p]; ok {
+		return host
+	}
+	return hostPath(r.Root, p)
+}
+
+// cacheArch returns the architecture to look libraries up in the
+// ld.so.cache for, for an object of arch.
+func (r *Resolver) cacheArch(arch string) string {
+	if r.Arch != "" {
+		return r.Arch
+	}
+	return arch
+}
+
+func (r *Resolver) logf(format string, args ...interface{}) {
+	if r.Logf != nil {
+		r.Logf(format, args...)
+	}
+}
+
+// isIgnored reports whether lib matches one of the Ignore patterns.
+func (r *Resolver) isIgnored(lib string) bool {
+	for _, pattern := range r.Ignore {
+		if ok, _ := path.Match(pattern, lib); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// recursiveImports returns the dependency graph of all imports for a given
+// filename, resolving each library as ld.so would. Libraries are visited
+// breadth first, which is the order ld.so loads them in, and the first place
+// a soname is found is the one used by every object needing it. The preload
+// libraries are loaded first, as if filename needed them.
+func (r *Resolver) recursiveImports(filename string, preload []string) (*Graph, error) {
+	g := &Graph{
+		Roots:    []string{filename},
+		Edges:    map[string][]string{},
+		Resolved: map[string]string{},
+	}
+
+	root, err := r.loadObject(filename, nil)
+	if err != nil {
+		return nil, err
+	}
+	root.needed = append(append([]string{}, preload...), root.needed...)
+
+	seen := map[string]bool{filename: true}
+	queue := []*object{root}
+	names := map[*object]string{root: filename}
+	loaded := []*object{root}
+	searchedBy := map[string]*object{}
+
+	// The interpreter is loaded by the kernel rather than ld.so, but the
+	// binary cannot start without it or its own dependencies.
+	if root.interp != "" {
+		interp, err := r.loadObject(root.interp, nil)
+		if err != nil {
+			return nil, fmt.Errorf("interpreter: %v", err)
+		}
+		g.Interps = []string{root.interp}
+		seen[root.interp] = true
+		names[interp] = root.interp
+		queue = append(queue, interp)
+		loaded = append(loaded, interp)
+	}
+
+	for len(queue) > 0 {
+		obj := queue[0]
+		queue = queue[1:]
+
+		var deps []string
+		for _, lib := range obj.needed {
+			if !r.isIgnored(lib) {
+				deps = append(deps, lib)
+			}
+		}
+		g.Edges[names[obj]] = deps
+
+		for _, lib := range deps {
+			if seen[lib] {
+				continue
+			}
+			seen[lib] = true
+
+			target := r.rename(lib)
+			if target != lib {
+				r.logf("%s renamed to %s", lib, target)
+			}
+			path, ok := r.search(target, obj)
+			if !ok {
+				continue
+			}
+			dep, err := r.loadObject(path, obj)
+			if err != nil {
+				return nil, err
+			}
+			g.Resolved[lib] = path
+			names[dep] = lib
+			queue = append(queue, dep)
+			loaded = append(loaded, dep)
+			searchedBy[lib] = obj
+		}
+	}
+
+	r.checkVersions(g, loaded, searchedBy)
+	return g, nil
+}
+
+// resolveBinary looks up "filename" in the $PATH and in the ld.so.cache.
+func (r *Resolver) resolveBinary(filename string) (string, error) {
+	var (
+		err error
+		fn  string
+	)
+
+	if _, err = r.stat(filename); os.IsNotExist(err) {
+		// Try looking for executables in $PATH.
+		if fn, err = r.lookPath(filename); err == nil {
+			filename = fn
+		} else if err != nil {
+			// Try looking in the ld.so.cache.
+			if fn, ok := r.Cache.LookupArch(filename, r.cacheArch(r.rootArch())); ok {
+				r.logf("Resolved %q to %q", filename, fn)
+				filename, err = fn, nil
+			} else {
+				err = fmt.Errorf("Unable to locate %q", filename)
+			}
+		}
+	}
+	return filename, err
+}
+
+// rootArch returns the architecture of the binaries within r.Root, from the
+// ELF machine of its shell, to look binaries named by soname up for. It is
+// empty, for the running architecture, if Root is the host's or it has no
+// shell.
+func (r *Resolver) rootArch() string {
+	if r.FS == nil && isHostRoot(r.Root) {
+		return ""
+	}
+	for _, sh := range []string{"/bin/sh", "/usr/bin/sh"} {
+		f, err := r.openELF(sh)
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+		return ArchName(f.File)
+	}
+	return ""
+}
+
+// rootPath is searched for commands within a Root other than /, since the
+// host's $PATH says nothing about it.
+var rootPath = []string{
+	"/usr/local/sbin", "/usr/local/bin", "/usr/sbin", "/usr/bin", "/sbin", "/bin",
+}
+
+// lookPath is exec.LookPath, searching rootPath within r.Root if set.
+func (r *Resolver) lookPath(file string) (string, error) {
+	if r.FS == nil && isHostRoot(r.Root) {
+		return exec.LookPath(file)
+	}
+	if strings.Contains(file, "/") {
+		return "", fmt.Errorf("%s: not found", file)
+	}
+	for _, dir := range rootPath {
+		path := filepath.Join(dir, file)
+		fi, err := r.stat(path)
+		if err == nil && fi.Mode().IsRegular() && fi.Mode()&0111 != 0 {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%s: not found in %s", file, r.Root)
+}
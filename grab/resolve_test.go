@@ -0,0 +1,300 @@
+package grab
+
+import (
+	"archive/tar"
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pwaller/grab-ld-binaries/internal/cachetest"
+	"github.com/pwaller/grab-ld-binaries/internal/elftest"
+)
+
+// fixtureRoot builds a filesystem tree holding /bin/app, which needs
+// libfoo.so.1 through its DT_RUNPATH, which needs libbar.so.2 through the
+// ld.so.cache, and libmissing.so.9, which is nowhere.
+func fixtureRoot(t *testing.T) string {
+	root := t.TempDir()
+	for path, o := range map[string]elftest.Object{
+		"/bin/app": {
+			Interp:  "/lib64/ld-linux-x86-64.so.2",
+			Needed:  []string{"libfoo.so.1", "libmissing.so.9"},
+			RunPath: "$ORIGIN/../opt/lib",
+		},
+		"/lib64/ld-linux-x86-64.so.2": {Soname: "ld-linux-x86-64.so.2"},
+		"/opt/lib/libfoo.so.1.2.3":    {Soname: "libfoo.so.1", Needed: []string{"libbar.so.2"}},
+		"/srv/lib/libbar.so.2":        {Soname: "libbar.so.2"},
+		// A 32-bit impostor in a default directory, which must be skipped.
+		"/usr/lib/libbar.so.2": {Class: elf.ELFCLASS32, Machine: elf.EM_386, Soname: "libbar.so.2"},
+	} {
+		if err := o.Write(filepath.Join(root, path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("libfoo.so.1.2.3", filepath.Join(root, "opt/lib/libfoo.so.1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cachetest.WriteRoot(root, "amd64", map[string]string{
+		"libbar.so.2": "/srv/lib/libbar.so.2",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestGrabFixture(t *testing.T) {
+	root := fixtureRoot(t)
+	t.Setenv("LD_LIBRARY_PATH", "")
+
+	for _, reader := range ELFReaders {
+		r, err := NewRootResolver(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.ELFReader = reader
+		g, err := r.Resolve("/bin/app")
+		if err != nil {
+			t.Fatalf("%s: %v", reader, err)
+		}
+
+		wantResolved := map[string]string{
+			"libfoo.so.1": "/opt/lib/libfoo.so.1",
+			"libbar.so.2": "/srv/lib/libbar.so.2",
+		}
+		if !reflect.DeepEqual(g.Resolved, wantResolved) {
+			t.Errorf("%s: resolved %v; want %v", reader, g.Resolved, wantResolved)
+		}
+		if missing := g.Missing(); !reflect.DeepEqual(missing, []string{"libmissing.so.9"}) {
+			t.Errorf("%s: missing %q; want libmissing.so.9", reader, missing)
+		}
+
+		var buf bytes.Buffer
+		if err := WriteTar(&buf, g); err != nil {
+			t.Fatal(err)
+		}
+		type entry struct{ name, link, source string }
+		var got []entry
+		tr := tar.NewReader(&buf)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Name the fixture each file's contents came from.
+			source := ""
+			for _, path := range []string{
+				"/bin/app", "/lib64/ld-linux-x86-64.so.2",
+				"/opt/lib/libfoo.so.1.2.3", "/srv/lib/libbar.so.2",
+			} {
+				want, _ := ioutil.ReadFile(filepath.Join(root, path))
+				if hdr.Typeflag == tar.TypeReg && bytes.Equal(data, want) {
+					source = path
+				}
+			}
+			got = append(got, entry{hdr.Name, hdr.Linkname, source})
+		}
+		want := []entry{
+			{"app", "", "/bin/app"},
+			{"lib64/ld-linux-x86-64.so.2", "", "/lib64/ld-linux-x86-64.so.2"},
+			{"libbar.so.2", "", "/srv/lib/libbar.so.2"},
+			{"libfoo.so.1", "libfoo.so.1.2.3", ""},
+			{"libfoo.so.1.2.3", "", "/opt/lib/libfoo.so.1.2.3"},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: archived %+v; want %+v", reader, got, want)
+		}
+	}
+}
+
+func TestSearchOrder(t *testing.T) {
+	// Each case resolves /opt/app/bin/app, which needs libfoo.so.1, with
+	// $LD_LIBRARY_PATH set to /env and a copy of libfoo.so.1 in each of
+	// dirs.
+	for _, test := range []struct {
+		name           string
+		rpath, runpath string
+		dirs           []string
+		want           string
+	}{
+		{
+			name:  "rpath before LD_LIBRARY_PATH",
+			rpath: "/rpath",
+			dirs:  []string{"/rpath", "/env"},
+			want:  "/rpath/libfoo.so.1",
+		},
+		{
+			name:    "rpath ignored with runpath",
+			rpath:   "/rpath",
+			runpath: "/runpath",
+			dirs:    []string{"/rpath", "/runpath"},
+			want:    "/runpath/libfoo.so.1",
+		},
+		{
+			name:    "runpath after LD_LIBRARY_PATH",
+			runpath: "/runpath",
+			dirs:    []string{"/runpath", "/env"},
+			want:    "/env/libfoo.so.1",
+		},
+		{
+			name:    "runpath before the defaults",
+			runpath: "/runpath",
+			dirs:    []string{"/runpath", "/usr/lib"},
+			want:    "/runpath/libfoo.so.1",
+		},
+		{
+			name:    "origin",
+			runpath: "$ORIGIN/../lib:/runpath",
+			dirs:    []string{"/opt/app/lib", "/runpath"},
+			want:    "/opt/app/lib/libfoo.so.1",
+		},
+		{
+			name:  "braced origin",
+			rpath: "${ORIGIN}/../lib",
+			dirs:  []string{"/opt/app/lib", "/env"},
+			want:  "/opt/app/lib/libfoo.so.1",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("LD_LIBRARY_PATH", "/env")
+			root := t.TempDir()
+			app := elftest.Object{Needed: []string{"libfoo.so.1"}, RPath: test.rpath, RunPath: test.runpath}
+			if err := app.Write(filepath.Join(root, "opt/app/bin/app")); err != nil {
+				t.Fatal(err)
+			}
+			for _, dir := range test.dirs {
+				lib := elftest.Object{Soname: "libfoo.so.1"}
+				if err := lib.Write(filepath.Join(root, dir, "libfoo.so.1")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := cachetest.WriteRoot(root, "amd64", nil); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := NewRootResolver(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			g, err := r.Resolve("/opt/app/bin/app")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := g.Resolved["libfoo.so.1"]; got != test.want {
+				t.Errorf("resolved libfoo.so.1 to %q; want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestResolveSonameInForeignRoot(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("LD_LIBRARY_PATH", "")
+	for path, o := range map[string]elftest.Object{
+		"/bin/sh":          {Machine: elf.EM_AARCH64, Needed: []string{"libc.so.6"}},
+		"/lib/libfoo.so.1": {Machine: elf.EM_AARCH64, Soname: "libfoo.so.1"},
+		"/lib/libc.so.6":   {Machine: elf.EM_AARCH64, Soname: "libc.so.6"},
+	} {
+		if err := o.Write(filepath.Join(root, path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only the arm64 library is in the cache, so the running architecture
+	// would find nothing.
+	if err := cachetest.WriteRoot(root, "arm64", map[string]string{
+		"libfoo.so.1": "/lib/libfoo.so.1",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewRootResolver(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := r.Resolve("libfoo.so.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/lib/libfoo.so.1"}; !reflect.DeepEqual(g.Roots, want) {
+		t.Errorf("roots %q; want %q", g.Roots, want)
+	}
+}
+
+func TestResolveAllJobs(t *testing.T) {
+	root := fixtureRoot(t)
+	t.Setenv("LD_LIBRARY_PATH", "")
+	inputs := []string{"/bin/app"}
+	for i := 0; i < 8; i++ {
+		name := fmt.Sprintf("/bin/tool%d", i)
+		o := elftest.Object{Interp: "/lib64/ld-linux-x86-64.so.2", Needed: []string{"libbar.so.2"}}
+		switch i % 3 {
+		case 1:
+			// A vendored libbar.so.2, which conflicts with the cache's.
+			o.RunPath = "/vendor/lib"
+		case 2:
+			o = elftest.Object{}
+		}
+		if err := o.Write(filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, name)
+	}
+	vendored := elftest.Object{Soname: "libbar.so.2"}
+	if err := vendored.Write(filepath.Join(root, "vendor/lib/libbar.so.2")); err != nil {
+		t.Fatal(err)
+	}
+
+	resolve := func(jobs int) (*Graph, []Diagnostic, []string) {
+		r, err := NewRootResolver(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var logs []string
+		r.Logf = func(format string, args ...interface{}) {
+			logs = append(logs, fmt.Sprintf(format, args...))
+		}
+		r.Jobs = jobs
+		g, err := r.ResolveAll(inputs)
+		if err != nil {
+			t.Fatalf("jobs %d: %v", jobs, err)
+		}
+		return g, r.Diagnostics, logs
+	}
+	wantGraph, wantDiags, wantLogs := resolve(1)
+	if len(wantDiags) == 0 || len(wantLogs) == 0 {
+		t.Fatalf("want diagnostics and logs to compare; got %v and %q", wantDiags, wantLogs)
+	}
+	for _, jobs := range []int{2, 4} {
+		g, diags, logs := resolve(jobs)
+		if !reflect.DeepEqual(g, wantGraph) {
+			t.Errorf("jobs %d: graph %+v; want %+v", jobs, g, wantGraph)
+		}
+		if !reflect.DeepEqual(diags, wantDiags) {
+			t.Errorf("jobs %d: diagnostics %v; want %v", jobs, diags, wantDiags)
+		}
+		if !reflect.DeepEqual(logs, wantLogs) {
+			t.Errorf("jobs %d: logs %q; want %q", jobs, logs, wantLogs)
+		}
+	}
+
+	r, err := NewRootResolver(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Jobs = 4
+	if _, err := r.ResolveAll(append(inputs, "/bin/nonexistent")); err == nil {
+		t.Error("resolved a missing input")
+	}
+}
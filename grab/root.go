@@ -0,0 +1,85 @@
+package grab
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// maxSymlinks bounds symlink resolution, as the kernel's MAXSYMLINKS does.
+const maxSymlinks = 40
+
+// isHostRoot reports whether root means the running system.
+func isHostRoot(root string) bool {
+	return root == "" || root == "/"
+}
+
+// InRoot returns the host path of the absolute path p within the filesystem
+// tree at root, resolving symlinks as if root were /. Absolute link targets
+// are therefore taken relative to root rather than escaping to the host.
+// Components which do not exist are joined on unresolved, so that opening the
+// result reports the usual not-exist error.
+func InRoot(root, p string) (string, error) {
+	if isHostRoot(root) {
+		return p, nil
+	}
+	resolved, err := resolveLinks(p,
+		func(p string) (fs.FileInfo, error) { return os.Lstat(filepath.Join(root, p)) },
+		func(p string) (string, error) { return os.Readlink(filepath.Join(root, p)) })
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, resolved), nil
+}
+
+// resolveLinks returns the absolute path p with the symlinks in it resolved,
+// by lstat and readlink of absolute paths, as if the tree they read were /.
+// Components which do not exist are joined on unresolved.
+func resolveLinks(p string, lstat func(string) (fs.FileInfo, error), readlink func(string) (string, error)) (string, error) {
+	var (
+		resolved = "/"
+		rest     = strings.Split(path.Clean("/"+p), "/")
+		links    = 0
+	)
+	for len(rest) > 0 {
+		component := rest[0]
+		rest = rest[1:]
+		if component == "" || component == "." {
+			continue
+		}
+
+		next := path.Join(resolved, component)
+		fi, err := lstat(next)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("%s: too many levels of symbolic links", p)
+		}
+		target, err := readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+	return resolved, nil
+}
+
+// hostPath is InRoot, falling back to joining p onto root if symlinks within
+// root cannot be resolved.
+func hostPath(root, p string) string {
+	host, err := InRoot(root, p)
+	if err != nil {
+		return filepath.Join(root, p)
+	}
+	return host
+}
@@ -0,0 +1,54 @@
+package grab
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInRoot(t *testing.T) {
+	root := t.TempDir()
+
+	mkdir := func(p string) {
+		if err := os.MkdirAll(filepath.Join(root, p), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	symlink := func(target, p string) {
+		if err := os.Symlink(target, filepath.Join(root, p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mkdir("usr/lib/x86_64-linux-gnu")
+	mkdir("lib64")
+	symlink("usr/lib", "lib")
+	symlink("/lib/x86_64-linux-gnu/ld-2.31.so", "lib64/ld-linux-x86-64.so.2")
+	symlink("../../../../../../etc/passwd", "usr/lib/escape")
+	symlink("loop", "usr/lib/loop")
+
+	for _, tc := range []struct{ in, want string }{
+		{"/usr/lib", "/usr/lib"},
+		{"/lib/x86_64-linux-gnu", "/usr/lib/x86_64-linux-gnu"},
+		{"/lib64/ld-linux-x86-64.so.2", "/usr/lib/x86_64-linux-gnu/ld-2.31.so"},
+		{"/usr/lib/escape", "/etc/passwd"},
+		{"/usr/../lib/missing.so", "/usr/lib/missing.so"},
+	} {
+		got, err := InRoot(root, tc.in)
+		if err != nil {
+			t.Errorf("InRoot(%q): %v", tc.in, err)
+			continue
+		}
+		if want := filepath.Join(root, tc.want); got != want {
+			t.Errorf("InRoot(%q) = %q, want %q", tc.in, got, want)
+		}
+	}
+
+	if _, err := InRoot(root, "/usr/lib/loop"); err == nil {
+		t.Errorf("InRoot of a symlink loop succeeded")
+	}
+
+	if got, _ := InRoot("/", "/lib64/foo"); got != "/lib64/foo" {
+		t.Errorf("InRoot(/) = %q, want path unchanged", got)
+	}
+}
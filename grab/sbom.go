@@ -0,0 +1,238 @@
+package grab
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SBOMFile is an archived file as described in a software bill of materials.
+type SBOMFile struct {
+	Name   string // In the archive
+	Path   string // Within the resolver's root
+	SHA1   string
+	SHA256 string
+	// Package is the distribution package the file came from, if known.
+	Package *Package
+}
+
+// SBOMFiles hashes files, and looks up the packages they came from in db
+// (which may be nil).
+func (r *Resolver) SBOMFiles(files []File, db PackageDB) ([]SBOMFile, error) {
+	var out []SBOMFile
+	for _, file := range files {
+		// FIFOs and device nodes are archived empty.
+		hashed := file.Path
+		if file.Special {
+			hashed = os.DevNull
+		}
+		sha1sum, sha256sum, err := hashFile(hashed)
+		if err != nil {
+			return nil, err
+		}
+		sf := SBOMFile{
+			Name:   file.Name,
+			Path:   r.rootPath(file.Path),
+			SHA1:   sha1sum,
+			SHA256: sha256sum,
+		}
+		if db != nil {
+			sf.Package, _ = db.Lookup(sf.Path)
+		}
+		out = append(out, sf)
+	}
+	return out, nil
+}
+
+// rootPath returns the path within r.Root of host, a host path.
+func (r *Resolver) rootPath(host string) string {
+	if isHostRoot(r.Root) {
+		return host
+	}
+	rel, err := filepath.Rel(r.Root, host)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return host
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+// hashFile returns the hex sha1 and sha256 of the contents of filename.
+func hashFile(filename string) (string, string, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return "", "", err
+	}
+	defer fd.Close()
+
+	h1, h256 := sha1.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(h1, h256), fd); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(h1.Sum(nil)), hex.EncodeToString(h256.Sum(nil)), nil
+}
+
+// WriteSPDX writes an SPDX 2.3 JSON document named name describing files,
+// with a package element for each package they came from.
+func WriteSPDX(w io.Writer, name string, files []SBOMFile, created time.Time) error {
+	type checksum struct {
+		Algorithm string `json:"algorithm"`
+		Value     string `json:"checksumValue"`
+	}
+	type spdxFile struct {
+		Name      string     `json:"fileName"`
+		ID        string     `json:"SPDXID"`
+		Checksums []checksum `json:"checksums"`
+		License   string     `json:"licenseConcluded"`
+		Copyright string     `json:"copyrightText"`
+		Comment   string     `json:"comment,omitempty"`
+	}
+	type spdxPackage struct {
+		Name             string `json:"name"`
+		ID               string `json:"SPDXID"`
+		Version          string `json:"versionInfo,omitempty"`
+		Download         string `json:"downloadLocation"`
+		FilesAnalyzed    bool   `json:"filesAnalyzed"`
+		LicenseConcluded string `json:"licenseConcluded"`
+		LicenseDeclared  string `json:"licenseDeclared"`
+		Copyright        string `json:"copyrightText"`
+	}
+	type relationship struct {
+		Element string `json:"spdxElementId"`
+		Type    string `json:"relationshipType"`
+		Related string `json:"relatedSpdxElement"`
+	}
+
+	var (
+		digest        = sha256.New()
+		spdxFiles     []spdxFile
+		packages      []spdxPackage
+		packageIDs    = map[*Package]string{}
+		relationships []relationship
+	)
+	for i, file := range files {
+		id := fmt.Sprintf("SPDXRef-File-%d", i)
+		fmt.Fprintln(digest, file.Name, file.SHA256)
+		spdxFiles = append(spdxFiles, spdxFile{
+			Name: "./" + file.Name,
+			ID:   id,
+			Checksums: []checksum{
+				{"SHA1", file.SHA1},
+				{"SHA256", file.SHA256},
+			},
+			License:   "NOASSERTION",
+			Copyright: "NOASSERTION",
+			Comment:   "from " + file.Path,
+		})
+		relationships = append(relationships, relationship{"SPDXRef-DOCUMENT", "DESCRIBES", id})
+
+		if file.Package == nil {
+			continue
+		}
+		pkgID, ok := packageIDs[file.Package]
+		if !ok {
+			pkgID = fmt.Sprintf("SPDXRef-Package-%d", len(packages))
+			packageIDs[file.Package] = pkgID
+			packages = append(packages, spdxPackage{
+				Name:             file.Package.Name,
+				ID:               pkgID,
+				Version:          file.Package.Version,
+				Download:         "NOASSERTION",
+				LicenseConcluded: "NOASSERTION",
+				LicenseDeclared:  "NOASSERTION",
+				Copyright:        "NOASSERTION",
+			})
+		}
+		relationships = append(relationships, relationship{pkgID, "CONTAINS", id})
+	}
+
+	doc := map[string]interface{}{
+		"spdxVersion":       "SPDX-2.3",
+		"dataLicense":       "CC0-1.0",
+		"SPDXID":            "SPDXRef-DOCUMENT",
+		"name":              name,
+		"documentNamespace": "https://github.com/pwaller/grab-ld-binaries/spdx/" + name + "-" + hex.EncodeToString(digest.Sum(nil)),
+		"creationInfo": map[string]interface{}{
+			"created":  created.UTC().Format(time.RFC3339),
+			"creators": []string{"Tool: grab-ld-binaries"},
+		},
+		"files":         spdxFiles,
+		"packages":      packages,
+		"relationships": relationships,
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// WriteCycloneDX writes a CycloneDX 1.5 JSON BOM describing files as library
+// components. Files from a known package get a package URL, so that scanners
+// can match them against vulnerability databases; distro is the os-release
+// ID the package URLs are qualified with.
+func WriteCycloneDX(w io.Writer, name string, files []SBOMFile, distro string, created time.Time) error {
+	type hash struct {
+		Alg     string `json:"alg"`
+		Content string `json:"content"`
+	}
+	type property struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	type component struct {
+		Type       string     `json:"type"`
+		BOMRef     string     `json:"bom-ref"`
+		Name       string     `json:"name"`
+		Version    string     `json:"version,omitempty"`
+		Hashes     []hash     `json:"hashes"`
+		PURL       string     `json:"purl,omitempty"`
+		Properties []property `json:"properties"`
+	}
+
+	components := []component{}
+	for i, file := range files {
+		c := component{
+			Type:   "library",
+			BOMRef: fmt.Sprintf("file-%d", i),
+			Name:   path.Base(file.Name),
+			Hashes: []hash{{"SHA-1", file.SHA1}, {"SHA-256", file.SHA256}},
+			Properties: []property{
+				{"grab-ld-binaries:archive-path", file.Name},
+				{"grab-ld-binaries:source-path", file.Path},
+			},
+		}
+		if pkg := file.Package; pkg != nil {
+			c.Version = pkg.Version
+			c.Properties = append(c.Properties, property{"grab-ld-binaries:package", pkg.Name})
+			if distro != "" {
+				c.PURL = fmt.Sprintf("pkg:deb/%s/%s@%s", distro, pkg.Name, url.PathEscape(pkg.Version))
+				if pkg.Arch != "" {
+					c.PURL += "?arch=" + pkg.Arch
+				}
+			}
+		}
+		components = append(components, c)
+	}
+
+	bom := map[string]interface{}{
+		"bomFormat":   "CycloneDX",
+		"specVersion": "1.5",
+		"version":     1,
+		"metadata": map[string]interface{}{
+			"timestamp": created.UTC().Format(time.RFC3339),
+			"tools":     []map[string]string{{"name": "grab-ld-binaries"}},
+			"component": map[string]string{"type": "application", "name": name},
+		},
+		"components": components,
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(bom)
+}
@@ -0,0 +1,51 @@
+package grab
+
+import (
+	"debug/elf"
+	"io/fs"
+	"path"
+)
+
+// ScanDir returns the ELF executables and shared objects in the directory
+// dir within r.Root, as FindBinaries does, with every file in it as AddPath
+// returns them, so that an application's directory can be archived as it
+// is along with the combined dependencies of its binaries.
+func (r *Resolver) ScanDir(dir string) (binaries []string, files []File, err error) {
+	binaries, err = r.FindBinaries(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err = r.AddPath(path.Join("/", dir), nil)
+	return binaries, files, err
+}
+
+// FindBinaries returns the ELF executables and shared objects in the
+// directory dir within r.Root, found recursively, as paths within r.Root.
+// Symlinks are not followed.
+func (r *Resolver) FindBinaries(dir string) ([]string, error) {
+	var binaries []string
+	err := r.walkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || !r.isELF(p) {
+			return nil
+		}
+		f, err := r.openELF(p)
+		if err != nil {
+			r.logf("%s: not scanned: %v", p, err)
+			return nil
+		}
+		typ := f.Type
+		f.Close()
+		if typ != elf.ET_EXEC && typ != elf.ET_DYN {
+			return nil
+		}
+		binaries = append(binaries, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return binaries, nil
+}
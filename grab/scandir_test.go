@@ -0,0 +1,62 @@
+package grab
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pwaller/grab-ld-binaries/internal/elftest"
+)
+
+func TestScanDir(t *testing.T) {
+	root := t.TempDir()
+	for p, o := range map[string]elftest.Object{
+		"/opt/app/bin/app":       {Interp: "/lib64/ld-linux-x86-64.so.2", Needed: []string{"libapp.so"}},
+		"/opt/app/lib/libapp.so": {Soname: "libapp.so"},
+	} {
+		if err := o.Write(filepath.Join(root, p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(root, "opt/app/share"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "opt/app/share/app.conf"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("app", filepath.Join(root, "opt/app/bin/app-link")); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Resolver{Root: root}
+	binaries, files, err := r.ScanDir("opt/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/opt/app/bin/app", "/opt/app/lib/libapp.so"}; !reflect.DeepEqual(binaries, want) {
+		t.Errorf("binaries %q; want %q", binaries, want)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+	want := []string{"opt/app/bin/app", "opt/app/bin/app-link", "opt/app/lib/libapp.so", "opt/app/share/app.conf"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("files %q; want %q", names, want)
+	}
+
+	// The binaries are archived in place, not also by their base names.
+	g := &Graph{
+		Roots:   binaries,
+		Sysroot: root,
+		Extra:   files,
+		Placed:  map[string]bool{binaries[0]: true, binaries[1]: true},
+	}
+	for _, file := range g.Files() {
+		if filepath.Dir(file.Name) == "." {
+			t.Errorf("%s archived by its base name too", file.Name)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package grab
+
+import (
+	"debug/elf"
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// object is an ELF object loaded during resolution, with what is needed to
+// search for its own dependencies.
+type object struct {
+	path    string // Within the resolver's Root
+	interp  string // PT_INTERP, if any
+	needed  []string
+	rpath   []string // Only searched if runpath is empty
+	runpath []string
+	loader  *object // The object which first needed this one
+	class   elf.Class
+	machine elf.Machine
+	arch    string // See ArchName
+	// versionNeeds are the GNU symbol versions required of each needed
+	// library.
+	versionNeeds map[string][]string
+}
+
+// loadObject reads the dynamic section of the ELF file at path, which is
+// within r.Root, expanding $ORIGIN in its search paths. It is read with
+// debug/elf or streamed by readDynamic, as r.ELFReader chooses. Unreadable
+// files are stood in for by packageObject if r.PackageNeeds is set.
+func (r *Resolver) loadObject(path string, loader *object) (*object, error) {
+	obj, err := r.objects.open(r, path)
+	if errors.Is(err, fs.ErrPermission) && r.PackageNeeds != nil {
+		obj, err = r.packageObject(path, loader, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	obj.path, obj.loader = path, loader
+
+	origin := filepath.Dir(r.realPath(path))
+	obj.rpath = expandSearchPath(obj.rpath, origin)
+	obj.runpath = expandSearchPath(obj.runpath, origin)
+	return obj, nil
+}
+
+// objectCache memoizes openObject for ResolveAll, whose inputs mostly need
+// the same libraries. It is safe for concurrent use.
+type objectCache struct {
+	mu      sync.Mutex
+	objects map[string]objectResult // By path within the resolver's Root
+}
+
+type objectResult struct {
+	obj *object
+	err error
+}
+
+// open returns a copy of the object at path as r.openObject reads it,
+// reading it only the first time. A nil cache reads it every time.
+func (c *objectCache) open(r *Resolver, path string) (*object, error) {
+	if c == nil {
+		return r.openObject(path)
+	}
+	c.mu.Lock()
+	cached, ok := c.objects[path]
+	c.mu.Unlock()
+	if !ok {
+		cached.obj, cached.err = r.openObject(path)
+		c.mu.Lock()
+		c.objects[path] = cached
+		c.mu.Unlock()
+	}
+	if cached.err != nil {
+		return nil, cached.err
+	}
+	obj := *cached.obj
+	return &obj, nil
+}
+
+// openObject reads the object at path, within r.Root.
+func (r *Resolver) openObject(path string) (*object, error) {
+	fd, err := r.open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	ra, size, err := readerAt(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.streams(size) {
+		return readDynamic(ra)
+	}
+	return readObject(ra)
+}
+
+// packageObject stands in for the object at path, which could not be read
+// for readErr, with the libraries r.PackageNeeds says it needs. Its class
+// and machine are taken to be its loader's, or unknown without one.
+func (r *Resolver) packageObject(path string, loader *object, readErr error) (*object, error) {
+	needed, ok := r.PackageNeeds.Needed(path)
+	if !ok {
+		return nil, readErr
+	}
+	r.diagnose(NewDiagnostic(DiagUnreadable, path))
+	obj := &object{needed: needed}
+	if loader != nil {
+		obj.class, obj.machine, obj.arch = loader.class, loader.machine, loader.arch
+	}
+	return obj, nil
+}
+
+// readObject reads the object in fd with debug/elf. The search paths are
+// returned as in the file, without $ORIGIN expanded.
+func readObject(fd io.ReaderAt) (*object, error) {
+	f, err := elf.NewFile(fd)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	obj := &object{
+		class:   f.Class,
+		machine: f.Machine,
+		arch:    ArchName(f),
+	}
+
+	obj.needed, err = f.ImportedLibraries()
+	if err != nil {
+		return nil, err
+	}
+	obj.versionNeeds = versionNeeds(f)
+
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+		data, err := ioutil.ReadAll(prog.Open())
+		if err != nil {
+			return nil, err
+		}
+		obj.interp = strings.TrimRight(string(data), "\x00")
+	}
+
+	if obj.rpath, err = f.DynString(elf.DT_RPATH); err != nil {
+		return nil, err
+	}
+	if obj.runpath, err = f.DynString(elf.DT_RUNPATH); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// expandSearchPath splits the DT_RPATH or DT_RUNPATH values into their
+// directories, substituting origin for $ORIGIN.
+func expandSearchPath(values []string, origin string) []string {
+	var dirs []string
+	for _, value := range values {
+		for _, dir := range strings.Split(value, ":") {
+			if dir != "" {
+				dirs = append(dirs, expandOrigin(dir, origin))
+			}
+		}
+	}
+	return dirs
+}
+
+// expandOrigin substitutes origin for $ORIGIN and ${ORIGIN} in dir.
+func expandOrigin(dir, origin string) string {
+	dir = strings.Replace(dir, "${ORIGIN}", origin, -1)
+	return strings.Replace(dir, "$ORIGIN", origin, -1)
+}
+
+// RealPath returns path, which is within r.Root, with symlinks resolved
+// within r.Root.
+func (r *Resolver) RealPath(path string) string {
+	return r.realPath(path)
+}
+
+// realPath returns path, which is within r.Root, with symlinks resolved. This
+// is the path whose directory ld.so uses for $ORIGIN.
+func (r *Resolver) realPath(path string) string {
+	if r.FS != nil {
+		name, err := fsName(r.FS, path)
+		if err != nil {
+			return path
+		}
+		return "/" + strings.TrimPrefix(name, ".")
+	}
+	if isHostRoot(r.Root) {
+		real, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return path
+		}
+		abs, err := filepath.Abs(real)
+		if err != nil {
+			return real
+		}
+		return abs
+	}
+
+	host, err := InRoot(r.Root, path)
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(r.Root, host)
+	if err != nil {
+		return path
+	}
+	return "/" + rel
+}
+
+// search finds lib, as needed by obj, in the order glibc's ld.so uses:
+//
+//  1. the DT_RPATH of obj and then of each object in its loader chain, unless
+//     obj has a DT_RUNPATH,
+//  2. $LD_LIBRARY_PATH,
+//  3. the DT_RUNPATH of obj,
+//  4. the ld.so.cache,
+//  5. the directories of /etc/ld.so.conf, in case the cache is stale,
+//  6. the default system directories.
+//
+// Candidates whose ELF class or machine differ from obj's are skipped, as
+// ld.so does. The returned path is within r.Root.
+func (r *Resolver) search(lib string, obj *object) (string, bool) {
+	for _, c := range r.searchPaths(lib, obj) {
+		if r.compatible(c.path, obj) {
+			if c.viaConf {
+				r.logf("%s is missing from the ld.so.cache, found %s via ld.so.conf", lib, c.path)
+			}
+			return c.path, true
+		}
+	}
+	return "", false
+}
+
+// searchPath is a place search looks for a library.
+type searchPath struct {
+	path    string
+	viaConf bool // Only found by reading /etc/ld.so.conf
+}
+
+// searchPaths returns the paths search tries for lib, in order. Only the
+// ones which exist matter; the rest are rejected by compatible.
+func (r *Resolver) searchPaths(lib string, obj *object) []searchPath {
+	if strings.Contains(lib, "/") {
+		return []searchPath{{path: lib}}
+	}
+
+	var paths []searchPath
+	inDirs := func(dirs []string, viaConf bool) {
+		for _, dir := range dirs {
+			paths = append(paths, searchPath{filepath.Join(dir, lib), viaConf})
+		}
+	}
+
+	if len(obj.runpath) == 0 {
+		for o := obj; o != nil; o = o.loader {
+			inDirs(o.rpath, false)
+		}
+	}
+	if path, ok := r.Cache.LookupLibraryPath(lib); ok {
+		paths = append(paths, searchPath{path: path})
+	}
+	inDirs(obj.runpath, false)
+	if path, ok := r.Cache.LookupCacheArch(lib, r.cacheArch(obj.arch)); ok {
+		paths = append(paths, searchPath{path: path})
+	}
+	inDirs(r.ldConfDirs(), true)
+	inDirs(defaultDirs(obj.class, obj.machine), false)
+	return paths
+}
+
+// multiarchTriples are the Debian multiarch directory names, which
+// multiarch distributions compile into ld.so's default search path.
+var multiarchTriples = map[elf.Machine]string{
+	elf.EM_X86_64:  "x86_64-linux-gnu",
+	elf.EM_386:     "i386-linux-gnu",
+	elf.EM_AARCH64: "aarch64-linux-gnu",
+	elf.EM_ARM:     "arm-linux-gnueabihf",
+	elf.EM_PPC64:   "powerpc64le-linux-gnu",
+	elf.EM_S390:    "s390x-linux-gnu",
+	elf.EM_RISCV:   "riscv64-linux-gnu",
+}
+
+// defaultDirs are the trusted directories ld.so searches last.
+func defaultDirs(class elf.Class, machine elf.Machine) []string {
+	var dirs []string
+	if triple, ok := multiarchTriples[machine]; ok {
+		dirs = append(dirs, "/lib/"+triple, "/usr/lib/"+triple)
+	}
+	if class == elf.ELFCLASS64 {
+		dirs = append(dirs, "/lib64", "/usr/lib64")
+	}
+	return append(dirs, "/lib", "/usr/lib")
+}
+
+// compatible reports whether path, within r.Root, is an ELF object which obj
+// could load. With r.PackageNeeds set, unreadable files, and any file for an
+// obj of unknown class, are taken on trust.
+func (r *Resolver) compatible(path string, obj *object) bool {
+	f, err := r.openELF(path)
+	if errors.Is(err, fs.ErrPermission) && r.PackageNeeds != nil {
+		return true
+	}
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if obj.class == elf.ELFCLASSNONE {
+		return true
+	}
+	if f.Class != obj.class || f.Machine != obj.machine {
+		r.diagnose(NewDiagnostic(DiagArchMismatch, path,
+			f.Class, f.Machine, obj.path, obj.class, obj.machine))
+		return false
+	}
+	return true
+}
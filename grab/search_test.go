@@ -0,0 +1,50 @@
+package grab
+
+import (
+	"debug/elf"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pwaller/grab-ld-binaries/dlcache"
+)
+
+func TestSearchArchMismatch(t *testing.T) {
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(self)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := elf.Open(self)
+	if err != nil {
+		t.Skipf("test binary is not ELF: %v", err)
+	}
+	f.Close()
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "lib/libfoo.so.1"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// An object of some other machine must not pick up the test binary.
+	machine := elf.EM_S390
+	if f.Machine == machine {
+		machine = elf.EM_X86_64
+	}
+	obj := &object{path: "/bin/app", class: f.Class, machine: machine}
+
+	r := &Resolver{Root: root, Cache: &dlcache.DLCache{}}
+	if path, ok := r.search("libfoo.so.1", obj); ok {
+		t.Errorf("found %s for a foreign machine", path)
+	}
+	if len(r.Diagnostics) != 1 || r.Diagnostics[0].ID != DiagArchMismatch {
+		t.Errorf("got diagnostics %v, want one %s", r.Diagnostics, DiagArchMismatch)
+	}
+}
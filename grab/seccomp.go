@@ -0,0 +1,60 @@
+package grab
+
+import (
+	"fmt"
+	"sort"
+)
+
+// SeccompProfile is an OCI runtime seccomp profile, as docker and podman
+// take with --security-opt seccomp=FILE.
+type SeccompProfile struct {
+	DefaultAction   string           `json:"defaultAction"`
+	DefaultErrnoRet int              `json:"defaultErrnoRet"`
+	Architectures   []string         `json:"architectures"`
+	Syscalls        []SeccompSyscall `json:"syscalls"`
+}
+
+// SeccompSyscall is a rule of a SeccompProfile.
+type SeccompSyscall struct {
+	Names  []string `json:"names"`
+	Action string   `json:"action"`
+}
+
+// seccompArchs are the seccomp architectures of each ArchName.
+var seccompArchs = map[string]string{
+	"amd64":    "SCMP_ARCH_X86_64",
+	"386":      "SCMP_ARCH_X86",
+	"amd64p32": "SCMP_ARCH_X32",
+	"arm64":    "SCMP_ARCH_AARCH64",
+	"arm":      "SCMP_ARCH_ARM",
+	"ppc64":    "SCMP_ARCH_PPC64",
+	"ppc64le":  "SCMP_ARCH_PPC64LE",
+	"s390x":    "SCMP_ARCH_S390X",
+	"riscv64":  "SCMP_ARCH_RISCV64",
+	"loong64":  "SCMP_ARCH_LOONGARCH64",
+	"mips":     "SCMP_ARCH_MIPS",
+	"mipsle":   "SCMP_ARCH_MIPSEL",
+	"mips64":   "SCMP_ARCH_MIPS64",
+	"mips64le": "SCMP_ARCH_MIPSEL64",
+}
+
+// NewSeccompProfile returns a profile for binaries of arch, an ArchName,
+// which allows only syscalls, such as a Trace made, and fails any other
+// system call with EPERM.
+func NewSeccompProfile(arch string, syscalls []string) (*SeccompProfile, error) {
+	scmpArch, ok := seccompArchs[arch]
+	if !ok {
+		return nil, fmt.Errorf("no seccomp architecture for %s", arch)
+	}
+	if len(syscalls) == 0 {
+		return nil, fmt.Errorf("no system calls to allow")
+	}
+	names := append([]string(nil), syscalls...)
+	sort.Strings(names)
+	return &SeccompProfile{
+		DefaultAction:   "SCMP_ACT_ERRNO",
+		DefaultErrnoRet: 1, // EPERM
+		Architectures:   []string{scmpArch},
+		Syscalls:        []SeccompSyscall{{Names: names, Action: "SCMP_ACT_ALLOW"}},
+	}, nil
+}
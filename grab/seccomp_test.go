@@ -0,0 +1,30 @@
+package grab
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNewSeccompProfile(t *testing.T) {
+	profile, err := NewSeccompProfile("arm64", []string{"read", "execve", "exit_group"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"defaultAction":"SCMP_ACT_ERRNO","defaultErrnoRet":1,` +
+		`"architectures":["SCMP_ARCH_AARCH64"],` +
+		`"syscalls":[{"names":["execve","exit_group","read"],"action":"SCMP_ACT_ALLOW"}]}`
+	if string(data) != want {
+		t.Errorf("profile\n%s\nwant\n%s", data, want)
+	}
+
+	if _, err := NewSeccompProfile("sparc64", []string{"read"}); err == nil {
+		t.Error("profile for an unknown architecture")
+	}
+	if _, err := NewSeccompProfile("amd64", nil); err == nil {
+		t.Error("profile allowing nothing")
+	}
+}
@@ -0,0 +1,186 @@
+package grab
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// SecurityReport describes the privileges the binaries of a bundle appear to
+// need, with recommendations for running an image of them with least
+// privilege.
+type SecurityReport struct {
+	// Setuid lists the binaries with the setuid or setgid bit set.
+	Setuid []string `json:"setuid,omitempty"`
+	// FileCapabilities maps binaries to the capabilities in their
+	// security.capability attribute.
+	FileCapabilities map[string][]string `json:"fileCapabilities,omitempty"`
+	// PrivilegedPorts are ports below 1024 which the added configuration
+	// files appear to listen on, mapped to the files that mention them.
+	PrivilegedPorts map[int][]string `json:"privilegedPorts,omitempty"`
+
+	// RunAsNonRoot is whether the image can run as a non-root user,
+	// given NeededCapabilities.
+	RunAsNonRoot bool `json:"runAsNonRoot"`
+	// NeededCapabilities should be added to the container; all others
+	// can be dropped.
+	NeededCapabilities []string `json:"neededCapabilities"`
+	Notes              []string `json:"notes,omitempty"`
+}
+
+// capNames are the Linux capability names by bit number.
+var capNames = []string{
+	"CAP_CHOWN", "CAP_DAC_OVERRIDE", "CAP_DAC_READ_SEARCH", "CAP_FOWNER",
+	"CAP_FSETID", "CAP_KILL", "CAP_SETGID", "CAP_SETUID", "CAP_SETPCAP",
+	"CAP_LINUX_IMMUTABLE", "CAP_NET_BIND_SERVICE", "CAP_NET_BROADCAST",
+	"CAP_NET_ADMIN", "CAP_NET_RAW", "CAP_IPC_LOCK", "CAP_IPC_OWNER",
+	"CAP_SYS_MODULE", "CAP_SYS_RAWIO", "CAP_SYS_CHROOT", "CAP_SYS_PTRACE",
+	"CAP_SYS_PACCT", "CAP_SYS_ADMIN", "CAP_SYS_BOOT", "CAP_SYS_NICE",
+	"CAP_SYS_RESOURCE", "CAP_SYS_TIME", "CAP_SYS_TTY_CONFIG", "CAP_MKNOD",
+	"CAP_LEASE", "CAP_AUDIT_WRITE", "CAP_AUDIT_CONTROL", "CAP_SETFCAP",
+	"CAP_MAC_OVERRIDE", "CAP_MAC_ADMIN", "CAP_SYSLOG", "CAP_WAKE_ALARM",
+	"CAP_BLOCK_SUSPEND", "CAP_AUDIT_READ", "CAP_PERFMON", "CAP_BPF",
+	"CAP_CHECKPOINT_RESTORE",
+}
+
+// portHint matches configuration lines such as "port 80", "Listen 443",
+// "listen = 0.0.0.0:80" or "port: 25".
+var portHint = regexp.MustCompile(`(?i)\b(?:port|listen)\b\s*[=:]?\s*(?:\S*:)?(\d{1,5})\b`)
+
+// AnalyzeSecurity inspects the roots of g for setuid bits and file
+// capabilities, and the extra files (such as configuration added with
+// AddPath) for privileged ports, and recommends how to run them.
+func (g *Graph) AnalyzeSecurity() (*SecurityReport, error) {
+	report := &SecurityReport{
+		FileCapabilities: map[string][]string{},
+		PrivilegedPorts:  map[int][]string{},
+	}
+	needed := map[string]struct{}{}
+
+	for _, root := range g.Roots {
+		host := g.host(root)
+		fi, err := os.Stat(host)
+		if err != nil {
+			return nil, err
+		}
+		if fi.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0 {
+			report.Setuid = append(report.Setuid, root)
+		}
+
+		caps, err := fileCapabilities(host)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", root, err)
+		}
+		if len(caps) > 0 {
+			report.FileCapabilities[root] = caps
+			for _, c := range caps {
+				needed[c] = struct{}{}
+			}
+		}
+	}
+
+	for _, file := range g.Extra {
+		ports, err := privilegedPorts(file.Path)
+		if err != nil {
+			return nil, err
+		}
+		for _, port := range ports {
+			report.PrivilegedPorts[port] = append(report.PrivilegedPorts[port], file.Name)
+			needed["CAP_NET_BIND_SERVICE"] = struct{}{}
+		}
+	}
+
+	report.NeededCapabilities = SortedSet(needed)
+	// Setuid binaries expect to gain privileges, which a non-root user
+	// under no_new_privileges cannot.
+	report.RunAsNonRoot = len(report.Setuid) == 0
+	if len(report.Setuid) > 0 {
+		report.Notes = append(report.Notes,
+			"setuid and setgid bits have no effect under no_new_privileges; "+
+				"audit which privileges the setuid binaries use and grant those "+
+				"capabilities instead so that the image can run as non-root")
+	}
+	if len(report.NeededCapabilities) > 0 {
+		report.Notes = append(report.Notes,
+			"drop all capabilities and add only neededCapabilities; "+
+				"as a non-root user they must also be ambient capabilities")
+	}
+	return report, nil
+}
+
+// fileCapabilities returns the permitted capabilities in the
+// security.capability attribute of path, if any. The attribute is a
+// vfs_cap_data: a magic/revision word, then the low permitted and
+// inheritable words, then (from revision 2) the high ones.
+func fileCapabilities(path string) ([]string, error) {
+	buf := make([]byte, 24)
+	n, err := syscall.Getxattr(path, "security.capability", buf)
+	if err == syscall.ENODATA || err == syscall.ENOTSUP {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n < 12 {
+		return nil, fmt.Errorf("security.capability is %d bytes", n)
+	}
+
+	permitted := uint64(binary.LittleEndian.Uint32(buf[4:]))
+	if n >= 20 {
+		permitted |= uint64(binary.LittleEndian.Uint32(buf[12:])) << 32
+	}
+
+	var caps []string
+	for bit := uint(0); bit < 64; bit++ {
+		if permitted&(1<<bit) == 0 {
+			continue
+		}
+		if int(bit) < len(capNames) {
+			caps = append(caps, capNames[bit])
+		} else {
+			caps = append(caps, "CAP_"+strconv.Itoa(int(bit)))
+		}
+	}
+	return caps, nil
+}
+
+// privilegedPorts returns the ports below 1024 which the text file at path
+// appears to configure, by portHint, ignoring comment lines.
+func privilegedPorts(path string) ([]int, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	seen := map[int]bool{}
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		for _, m := range portHint.FindAllStringSubmatch(line, -1) {
+			port, err := strconv.Atoi(m[1])
+			if err == nil && port > 0 && port < 1024 {
+				seen[port] = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil && err != bufio.ErrTooLong {
+		return nil, err
+	}
+
+	var ports []int
+	for port := range seen {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
@@ -0,0 +1,25 @@
+package grab
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPrivilegedPorts(t *testing.T) {
+	conf := filepath.Join(t.TempDir(), "app.conf")
+	data := "Listen 0.0.0.0:443\nport = 8080\n# port: 25\nlisten [::]:80\nsupport 22\n"
+	if err := ioutil.WriteFile(conf, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ports, err := privilegedPorts(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{80, 443}
+	if !reflect.DeepEqual(ports, want) {
+		t.Errorf("got %v, want %v", ports, want)
+	}
+}
@@ -0,0 +1,81 @@
+package grab
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// SpecialFilePolicies are the supported values for Resolver.SpecialFiles:
+// skip FIFOs, sockets and device nodes with a diagnostic, archive them as
+// such (sockets, which tar cannot hold, are still skipped), or fail.
+var SpecialFilePolicies = []string{"skip", "archive", "error"}
+
+// specialKind names the type of mode, which is not a regular file.
+func specialKind(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeCharDevice != 0:
+		return "character device"
+	case mode&os.ModeDevice != 0:
+		return "block device"
+	case mode.IsDir():
+		return "directory"
+	}
+	return "file of unknown type"
+}
+
+// addSpecial applies r.SpecialFiles to host, a file which is not regular,
+// returning the file to archive for it if any.
+func (r *Resolver) addSpecial(host, name string, mode os.FileMode) (*File, error) {
+	kind := specialKind(mode)
+	switch r.SpecialFiles {
+	case "archive":
+		// Nodes are archived from the host, so those of an FS cannot be.
+		if mode&(os.ModeNamedPipe|os.ModeDevice) != 0 && r.FS == nil {
+			return &File{Path: host, Name: name, Special: true}, nil
+		}
+	case "error":
+		return nil, fmt.Errorf("%s: is a %s", host, kind)
+	}
+	r.diagnose(NewDiagnostic(DiagSkippedFile, host, kind))
+	return nil, nil
+}
+
+// checkSpecialFiles returns an error if policy is not one of
+// SpecialFilePolicies. Empty means skip.
+func checkSpecialFiles(policy string) error {
+	if policy == "" {
+		return nil
+	}
+	for _, p := range SpecialFilePolicies {
+		if policy == p {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown special files policy %q, want one of %s",
+		policy, strings.Join(SpecialFilePolicies, ", "))
+}
+
+// mknod creates target as a FIFO or device node of mode, with the device
+// number major:minor.
+func mknod(target string, mode os.FileMode, major, minor uint32) error {
+	perm := uint32(mode.Perm())
+	switch {
+	case mode&os.ModeNamedPipe != 0:
+		perm |= syscall.S_IFIFO
+	case mode&os.ModeCharDevice != 0:
+		perm |= syscall.S_IFCHR
+	case mode&os.ModeDevice != 0:
+		perm |= syscall.S_IFBLK
+	default:
+		return fmt.Errorf("%s: cannot create a %s", target, specialKind(mode))
+	}
+	return syscall.Mknod(target, perm, int(unix.Mkdev(major, minor)))
+}
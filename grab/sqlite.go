@@ -0,0 +1,227 @@
+package grab
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sqlSchema is created in each database WriteSQL writes to, so that the
+// grabs of a fleet can be appended to one database and queried together.
+const sqlSchema = `CREATE TABLE IF NOT EXISTS bundles (
+	id INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	created TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS packages (
+	id INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	version TEXT NOT NULL,
+	arch TEXT NOT NULL,
+	UNIQUE (name, version, arch)
+);
+CREATE TABLE IF NOT EXISTS objects (
+	id INTEGER PRIMARY KEY,
+	bundle INTEGER NOT NULL REFERENCES bundles (id),
+	name TEXT NOT NULL,
+	path TEXT NOT NULL,
+	soname TEXT,
+	size INTEGER NOT NULL,
+	package INTEGER REFERENCES packages (id)
+);
+CREATE TABLE IF NOT EXISTS hashes (
+	object INTEGER NOT NULL REFERENCES objects (id),
+	algorithm TEXT NOT NULL,
+	digest TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS dependencies (
+	bundle INTEGER NOT NULL REFERENCES bundles (id),
+	object TEXT NOT NULL,
+	needs TEXT NOT NULL,
+	path TEXT
+);
+`
+
+// WriteSQL writes SQL to w which adds a bundle named bundle, created at
+// created, to a database: a row for each of files in objects, with their
+// sha1 and sha256 in hashes and the package owning them, as found in db
+// (which may be nil), in packages; and the DT_NEEDED edges of g, with the
+// path each library resolved to, in dependencies.
+func (r *Resolver) WriteSQL(
+	w io.Writer, bundle string, g *Graph, files []File, db PackageDB, created time.Time,
+) error {
+	sbomFiles, err := r.SBOMFiles(files, db)
+	if err != nil {
+		return err
+	}
+	sonames := map[string]string{}
+	for lib, p := range g.Resolved {
+		sonames[p] = lib
+	}
+
+	var b bytes.Buffer
+	b.WriteString(sqlSchema)
+	b.WriteString("BEGIN;\n")
+	fmt.Fprintf(&b, "INSERT INTO bundles (name, created) VALUES (%s, %s);\n",
+		sqlQuote(bundle), sqlQuote(created.UTC().Format(time.RFC3339)))
+	b.WriteString("CREATE TEMP TABLE current AS SELECT last_insert_rowid() AS bundle;\n")
+
+	for i, file := range sbomFiles {
+		var size int64
+		if !files[i].Special {
+			fi, err := os.Stat(files[i].Path)
+			if err != nil {
+				return err
+			}
+			size = fi.Size()
+		}
+		pkg := "NULL"
+		if p := file.Package; p != nil {
+			fmt.Fprintf(&b, "INSERT OR IGNORE INTO packages (name, version, arch) VALUES (%s, %s, %s);\n",
+				sqlQuote(p.Name), sqlQuote(p.Version), sqlQuote(p.Arch))
+			pkg = fmt.Sprintf("(SELECT id FROM packages WHERE name = %s AND version = %s AND arch = %s)",
+				sqlQuote(p.Name), sqlQuote(p.Version), sqlQuote(p.Arch))
+		}
+		fmt.Fprintf(&b, "INSERT INTO objects (bundle, name, path, soname, size, package) "+
+			"VALUES ((SELECT bundle FROM current), %s, %s, %s, %d, %s);\n",
+			sqlQuote(file.Name), sqlQuote(file.Path), sqlNullable(sonames[file.Path]), size, pkg)
+		for _, hash := range [][2]string{{"sha1", file.SHA1}, {"sha256", file.SHA256}} {
+			fmt.Fprintf(&b, "INSERT INTO hashes (object, algorithm, digest) "+
+				"SELECT id, %s, %s FROM objects WHERE bundle = (SELECT bundle FROM current) AND name = %s;\n",
+				sqlQuote(hash[0]), sqlQuote(hash[1]), sqlQuote(file.Name))
+		}
+	}
+
+	var nodes []string
+	for node := range g.Edges {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		for _, lib := range g.Edges[node] {
+			fmt.Fprintf(&b, "INSERT INTO dependencies (bundle, object, needs, path) "+
+				"VALUES ((SELECT bundle FROM current), %s, %s, %s);\n",
+				sqlQuote(node), sqlQuote(lib), sqlNullable(g.Resolved[lib]))
+		}
+	}
+	b.WriteString("DROP TABLE current;\nCOMMIT;\n")
+
+	_, err = w.Write(b.Bytes())
+	return err
+}
+
+// WriteSQLite adds the bundle to the SQLite database at filename as WriteSQL
+// does, creating it if need be, using the sqlite3 program.
+func (r *Resolver) WriteSQLite(
+	filename, bundle string, g *Graph, files []File, db PackageDB, created time.Time,
+) error {
+	var script bytes.Buffer
+	if err := r.WriteSQL(&script, bundle, g, files, db, created); err != nil {
+		return err
+	}
+	cmd := exec.Command("sqlite3", "-bail", filename)
+	cmd.Stdin = &script
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("sqlite3: %v", err)
+	}
+	return nil
+}
+
+// CheckSQLite returns an error if the sqlite3 program, which WriteSQLite and
+// ReadDrift run, is not installed.
+func CheckSQLite() error {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		return fmt.Errorf("the sqlite3 program is needed: %v", err)
+	}
+	return nil
+}
+
+// sqlQuote returns s as an SQL string literal.
+func sqlQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// sqlNullable is sqlQuote, with NULL for the empty string.
+func sqlNullable(s string) string {
+	if s == "" {
+		return "NULL"
+	}
+	return sqlQuote(s)
+}
+
+// Drift is a change to an archived file between the last two bundles of the
+// same name in a database written by WriteSQL.
+type Drift struct {
+	// Change is "added", "removed" or "changed".
+	Change string `json:"change"`
+	Name   string `json:"name"` // In the archive
+	// Old and New are the file's sha256 before and after; empty if it was
+	// added or removed.
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+func (d Drift) String() string {
+	switch d.Change {
+	case "added":
+		return fmt.Sprintf("added %s (%.12s)", d.Name, d.New)
+	case "removed":
+		return fmt.Sprintf("removed %s (%.12s)", d.Name, d.Old)
+	}
+	return fmt.Sprintf("changed %s (%.12s -> %.12s)", d.Name, d.Old, d.New)
+}
+
+// driftQuery compares the files of the last two bundles named :name.
+const driftQuery = `WITH latest AS (
+	SELECT id FROM bundles WHERE name = :name ORDER BY id DESC LIMIT 2
+), files AS (
+	SELECT o.bundle, o.name, h.digest FROM objects o
+	JOIN hashes h ON h.object = o.id AND h.algorithm = 'sha256'
+	WHERE o.bundle IN latest AND (SELECT count(*) FROM latest) = 2
+), cur AS (
+	SELECT name, digest FROM files WHERE bundle = (SELECT max(id) FROM latest)
+), prev AS (
+	SELECT name, digest FROM files WHERE bundle = (SELECT min(id) FROM latest)
+)
+SELECT 'added' AS change, name, '' AS old, digest AS new FROM cur WHERE name NOT IN (SELECT name FROM prev)
+UNION ALL
+SELECT 'removed', name, digest, '' FROM prev WHERE name NOT IN (SELECT name FROM cur)
+UNION ALL
+SELECT 'changed', cur.name, prev.digest, cur.digest FROM cur JOIN prev USING (name)
+WHERE cur.digest != prev.digest
+ORDER BY 2;
+`
+
+// ReadDrift returns the changes between the last two bundles named bundle in
+// the SQLite database at filename, as written by WriteSQLite, using the
+// sqlite3 program (3.33 or later, for -json). There are none until there are
+// two such bundles.
+func ReadDrift(filename, bundle string) ([]Drift, error) {
+	query := strings.Replace(driftQuery, ":name", sqlQuote(bundle), 1)
+	// Names may hold any character, so the rows are read as JSON rather than
+	// split into lines and fields.
+	cmd := exec.Command("sqlite3", "-bail", "-json", filename, query)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("sqlite3: %v", err)
+	}
+
+	// No rows are no output at all.
+	var drift []Drift
+	if len(bytes.TrimSpace(out)) == 0 {
+		return nil, nil
+	}
+	if err := json.Unmarshal(out, &drift); err != nil {
+		return nil, fmt.Errorf("sqlite3: %v", err)
+	}
+	return drift, nil
+}
@@ -0,0 +1,176 @@
+package grab
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteSQLite(t *testing.T) {
+	if err := CheckSQLite(); err != nil {
+		t.Skip(err)
+	}
+
+	root := t.TempDir()
+	for name, contents := range map[string]string{
+		"usr/bin/app":   "app",
+		"lib/libc.so.6": "libc",
+		"lib/libz's.so": "z",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g := &Graph{
+		Roots: []string{"/usr/bin/app"},
+		Edges: map[string][]string{
+			"/usr/bin/app": {"libc.so.6", "libz's.so", "libmissing.so"},
+		},
+		Resolved: map[string]string{
+			"libc.so.6": "/lib/libc.so.6",
+			"libz's.so": "/lib/libz's.so",
+		},
+	}
+	files := []File{
+		{Path: filepath.Join(root, "usr/bin/app"), Name: "usr/bin/app"},
+		{Path: filepath.Join(root, "lib/libc.so.6"), Name: "lib/libc.so.6"},
+		{Path: filepath.Join(root, "lib/libz's.so"), Name: "lib/libz's.so"},
+	}
+	db := PackageIndex{
+		"/lib/libc.so.6": {Name: "libc6", Version: "2.36", Arch: "amd64"},
+	}
+
+	r := &Resolver{Root: root}
+	out := filepath.Join(t.TempDir(), "grabs.sqlite")
+	// A second grab is added to the same database.
+	for _, bundle := range []string{"app", "app2"} {
+		if err := r.WriteSQLite(out, bundle, g, files, db, time.Unix(0, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for query, want := range map[string]string{
+		"SELECT count(*) FROM bundles":  "2",
+		"SELECT count(*) FROM packages": "1",
+		"SELECT count(*) FROM hashes":   "12",
+		"SELECT soname, size FROM objects WHERE bundle = 2 AND path = '/lib/libz''s.so'":      "libz's.so|1",
+		"SELECT p.name FROM objects o JOIN packages p ON o.package = p.id WHERE o.bundle = 1": "libc6",
+		"SELECT needs FROM dependencies WHERE bundle = 1 AND path IS NULL":                    "libmissing.so",
+	} {
+		got, err := exec.Command("sqlite3", out, query).Output()
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if strings.TrimSpace(string(got)) != want {
+			t.Errorf("%s = %q; want %q", query, got, want)
+		}
+	}
+}
+
+func TestWriteSQL(t *testing.T) {
+	root := t.TempDir()
+	for name, contents := range map[string]string{"app": "app", "libz's.so": "z"} {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g := &Graph{
+		Roots:    []string{"/app"},
+		Edges:    map[string][]string{"/app": {"libz's.so", "libmissing.so"}},
+		Resolved: map[string]string{"libz's.so": "/libz's.so"},
+	}
+	files := []File{
+		{Path: filepath.Join(root, "app"), Name: "app"},
+		{Path: filepath.Join(root, "libz's.so"), Name: "libz's.so"},
+	}
+	db := PackageIndex{
+		"/libz's.so": {Name: "zlib1g", Version: "1:1.2.13", Arch: "amd64"},
+	}
+
+	var b strings.Builder
+	r := &Resolver{Root: root}
+	if err := r.WriteSQL(&b, "app's", g, files, db, time.Unix(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	script := b.String()
+	if !strings.HasPrefix(script, sqlSchema+"BEGIN;\n") || !strings.HasSuffix(script, "COMMIT;\n") {
+		t.Errorf("script is not the schema and one transaction:\n%s", script)
+	}
+	for _, want := range []string{
+		"INSERT INTO bundles (name, created) VALUES ('app''s', '1970-01-01T00:00:00Z');\n",
+		"INSERT OR IGNORE INTO packages (name, version, arch) VALUES ('zlib1g', '1:1.2.13', 'amd64');\n",
+		"VALUES ((SELECT bundle FROM current), 'app', '/app', NULL, 3, NULL);\n",
+		"VALUES ((SELECT bundle FROM current), 'libz''s.so', '/libz''s.so', 'libz''s.so', 1, (SELECT id FROM packages WHERE name = 'zlib1g'",
+		"SELECT id, 'sha256', '594e519ae499312b29433b7dd8a97ff068defcba9755b6d5d00e84c524d67b06' FROM objects",
+		"VALUES ((SELECT bundle FROM current), '/app', 'libz''s.so', '/libz''s.so');\n",
+		"VALUES ((SELECT bundle FROM current), '/app', 'libmissing.so', NULL);\n",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script lacks %q:\n%s", want, script)
+		}
+	}
+}
+
+func TestReadDrift(t *testing.T) {
+	if err := CheckSQLite(); err != nil {
+		t.Skip(err)
+	}
+
+	root := t.TempDir()
+	write := func(name, contents string) File {
+		path := filepath.Join(root, name)
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return File{Path: path, Name: name}
+	}
+	r := &Resolver{Root: root}
+	out := filepath.Join(t.TempDir(), "fleet.sqlite")
+	scan := func(files ...File) []Drift {
+		if err := r.WriteSQLite(out, "app", &Graph{}, files, nil, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		drift, err := ReadDrift(out, "app")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return drift
+	}
+
+	if drift := scan(write("app", "1"), write("liba.so", "a")); len(drift) != 0 {
+		t.Errorf("first scan drifted: %v", drift)
+	}
+	if drift := scan(write("app", "1"), write("liba.so", "a")); len(drift) != 0 {
+		t.Errorf("unchanged scan drifted: %v", drift)
+	}
+	drift := scan(write("app", "2"), write("libb.so", "b"))
+	var got []string
+	for _, d := range drift {
+		got = append(got, d.Change+" "+d.Name)
+	}
+	want := []string{"changed app", "removed liba.so", "added libb.so"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("drift = %q; want %q", got, want)
+	}
+
+	// Names may hold the separators of sqlite3's other output modes.
+	odd := "lib|odd\tname\n.so"
+	drift = scan(write("app", "2"), write("libb.so", "b"), write(odd, "odd"))
+	got = nil
+	for _, d := range drift {
+		got = append(got, d.Change+" "+d.Name)
+	}
+	want = []string{"added " + odd}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("drift = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,49 @@
+package grab
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// WriteSquashFS writes files to the SquashFS image filename, which can be
+// loop-mounted or used as a systemd portable service image, and returns the
+// total bytes read from disk. The files are staged in a temporary directory
+// with WriteDir and packed by mksquashfs. They are owned by root, or by
+// opts.Owner; with opts.Reproducible, every time in the image is
+// opts.ModTime. opts may be nil.
+func WriteSquashFS(filename string, files []File, opts *TarOptions) (int64, error) {
+	if opts == nil {
+		opts = &TarOptions{}
+	}
+	stage, err := os.MkdirTemp("", "grab-squashfs-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(stage)
+
+	total, err := WriteDir(stage, files, opts)
+	if err != nil {
+		return total, err
+	}
+
+	args := []string{stage, filename, "-noappend", "-quiet", "-no-progress"}
+	if o := opts.Owner; o != nil {
+		args = append(args, "-force-uid", strconv.Itoa(o.UID), "-force-gid", strconv.Itoa(o.GID))
+	} else {
+		args = append(args, "-all-root")
+	}
+	if opts.Reproducible {
+		epoch := strconv.FormatInt(opts.modTime().Unix(), 10)
+		args = append(args, "-mkfs-time", epoch, "-all-time", epoch)
+	}
+	cmd := exec.Command("mksquashfs", args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return total, fmt.Errorf("mksquashfs %s: %v", strings.Join(args[1:], " "), err)
+	}
+	return total, nil
+}
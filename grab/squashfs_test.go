@@ -0,0 +1,41 @@
+package grab
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteSquashFS(t *testing.T) {
+	for _, tool := range []string{"mksquashfs", "unsquashfs"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not installed", tool)
+		}
+	}
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "libfoo.so.1")
+	if err := ioutil.WriteFile(lib, []byte("lib"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	image := filepath.Join(dir, "bundle.sqfs")
+	files := []File{{Path: lib, Name: "usr/lib/libfoo.so.1"}}
+	if _, err := WriteSquashFS(image, files, &TarOptions{Owner: &Owner{UID: 1, GID: 2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command("unsquashfs", "-lln", image).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasSuffix(line, "/usr/lib/libfoo.so.1") {
+			if fields := strings.Fields(line); fields[0] != "-rw-r--r--" || fields[1] != "1/2" {
+				t.Errorf("archived as %s", line)
+			}
+			return
+		}
+	}
+	t.Errorf("usr/lib/libfoo.so.1 not in the image:\n%s", out)
+}
@@ -0,0 +1,359 @@
+package grab
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SSHFS is the root filesystem of a remote host, read by running stat,
+// readlink, cat and ls there with the ssh command over one multiplexed
+// connection, so that nothing need be installed on the host. It implements
+// fs.ReadLinkFS and fs.ReadDirFS, for use as a Resolver's FS. Every file
+// and symlink read is mirrored into the local directory Mirror, so that
+// what was resolved can be archived with Mirror as the Resolver's Root.
+type SSHFS struct {
+	// Dest is the host to read, as ssh takes it, such as user@host.
+	Dest string
+	// Mirror is the local directory files are mirrored into.
+	Mirror string
+
+	control string // Directory holding the multiplexing socket
+
+	mu      sync.Mutex // Guards the caches below
+	lstats  map[string]statResult
+	stats   map[string]statResult
+	fetched map[string]bool // Files mirrored, by name
+}
+
+// statResult is a cached stat of a remote file.
+type statResult struct {
+	fi  fs.FileInfo
+	err error
+}
+
+// DialSSH connects to dest, as ssh takes it, returning an SSHFS of its
+// root filesystem which mirrors what it reads into mirror. ssh prompts for
+// passwords and host keys on the terminal as usual. Close disconnects.
+func DialSSH(dest, mirror string) (*SSHFS, error) {
+	control, err := os.MkdirTemp("", "grab-ssh-")
+	if err != nil {
+		return nil, err
+	}
+	s := &SSHFS{
+		Dest:    dest,
+		Mirror:  mirror,
+		control: control,
+		lstats:  map[string]statResult{},
+		stats:   map[string]statResult{},
+		fetched: map[string]bool{},
+	}
+	if _, err := s.run("true"); err != nil {
+		os.RemoveAll(control)
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close disconnects from the host.
+func (s *SSHFS) Close() error {
+	err := exec.Command("ssh", append(s.sshArgs(), "-O", "exit", s.Dest)...).Run()
+	os.RemoveAll(s.control)
+	return err
+}
+
+// Forget drops the files s has stat'd and fetched, and empties the mirror,
+// so that the host is read afresh, as when it may since have changed.
+func (s *SSHFS) Forget() error {
+	s.mu.Lock()
+	s.lstats = map[string]statResult{}
+	s.stats = map[string]statResult{}
+	s.fetched = map[string]bool{}
+	s.mu.Unlock()
+	entries, err := os.ReadDir(s.Mirror)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(s.Mirror, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sshArgs are the options multiplexing every command over one connection,
+// which lingers briefly should Close not be called.
+func (s *SSHFS) sshArgs() []string {
+	return []string{
+		"-o", "ControlMaster=auto",
+		"-o", "ControlPath=" + filepath.Join(s.control, "ssh"),
+		"-o", "ControlPersist=60",
+	}
+}
+
+// run runs the command args on the host, returning its output. Failures
+// because a file is missing or unreadable are fs.ErrNotExist and
+// fs.ErrPermission.
+func (s *SSHFS) run(args ...string) ([]byte, error) {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	cmd := exec.Command("ssh", append(s.sshArgs(), s.Dest, "--", strings.Join(quoted, " "))...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err == nil {
+		return out, nil
+	}
+	msg := strings.TrimSpace(stderr.String())
+	// ssh itself, rather than the command, fails with status 255.
+	if exit, ok := err.(*exec.ExitError); ok && exit.ExitCode() != 255 {
+		switch {
+		case strings.Contains(msg, "No such file or directory"), strings.Contains(msg, "Not a directory"):
+			return nil, fs.ErrNotExist
+		case strings.Contains(msg, "Permission denied"):
+			return nil, fs.ErrPermission
+		}
+	}
+	if msg != "" {
+		return nil, fmt.Errorf("ssh %s %s: %s", s.Dest, args[0], msg)
+	}
+	return nil, fmt.Errorf("ssh %s %s: %v", s.Dest, args[0], err)
+}
+
+// remote returns the path on the host of name, or an error for op if it is
+// not a valid fs.FS name.
+func remote(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return path.Join("/", name), nil
+}
+
+// Lstat returns a FileInfo describing the file name, without following a
+// final symlink.
+func (s *SSHFS) Lstat(name string) (fs.FileInfo, error) {
+	return s.stat("lstat", name, s.lstats)
+}
+
+// Stat returns a FileInfo describing the file name.
+func (s *SSHFS) Stat(name string) (fs.FileInfo, error) {
+	return s.stat("stat", name, s.stats)
+}
+
+func (s *SSHFS) stat(op, name string, cache map[string]statResult) (fs.FileInfo, error) {
+	s.mu.Lock()
+	cached, ok := cache[name]
+	s.mu.Unlock()
+	if ok {
+		return cached.fi, cached.err
+	}
+	p, err := remote(op, name)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"stat", "-c", "%f %s %Y", "--", p}
+	if op == "stat" {
+		args = []string{"stat", "-L", "-c", "%f %s %Y", "--", p}
+	}
+	out, err := s.run(args...)
+	var fi fs.FileInfo
+	if err == nil {
+		fi, err = parseStat(path.Base(p), string(out))
+	}
+	if err != nil {
+		err = &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	s.mu.Lock()
+	cache[name] = statResult{fi, err}
+	s.mu.Unlock()
+	return fi, err
+}
+
+// ReadLink returns the target of the symlink name, mirroring the symlink.
+func (s *SSHFS) ReadLink(name string) (string, error) {
+	p, err := remote("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	out, err := s.run("readlink", "--", p)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	target := strings.TrimSuffix(string(out), "\n")
+	mirrored := filepath.Join(s.Mirror, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(mirrored), 0755); err != nil {
+		return "", err
+	}
+	if err := os.Symlink(target, mirrored); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	return target, nil
+}
+
+// ReadDir returns the entries of the directory name, sorted by name.
+func (s *SSHFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := remote("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.run("ls", "-1A", "--", p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	var entries []fs.DirEntry
+	for _, entry := range strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") {
+		if entry == "" {
+			continue
+		}
+		fi, err := s.Lstat(path.Join(name, entry))
+		if err != nil {
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(fi))
+	}
+	return entries, nil
+}
+
+// Open fetches the regular file name, mirroring it. Files are fetched once,
+// and opened again from the mirror.
+func (s *SSHFS) Open(name string) (fs.File, error) {
+	fi, err := s.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fmt.Errorf("not a regular file")}
+	}
+	mirrored := filepath.Join(s.Mirror, filepath.FromSlash(name))
+	s.mu.Lock()
+	fetched := s.fetched[name]
+	s.mu.Unlock()
+	if fetched {
+		if fd, err := os.Open(mirrored); err == nil {
+			return fd, nil
+		}
+	}
+	p, _ := remote("open", name)
+	data, err := s.run("cat", "--", p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	// The file is written aside and renamed into place, as another Open
+	// may be fetching it at the same time.
+	if err := os.MkdirAll(filepath.Dir(mirrored), 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(mirrored), ".fetch-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(tmp.Name(), fi.Mode().Perm()); err != nil {
+		return nil, err
+	}
+	if err := os.Chtimes(tmp.Name(), fi.ModTime(), fi.ModTime()); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), mirrored); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.fetched[name] = true
+	s.mu.Unlock()
+	return &sshFile{bytes.NewReader(data), fi}, nil
+}
+
+// sshFile is a file fetched by SSHFS.Open.
+type sshFile struct {
+	*bytes.Reader
+	fi fs.FileInfo
+}
+
+func (f *sshFile) Stat() (fs.FileInfo, error) { return f.fi, nil }
+func (f *sshFile) Close() error               { return nil }
+
+// parseStat parses the output of stat -c '%f %s %Y': the raw mode in hex,
+// the size and the modification time.
+func parseStat(name, out string) (fs.FileInfo, error) {
+	fields := strings.Fields(out)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("unexpected stat output %q", out)
+	}
+	raw, err := strconv.ParseUint(fields[0], 16, 32)
+	if err != nil {
+		return nil, err
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	mtime, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &remoteInfo{name, size, rawMode(uint32(raw)), time.Unix(mtime, 0)}, nil
+}
+
+// rawMode converts a stat st_mode to a FileMode.
+func rawMode(raw uint32) fs.FileMode {
+	mode := fs.FileMode(raw & 0777)
+	switch raw & 0170000 {
+	case 0040000:
+		mode |= fs.ModeDir
+	case 0120000:
+		mode |= fs.ModeSymlink
+	case 0010000:
+		mode |= fs.ModeNamedPipe
+	case 0140000:
+		mode |= fs.ModeSocket
+	case 0020000:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case 0060000:
+		mode |= fs.ModeDevice
+	}
+	if raw&04000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if raw&02000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if raw&01000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
+
+// remoteInfo is a FileInfo from a remote stat.
+type remoteInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (fi *remoteInfo) Name() string       { return fi.name }
+func (fi *remoteInfo) Size() int64        { return fi.size }
+func (fi *remoteInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi *remoteInfo) ModTime() time.Time { return fi.modTime }
+func (fi *remoteInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *remoteInfo) Sys() interface{}   { return nil }
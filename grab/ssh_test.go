@@ -0,0 +1,129 @@
+package grab
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseStat(t *testing.T) {
+	for _, tt := range []struct {
+		out  string
+		mode fs.FileMode
+		size int64
+	}{
+		{"81ed 151344 1663687620\n", 0755, 151344},
+		{"a1ff 15 1663687620\n", fs.ModeSymlink | 0777, 15},
+		{"41ed 4096 1663687620\n", fs.ModeDir | 0755, 4096},
+		{"89ed 55680 1663687620\n", fs.ModeSetuid | 0755, 55680},
+	} {
+		fi, err := parseStat("f", tt.out)
+		if err != nil {
+			t.Errorf("%q: %v", tt.out, err)
+			continue
+		}
+		if fi.Mode() != tt.mode || fi.Size() != tt.size || fi.ModTime().Unix() != 1663687620 {
+			t.Errorf("%q: got %v %d %v", tt.out, fi.Mode(), fi.Size(), fi.ModTime())
+		}
+	}
+	if _, err := parseStat("f", "stat: cannot stat\n"); err == nil {
+		t.Errorf("bad output parsed without error")
+	}
+}
+
+// fakeSSH puts an ssh on $PATH which runs commands locally, logging each,
+// and returns the log.
+func fakeSSH(t *testing.T) string {
+	bin := t.TempDir()
+	script := `#!/bin/sh
+while [ "$1" != "--" ]; do
+	[ "$1" = "-O" ] && exit 0
+	shift
+done
+shift
+echo "$1" >>"$GRAB_SSH_LOG"
+exec sh -c "$1"
+`
+	if err := ioutil.WriteFile(filepath.Join(bin, "ssh"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(bin, "log")
+	t.Setenv("GRAB_SSH_LOG", log)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return log
+}
+
+func TestSSHFSOpenFetchesOnce(t *testing.T) {
+	log := fakeSSH(t)
+	remote := filepath.Join(t.TempDir(), "libfoo.so.1")
+	if err := ioutil.WriteFile(remote, []byte("library"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	s, err := DialSSH("host", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	name := strings.TrimPrefix(remote, "/")
+	for i := 0; i < 2; i++ {
+		data, err := fs.ReadFile(s, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "library" {
+			t.Errorf("read %q", data)
+		}
+	}
+	commands, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(commands), "'cat'"); n != 1 {
+		t.Errorf("fetched %d times; want once:\n%s", n, commands)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(s.Mirror, name)); err != nil || string(data) != "library" {
+		t.Errorf("mirrored %q, %v", data, err)
+	}
+}
+
+func TestSSHFSForget(t *testing.T) {
+	log := fakeSSH(t)
+	remote := filepath.Join(t.TempDir(), "libfoo.so.1")
+	if err := ioutil.WriteFile(remote, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	s, err := DialSSH("host", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	name := strings.TrimPrefix(remote, "/")
+	if _, err := fs.ReadFile(s, name); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(remote, []byte("new"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Forget(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(s.Mirror); len(entries) != 0 {
+		t.Errorf("mirror holds %d entries after Forget", len(entries))
+	}
+	data, err := fs.ReadFile(s, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new" {
+		t.Errorf("read %q after Forget; want the file as changed", data)
+	}
+	commands, _ := ioutil.ReadFile(log)
+	if n := strings.Count(string(commands), "'cat'"); n != 2 {
+		t.Errorf("fetched %d times; want twice:\n%s", n, commands)
+	}
+}
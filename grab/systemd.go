@@ -0,0 +1,137 @@
+package grab
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// unitDirs are where systemd looks for unit files, most important first.
+var unitDirs = []string{
+	"/etc/systemd/system", "/run/systemd/system",
+	"/usr/local/lib/systemd/system", "/lib/systemd/system", "/usr/lib/systemd/system",
+}
+
+// execKeys are the [Service] settings which name commands to run.
+var execKeys = map[string]bool{
+	"ExecCondition": true, "ExecStartPre": true, "ExecStart": true,
+	"ExecStartPost": true, "ExecReload": true, "ExecStop": true,
+	"ExecStopPost": true,
+}
+
+// Unit is what a systemd service needs to run.
+type Unit struct {
+	// Files are the unit file and its drop-ins.
+	Files []string
+	// Binaries are the commands run by its Exec* settings.
+	Binaries []string
+	// EnvironmentFiles are read by the service manager. Optional ones
+	// (prefixed "-" in the unit) which do not exist are left out.
+	EnvironmentFiles []string
+}
+
+// LoadUnit finds the unit file for the service name (".service" is implied)
+// within r.Root, along with its name.d/*.conf drop-ins, and reads the
+// commands and environment files they refer to.
+func (r *Resolver) LoadUnit(name string) (*Unit, error) {
+	if filepath.Ext(name) == "" {
+		name += ".service"
+	}
+
+	u := &Unit{}
+	for _, dir := range unitDirs {
+		path := filepath.Join(dir, name)
+		if _, err := r.stat(path); err == nil {
+			u.Files = append(u.Files, path)
+			break
+		}
+	}
+	if len(u.Files) == 0 {
+		return nil, fmt.Errorf("unit %s not found in %s", name, strings.Join(unitDirs, ", "))
+	}
+	for _, dir := range unitDirs {
+		dropIns, _ := r.globDir(filepath.Join(dir, name+".d"), "*.conf")
+		for _, dropIn := range dropIns {
+			u.Files = append(u.Files, filepath.Join(dir, name+".d", dropIn))
+		}
+	}
+
+	for _, file := range u.Files {
+		if err := r.parseUnitFile(u, file); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+	}
+	return u, nil
+}
+
+// parseUnitFile adds the commands and environment files of the [Service]
+// section of file to u.
+func (r *Resolver) parseUnitFile(u *Unit, file string) error {
+	fd, err := r.open(file)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	var (
+		section string
+		logical string
+	)
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasSuffix(line, "\\") {
+			logical += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		line, logical = logical+line, ""
+
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+			continue
+		case line[0] == '[':
+			section = strings.Trim(line, "[]")
+			continue
+		}
+		if section != "Service" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		switch {
+		case execKeys[key]:
+			if bin := execBinary(value); bin != "" && !contains(u.Binaries, bin) {
+				u.Binaries = append(u.Binaries, bin)
+			}
+		case key == "EnvironmentFile":
+			optional := strings.HasPrefix(value, "-")
+			path := strings.TrimPrefix(value, "-")
+			if _, err := r.stat(path); err != nil {
+				if optional {
+					continue
+				}
+				return fmt.Errorf("EnvironmentFile %s: %v", path, err)
+			}
+			if !contains(u.EnvironmentFiles, path) {
+				u.EnvironmentFiles = append(u.EnvironmentFiles, path)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// execBinary returns the command of an Exec* setting, without the special
+// prefixes (such as "-" to ignore failure) that systemd allows before it.
+func execBinary(value string) string {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.TrimLeft(fields[0], "@-:+!|")
+}
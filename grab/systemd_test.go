@@ -0,0 +1,56 @@
+package grab
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadUnit(t *testing.T) {
+	root := t.TempDir()
+	for name, contents := range map[string]string{
+		"lib/systemd/system/app.service": `[Unit]
+Description=ExecStart=/not/this
+
+[Service]
+EnvironmentFile=-/etc/default/missing
+EnvironmentFile=/etc/default/app
+ExecStartPre=-/usr/bin/app-check \
+    --strict
+ExecStart=/usr/bin/app --serve
+`,
+		"etc/systemd/system/app.service.d/override.conf": `[Service]
+ExecStart=
+ExecStart=!!/usr/sbin/app-wrapper /usr/bin/app
+`,
+		"etc/default/app": "OPTS=1\n",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &Resolver{Root: root}
+	u, err := r.LoadUnit("app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Unit{
+		Files: []string{
+			"/lib/systemd/system/app.service",
+			"/etc/systemd/system/app.service.d/override.conf",
+		},
+		Binaries:         []string{"/usr/bin/app-check", "/usr/bin/app", "/usr/sbin/app-wrapper"},
+		EnvironmentFiles: []string{"/etc/default/app"},
+	}
+	if !reflect.DeepEqual(u, want) {
+		t.Errorf("got %+v, want %+v", u, want)
+	}
+}
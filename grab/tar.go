@@ -0,0 +1,377 @@
+package grab
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// File is a file on disk and the name it is given in the archive.
+type File struct {
+	Path, Name string
+	// Link, if set, means the file is archived as a symlink to Link rather
+	// than with the contents of Path.
+	Link string
+	// Special, if set, means Path is a FIFO or device node, archived as
+	// such without contents. See Resolver.SpecialFiles.
+	Special bool
+	// Dir, if set, means Path is a directory, archived as a directory entry
+	// without its contents. See TarOptions.Dirs.
+	Dir bool
+}
+
+// stat is os.Lstat for symlinks and os.Stat otherwise.
+func (file File) stat() (os.FileInfo, error) {
+	if file.Link != "" {
+		return os.Lstat(file.Path)
+	}
+	return os.Stat(file.Path)
+}
+
+// TarOptions control how files are written to a tar. The zero value is the
+// default behaviour.
+type TarOptions struct {
+	// NoPAX leaves the choice of header format to archive/tar, rather than
+	// forcing PAX records for long and non-ASCII names.
+	NoPAX bool
+	// Progress, if non-nil, is called after each file is written with the
+	// number of bytes of it read from disk.
+	Progress func(file File, n int64)
+	// Prefetch, one of Prefetches, has the files' contents read ahead in the
+	// background while they are written. See Prefetch.
+	Prefetch string
+	// Reproducible makes the archive depend only on the files' names,
+	// contents, modes and link targets, for bit-identical output across
+	// machines: entries are sorted by name, owners are zeroed and every
+	// modification time is ModTime.
+	Reproducible bool
+	// ModTime is the modification time of each entry when Reproducible,
+	// such as from $SOURCE_DATE_EPOCH. The zero Time means the Unix epoch.
+	ModTime time.Time
+	// SELinux archives the files' SELinux contexts, from their
+	// security.selinux attributes, with their other extended attributes.
+	SELinux bool
+	// Owner, if set, owns every entry, whoever owns the files on disk.
+	Owner *Owner
+	// Dirs adds a directory entry for each parent directory of the files'
+	// names, before the first file within it, for extractors which do not
+	// create missing parents. A directory takes its mode from the directory
+	// holding the file on disk at the same depth. Directory entries replace
+	// symlinks at their paths when a layer is applied, as /lib is on
+	// merged-/usr images, so they are not written by default.
+	Dirs bool
+}
+
+// Owner is the user and group an archive's entries are given.
+type Owner struct {
+	UID, GID     int
+	Uname, Gname string // Empty for numeric ownership
+}
+
+// ParseOwner parses USER:GROUP, each a name or a number. Names are looked up
+// on the host, as GNU tar's --owner does; numbers are archived without names.
+func ParseOwner(s string) (*Owner, error) {
+	userPart, groupPart, ok := strings.Cut(s, ":")
+	if !ok || userPart == "" || groupPart == "" {
+		return nil, fmt.Errorf("%q is not USER:GROUP", s)
+	}
+	o := &Owner{}
+	if uid, err := strconv.Atoi(userPart); err == nil && uid >= 0 {
+		o.UID = uid
+	} else {
+		u, err := user.Lookup(userPart)
+		if err != nil {
+			return nil, err
+		}
+		if o.UID, err = strconv.Atoi(u.Uid); err != nil {
+			return nil, fmt.Errorf("user %s has uid %q", userPart, u.Uid)
+		}
+		o.Uname = u.Username
+	}
+	if gid, err := strconv.Atoi(groupPart); err == nil && gid >= 0 {
+		o.GID = gid
+	} else {
+		g, err := user.LookupGroup(groupPart)
+		if err != nil {
+			return nil, err
+		}
+		if o.GID, err = strconv.Atoi(g.Gid); err != nil {
+			return nil, fmt.Errorf("group %s has gid %q", groupPart, g.Gid)
+		}
+		o.Gname = g.Name
+	}
+	return o, nil
+}
+
+// modTime returns the modification time of entries when Reproducible.
+func (opts *TarOptions) modTime() time.Time {
+	if opts.ModTime.IsZero() {
+		return time.Unix(0, 0)
+	}
+	return opts.ModTime.Truncate(time.Second)
+}
+
+// ordered returns the entries archived for files with opts, in order.
+func (opts *TarOptions) ordered(files []File) []File {
+	if opts.Dirs {
+		files = parentDirs(files)
+	}
+	if !opts.Reproducible {
+		return files
+	}
+	files = append([]File{}, files...)
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	return files
+}
+
+// parentDirs returns files with an entry before each of them for every
+// parent directory of its name not already archived. Names sort after their
+// parents, so Reproducible ordering keeps parents first.
+func parentDirs(files []File) []File {
+	var out []File
+	seen := map[string]bool{}
+	for _, file := range files {
+		if file.Dir {
+			seen[strings.TrimSuffix(file.Name, "/")] = true
+		}
+	}
+	for _, file := range files {
+		var dirs []File
+		name, host := path.Clean(file.Name), file.Path
+		for {
+			name, host = path.Dir(name), filepath.Dir(host)
+			if name == "." || name == "/" || seen[name] {
+				break
+			}
+			seen[name] = true
+			dirs = append(dirs, File{Path: host, Name: name + "/", Dir: true})
+		}
+		for i := len(dirs) - 1; i >= 0; i-- {
+			out = append(out, dirs[i])
+		}
+		out = append(out, file)
+	}
+	return out
+}
+
+// WriteTar writes the files of g to w as a tar stream.
+func WriteTar(w io.Writer, g *Graph) error {
+	_, err := WriteFiles(w, g.Files(), nil)
+	return err
+}
+
+// WriteFiles writes files to w as a tar stream and returns the total bytes
+// read from disk. opts may be nil.
+func WriteFiles(w io.Writer, files []File, opts *TarOptions) (int64, error) {
+	if opts == nil {
+		opts = &TarOptions{}
+	}
+	tf := tar.NewWriter(w)
+	total, err := writeEntries(tf, opts.ordered(files), opts)
+	if err != nil {
+		return total, err
+	}
+	return total, tf.Close()
+}
+
+// AppendFiles writes to w a tar stream of the entries of the tar read from
+// existing, followed by those of files which it does not already hold by
+// name, and returns the total bytes read from disk. opts may be nil; it
+// applies only to the appended files.
+func AppendFiles(w io.Writer, existing io.Reader, files []File, opts *TarOptions) (int64, error) {
+	if opts == nil {
+		opts = &TarOptions{}
+	}
+	tf := tar.NewWriter(w)
+	have := map[string]bool{}
+	tr := tar.NewReader(existing)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		have[path.Clean(hdr.Name)] = true
+		if err := tf.WriteHeader(hdr); err != nil {
+			return 0, err
+		}
+		if _, err := io.Copy(tf, tr); err != nil {
+			return 0, err
+		}
+	}
+
+	var added []File
+	for _, file := range opts.ordered(files) {
+		if !have[path.Clean(file.Name)] {
+			added = append(added, file)
+		}
+	}
+	total, err := writeEntries(tf, added, opts)
+	if err != nil {
+		return total, err
+	}
+	return total, tf.Close()
+}
+
+// writeEntries writes files to tf in order and returns the total bytes read
+// from disk.
+func writeEntries(tf *tar.Writer, files []File, opts *TarOptions) (int64, error) {
+	go Prefetch(files, opts.Prefetch)
+
+	var total int64
+	for _, file := range files {
+		hdr, err := TarHeader(file, opts)
+		if err != nil {
+			return total, err
+		}
+
+		err = tf.WriteHeader(hdr)
+		if err != nil {
+			return total, err
+		}
+
+		var n int64
+		if file.Dir {
+			continue
+		}
+		if file.Link == "" && !file.Special {
+			n, err = copyFile(tf, file.Path)
+			total += n
+			if err != nil {
+				return total, err
+			}
+		}
+		if opts.Progress != nil {
+			opts.Progress(file, n)
+		}
+	}
+	return total, nil
+}
+
+// EstimateTarSize returns the approximate size of the tar WriteFiles would
+// produce for files: one header block per entry plus padded contents and the
+// end-of-archive marker. Long names cost an extra PAX header which is not
+// counted exactly, so one more block is allowed per entry for it.
+func EstimateTarSize(files []File) (int64, error) {
+	const blockSize = 512
+	size := int64(2 * blockSize)
+	for _, file := range files {
+		fi, err := file.stat()
+		if err != nil {
+			return 0, err
+		}
+		if file.Link != "" || file.Special || file.Dir {
+			size += 2 * blockSize
+			continue
+		}
+		size += 2*blockSize + (fi.Size()+blockSize-1)/blockSize*blockSize
+	}
+	return size, nil
+}
+
+func copyFile(w io.Writer, path string) (int64, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+
+	return io.Copy(w, fd)
+}
+
+// TarHeader returns the tar header which file is archived with. opts may be
+// nil.
+func TarHeader(file File, opts *TarOptions) (*tar.Header, error) {
+	if opts == nil {
+		opts = &TarOptions{}
+	}
+
+	fi, err := file.stat()
+	if err != nil {
+		return nil, err
+	}
+
+	hdr, err := tar.FileInfoHeader(fi, file.Link)
+	if err != nil {
+		return nil, err
+	}
+	hdr.Name = file.Name
+	if file.Link == "" && !file.Special {
+		attrs, err := xattrs(file.Path, opts.SELinux)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file.Path, err)
+		}
+		for name, value := range attrs {
+			if hdr.PAXRecords == nil {
+				hdr.PAXRecords = map[string]string{}
+			}
+			hdr.PAXRecords[paxXattrPrefix+name] = value
+		}
+	}
+	if opts.Reproducible {
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		hdr.ModTime = opts.modTime()
+		hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+	}
+	if o := opts.Owner; o != nil {
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = o.UID, o.GID, o.Uname, o.Gname
+	}
+	if !opts.NoPAX {
+		enforcePAX(hdr)
+	}
+	return hdr, nil
+}
+
+// enforcePAX makes hdr use PAX records for any name or link target which does
+// not fit in the USTAR name field as plain ASCII. archive/tar would otherwise
+// prefer splitting long names across the USTAR prefix field, which some
+// extractors truncate. The times are kept to what the other formats hold, as
+// archive/tar would otherwise record access and change times and sub-second
+// modification times, which differ from one grab to the next.
+func enforcePAX(hdr *tar.Header) {
+	const ustarNameSize = 100
+	needsPAX := func(s string) bool {
+		if len(s) > ustarNameSize {
+			return true
+		}
+		for i := 0; i < len(s); i++ {
+			if s[i] >= 0x80 {
+				return true
+			}
+		}
+		return false
+	}
+
+	records := map[string]string{}
+	if needsPAX(hdr.Name) {
+		records["path"] = hdr.Name
+	}
+	if needsPAX(hdr.Linkname) {
+		records["linkpath"] = hdr.Linkname
+	}
+	if len(records) == 0 {
+		return
+	}
+
+	if hdr.PAXRecords == nil {
+		hdr.PAXRecords = map[string]string{}
+	}
+	for k, v := range records {
+		hdr.PAXRecords[k] = v
+	}
+	hdr.ModTime = hdr.ModTime.Round(time.Second)
+	hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+	hdr.Format = tar.FormatPAX
+}
@@ -0,0 +1,380 @@
+package grab
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteTarLongAndUnicodeNames(t *testing.T) {
+	dir := t.TempDir()
+
+	names := []string{
+		"libshort.so.1",
+		strings.Repeat("vendor/", 15) + "lib/x86_64-linux-gnu/libdeep.so.1",
+		"opt/" + strings.Repeat("toolchain-", 20) + "/libverylongdirectory.so",
+		"usr/lib/libünïcødé.so.2",
+	}
+
+	var files []File
+	for i, name := range names {
+		path := filepath.Join(dir, string(rune('a'+i)))
+		err := ioutil.WriteFile(path, []byte(name), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, File{Path: path, Name: name})
+	}
+
+	for _, pax := range []bool{true, false} {
+		var buf bytes.Buffer
+		_, err := WriteFiles(&buf, files, &TarOptions{NoPAX: !pax})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tr := tar.NewReader(&buf)
+		for _, name := range names {
+			hdr, err := tr.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Name != name {
+				t.Errorf("pax=%t: got name %q, want %q", pax, hdr.Name, name)
+			}
+
+			isPAX := hdr.Format == tar.FormatPAX
+			nonASCII := strings.IndexFunc(name, func(r rune) bool {
+				return r >= 0x80
+			}) >= 0
+			wantPAX := len(name) > 100 || nonASCII
+			if pax && isPAX != wantPAX {
+				t.Errorf("pax=%t: %q has format %v", pax, name, hdr.Format)
+			}
+
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != name {
+				t.Errorf("pax=%t: %q has contents %q", pax, name, data)
+			}
+		}
+		if _, err := tr.Next(); err != io.EOF {
+			t.Errorf("pax=%t: expected end of archive, got %v", pax, err)
+		}
+	}
+}
+
+func TestEnforcePAXLinkname(t *testing.T) {
+	hdr := &tar.Header{
+		Name:     "libfoo.so",
+		Linkname: strings.Repeat("../", 40) + "libfoo.so.1",
+		Typeflag: tar.TypeSymlink,
+	}
+	enforcePAX(hdr)
+	if hdr.Format != tar.FormatPAX || hdr.PAXRecords["linkpath"] != hdr.Linkname {
+		t.Errorf("long link target not encoded with PAX: %+v", hdr)
+	}
+	if _, ok := hdr.PAXRecords["path"]; ok {
+		t.Errorf("short name unnecessarily encoded with PAX")
+	}
+}
+
+func TestWriteFilesPAXTimes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lib")
+	if err := ioutil.WriteFile(path, []byte("lib"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(1700000000, 600000000)
+	files := []File{{Path: path, Name: "usr/lib/libünïcødé.so.2"}}
+
+	write := func() []byte {
+		// Changing the access time changes the change time too.
+		if err := os.Chtimes(path, time.Now(), mtime); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if _, err := WriteFiles(&buf, files, nil); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	first := write()
+	time.Sleep(10 * time.Millisecond)
+	if !bytes.Equal(first, write()) {
+		t.Errorf("archives differ after the file was accessed")
+	}
+
+	hdr, err := tar.NewReader(bytes.NewReader(first)).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"atime", "ctime", "mtime"} {
+		if value, ok := hdr.PAXRecords[key]; ok {
+			t.Errorf("PAX record %s=%s", key, value)
+		}
+	}
+	if want := mtime.Round(time.Second); !hdr.ModTime.Equal(want) {
+		t.Errorf("mtime %v; want %v", hdr.ModTime, want)
+	}
+}
+
+func TestWriteFilesReproducible(t *testing.T) {
+	dir := t.TempDir()
+	var files []File
+	for _, name := range []string{"libb.so", "liba.so"} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, File{Path: path, Name: name})
+	}
+
+	epoch := time.Unix(1700000000, 0)
+	write := func() []byte {
+		var buf bytes.Buffer
+		opts := &TarOptions{Reproducible: true, ModTime: epoch}
+		if _, err := WriteFiles(&buf, files, opts); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	first := write()
+	// A touched file must archive the same.
+	if err := os.Chtimes(files[0].Path, time.Now(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, write()) {
+		t.Errorf("archives differ after touching a file")
+	}
+
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(first))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+		if hdr.Uid != 0 || hdr.Gid != 0 || hdr.Uname != "" || hdr.Gname != "" || !hdr.ModTime.Equal(epoch) {
+			t.Errorf("%s: uid %d gid %d uname %q gname %q mtime %v",
+				hdr.Name, hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname, hdr.ModTime)
+		}
+	}
+	if strings.Join(names, ",") != "liba.so,libb.so" {
+		t.Errorf("entries in order %q, want sorted", names)
+	}
+}
+
+func TestWriteFilesXattrs(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "ping")
+	if err := ioutil.WriteFile(src, []byte("ping"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"user.origin": "host\x00with a NUL"}
+	if err := setXattrs(src, want); err != nil {
+		t.Skipf("filesystem lacks user xattrs: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := WriteFiles(&buf, []File{{Path: src, Name: "bin/ping"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	dest := t.TempDir()
+	if err := Extract(&buf, dest, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := xattrs(filepath.Join(dest, "bin/ping"), false); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("extracted xattrs = %q, %v; want %q", got, err, want)
+	}
+
+	dir := t.TempDir()
+	if _, err := WriteDir(dir, []File{{Path: src, Name: "ping"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := xattrs(filepath.Join(dir, "ping"), false); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("copied xattrs = %q, %v; want %q", got, err, want)
+	}
+}
+
+func TestTarHeaderSELinux(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "httpd")
+	if err := ioutil.WriteFile(src, []byte("httpd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	const context = "system_u:object_r:httpd_exec_t:s0\x00"
+	if err := setXattrs(src, map[string]string{selinuxXattr: context}); err != nil {
+		t.Skipf("cannot set %s: %v", selinuxXattr, err)
+	}
+
+	for _, selinux := range []bool{false, true} {
+		hdr, err := TarHeader(File{Path: src, Name: "httpd"}, &TarOptions{SELinux: selinux})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := hdr.PAXRecords[paxXattrPrefix+selinuxXattr]
+		if ok != selinux || (ok && got != context) {
+			t.Errorf("SELinux %v: got context %q, %v", selinux, got, ok)
+		}
+	}
+}
+
+func TestTarHeaderOwner(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "app")
+	if err := ioutil.WriteFile(src, []byte("app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for s, want := range map[string]Owner{
+		"0:0":         {},
+		"1000:100":    {UID: 1000, GID: 100},
+		"root:0":      {Uname: "root"},
+		"65534:65534": {UID: 65534, GID: 65534},
+	} {
+		owner, err := ParseOwner(s)
+		if err != nil {
+			t.Errorf("ParseOwner(%s): %v", s, err)
+			continue
+		}
+		hdr, err := TarHeader(File{Path: src, Name: "app"}, &TarOptions{Owner: owner})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := Owner{hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname}
+		if got != want {
+			t.Errorf("-owner %s: archived as %+v; want %+v", s, got, want)
+		}
+	}
+	for _, s := range []string{"root", ":0", "no-such-user-here:0"} {
+		if _, err := ParseOwner(s); err == nil {
+			t.Errorf("ParseOwner(%q) succeeded", s)
+		}
+	}
+}
+
+func TestWriteFilesDirs(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string) string {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "lib64"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	files := []File{
+		{Path: write("app"), Name: "app"},
+		{Path: write("usr/lib/x86_64-linux-gnu/libc.so.6"), Name: "usr/lib/x86_64-linux-gnu/libc.so.6"},
+		{Path: write("usr/lib/x86_64-linux-gnu/libm.so.6"), Name: "usr/lib/x86_64-linux-gnu/libm.so.6"},
+		{Path: write("lib64/ld-linux-x86-64.so.2"), Name: "lib64/ld-linux-x86-64.so.2"},
+	}
+
+	var buf bytes.Buffer
+	if _, err := WriteFiles(&buf, files, &TarOptions{Dirs: true}); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	modes := map[string]int64{}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (hdr.Typeflag == tar.TypeDir) != strings.HasSuffix(hdr.Name, "/") {
+			t.Errorf("%s has type %c", hdr.Name, hdr.Typeflag)
+		}
+		got = append(got, hdr.Name)
+		modes[hdr.Name] = hdr.Mode & 0777
+	}
+	want := []string{
+		"app",
+		"usr/", "usr/lib/", "usr/lib/x86_64-linux-gnu/",
+		"usr/lib/x86_64-linux-gnu/libc.so.6",
+		"usr/lib/x86_64-linux-gnu/libm.so.6",
+		"lib64/",
+		"lib64/ld-linux-x86-64.so.2",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entries %q; want %q", got, want)
+	}
+	if modes["lib64/"] != 0700 || modes["usr/lib/"] != 0755 {
+		t.Errorf("directory modes %o and %o; want 700 and 755", modes["lib64/"], modes["usr/lib/"])
+	}
+
+	buf.Reset()
+	if _, err := WriteFiles(&buf, files, nil); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "usr/lib/\x00") {
+		t.Errorf("directory entries written without Dirs")
+	}
+}
+
+func TestAppendFiles(t *testing.T) {
+	dir := t.TempDir()
+	var files []File
+	for _, name := range []string{"liba.so", "libb.so", "libc.so"} {
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, File{Path: p, Name: name})
+	}
+
+	var first bytes.Buffer
+	if _, err := WriteFiles(&first, files[:2], nil); err != nil {
+		t.Fatal(err)
+	}
+	// libb.so is already archived, from what may be another build.
+	if err := ioutil.WriteFile(files[1].Path, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var second bytes.Buffer
+	total, err := AppendFiles(&second, &first, files[1:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != int64(len("libc.so")) {
+		t.Errorf("read %d bytes from disk; want only libc.so's", total)
+	}
+
+	var got []string
+	tr := tar.NewReader(&second)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, hdr.Name+"="+string(data))
+	}
+	want := []string{"liba.so=liba.so", "libb.so=libb.so", "libc.so=libc.so"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entries %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,145 @@
+package grab
+
+import (
+	"bytes"
+	"debug/buildinfo"
+	"debug/elf"
+	"strings"
+)
+
+// unwinder is dlopened by glibc for unwinding (pthread_cancel, backtrace),
+// so it is needed at runtime without appearing in DT_NEEDED.
+const unwinder = "libgcc_s.so.1"
+
+// binaryInfo is what can be cheaply determined about how a binary was built.
+type binaryInfo struct {
+	Toolchain string // "go", "rust", or "" if unknown
+	Static    bool   // No interpreter and no DT_NEEDED entries
+	Arch      string // See ArchName
+}
+
+// inspectBinary determines the toolchain which built filename, within
+// r.Root, and whether it is statically linked.
+func (r *Resolver) inspectBinary(filename string) (binaryInfo, error) {
+	var info binaryInfo
+
+	f, err := r.openELF(filename)
+	if err != nil {
+		return info, err
+	}
+	defer f.Close()
+
+	needed, err := f.ImportedLibraries()
+	if err != nil {
+		return info, err
+	}
+	hasInterp := false
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_INTERP {
+			hasInterp = true
+		}
+	}
+	info.Static = !hasInterp && len(needed) == 0
+	info.Arch = ArchName(f.File)
+
+	switch {
+	case isGoBinary(f):
+		info.Toolchain = "go"
+	case isRustBinary(f.File):
+		info.Toolchain = "rust"
+	}
+	return info, nil
+}
+
+func isGoBinary(f *elfFile) bool {
+	if f.Section(".go.buildinfo") != nil || f.Section(".note.go.buildid") != nil {
+		return true
+	}
+	ra, _, err := readerAt(f.fd)
+	if err != nil {
+		return false
+	}
+	_, err = buildinfo.Read(ra)
+	return err == nil
+}
+
+func isRustBinary(f *elf.File) bool {
+	if f.Section(".rustc") != nil {
+		return true
+	}
+	if comment := f.Section(".comment"); comment != nil {
+		data, err := comment.Data()
+		if err == nil && bytes.Contains(data, []byte("rustc version")) {
+			return true
+		}
+	}
+	for _, symbols := range []func() ([]elf.Symbol, error){f.Symbols, f.DynamicSymbols} {
+		syms, err := symbols()
+		if err != nil {
+			continue
+		}
+		for _, sym := range syms {
+			if strings.HasPrefix(sym.Name, "__rust_") || sym.Name == "rust_begin_unwind" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// toolchainImports is recursiveImports with shortcuts for the mostly-static
+// binaries Go and Rust produce. Fully static binaries are not analysed
+// further, and Rust binaries which use glibc get its dlopened unwinder.
+func (r *Resolver) toolchainImports(filename string) (*Graph, error) {
+	info, err := r.inspectBinary(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	describe := "binary"
+	if info.Toolchain != "" {
+		describe = info.Toolchain + " binary"
+	}
+
+	if info.Static {
+		r.logf("%s is a statically linked %s; it has no libraries to include",
+			filename, describe)
+		return &Graph{
+			Roots:    []string{filename},
+			Edges:    map[string][]string{},
+			Resolved: map[string]string{},
+		}, nil
+	}
+
+	g, err := r.recursiveImports(filename, r.Preload)
+	if err != nil {
+		return nil, err
+	}
+	if info.Toolchain == "" {
+		return g, nil
+	}
+
+	libs := g.Libraries()
+	r.logf("%s is a %s with %d dynamic dependencies", filename, describe, len(libs))
+
+	_, hasLibc := libs["libc.so.6"]
+	_, hasUnwinder := libs[unwinder]
+	if info.Toolchain != "rust" || !hasLibc || hasUnwinder {
+		return g, nil
+	}
+
+	path, ok := r.Cache.LookupArch(unwinder, r.cacheArch(info.Arch))
+	if !ok {
+		r.logf("%s uses glibc but %s, which it dlopens to unwind, was not found",
+			describe, unwinder)
+		return g, nil
+	}
+
+	r.logf("Adding %s, which glibc dlopens to unwind %s", unwinder, describe)
+	sub, err := r.recursiveImports(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	g.addLoaded(filename, unwinder, path, sub)
+	return g, nil
+}
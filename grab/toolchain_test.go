@@ -0,0 +1,146 @@
+package grab
+
+import (
+	"debug/elf"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pwaller/grab-ld-binaries/internal/cachetest"
+	"github.com/pwaller/grab-ld-binaries/internal/elftest"
+)
+
+const interp = "/lib64/ld-linux-x86-64.so.2"
+
+var (
+	goBuildID   = map[string]string{".note.go.buildid": "\x04\x00\x00\x00Go\x00\x00"}
+	rustComment = map[string]string{".comment": "GCC: (Debian 12.2.0-14) 12.2.0\x00rustc version 1.75.0\x00"}
+)
+
+func TestInspectBinary(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		o    elftest.Object
+		want binaryInfo
+	}{
+		{"c", elftest.Object{Interp: interp, Needed: []string{"libc.so.6"}},
+			binaryInfo{Arch: "amd64"}},
+		{"static c", elftest.Object{},
+			binaryInfo{Static: true, Arch: "amd64"}},
+		{"static go", elftest.Object{Sections: goBuildID},
+			binaryInfo{Toolchain: "go", Static: true, Arch: "amd64"}},
+		{"cgo", elftest.Object{Interp: interp, Needed: []string{"libc.so.6"}, Sections: goBuildID},
+			binaryInfo{Toolchain: "go", Arch: "amd64"}},
+		{"go arm64", elftest.Object{Machine: elf.EM_AARCH64, Sections: map[string]string{".go.buildinfo": "\xff Go buildinf:"}},
+			binaryInfo{Toolchain: "go", Static: true, Arch: "arm64"}},
+		{"rust comment", elftest.Object{Interp: interp, Needed: []string{"libc.so.6"}, Sections: rustComment},
+			binaryInfo{Toolchain: "rust", Arch: "amd64"}},
+		{"rust section", elftest.Object{Sections: map[string]string{".rustc": "rust"}},
+			binaryInfo{Toolchain: "rust", Static: true, Arch: "amd64"}},
+		{"gcc comment", elftest.Object{Interp: interp, Sections: map[string]string{".comment": "GCC: (GNU) 13.2.0\x00"}},
+			binaryInfo{Arch: "amd64"}},
+		// An interpreter alone still makes a binary dynamic.
+		{"interp only", elftest.Object{Interp: interp, Sections: goBuildID},
+			binaryInfo{Toolchain: "go", Arch: "amd64"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			if err := test.o.Write(filepath.Join(root, "bin/app")); err != nil {
+				t.Fatal(err)
+			}
+			r := &Resolver{Root: root}
+			info, err := r.inspectBinary("/bin/app")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info != test.want {
+				t.Errorf("got %+v; want %+v", info, test.want)
+			}
+		})
+	}
+}
+
+func TestToolchainImports(t *testing.T) {
+	libs := map[string]elftest.Object{
+		"/lib/libc.so.6":     {Soname: "libc.so.6"},
+		"/lib/libgcc_s.so.1": {Soname: "libgcc_s.so.1", Needed: []string{"libc.so.6"}},
+		"/lib/libssl.so.3":   {Soname: "libssl.so.3"},
+	}
+	// The binaries have no interpreter, which would need to be in the root,
+	// but needing libraries makes them dynamic all the same.
+	for _, test := range []struct {
+		name string
+		o    elftest.Object
+		// noUnwinder leaves libgcc_s.so.1 out of the ld.so.cache.
+		noUnwinder bool
+		want       map[string]string
+	}{
+		{
+			name: "static go",
+			o:    elftest.Object{Sections: goBuildID},
+			want: map[string]string{},
+		},
+		{
+			name: "cgo",
+			o:    elftest.Object{Needed: []string{"libc.so.6"}, Sections: goBuildID},
+			want: map[string]string{"libc.so.6": "/lib/libc.so.6"},
+		},
+		{
+			name: "static rust",
+			o:    elftest.Object{Sections: rustComment},
+			want: map[string]string{},
+		},
+		{
+			name: "rust with glibc",
+			o:    elftest.Object{Needed: []string{"libc.so.6"}, Sections: rustComment},
+			want: map[string]string{"libc.so.6": "/lib/libc.so.6", "libgcc_s.so.1": "/lib/libgcc_s.so.1"},
+		},
+		{
+			name: "rust needing the unwinder",
+			o: elftest.Object{Needed: []string{"libgcc_s.so.1", "libc.so.6"},
+				Sections: rustComment},
+			want: map[string]string{"libc.so.6": "/lib/libc.so.6", "libgcc_s.so.1": "/lib/libgcc_s.so.1"},
+		},
+		{
+			name:       "rust without the unwinder",
+			o:          elftest.Object{Needed: []string{"libc.so.6"}, Sections: rustComment},
+			noUnwinder: true,
+			want:       map[string]string{"libc.so.6": "/lib/libc.so.6"},
+		},
+		{
+			name: "rust without glibc",
+			o:    elftest.Object{Needed: []string{"libssl.so.3"}, Sections: rustComment},
+			want: map[string]string{"libssl.so.3": "/lib/libssl.so.3"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			cache := map[string]string{}
+			for p, o := range libs {
+				if err := o.Write(filepath.Join(root, p)); err != nil {
+					t.Fatal(err)
+				}
+				if o.Soname != "libgcc_s.so.1" || !test.noUnwinder {
+					cache[o.Soname] = p
+				}
+			}
+			if err := test.o.Write(filepath.Join(root, "bin/app")); err != nil {
+				t.Fatal(err)
+			}
+			if err := cachetest.WriteRoot(root, "amd64", cache); err != nil {
+				t.Fatal(err)
+			}
+			r, err := NewRootResolver(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			g, err := r.toolchainImports("/bin/app")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(g.Resolved, test.want) {
+				t.Errorf("resolved %v; want %v", g.Resolved, test.want)
+			}
+		})
+	}
+}
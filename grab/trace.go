@@ -0,0 +1,179 @@
+package grab
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Trace is what a command did while TraceCommand traced it.
+type Trace struct {
+	Exe string
+	// Files are the other regular files the command, or its children,
+	// opened or executed, by absolute path, in order of first use.
+	Files []string
+	// Syscalls are the distinct system calls made, sorted.
+	Syscalls []string
+}
+
+// CheckStrace returns an error if the strace program, which TraceCommand
+// runs, is not installed.
+func CheckStrace() error {
+	if _, err := exec.LookPath("strace"); err != nil {
+		return fmt.Errorf("the strace program is needed: %v", err)
+	}
+	return nil
+}
+
+// TraceCommand runs argv under strace, following its children, with the
+// standard input and error of this process and its standard output going
+// to stdout, and returns what it did. It fails if the command does.
+func TraceCommand(argv []string, stdout io.Writer) (*Trace, error) {
+	exe, err := exec.LookPath(argv[0])
+	if err != nil {
+		return nil, err
+	}
+	if exe, err = filepath.Abs(exe); err != nil {
+		return nil, err
+	}
+
+	out, err := os.CreateTemp("", "grab-trace-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	// -x prints strings with non-ASCII bytes in hex, which strconv.Unquote
+	// reads back, and -s keeps long paths whole.
+	args := append([]string{"-f", "-qq", "-x", "-s", "4096", "-o", out.Name(), "--", exe}, argv[1:]...)
+	cmd := exec.Command("strace", args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %v", argv[0], err)
+	}
+
+	trace, err := ReadStrace(out)
+	if err != nil {
+		return nil, err
+	}
+	trace.Exe = exe
+	// Skip what cannot be archived, and the devices and FIFOs which would
+	// block on reading.
+	files := trace.Files[:0]
+	for _, p := range trace.Files {
+		if fi, err := os.Stat(p); err == nil && fi.Mode().IsRegular() && p != exe {
+			files = append(files, p)
+		}
+	}
+	trace.Files = files
+	return trace, nil
+}
+
+// fileSyscalls are the system calls whose first string argument is a file
+// which is read or executed, if they succeed.
+var fileSyscalls = map[string]bool{
+	"open": true, "openat": true, "openat2": true, "execve": true, "execveat": true,
+	"uselib": true,
+}
+
+// straceCall matches the name of a system call starting an strace line.
+var straceCall = regexp.MustCompile(`^([a-z0-9_]+)\(`)
+
+// ReadStrace reads the output of strace -f, with -x, and returns the
+// system calls made and the absolute paths of the files which were opened
+// or executed successfully. Its lines are such as
+//
+//	1234  openat(AT_FDCWD, "/lib/x86_64-linux-gnu/libc.so.6", O_RDONLY|O_CLOEXEC) = 3
+//
+// where calls interrupted by another process are split into "<unfinished
+// ...>" and "<... openat resumed>" lines. Signals and exits are skipped.
+func ReadStrace(r io.Reader) (*Trace, error) {
+	trace := &Trace{}
+	syscalls := map[string]bool{}
+	seen := map[string]bool{}
+	unfinished := map[string]string{} // By pid
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		var pid string
+		if i := strings.IndexByte(line, ' '); i > 0 {
+			if _, err := strconv.Atoi(line[:i]); err == nil {
+				pid, line = line[:i], strings.TrimSpace(line[i:])
+			}
+		}
+
+		if strings.HasPrefix(line, "<... ") {
+			i := strings.Index(line, " resumed>")
+			if i < 0 {
+				continue
+			}
+			start, ok := unfinished[pid]
+			if !ok {
+				continue
+			}
+			delete(unfinished, pid)
+			line = start + line[i+len(" resumed>"):]
+		}
+		m := straceCall.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		name := m[1]
+		syscalls[name] = true
+		if strings.HasSuffix(line, " <unfinished ...>") {
+			unfinished[pid] = strings.TrimSuffix(line, " <unfinished ...>")
+			continue
+		}
+		if !fileSyscalls[name] {
+			continue
+		}
+
+		// strace pads the result, such as " = 3" or " = -1 ENOENT (No
+		// such file or directory)", into a column.
+		i := strings.LastIndex(line, " = ")
+		if i < 0 {
+			continue
+		}
+		if result := line[i+len(" = "):]; strings.HasPrefix(result, "-") || strings.HasPrefix(result, "?") {
+			continue
+		}
+		q := strings.IndexByte(line, '"')
+		if q < 0 {
+			continue
+		}
+		quoted, err := strconv.QuotedPrefix(line[q:])
+		if err != nil {
+			return nil, fmt.Errorf("strace: %q: %v", line, err)
+		}
+		p, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, fmt.Errorf("strace: %q: %v", line, err)
+		}
+		// Relative paths depend on the working directory at the time.
+		if !filepath.IsAbs(p) {
+			continue
+		}
+		p = filepath.Clean(p)
+		if !seen[p] {
+			seen[p] = true
+			trace.Files = append(trace.Files, p)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for name := range syscalls {
+		trace.Syscalls = append(trace.Syscalls, name)
+	}
+	sort.Strings(trace.Syscalls)
+	return trace, nil
+}
@@ -0,0 +1,70 @@
+package grab
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const straceOutput = `1200  execve("/usr/bin/app", ["app", "-v"], 0x7ffd5a1c3e08 /* 20 vars */) = 0
+1200  brk(NULL)                         = 0x55d4c7a2e000
+1200  openat(AT_FDCWD, "/etc/ld.so.cache", O_RDONLY|O_CLOEXEC) = 3
+1200  openat(AT_FDCWD, "/lib/x86_64-linux-gnu/libc.so.6", O_RDONLY|O_CLOEXEC) = 3
+1200  openat(AT_FDCWD, "/lib/x86_64-linux-gnu/libmissing.so", O_RDONLY|O_CLOEXEC) = -1 ENOENT (No such file or directory)
+1200  clone3({flags=CLONE_VM|CLONE_FS, ...}, 88) = 1201
+1201  openat(AT_FDCWD, "/usr/lib/app/plugins/../plugins/libpng-plugin.so", O_RDONLY|O_CLOEXEC <unfinished ...>
+1200  futex(0x7f1c2e5ff990, FUTEX_WAIT_BITSET|FUTEX_CLOEXEC_PRIVATE, 1201, NULL <unfinished ...>
+1201  <... openat resumed>)             = 4
+1201  openat(AT_FDCWD, "relative.conf", O_RDONLY) = 5
+1201  openat(AT_FDCWD, "/srv/caf\xc3\xa9.txt", O_RDONLY) = 6
+1201  exit(0)                           = ?
+1201  +++ exited with 0 +++
+1200  <... futex resumed>)              = 0
+1200  --- SIGCHLD {si_signo=SIGCHLD, si_code=CLD_EXITED, si_pid=1201} ---
+1200  openat(AT_FDCWD, "/etc/ld.so.cache", O_RDONLY|O_CLOEXEC) = 3
+1200  exit_group(0)                     = ?
+1200  +++ exited with 0 +++
+`
+
+func TestReadStrace(t *testing.T) {
+	trace, err := ReadStrace(strings.NewReader(straceOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFiles := []string{
+		"/usr/bin/app",
+		"/etc/ld.so.cache",
+		"/lib/x86_64-linux-gnu/libc.so.6",
+		"/usr/lib/app/plugins/libpng-plugin.so",
+		"/srv/café.txt",
+	}
+	if !reflect.DeepEqual(trace.Files, wantFiles) {
+		t.Errorf("files %q; want %q", trace.Files, wantFiles)
+	}
+	wantSyscalls := []string{"brk", "clone3", "execve", "exit", "exit_group", "futex", "openat"}
+	if !reflect.DeepEqual(trace.Syscalls, wantSyscalls) {
+		t.Errorf("syscalls %q; want %q", trace.Syscalls, wantSyscalls)
+	}
+}
+
+func TestTraceCommand(t *testing.T) {
+	if err := CheckStrace(); err != nil {
+		t.Skip(err)
+	}
+	trace, err := TraceCommand([]string{"true"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(trace.Exe, "/true") {
+		t.Errorf("traced %s; want true", trace.Exe)
+	}
+	for _, want := range []string{"execve", "exit_group"} {
+		found := false
+		for _, name := range trace.Syscalls {
+			found = found || name == want
+		}
+		if !found {
+			t.Errorf("syscalls %q lack %s", trace.Syscalls, want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package grab
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteTree writes g to w as an indented tree in the style of lddtree: each
+// root, then the libraries each node needs beneath it with the file it
+// resolved to. Unless all is set, a library's own dependencies are only shown
+// the first time it appears.
+func (g *Graph) WriteTree(w io.Writer, all bool) error {
+	bw := bufio.NewWriter(w)
+	expanded := map[string]bool{}
+	onChain := map[string]bool{}
+
+	var visit func(node string, depth int)
+	visit = func(node string, depth int) {
+		indent := strings.Repeat("    ", depth)
+		if depth == 0 {
+			fmt.Fprintf(bw, "%s", node)
+			if len(g.Interps) > 0 {
+				fmt.Fprintf(bw, " (interpreter => %s)", strings.Join(g.Interps, ", "))
+			}
+			fmt.Fprintln(bw)
+		} else {
+			path, ok := g.Resolved[node]
+			switch {
+			case g.Provided[node]:
+				path = "(provided by base)"
+			case !ok:
+				path = "not found"
+			}
+			fmt.Fprintf(bw, "%s%s => %s\n", indent, node, path)
+		}
+
+		if onChain[node] || (expanded[node] && !all) {
+			return
+		}
+		expanded[node] = true
+		onChain[node] = true
+		for _, dep := range g.Edges[node] {
+			visit(dep, depth+1)
+		}
+		onChain[node] = false
+	}
+	for _, root := range g.Roots {
+		visit(root, 0)
+	}
+	return bw.Flush()
+}
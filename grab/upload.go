@@ -0,0 +1,107 @@
+package grab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// IsUploadURL reports whether dest is a destination Upload streams to
+// rather than a file name: an s3://, https:// or http:// URL.
+func IsUploadURL(dest string) bool {
+	for _, scheme := range []string{"s3://", "https://", "http://"} {
+		if strings.HasPrefix(dest, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// errAborted fails an upload which Abort cancelled.
+var errAborted = errors.New("upload aborted")
+
+// Upload streams what is written to it to a URL, without buffering it on
+// disk: s3:// URLs with the aws CLI, which takes its usual credentials, and
+// http(s):// URLs as a chunked PUT. Close completes the upload; Abort
+// cancels it so that nothing incomplete is stored.
+type Upload struct {
+	Dest string
+
+	w      *io.PipeWriter
+	cancel context.CancelFunc
+	done   chan error
+}
+
+// StartUpload starts streaming an upload to dest, one of IsUploadURL.
+func StartUpload(dest string) (*Upload, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	u := &Upload{Dest: dest, w: pw, cancel: cancel, done: make(chan error, 1)}
+
+	if strings.HasPrefix(dest, "s3://") {
+		// Killed by cancel before its input ends, aws leaves the multipart
+		// upload incomplete, so no object is created.
+		cmd := exec.CommandContext(ctx, "aws", "s3", "cp", "--only-show-errors", "-", dest)
+		cmd.Stdin = pr
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Start(); err != nil {
+			cancel()
+			return nil, fmt.Errorf("aws s3 cp: %v", err)
+		}
+		go func() {
+			err := cmd.Wait()
+			pr.CloseWithError(errAborted)
+			if err != nil {
+				err = fmt.Errorf("aws s3 cp - %s: %v", dest, err)
+			}
+			u.done <- err
+		}()
+		return u, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, dest, pr)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// Unknown length: the body is sent chunked, and an aborted one lacks
+	// its final chunk, so servers discard it.
+	req.ContentLength = -1
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		pr.CloseWithError(errAborted)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				err = fmt.Errorf("PUT %s: %s", dest, resp.Status)
+			}
+		}
+		u.done <- err
+	}()
+	return u, nil
+}
+
+// Write streams p to the upload.
+func (u *Upload) Write(p []byte) (int, error) {
+	return u.w.Write(p)
+}
+
+// Close ends the content and waits for the upload to complete.
+func (u *Upload) Close() error {
+	u.w.Close()
+	err := <-u.done
+	u.cancel()
+	return err
+}
+
+// Abort cancels the upload before its content ends.
+func (u *Upload) Abort() {
+	u.cancel()
+	u.w.CloseWithError(errAborted)
+}
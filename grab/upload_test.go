@@ -0,0 +1,47 @@
+package grab
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUpload(t *testing.T) {
+	stored := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil || req.Method != http.MethodPut {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		stored[req.URL.Path] = string(data)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	u, err := StartUpload(srv.URL + "/complete.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Write([]byte("bundle"))
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := stored["/complete.tar"]; got != "bundle" {
+		t.Errorf("stored %q, want bundle", got)
+	}
+
+	u, err = StartUpload(srv.URL + "/aborted.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Write([]byte("bund"))
+	u.Abort()
+	if err := u.Close(); err == nil {
+		t.Errorf("aborted upload completed")
+	}
+	if _, ok := stored["/aborted.tar"]; ok {
+		t.Errorf("aborted upload was stored")
+	}
+}
@@ -0,0 +1,108 @@
+package grab
+
+import (
+	"debug/elf"
+	"fmt"
+	"path/filepath"
+)
+
+// anyVersion marks a symbol defined without a version, which satisfies
+// references to every version, as ld.so matches it.
+const anyVersion = "*"
+
+// VerifySymbols returns a DiagUndefinedSymbol for each undefined dynamic
+// symbol of the objects of g, its roots, interpreters and libraries, which
+// none of them defines with the version required, as would fail at load
+// time or on first call with "symbol lookup error". Weak references, which
+// may stay unresolved, are not checked. Provided libraries are read from
+// the host, standing in for the target's.
+func (g *Graph) VerifySymbols() ([]Diagnostic, error) {
+	type object struct{ name, path string }
+	var objects []object
+	for _, root := range g.Roots {
+		objects = append(objects, object{root, root})
+	}
+	for _, interp := range g.Interps {
+		objects = append(objects, object{interp, interp})
+	}
+	for _, lib := range SortedSet(g.Libraries()) {
+		if path, ok := g.Resolved[lib]; ok {
+			objects = append(objects, object{lib, path})
+		}
+	}
+
+	// defined maps each symbol to the versions defined: "" for the default
+	// version, which unversioned references bind to.
+	defined := map[string]map[string]bool{}
+	undefined := map[string][]elf.Symbol{}
+	seen := map[string]bool{}
+	for _, o := range objects {
+		host := g.host(o.path)
+		if real, err := filepath.EvalSymlinks(host); err == nil {
+			host = real
+		}
+		if seen[host] {
+			continue
+		}
+		seen[host] = true
+
+		f, err := elf.Open(host)
+		if err != nil {
+			return nil, err
+		}
+		syms, err := f.DynamicSymbols()
+		f.Close()
+		if err == elf.ErrNoSymbols {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", o.path, err)
+		}
+		for _, sym := range syms {
+			bind := elf.ST_BIND(sym.Info)
+			switch {
+			case sym.Section == elf.SHN_UNDEF:
+				if bind != elf.STB_WEAK {
+					undefined[o.name] = append(undefined[o.name], sym)
+				}
+				continue
+			case bind == elf.STB_LOCAL, elf.ST_VISIBILITY(sym.Other) == elf.STV_HIDDEN:
+				continue
+			}
+			versions := defined[sym.Name]
+			if versions == nil {
+				versions = map[string]bool{}
+				defined[sym.Name] = versions
+			}
+			switch {
+			case !sym.HasVersion || sym.Version == "":
+				versions[anyVersion] = true
+			case sym.VersionIndex.IsHidden():
+				versions[sym.Version] = true
+			default:
+				versions[sym.Version] = true
+				versions[""] = true
+			}
+		}
+	}
+
+	var diags []Diagnostic
+	for _, o := range objects {
+		reported := map[string]bool{}
+		for _, sym := range undefined[o.name] {
+			versions := defined[sym.Name]
+			if versions[anyVersion] || versions[sym.Version] {
+				continue
+			}
+			name := sym.Name
+			if sym.Version != "" {
+				name += "@" + sym.Version
+			}
+			if !reported[name] {
+				reported[name] = true
+				diags = append(diags, NewDiagnostic(DiagUndefinedSymbol, o.name, name))
+			}
+		}
+	}
+	return diags, nil
+}
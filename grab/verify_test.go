@@ -0,0 +1,63 @@
+package grab
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pwaller/grab-ld-binaries/dlcache"
+)
+
+func TestVerifySymbols(t *testing.T) {
+	g, err := Resolve("/bin/true")
+	if err != nil || g.Libc() != LibcGlibc {
+		t.Skipf("needs a glibc host: %v", err)
+	}
+	diags, err := g.VerifySymbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) != 0 {
+		t.Errorf("host /bin/true has undefined symbols: %v", diags)
+	}
+
+	// With an impostor in place of libc, what /bin/true needs of it is
+	// undefined.
+	root := t.TempDir()
+	for dst, src := range map[string]string{
+		"/bin/true":                   "/bin/true",
+		"/lib64/ld-linux-x86-64.so.2": "/lib64/ld-linux-x86-64.so.2",
+		"/opt/libc.so.6":              "/lib/x86_64-linux-gnu/libz.so.1",
+	} {
+		data, err := ioutil.ReadFile(src)
+		if err != nil {
+			t.Skipf("needs an x86-64 glibc host: %v", err)
+		}
+		path := filepath.Join(root, dst)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, data, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("LD_LIBRARY_PATH", "/opt")
+	r := &Resolver{Root: root, Cache: &dlcache.DLCache{Root: root}}
+	if g, err = r.Resolve("/bin/true"); err != nil {
+		t.Fatal(err)
+	}
+	if diags, err = g.VerifySymbols(); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, d := range diags {
+		if d.ID != DiagUndefinedSymbol {
+			t.Errorf("got %s", d)
+		}
+		found = found || d.Subject == "/bin/true"
+	}
+	if !found {
+		t.Errorf("no %s for /bin/true in %v", DiagUndefinedSymbol, diags)
+	}
+}
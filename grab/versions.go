@@ -0,0 +1,303 @@
+package grab
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// versionNeeds returns the GNU symbol versions f requires of each library it
+// needs. Weak requirements, which ld.so only warns about, are left out.
+func versionNeeds(f *elf.File) map[string][]string {
+	// Objects without symbol versioning report an error here.
+	needs, err := f.DynamicVersionNeeds()
+	if err != nil {
+		return nil
+	}
+	byLib := map[string][]string{}
+	for _, need := range needs {
+		for _, dep := range need.Needs {
+			if dep.Flags&elf.VER_FLG_WEAK == 0 {
+				byLib[need.Name] = append(byLib[need.Name], dep.Dep)
+			}
+		}
+	}
+	return byLib
+}
+
+// versionDefs returns the GNU symbol versions defined by the ELF file at
+// path, within r.Root.
+func (r *Resolver) versionDefs(path string) (map[string]bool, error) {
+	f, err := r.openELF(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	defs := map[string]bool{}
+	versions, err := f.DynamicVersions()
+	if err != nil {
+		// Unversioned libraries define no versions.
+		return defs, nil
+	}
+	for _, version := range versions {
+		if version.Flags&elf.VER_FLG_BASE == 0 {
+			defs[version.Name] = true
+		}
+	}
+	return defs, nil
+}
+
+// checkVersions compares the GNU symbol versions which the loaded objects
+// require of each library in g with those the chosen file defines. Where it
+// lacks some, as when an older copy of a library is found first, the other
+// candidates search could have found are checked too, and a
+// DiagVersionConflict names the one which satisfies every dependent, if any.
+// searchedBy holds the object whose search found each library.
+func (r *Resolver) checkVersions(g *Graph, loaded []*object, searchedBy map[string]*object) {
+	for _, lib := range SortedSet(g.Libraries()) {
+		chosen, ok := g.Resolved[lib]
+		if !ok {
+			continue
+		}
+
+		// required maps each version to the objects needing it.
+		required := map[string][]string{}
+		for _, obj := range loaded {
+			for _, version := range obj.versionNeeds[lib] {
+				required[version] = append(required[version], obj.path)
+			}
+		}
+		if len(required) == 0 {
+			continue
+		}
+
+		lacking := func(path string) []string {
+			defs, err := r.versionDefs(path)
+			if errors.Is(err, fs.ErrPermission) {
+				// An unreadable stand-in from package metadata.
+				return nil
+			}
+			var missing []string
+			for version := range required {
+				if err != nil || !defs[version] {
+					missing = append(missing, version)
+				}
+			}
+			sort.Strings(missing)
+			return missing
+		}
+
+		missing := lacking(chosen)
+		if len(missing) == 0 {
+			continue
+		}
+		var lacks []string
+		for _, version := range missing {
+			lacks = append(lacks, fmt.Sprintf("%s (needed by %s)",
+				version, strings.Join(required[version], ", ")))
+		}
+
+		advice := "no other candidate satisfies every dependent"
+		seen := map[string]bool{r.realPath(chosen): true}
+		for _, c := range r.searchPaths(r.rename(lib), searchedBy[lib]) {
+			real := r.realPath(c.path)
+			if seen[real] || !r.sameArch(c.path, searchedBy[lib]) {
+				continue
+			}
+			seen[real] = true
+			if len(lacking(c.path)) == 0 {
+				advice = c.path + " satisfies every dependent"
+				break
+			}
+		}
+		r.diagnose(NewDiagnostic(DiagVersionConflict, lib,
+			chosen, strings.Join(lacks, ", "), advice))
+	}
+}
+
+// sameArch is compatible without the diagnostics, for paths which are only
+// being considered rather than searched.
+func (r *Resolver) sameArch(path string, obj *object) bool {
+	f, err := r.openELF(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	return f.Class == obj.class && f.Machine == obj.machine
+}
+
+// CheckGlibc returns a DiagGlibcTooNew for each object g archives (its roots
+// and the libraries not provided by the target) which requires GLIBC_ symbol
+// versions newer than target, a glibc version such as "2.28", as binaries
+// built on a newer distribution than the fleet runs do. glibc's own
+// libraries, which define GLIBC_ versions, are skipped: they only require
+// versions of each other.
+func (g *Graph) CheckGlibc(target string) ([]Diagnostic, error) {
+	max, ok := parseGlibcVersion("GLIBC_" + target)
+	if !ok {
+		return nil, fmt.Errorf("bad glibc version %q, want one like 2.28", target)
+	}
+
+	var diags []Diagnostic
+	check := func(name, path string) error {
+		f, err := elf.Open(g.host(path))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		defs, _ := f.DynamicVersions()
+		for _, def := range defs {
+			if _, ok := parseGlibcVersion(def.Name); ok {
+				return nil
+			}
+		}
+		var tooNew []string
+		seen := map[string]bool{}
+		for _, versions := range versionNeeds(f) {
+			for _, version := range versions {
+				v, ok := parseGlibcVersion(version)
+				if ok && compareVersions(v, max) > 0 && !seen[version] {
+					seen[version] = true
+					tooNew = append(tooNew, version)
+				}
+			}
+		}
+		if len(tooNew) > 0 {
+			sort.Slice(tooNew, func(i, j int) bool {
+				a, _ := parseGlibcVersion(tooNew[i])
+				b, _ := parseGlibcVersion(tooNew[j])
+				return compareVersions(a, b) < 0
+			})
+			diags = append(diags, NewDiagnostic(DiagGlibcTooNew, name,
+				strings.Join(tooNew, ", "), target))
+		}
+		return nil
+	}
+
+	for _, root := range g.Roots {
+		if err := check(root, root); err != nil {
+			return nil, err
+		}
+	}
+	for _, lib := range SortedSet(g.Libraries()) {
+		if path, ok := g.Resolved[lib]; ok && !g.Provided[lib] {
+			if err := check(lib, path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return diags, nil
+}
+
+// RequiredVersions returns, for each library of g, the highest symbol
+// version of each family, such as GLIBC_2.34 or GLIBCXX_3.4.29, which its
+// roots and resolved libraries require of it, in family order: the oldest
+// release of the library they can run with. Unnumbered versions, such as
+// GLIBC_PRIVATE, are left out.
+func (g *Graph) RequiredVersions() (map[string][]string, error) {
+	paths := append([]string{}, g.Roots...)
+	for _, lib := range SortedSet(g.Libraries()) {
+		if path, ok := g.Resolved[lib]; ok {
+			paths = append(paths, path)
+		}
+	}
+
+	// highest maps each library and family to the highest version needed.
+	highest := map[string]map[string]string{}
+	for _, path := range paths {
+		f, err := elf.Open(g.host(path))
+		if errors.Is(err, fs.ErrPermission) {
+			// An unreadable stand-in from package metadata.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		needs := versionNeeds(f)
+		f.Close()
+		for lib, versions := range needs {
+			for _, version := range versions {
+				family, v, ok := parseSymbolVersion(version)
+				if !ok {
+					continue
+				}
+				if highest[lib] == nil {
+					highest[lib] = map[string]string{}
+				}
+				if prev, ok := highest[lib][family]; ok {
+					_, p, _ := parseSymbolVersion(prev)
+					if compareVersions(v, p) <= 0 {
+						continue
+					}
+				}
+				highest[lib][family] = version
+			}
+		}
+	}
+
+	required := map[string][]string{}
+	for lib, families := range highest {
+		var names []string
+		for family := range families {
+			names = append(names, family)
+		}
+		sort.Strings(names)
+		for _, family := range names {
+			required[lib] = append(required[lib], families[family])
+		}
+	}
+	return required, nil
+}
+
+// parseSymbolVersion splits a symbol version such as GLIBCXX_3.4.29 into
+// its family and numbers, and reports whether it is one. GLIBC_PRIVATE is
+// not.
+func parseSymbolVersion(version string) (string, []int, bool) {
+	i := strings.LastIndexByte(version, '_')
+	if i <= 0 {
+		return "", nil, false
+	}
+	var v []int
+	for _, field := range strings.Split(version[i+1:], ".") {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return "", nil, false
+		}
+		v = append(v, n)
+	}
+	return version[:i], v, true
+}
+
+// parseGlibcVersion returns the numbers of a symbol version such as
+// GLIBC_2.2.5, and whether it is one. GLIBC_PRIVATE is not.
+func parseGlibcVersion(version string) ([]int, bool) {
+	family, v, ok := parseSymbolVersion(version)
+	return v, ok && family == "GLIBC"
+}
+
+// compareVersions compares version numbers a and b, returning -1, 0 or 1.
+// Missing trailing numbers count as zero.
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
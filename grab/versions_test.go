@@ -0,0 +1,137 @@
+package grab
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pwaller/grab-ld-binaries/dlcache"
+)
+
+func TestCheckVersionsConflict(t *testing.T) {
+	const libDir = "/lib/x86_64-linux-gnu"
+	root := t.TempDir()
+	for dst, src := range map[string]string{
+		"/bin/true":                   "/bin/true",
+		libDir + "/libc.so.6":         libDir + "/libc.so.6",
+		"/lib64/ld-linux-x86-64.so.2": "/lib64/ld-linux-x86-64.so.2",
+		// An unversioned impostor, found first through $LD_LIBRARY_PATH.
+		"/opt/libc.so.6": libDir + "/libz.so.1",
+	} {
+		data, err := ioutil.ReadFile(src)
+		if err != nil {
+			t.Skipf("needs an x86-64 glibc host: %v", err)
+		}
+		path := filepath.Join(root, dst)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, data, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("LD_LIBRARY_PATH", "/opt")
+
+	r := &Resolver{Root: root, Cache: &dlcache.DLCache{Root: root}}
+	g, err := r.Resolve("/bin/true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := g.Resolved["libc.so.6"]; got != "/opt/libc.so.6" {
+		t.Fatalf("libc.so.6 resolved to %s, want the impostor", got)
+	}
+
+	var conflict *Diagnostic
+	for i, d := range r.Diagnostics {
+		if d.ID == DiagVersionConflict {
+			conflict = &r.Diagnostics[i]
+		}
+	}
+	if conflict == nil {
+		t.Fatalf("no %s in %v", DiagVersionConflict, r.Diagnostics)
+	}
+	if !strings.Contains(conflict.Message, "GLIBC_2.") ||
+		!strings.HasSuffix(conflict.Message, libDir+"/libc.so.6 satisfies every dependent") {
+		t.Errorf("got %s", conflict)
+	}
+}
+
+func TestCheckGlibc(t *testing.T) {
+	g, err := Resolve("/bin/true")
+	if err != nil {
+		t.Skipf("needs a glibc host: %v", err)
+	}
+	if g.Libc() != LibcGlibc {
+		t.Skip("needs a glibc host")
+	}
+
+	diags, err := g.CheckGlibc("2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// glibc's own libraries only need versions of each other.
+	if len(diags) != 1 || diags[0].ID != DiagGlibcTooNew || diags[0].Subject != "/bin/true" {
+		t.Errorf("CheckGlibc(2.0) = %v; want one %s for /bin/true", diags, DiagGlibcTooNew)
+	}
+	if diags, err := g.CheckGlibc("99.0"); err != nil || len(diags) != 0 {
+		t.Errorf("CheckGlibc(99.0) = %v, %v; want none", diags, err)
+	}
+	if _, err := g.CheckGlibc("2.x"); err == nil {
+		t.Errorf("CheckGlibc(2.x) succeeded")
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want int
+	}{
+		{"GLIBC_2.2.5", "GLIBC_2.28", -1},
+		{"GLIBC_2.34", "GLIBC_2.28", 1},
+		{"GLIBC_2.28", "GLIBC_2.28.0", 0},
+	} {
+		a, _ := parseGlibcVersion(c.a)
+		b, _ := parseGlibcVersion(c.b)
+		if got := compareVersions(a, b); got != c.want {
+			t.Errorf("compareVersions(%s, %s) = %d; want %d", c.a, c.b, got, c.want)
+		}
+	}
+	if _, ok := parseGlibcVersion("GLIBC_PRIVATE"); ok {
+		t.Errorf("GLIBC_PRIVATE parsed as a version")
+	}
+}
+
+func TestRequiredVersions(t *testing.T) {
+	g, err := Resolve("/bin/true")
+	if err != nil || g.Libc() != LibcGlibc {
+		t.Skipf("needs a glibc host: %v", err)
+	}
+	required, err := g.RequiredVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := required["libc.so.6"]
+	if len(versions) != 1 || !strings.HasPrefix(versions[0], "GLIBC_2.") {
+		t.Errorf("libc.so.6 needs %v; want one GLIBC_2.x", versions)
+	}
+}
+
+func TestParseSymbolVersion(t *testing.T) {
+	for _, c := range []struct {
+		version, family string
+		ok              bool
+	}{
+		{"GLIBC_2.2.5", "GLIBC", true},
+		{"GLIBCXX_3.4.29", "GLIBCXX", true},
+		{"CXXABI_TM_1", "CXXABI_TM", true},
+		{"GLIBC_PRIVATE", "", false},
+		{"libfoo.so.1", "", false},
+	} {
+		family, _, ok := parseSymbolVersion(c.version)
+		if family != c.family || ok != c.ok {
+			t.Errorf("parseSymbolVersion(%s) = %q, %v; want %q, %v", c.version, family, ok, c.family, c.ok)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package grab
+
+import (
+	"archive/tar"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// paxXattrPrefix prefixes the PAX records holding extended attributes, as
+// GNU tar and bsdtar write them.
+const paxXattrPrefix = "SCHILY.xattr."
+
+// selinuxXattr holds a file's SELinux context. It is only archived if asked
+// for, since labels belong to the host's policy, and targets usually
+// relabel files anyway.
+const selinuxXattr = "security.selinux"
+
+// xattrs returns the extended attributes of the file at path, such as the
+// file capabilities in security.capability, by name, with its SELinux
+// context only if selinux is set. Filesystems without them yield none.
+func xattrs(path string, selinux bool) (map[string]string, error) {
+	size, err := unix.Listxattr(path, nil)
+	if err == unix.ENOTSUP || size == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	size, err = unix.Listxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := map[string]string{}
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		if name == "" || (name == selinuxXattr && !selinux) {
+			continue
+		}
+		n, err := unix.Getxattr(path, name, nil)
+		if err == unix.ENODATA {
+			// Removed since it was listed.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, n)
+		if n, err = unix.Getxattr(path, name, value); err != nil {
+			return nil, err
+		}
+		attrs[name] = string(value[:n])
+	}
+	return attrs, nil
+}
+
+// xattrRecords returns the extended attributes in hdr's PAX records, or nil
+// if it has none.
+func xattrRecords(hdr *tar.Header) map[string]string {
+	var attrs map[string]string
+	for key, value := range hdr.PAXRecords {
+		if name := strings.TrimPrefix(key, paxXattrPrefix); name != key {
+			if attrs == nil {
+				attrs = map[string]string{}
+			}
+			attrs[name] = value
+		}
+	}
+	return attrs
+}
+
+// setXattrs sets the extended attributes attrs, by name, on the file at path.
+func setXattrs(path string, attrs map[string]string) error {
+	for name, value := range attrs {
+		if err := unix.Setxattr(path, name, []byte(value), 0); err != nil {
+			return fmt.Errorf("xattr %s: %v", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package grab
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+)
+
+// WriteZip writes files to w as a zip archive, as AWS Lambda and other
+// deployment tools take, and returns the total bytes read from disk. Modes,
+// including executable bits, are kept in the entries' Unix external
+// attributes and symlinks are stored as such; FIFOs and device nodes cannot
+// be. Of opts, which may be nil, Progress, Reproducible, ModTime and Dirs
+// apply.
+func WriteZip(w io.Writer, files []File, opts *TarOptions) (int64, error) {
+	if opts == nil {
+		opts = &TarOptions{}
+	}
+	files = opts.ordered(files)
+
+	go Prefetch(files, opts.Prefetch)
+
+	zw := zip.NewWriter(w)
+
+	var total int64
+	for _, file := range files {
+		if file.Special {
+			return total, fmt.Errorf("%s: zip cannot hold special files", file.Path)
+		}
+		fi, err := file.stat()
+		if err != nil {
+			return total, err
+		}
+		hdr, err := zip.FileInfoHeader(fi)
+		if err != nil {
+			return total, err
+		}
+		hdr.Name = file.Name
+		hdr.Method = zip.Deflate
+		if file.Dir {
+			hdr.Method = zip.Store
+		}
+		if opts.Reproducible {
+			hdr.Modified = opts.modTime().UTC()
+		}
+
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return total, err
+		}
+		var n int64
+		switch {
+		case file.Link != "":
+			_, err = io.WriteString(fw, file.Link)
+		case !file.Dir:
+			n, err = copyFile(fw, file.Path)
+			total += n
+		}
+		if err != nil {
+			return total, err
+		}
+		if opts.Progress != nil && !file.Dir {
+			opts.Progress(file, n)
+		}
+	}
+	return total, zw.Close()
+}
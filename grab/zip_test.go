@@ -0,0 +1,72 @@
+package grab
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteZip(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "app")
+	if err := ioutil.WriteFile(bin, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	lib := filepath.Join(dir, "libfoo.so.1.2")
+	if err := ioutil.WriteFile(lib, []byte("lib"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "libfoo.so.1")
+	if err := os.Symlink("libfoo.so.1.2", link); err != nil {
+		t.Fatal(err)
+	}
+	files := []File{
+		{Path: bin, Name: "app"},
+		{Path: link, Name: "libfoo.so.1", Link: "libfoo.so.1.2"},
+		{Path: lib, Name: "libfoo.so.1.2"},
+	}
+
+	var buf bytes.Buffer
+	if _, err := WriteZip(&buf, files, nil); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		name     string
+		mode     os.FileMode
+		contents string
+	}{
+		{"app", 0755, "#!/bin/sh\n"},
+		{"libfoo.so.1", os.ModeSymlink | 0777, "libfoo.so.1.2"},
+		{"libfoo.so.1.2", 0644, "lib"},
+	}
+	if len(zr.File) != len(want) {
+		t.Fatalf("%d entries; want %d", len(zr.File), len(want))
+	}
+	for i, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := want[i]
+		if f.Name != w.name || f.Mode() != w.mode || string(data) != w.contents {
+			t.Errorf("entry %d: %s mode %v %q; want %s mode %v %q",
+				i, f.Name, f.Mode(), data, w.name, w.mode, w.contents)
+		}
+	}
+
+	if _, err := WriteZip(ioutil.Discard, []File{{Path: bin, Name: "fifo", Special: true}}, nil); err == nil {
+		t.Errorf("WriteZip archived a special file")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/pwaller/grab-ld-binaries/dlcache"
+	"github.com/pwaller/grab-ld-binaries/grab"
+)
+
+// image implements `grab-ld-binaries image [-arch GOARCH] [-json]
+// image.tar [binary...]`, auditing a `docker save` or OCI image tarball:
+// it resolves the given binaries, or every ELF binary in the image, within
+// the image's root filesystem, and prints the libraries each needs which
+// the image's layers do not provide. It exits 1 if any are missing or a
+// binary cannot be resolved.
+func image(args []string) {
+	flags := flag.NewFlagSet("image", flag.ExitOnError)
+	imageArch := flags.String("arch", runtime.GOARCH, "audit the image for this architecture, of a multi-platform image")
+	asJSON := flags.Bool("json", false, "print the missing libraries of each binary as JSON")
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		log.Fatal("usage: grab-binaries image [-arch GOARCH] [-json] <image.tar> [binary...]")
+	}
+
+	missing, err := auditImage(flags.Arg(0), *imageArch, flags.Args()[1:], *asJSON)
+	if err != nil {
+		log.Fatalf("image: %v", err)
+	}
+	if missing {
+		os.Exit(1)
+	}
+}
+
+// auditImage audits binaries, or every binary, of the image tarball
+// filename for arch, printing what each lacks, and reports whether any
+// lack libraries or could not be resolved.
+func auditImage(filename, arch string, binaries []string, asJSON bool) (bool, error) {
+	in := os.Stdin
+	if filename != "-" {
+		fd, err := os.Open(filename)
+		if err != nil {
+			return false, err
+		}
+		defer fd.Close()
+		in = fd
+	}
+	ifs, err := grab.ReadImage(in, arch)
+	if err != nil {
+		return false, err
+	}
+	defer ifs.Close()
+
+	r, err := imageResolver(ifs)
+	if err != nil {
+		return false, err
+	}
+	if len(binaries) == 0 {
+		if binaries, err = r.FindBinaries("/"); err != nil {
+			return false, err
+		}
+	}
+
+	missing := map[string][]string{}
+	all := map[string]struct{}{}
+	failed := 0
+	for _, binary := range binaries {
+		g, err := r.Resolve(binary)
+		if err != nil {
+			// One unresolvable binary, such as one whose interpreter the
+			// image lacks, should not stop the audit of the rest.
+			log.Printf("image: %s: %v", binary, err)
+			failed++
+			continue
+		}
+		if libs := g.Missing(); len(libs) > 0 {
+			missing[binary] = libs
+			for _, lib := range libs {
+				all[lib] = struct{}{}
+			}
+		}
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(missing); err != nil {
+			return false, err
+		}
+	} else {
+		for _, binary := range binaries {
+			if libs, ok := missing[binary]; ok {
+				fmt.Printf("%s: %s\n", binary, strings.Join(libs, " "))
+			}
+		}
+	}
+	if len(all) > 0 {
+		log.Printf("image: %d binaries need %s, which the image does not provide",
+			len(missing), strings.Join(grab.SortedSet(all), ", "))
+	}
+	return len(all) > 0 || failed > 0, nil
+}
+
+// imageResolver returns a quiet Resolver for the image root filesystem
+// ifs. Images often have no ld.so.cache, such as those built on musl or
+// distroless, and then only ld.so's default directories are searched.
+func imageResolver(ifs *grab.ImageFS) (*grab.Resolver, error) {
+	r, err := grab.NewFSResolver(ifs)
+	if err != nil {
+		if _, statErr := ifs.Stat("etc/ld.so.cache"); !errors.Is(statErr, fs.ErrNotExist) {
+			return nil, err
+		}
+		r = &grab.Resolver{
+			FS:     ifs,
+			Cache:  &dlcache.DLCache{FS: ifs},
+			Ignore: grab.DefaultIgnore,
+		}
+	}
+	r.Logf = func(string, ...interface{}) {}
+	return r, nil
+}
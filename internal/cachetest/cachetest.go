@@ -0,0 +1,48 @@
+// Package cachetest builds ld.so.cache files for tests.
+package cachetest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pwaller/grab-ld-binaries/dlcache"
+)
+
+// Build returns an ld.so.cache recording libs, which maps sonames to the
+// paths of the libraries, as built for arch, one of dlcache.Arches.
+func Build(arch string, libs map[string]string) ([]byte, error) {
+	abi, ok := dlcache.Arches[arch]
+	if !ok {
+		return nil, fmt.Errorf("unknown architecture %q", arch)
+	}
+	var entries []dlcache.Entry
+	for soname, path := range libs {
+		entries = append(entries, dlcache.Entry{
+			Flags: dlcache.FlagELFLibc6 | abi,
+			Key:   soname,
+			Value: path,
+		})
+	}
+	var buf bytes.Buffer
+	if err := dlcache.Write(&buf, entries); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteRoot writes the ld.so.cache Build makes to etc/ld.so.cache in the
+// filesystem tree at root, where dlcache.LoadRoot reads it.
+func WriteRoot(root, arch string, libs map[string]string) error {
+	data, err := Build(arch, libs)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(root, "etc", "ld.so.cache")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,33 @@
+package cachetest
+
+import (
+	"testing"
+
+	"github.com/pwaller/grab-ld-binaries/dlcache"
+)
+
+func TestWriteRoot(t *testing.T) {
+	root := t.TempDir()
+	libs := map[string]string{
+		"libfoo.so.1": "/opt/lib/libfoo.so.1",
+		"libc.so.6":   "/lib/x86_64-linux-gnu/libc.so.6",
+	}
+	if err := WriteRoot(root, "amd64", libs); err != nil {
+		t.Fatal(err)
+	}
+	dc, err := dlcache.LoadRoot(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for soname, want := range libs {
+		if got, ok := dc.LookupCacheArch(soname, "amd64"); !ok || got != want {
+			t.Errorf("LookupCacheArch(%s) = %s, %v; want %s", soname, got, ok, want)
+		}
+	}
+	if got, ok := dc.LookupCacheArch("libfoo.so.1", "arm64"); ok {
+		t.Errorf("LookupCacheArch(libfoo.so.1, arm64) = %s; want none", got)
+	}
+	if _, err := Build("vax", libs); err == nil {
+		t.Errorf("Build(vax) succeeded")
+	}
+}
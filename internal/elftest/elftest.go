@@ -0,0 +1,268 @@
+// Package elftest builds minimal ELF shared objects and executables for
+// tests, so that they need not depend on the host's binaries.
+package elftest
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Object describes an ELF object to build. Its file holds only what the
+// dynamic loader reads to find dependencies: the program headers, the
+// program interpreter and a dynamic section with its string table, with
+// section headers so that debug/elf can read it too.
+type Object struct {
+	// Class defaults to ELFCLASS64, Data to ELFDATA2LSB and Machine to
+	// EM_X86_64.
+	Class   elf.Class
+	Data    elf.Data
+	Machine elf.Machine
+	// Interp is the PT_INTERP of an executable, if any.
+	Interp string
+	Soname string
+	Needed []string
+	// RPath and RunPath are colon-separated lists of directories, which may
+	// use $ORIGIN.
+	RPath, RunPath string
+	// Sections are further sections, by name, and their contents, such as
+	// the .comment or .note.go.buildid which toolchains leave.
+	Sections map[string]string
+}
+
+// Bytes returns the contents of the object's file.
+func (o Object) Bytes() []byte {
+	b := &builder{is64: o.Class != elf.ELFCLASS32, order: binary.LittleEndian}
+	class, data, machine := o.Class, o.Data, o.Machine
+	if class == elf.ELFCLASSNONE {
+		class = elf.ELFCLASS64
+	}
+	if data == elf.ELFDATANONE {
+		data = elf.ELFDATA2LSB
+	}
+	if data == elf.ELFDATA2MSB {
+		b.order = binary.BigEndian
+	}
+	if machine == elf.EM_NONE {
+		machine = elf.EM_X86_64
+	}
+
+	ehsize, phentsize, shentsize := 52, 32, 40
+	dynentsize := 8
+	if b.is64 {
+		ehsize, phentsize, shentsize = 64, 56, 64
+		dynentsize = 16
+	}
+
+	// The string table is shared by the dynamic section and the section
+	// names.
+	strtab := []byte{0}
+	str := func(s string) uint64 {
+		offset := uint64(len(strtab))
+		strtab = append(strtab, s...)
+		strtab = append(strtab, 0)
+		return offset
+	}
+	type dyn struct {
+		tag elf.DynTag
+		val uint64
+	}
+	var dynamic []dyn
+	for _, lib := range o.Needed {
+		dynamic = append(dynamic, dyn{elf.DT_NEEDED, str(lib)})
+	}
+	for _, d := range []struct {
+		tag   elf.DynTag
+		value string
+	}{{elf.DT_SONAME, o.Soname}, {elf.DT_RPATH, o.RPath}, {elf.DT_RUNPATH, o.RunPath}} {
+		if d.value != "" {
+			dynamic = append(dynamic, dyn{d.tag, str(d.value)})
+		}
+	}
+	nameInterp, nameStrtab, nameDynamic := str(".interp"), str(".dynstr"), str(".dynamic")
+	var extra []string
+	for name := range o.Sections {
+		extra = append(extra, name)
+	}
+	sort.Strings(extra)
+	extraNames := make([]uint64, len(extra))
+	for i, name := range extra {
+		extraNames[i] = str(name)
+	}
+
+	// Layout: ELF header, program headers, interpreter, string table,
+	// dynamic section, further sections, section headers. Addresses equal
+	// file offsets.
+	phnum := 2
+	if o.Interp != "" {
+		phnum++
+	}
+	interpOff := uint64(ehsize + phnum*phentsize)
+	interpSize := uint64(0)
+	if o.Interp != "" {
+		interpSize = uint64(len(o.Interp) + 1)
+	}
+	strtabOff := interpOff + interpSize
+	dynamicOff := align(strtabOff+uint64(len(strtab)), 8)
+	dynamic = append(dynamic,
+		dyn{elf.DT_STRTAB, strtabOff},
+		dyn{elf.DT_STRSZ, uint64(len(strtab))},
+		dyn{elf.DT_NULL, 0})
+	dynamicSize := uint64(len(dynamic) * dynentsize)
+	extraOff := dynamicOff + dynamicSize
+	end := extraOff
+	for _, name := range extra {
+		end += uint64(len(o.Sections[name]))
+	}
+	shoff := align(end, 8)
+	shnum := 3 + len(extra)
+	if o.Interp != "" {
+		shnum++
+	}
+	size := shoff + uint64(shnum*shentsize)
+
+	typ := elf.ET_DYN
+	b.bytes(elf.ELFMAG)
+	b.bytes(string([]byte{byte(class), byte(data), byte(elf.EV_CURRENT), byte(elf.ELFOSABI_NONE)}))
+	b.pad(elf.EI_NIDENT)
+	b.u16(uint16(typ))
+	b.u16(uint16(machine))
+	b.u32(uint32(elf.EV_CURRENT))
+	b.word(0) // Entry
+	b.word(uint64(ehsize))
+	b.word(shoff)
+	b.u32(0) // Flags
+	b.u16(uint16(ehsize))
+	b.u16(uint16(phentsize))
+	b.u16(uint16(phnum))
+	b.u16(uint16(shentsize))
+	b.u16(uint16(shnum))
+	b.u16(uint16(shnum - 1)) // The string table comes last.
+
+	b.prog(elf.PT_LOAD, elf.PF_R, 0, size, 0x1000)
+	if o.Interp != "" {
+		b.prog(elf.PT_INTERP, elf.PF_R, interpOff, interpSize, 1)
+	}
+	b.prog(elf.PT_DYNAMIC, elf.PF_R|elf.PF_W, dynamicOff, dynamicSize, 8)
+
+	if o.Interp != "" {
+		b.bytes(o.Interp + "\x00")
+	}
+	b.bytes(string(strtab))
+	b.pad(int(dynamicOff))
+	for _, d := range dynamic {
+		if b.is64 {
+			b.u64(uint64(d.tag))
+			b.u64(d.val)
+		} else {
+			b.u32(uint32(d.tag))
+			b.u32(uint32(d.val))
+		}
+	}
+	for _, name := range extra {
+		b.bytes(o.Sections[name])
+	}
+	b.pad(int(shoff))
+
+	b.section(0, elf.SHT_NULL, 0, 0, 0, 0, 0, 0)
+	if o.Interp != "" {
+		b.section(nameInterp, elf.SHT_PROGBITS, elf.SHF_ALLOC, interpOff, interpSize, 0, 1, 0)
+	}
+	b.section(nameDynamic, elf.SHT_DYNAMIC, elf.SHF_ALLOC|elf.SHF_WRITE,
+		dynamicOff, dynamicSize, uint32(shnum-1), 8, uint64(dynentsize))
+	off := extraOff
+	for i, name := range extra {
+		n := uint64(len(o.Sections[name]))
+		b.section(extraNames[i], elf.SHT_PROGBITS, 0, off, n, 0, 1, 0)
+		off += n
+	}
+	b.section(nameStrtab, elf.SHT_STRTAB, elf.SHF_ALLOC, strtabOff, uint64(len(strtab)), 0, 1, 0)
+	return b.buf
+}
+
+// Write writes the object to path, creating its directory if need be.
+func (o Object) Write(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, o.Bytes(), 0755)
+}
+
+func align(n, to uint64) uint64 {
+	return (n + to - 1) / to * to
+}
+
+// builder encodes an ELF file of one class and byte order.
+type builder struct {
+	buf   []byte
+	is64  bool
+	order binary.AppendByteOrder
+}
+
+func (b *builder) bytes(s string) { b.buf = append(b.buf, s...) }
+func (b *builder) u16(v uint16)   { b.buf = b.order.AppendUint16(b.buf, v) }
+func (b *builder) u32(v uint32)   { b.buf = b.order.AppendUint32(b.buf, v) }
+func (b *builder) u64(v uint64)   { b.buf = b.order.AppendUint64(b.buf, v) }
+
+// pad appends zeros up to offset n.
+func (b *builder) pad(n int) {
+	for len(b.buf) < n {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+// word appends an address or offset, which are as wide as the class.
+func (b *builder) word(v uint64) {
+	if b.is64 {
+		b.u64(v)
+	} else {
+		b.u32(uint32(v))
+	}
+}
+
+// prog appends a program header for a segment loaded at its file offset.
+func (b *builder) prog(typ elf.ProgType, flags elf.ProgFlag, off, size, align uint64) {
+	if b.is64 {
+		b.u32(uint32(typ))
+		b.u32(uint32(flags))
+		b.u64(off)
+		b.u64(off) // Vaddr
+		b.u64(off) // Paddr
+		b.u64(size)
+		b.u64(size) // Memsz
+		b.u64(align)
+		return
+	}
+	b.u32(uint32(typ))
+	b.u32(uint32(off))
+	b.u32(uint32(off))
+	b.u32(uint32(off))
+	b.u32(uint32(size))
+	b.u32(uint32(size))
+	b.u32(uint32(flags))
+	b.u32(uint32(align))
+}
+
+// section appends a section header for a section at its file offset.
+func (b *builder) section(
+	name uint64, typ elf.SectionType, flags elf.SectionFlag, off, size uint64,
+	link uint32, align, entsize uint64,
+) {
+	b.u32(uint32(name))
+	b.u32(uint32(typ))
+	b.word(uint64(flags))
+	if flags&elf.SHF_ALLOC != 0 {
+		b.word(off) // Addr
+	} else {
+		b.word(0)
+	}
+	b.word(off)
+	b.word(size)
+	b.u32(link)
+	b.u32(0) // Info
+	b.word(align)
+	b.word(entsize)
+}
@@ -0,0 +1,60 @@
+package elftest
+
+import (
+	"bytes"
+	"debug/elf"
+	"reflect"
+	"testing"
+)
+
+func TestBytes(t *testing.T) {
+	for _, o := range []Object{
+		{
+			Interp:  "/lib64/ld-linux-x86-64.so.2",
+			Soname:  "libfoo.so.1",
+			Needed:  []string{"libbar.so.2", "libc.so.6"},
+			RunPath: "$ORIGIN/../lib",
+		},
+		{
+			Class:   elf.ELFCLASS32,
+			Data:    elf.ELFDATA2MSB,
+			Machine: elf.EM_MIPS,
+			Needed:  []string{"libc.so.6"},
+			RPath:   "/opt/lib",
+		},
+	} {
+		f, err := elf.NewFile(bytes.NewReader(o.Bytes()))
+		if err != nil {
+			t.Fatalf("%+v: %v", o, err)
+		}
+		if o.Class != elf.ELFCLASSNONE && (f.Class != o.Class || f.Data != o.Data || f.Machine != o.Machine) {
+			t.Errorf("got %v %v %v; want %v %v %v", f.Class, f.Data, f.Machine, o.Class, o.Data, o.Machine)
+		}
+		needed, err := f.ImportedLibraries()
+		if err != nil || !reflect.DeepEqual(needed, o.Needed) {
+			t.Errorf("ImportedLibraries() = %q, %v; want %q", needed, err, o.Needed)
+		}
+		for tag, want := range map[elf.DynTag]string{
+			elf.DT_SONAME:  o.Soname,
+			elf.DT_RPATH:   o.RPath,
+			elf.DT_RUNPATH: o.RunPath,
+		} {
+			got, err := f.DynString(tag)
+			if err != nil || (want == "" && len(got) != 0) || (want != "" && !reflect.DeepEqual(got, []string{want})) {
+				t.Errorf("DynString(%v) = %q, %v; want %q", tag, got, err, want)
+			}
+		}
+
+		var interp string
+		for _, prog := range f.Progs {
+			if prog.Type == elf.PT_INTERP {
+				data := make([]byte, prog.Filesz)
+				prog.ReadAt(data, 0)
+				interp = string(bytes.TrimRight(data, "\x00"))
+			}
+		}
+		if interp != o.Interp {
+			t.Errorf("PT_INTERP = %q; want %q", interp, o.Interp)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// cgroupRoot is where the cgroup v2 hierarchy is mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// ioniceClasses are the I/O scheduling classes of -ionice, as ionice(1)
+// names them.
+var ioniceClasses = map[string]int{
+	"realtime":    1,
+	"best-effort": 2,
+	"idle":        3,
+}
+
+// applyLimits throttles the process as -nice, -ionice and -memory-limit ask,
+// and within a cgroup (v2), bounds GOMAXPROCS by its CPU quota and by default the
+// Go heap by its memory limit, so that a grab does not starve the workload
+// on the host it is capturing.
+func applyLimits() error {
+	if cpus, ok := cgroupCPUs(); ok && cpus < runtime.GOMAXPROCS(0) {
+		runtime.GOMAXPROCS(cpus)
+	}
+
+	limit, err := memoryLimit(*memLimit)
+	if err != nil {
+		return fmt.Errorf("-memory-limit: %v", err)
+	}
+	if limit > 0 {
+		debug.SetMemoryLimit(limit)
+	}
+
+	if *niceness != 0 {
+		if err := eachThread(func(tid int) error {
+			return unix.Setpriority(unix.PRIO_PROCESS, tid, *niceness)
+		}); err != nil {
+			return fmt.Errorf("-nice: %v", err)
+		}
+	}
+	if *ionice != "" {
+		prio, err := parseIonice(*ionice)
+		if err != nil {
+			return fmt.Errorf("-ionice: %v", err)
+		}
+		if err := eachThread(func(tid int) error {
+			const whoProcess = 1 // IOPRIO_WHO_PROCESS
+			_, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, whoProcess, uintptr(tid), uintptr(prio))
+			if errno != 0 {
+				return errno
+			}
+			return nil
+		}); err != nil {
+			return fmt.Errorf("-ionice: %v", err)
+		}
+	}
+	return nil
+}
+
+// eachThread calls f with the id of each thread of the process. Linux keeps
+// the nice value and I/O priority per thread, and new threads inherit them
+// from the thread creating them, so each existing one must be changed.
+func eachThread(f func(tid int) error) error {
+	tasks, err := ioutil.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		// Threads may exit while we go.
+		if err := f(tid); err != nil && err != unix.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseIonice parses an -ionice value, a class from ioniceClasses and for
+// realtime and best-effort an optional level from 0 (highest) to 7, into
+// an ioprio_set(2) priority.
+func parseIonice(s string) (int, error) {
+	name, levelString, hasLevel := strings.Cut(s, ":")
+	class, ok := ioniceClasses[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown class %q, want idle, best-effort or realtime", name)
+	}
+	level := 4
+	if hasLevel {
+		var err error
+		level, err = strconv.Atoi(levelString)
+		if err != nil || level < 0 || level > 7 {
+			return 0, fmt.Errorf("level %q is not from 0 to 7", levelString)
+		}
+	}
+	if name == "idle" {
+		level = 0
+	}
+	return class<<13 | level, nil
+}
+
+// memoryLimit returns the Go memory limit which -memory-limit s asks for: a
+// number of bytes with an optional K, M or G suffix (powers of 1024), or
+// "off". Empty means nine tenths of the cgroup's memory limit, if any. Zero
+// means none.
+func memoryLimit(s string) (int64, error) {
+	switch s {
+	case "off":
+		return 0, nil
+	case "":
+		if limit, ok := cgroupMemory(); ok {
+			return limit / 10 * 9, nil
+		}
+		return 0, nil
+	}
+
+	size, shift := s, 0
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		shift = 10
+	case "M":
+		shift = 20
+	case "G":
+		shift = 30
+	}
+	if shift != 0 {
+		size = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%q is not a positive size", s)
+	}
+	return n << shift, nil
+}
+
+// cgroupDirs returns the cgroup v2 directory of the process and those of
+// its ancestors, whose limits also apply to it.
+func cgroupDirs() []string {
+	data, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return nil
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "0::") {
+			continue
+		}
+		var dirs []string
+		for p := filepath.Clean("/" + line[3:]); ; p = filepath.Dir(p) {
+			dirs = append(dirs, filepath.Join(cgroupRoot, p))
+			if p == "/" {
+				return dirs
+			}
+		}
+	}
+	return nil
+}
+
+// cgroupCPUs returns the number of CPUs the cgroup quotas of the process
+// allow, rounded up, if any bound it.
+func cgroupCPUs() (int, bool) {
+	cpus, found := 0, false
+	for _, dir := range cgroupDirs() {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "cpu.max"))
+		if err != nil {
+			continue
+		}
+		fields := strings.Fields(string(data))
+		if len(fields) != 2 || fields[0] == "max" {
+			continue
+		}
+		quota, err1 := strconv.ParseInt(fields[0], 10, 64)
+		period, err2 := strconv.ParseInt(fields[1], 10, 64)
+		if err1 != nil || err2 != nil || quota <= 0 || period <= 0 {
+			continue
+		}
+		n := int((quota + period - 1) / period)
+		if !found || n < cpus {
+			cpus, found = n, true
+		}
+	}
+	return cpus, found
+}
+
+// cgroupMemory returns the lowest memory limit of the cgroups of the
+// process, if any.
+func cgroupMemory() (int64, bool) {
+	var limit int64
+	found := false
+	for _, dir := range cgroupDirs() {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "memory.max"))
+		if err != nil {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			// "max" means unlimited.
+			continue
+		}
+		if !found || n < limit {
+			limit, found = n, true
+		}
+	}
+	return limit, found
+}
@@ -1,210 +1,1507 @@
 package main
 
 import (
-	"archive/tar"
-	"debug/elf"
+	"bufio"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/mattn/go-isatty"
 	"github.com/pwaller/grab-ld-binaries/dlcache"
+	"github.com/pwaller/grab-ld-binaries/grab"
 )
 
+var (
+	kernelModules = flag.String("kernel-modules", "",
+		"file listing kernel modules to include from /lib/modules/<release>,\n"+
+			"for the running kernel, or the only release with modules in -root")
+	kernelRelease = flag.String("kernel-release", "",
+		"take -kernel-modules from /lib/modules of this kernel release")
+	pid = flag.Int("pid", 0,
+		"also grab the binary this running process runs, with every library\n"+
+			"it has mapped, including those it loaded with dlopen (use -root\n"+
+			"/proc/PID/root for a process in a container)")
+	coreFile = flag.String("core", "",
+		"also grab the binary which dumped this core, with every library it\n"+
+			"had mapped, as a kit for debugging the core elsewhere; files whose\n"+
+			"build IDs differ from the core's are diagnosed")
+	recursive = flag.Bool("recursive", false,
+		"for inputs which are directories, grab every ELF executable and\n"+
+			"shared object in them and archive the directories as they are")
+	nss = flag.Bool("nss", false,
+		"include the libnss_* modules /etc/nsswitch.conf names, which glibc\n"+
+			"loads with dlopen to look up users, groups and hosts")
+	withGconv = flag.Bool("with-gconv", false,
+		"include glibc's gconv modules, which iconv loads with dlopen to\n"+
+			"convert character sets, if glibc is needed")
+	nssConfig = flag.Bool("nss-config", false,
+		"include /etc/nsswitch.conf itself")
+	splitArch = flag.String("split-arch", "",
+		"write one tar per ELF architecture, named by formatting the\n"+
+			"architecture into this pattern (e.g. bundle-%s.tar)")
+	destDir = flag.String("dest", "",
+		"copy the files into this directory instead of writing a tar")
+	appDir = flag.String("appdir", "",
+		"copy the files into this directory as an AppImage AppDir, with an\n"+
+			"AppRun for the first binary, for appimagetool, instead of writing a tar")
+	squashfs = flag.String("squashfs", "",
+		"write a SquashFS image to this file instead of a tar, to loop-mount\n"+
+			"or use as a portable service image (needs the mksquashfs program)")
+	ociArchive = flag.String("oci", "",
+		"write a single-layer OCI image archive, with the first binary as its\n"+
+			"entrypoint, to this file instead of a tar")
+	ociDir = flag.String("oci-dir", "",
+		"write the OCI image as an image layout in this directory")
+	push = flag.String("push", "",
+		"push the OCI image to this registry reference, such as\n"+
+			"registry.example.com/app:tag, with oras, which takes credentials\n"+
+			"from the docker config and its credential helpers")
+	containerd = flag.String("containerd", "",
+		"add the bundle as a new layer on top of this image in the local\n"+
+			"containerd, via ctr, instead of writing a tar")
+	containerdTag = flag.String("containerd-tag", "",
+		"the name to import the -containerd image with the new layer as")
+	containerdNamespace = flag.String("containerd-namespace", "default",
+		"the containerd namespace of -containerd")
+	ociAudit = flag.Bool("oci-audit", false,
+		"annotate the OCI image with the host, input digests and recipe\n"+
+			"digest it was grabbed with, as well as its creation time and the\n"+
+			"tool version")
+	showChecklist = flag.Bool("checklist", false,
+		"after writing the bundle, list the files, plugins, capabilities and\n"+
+			"devices it appears to expect of the target, and the symbol versions\n"+
+			"needed of each library")
+	securityReport = flag.String("security-report", "",
+		"write a JSON report of the privileges the binaries appear to need\n"+
+			"(setuid, file capabilities, privileged ports) to this file")
+	format = flag.String("format", "text",
+		"text: log the resolution and write the tar; zip: likewise, but\n"+
+			"write a zip archive, as AWS Lambda takes; json: instead print\n"+
+			"the resolution (each library's path, size and dependents) as JSON")
+	reportOut = flag.String("report", "",
+		"also write the JSON report of -format json, including what needs\n"+
+			"each library, to this file; why can answer from it offline")
+	sbomOut = flag.String("sbom", "",
+		"write an SBOM of the archived files to this file")
+	sbomFormat = flag.String("sbom-format", "spdx",
+		"format of the -sbom: spdx (SPDX 2.3 JSON) or cyclonedx (CycloneDX\n"+
+			"1.5 JSON)")
+	dbOut = flag.String("db", "",
+		"add the archived objects, their dependencies, hashes and packages\n"+
+			"to this SQLite database, creating it if need be, so the grabs of\n"+
+			"a fleet can be queried together (needs the sqlite3 program)")
+	recipeOut = flag.String("recipe", "",
+		"write the recipe (inputs, ld.so.cache and file hashes) which\n"+
+			"determines the output tar to this file")
+	checksum = flag.String("checksum", "sha256",
+		"hash the files in the recipe with this algorithm: "+
+			strings.Join(grab.Checksums, ", "))
+	cacheDir = flag.String("cache-dir", "",
+		"reuse the output tar stored here if the recipe is unchanged")
+	output = flag.String("output", "",
+		"write the tar to this file rather than stdout, renaming it into\n"+
+			"place only once complete, or stream it to an s3:// URL (with the\n"+
+			"aws CLI) or an http(s):// URL (with PUT), aborting on failure")
+	appendTo = flag.String("append", "",
+		"add the files to this tar, if they are not already in it, rather than\n"+
+			"writing a new one to stdout, so a bundle can be built up over\n"+
+			"several runs; it is created if need be")
+	forceStdout = flag.Bool("force-stdout", false,
+		"write the tar to stdout even if it is a terminal")
+	onTTY = flag.String("on-tty", "discard",
+		"when stdout is a terminal: discard (and exit 1), error, or file:PATH")
+	prefetch = flag.String("prefetch", "on",
+		"read the archived files ahead into the page cache: "+
+			strings.Join(grab.Prefetches, ", ")+"\n(physical requests them in order of their location on disk)")
+	paxNames = flag.Bool("pax", true,
+		"encode names longer than 100 bytes or containing non-ASCII with\n"+
+			"PAX records instead of relying on the USTAR prefix field")
+	selinux = flag.Bool("selinux", false,
+		"also archive the files' SELinux contexts (security.selinux), so that\n"+
+			"they keep their labels on SELinux-enforcing targets")
+	dirs = flag.Bool("dirs", false,
+		"archive the binaries and libraries at their paths, as on disk, with\n"+
+			"a directory entry for each parent directory, for extractors which\n"+
+			"need them; as a container layer these replace symlinked\n"+
+			"directories such as /lib on merged-/usr images")
+	owner = flag.String("owner", "",
+		"archive every file as owned by USER:GROUP, each a name (looked up on\n"+
+			"this host) or a number, such as root:root or 0:0, whoever owns it")
+	reproducible = flag.Bool("reproducible", false,
+		"write bit-identical archives across machines: entries sorted by\n"+
+			"name, owners zeroed, and modification times set to\n"+
+			"$SOURCE_DATE_EPOCH (or 1970 if unset)")
+	compression = flag.String("compress", "",
+		"compress the output: "+strings.Join(grab.Compressions, ", ")+
+			" (default inferred\nfrom the output file name, otherwise none)")
+	root = flag.String("root", "/",
+		"resolve binaries and libraries in this filesystem tree, using its\n"+
+			"etc/ld.so.cache, instead of the running system")
+	sshDest = flag.String("ssh", "",
+		"resolve binaries and libraries on this host, such as user@host,\n"+
+			"reading its files with ssh, and archive them locally; the host\n"+
+			"needs only a shell with stat, readlink, cat and ls")
+	basePath = flag.String("base", "",
+		"what the target base image provides, which is left out of the\n"+
+			"archive: a manifest (see the manifest command), a root filesystem\n"+
+			"directory or tarball, or an image such as debian:bookworm, which\n"+
+			"is exported with docker or podman")
+	targetGlibc = flag.String("target-glibc", "",
+		"fail if the binaries or the libraries archived with them need glibc\n"+
+			"symbol versions newer than this glibc version, such as 2.28,\n"+
+			"listing each object and the versions it needs; -checklist lists\n"+
+			"the highest version needed of each library either way")
+	renamesFile = flag.String("renames", "",
+		"file of rules, one per line, substituting libraries for needed ones,\n"+
+			"such as vendored forks: 'libcrypto.so.1.1 -> /opt/lib/libcrypto-acme.so'\n"+
+			"uses that file, and 'libjpeg.so.8 -> libturbojpeg.so.0' searches for\n"+
+			"the other soname; either is archived as the needed soname")
+	allowLibcMismatch = flag.Bool("allow-libc-mismatch", false,
+		"if the binaries need a different libc (glibc or musl) from the\n"+
+			"-base, bundle every library and the loader instead of failing")
+	timeout = flag.Duration("timeout", 0,
+		"exit with status 124 if the whole grab takes longer than this")
+	resolveTimeout = flag.Duration("resolve-timeout", 0,
+		"deadline for resolving and hashing dependencies")
+	archiveTimeout = flag.Duration("archive-timeout", 0,
+		"deadline for writing the archive")
+	uploadTimeout = flag.Duration("upload-timeout", 0,
+		"deadline for an upload to an -output URL, or a -push, to complete\n"+
+			"once the archive is written")
+	niceness = flag.Int("nice", 0,
+		"run at this nice value, as nice(1) does, so as not to starve the\n"+
+			"host's workload")
+	ionice = flag.String("ionice", "",
+		"run in this I/O scheduling class: idle, or best-effort or realtime\n"+
+			"with an optional :level from 0 to 7, as ionice(1) does")
+	memLimit = flag.String("memory-limit", "",
+		"soft limit on the memory used, in bytes with an optional K, M or G\n"+
+			"suffix, or off (default: nine tenths of the cgroup's limit, if any)")
+	inputList = flag.String("input-list", "",
+		"read further binaries from this file, one per line, or - for stdin")
+	progressFD = flag.Int("progress-fd", 0,
+		"write JSON progress events, one per line, to this file descriptor")
+	keepPartial = flag.Bool("keep-partial", false,
+		"on failure, keep incomplete output files (named *"+partialSuffix+")\n"+
+			"instead of removing them")
+	packagesBackend = flag.String("packages", "",
+		"report the package owning each library, from this package database:\n"+
+			strings.Join(grab.PackageBackends, ", "))
+	packageDeps = flag.String("package-deps", "",
+		"when a binary or library cannot be read (EACCES), take the libraries\n"+
+			"it needs from this package database's dependency metadata, so that\n"+
+			"-format json, -report and the missing libraries are complete\n"+
+			"although it cannot be archived: "+strings.Join(grab.PackageBackends, ", "))
+	includeLicenses = flag.Bool("include-licenses", false,
+		"also archive the copyright and license files of the packages owning\n"+
+			"the archived files, under licenses/<package>/")
+	withSources = flag.String("with-sources", "",
+		"write the source packages of the packages owning the archived files\n"+
+			"to this JSON file, as a manifest of the sources to offer alongside\n"+
+			"the archive for GPL compliance")
+	preload = flag.Bool("preload", false,
+		"also include the libraries in $LD_PRELOAD and the root's\n"+
+			"/etc/ld.so.preload, and their dependencies")
+	specialFiles = flag.String("special-files", "skip",
+		"what to do with FIFOs, sockets and device nodes under -add: skip\n"+
+			"them with a warning, archive them as such, or error")
+	arch = flag.String("arch", "",
+		"use the ld.so.cache entries for this architecture, such as amd64,\n"+
+			"386, amd64p32 (x32), arm, armel or arm64 (default: that of the\n"+
+			"binary or library needing each library)")
+	strict = flag.Bool("strict", false,
+		"exit with an error, writing nothing, if any library is not found,\n"+
+			"or if binaries resolve the same soname to different files")
+	elfReader = flag.String("elf-reader", "auto",
+		"how to read binaries and libraries: debug-elf, stream (only the\n"+
+			"dynamic segment, for multi-gigabyte binaries), or auto to stream\n"+
+			"files of 256 MiB or more")
+	jobs = flag.Int("jobs", runtime.NumCPU(),
+		"how many of the binaries to resolve at once")
+	ignore = flag.String("ignore", strings.Join(grab.DefaultIgnore, ","),
+		"comma-separated glob patterns of virtual libraries provided by the\n"+
+			"host which are not resolved or reported")
+)
+
+var (
+	addPaths stringsFlag
+	filters  stringsFlag
+	units    stringsFlag
+	pinned   stringsFlag
+	excludes stringsFlag
+	locales  stringsFlag
+	profiles stringsFlag
+)
+
+func init() {
+	flag.Var(&addPaths, "add",
+		"also archive this file or directory (repeatable)")
+	flag.Var(&filters, "filter",
+		"rsync-style rule for directories given to -add, such as '+ *.conf'\n"+
+			"or '- *.log'; the first matching rule wins (repeatable)")
+	flag.Var(&pinned, "input-sha256",
+		"refuse to proceed unless the input has this sha256, given as INPUT=HEX\n"+
+			"or just HEX if there is one input (repeatable)")
+	flag.Var(&excludes, "exclude",
+		"glob pattern of sonames, such as 'libc.so*' or 'ld-linux*', which the\n"+
+			"target is known to provide, so are not archived; their own\n"+
+			"dependencies still are (repeatable)")
+	flag.Var(&locales, "with-locale",
+		"also archive this compiled glibc locale, such as C.UTF-8, if glibc\n"+
+			"is needed: its directory in /usr/lib/locale, or else the whole\n"+
+			"locale-archive (repeatable)")
+	flag.Var(&profiles, "profile",
+		"also archive the runtime data binaries commonly need: "+strings.Join(grab.Profiles, ", ")+"\n"+
+			"(CA certificates, the terminfo database, time zones; repeatable)")
+	flag.Var(&units, "unit",
+		"also grab this systemd service: the binaries its Exec* settings run,\n"+
+			"its environment files, and the unit file with drop-ins (repeatable)")
+}
+
 func main() {
-	args := os.Args[1:]
-	if len(args) < 1 {
-		log.Fatal("usage: grab-binaries <filename>")
+	if spec := os.Getenv(runChildEnv); spec != "" {
+		runChild(spec)
+		return
+	}
+
+	// exitCode is the status to exit with once the deferred calls, which
+	// os.Exit would skip, have run.
+	var exitCode int
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
+	flag.Parse()
+	if err := applyLimits(); err != nil {
+		fatal(err)
+	}
+	defer closeSSH()
+
+	var traced *tracing
+	args := flag.Args()
+	if len(args) > 0 {
+		switch args[0] {
+		case "why":
+			why(args[1:])
+			return
+		case "manifest":
+			manifest(args[1:])
+			return
+		case "extract":
+			extract(args[1:])
+			return
+		case "run":
+			run(args[1:])
+			return
+		case "dockerfile":
+			dockerfile(args[1:])
+			return
+		case "graph":
+			graph(args[1:])
+			return
+		case "tree":
+			tree(args[1:])
+			return
+		case "scan":
+			scan(args[1:])
+			return
+		case "image":
+			image(args[1:])
+			return
+		case "oci-index":
+			ociIndex(args[1:])
+			return
+		case "verify":
+			verify(args[1:])
+			return
+		case "serve":
+			serve(args[1:])
+			return
+		case "trace":
+			traced = trace(args[1:])
+			args = nil
+		}
+	}
+
+	if *inputList != "" {
+		listed, err := readInputList(*inputList)
+		if err != nil {
+			fatalf("input-list: %v", err)
+		}
+		args = append(args, listed...)
+	}
+	var mappedExe string
+	var mappedFiles []string
+	var coreMappings []grab.CoreMapping
+	if *coreFile != "" {
+		var err error
+		coreMappings, err = grab.ReadCore(*coreFile)
+		if err != nil {
+			fatalf("core: %v", err)
+		}
+		mappedExe = coreMappings[0].Path
+		for _, m := range coreMappings[1:] {
+			mappedFiles = append(mappedFiles, m.Path)
+		}
+		log.Printf("Core dump of %s with %d files mapped", mappedExe, len(mappedFiles))
+		args = append(args, mappedExe)
+	}
+	if *pid != 0 && *coreFile != "" {
+		fatal("-pid cannot be used with -core")
+	}
+	if *sshDest != "" && (*pid != 0 || *coreFile != "" || *recursive) {
+		fatal("-ssh cannot be used with -pid, -core or -recursive")
+	}
+	if *pid != 0 {
+		var err error
+		mappedExe, mappedFiles, err = grab.ProcessMaps(*pid)
+		if err != nil {
+			fatalf("pid: %v", err)
+		}
+		log.Printf("Process %d runs %s with %d files mapped", *pid, mappedExe, len(mappedFiles))
+		args = append(args, mappedExe)
+	}
+	if traced != nil {
+		mappedExe, mappedFiles = traced.Exe, traced.Files
+		args = append(args, mappedExe)
+	}
+	if len(args) < 1 && len(units) == 0 {
+		fatal("usage: grab-binaries [flags] <filename>...")
 	}
 
-	filename := args[0]
+	if *progressFD > 0 {
+		openProgress(*progressFD)
+	}
+	defer watchdog("grab", *timeout)()
 
-	dc, err := dlcache.Load()
+	policy, err := parseTTYPolicy(*onTTY)
 	if err != nil {
-		log.Fatalf("Failed to load ld.so.cache: %v", err)
+		fatal(err)
+	}
+
+	switch *format {
+	case "text", "zip", "json":
+	default:
+		fatalf("unknown -format %q, want text, zip or json", *format)
+	}
+	if *format == "zip" && (*splitArch != "" || *destDir != "" || *appDir != "" || *squashfs != "" ||
+		*ociArchive != "" || *ociDir != "" || *push != "" || *containerd != "") {
+		fatal("-format zip cannot be used with -split-arch, -dest, -appdir, -squashfs, -oci, -oci-dir, -push or -containerd")
 	}
 
-	filename, err = resolveBinary(dc, filename)
+	if *splitArch != "" {
+		if err := checkSplitPattern(*splitArch); err != nil {
+			fatalf("split-arch: %v", err)
+		}
+	}
+
+	if err := grab.CheckPrefetch(*prefetch); err != nil {
+		fatalf("prefetch: %v", err)
+	}
+
+	var (
+		out       io.WriteCloser
+		outName   string // Empty for stdout
+		discarded bool
+	)
+	writesTar := *format != "json" && *splitArch == "" && *destDir == "" && *appDir == "" &&
+		*squashfs == "" &&
+		*ociArchive == "" && *ociDir == "" && *push == "" && *containerd == ""
+	if writesTar {
+		switch {
+		case *appendTo != "" && (*output != "" || *cacheDir != ""):
+			fatal("-append cannot be used with -output or -cache-dir")
+		case *appendTo != "":
+			out, err = createOutput(*appendTo)
+		case grab.IsUploadURL(*output):
+			out, err = startUpload(*output)
+			outName = *output
+		case *output != "":
+			out, err = createOutput(*output)
+		default:
+			out, discarded, err = openOutput(policy, *forceStdout)
+		}
+		if err != nil {
+			fatal(err)
+		}
+		if fd, ok := out.(*os.File); ok && fd != os.Stdout {
+			outName = strings.TrimSuffix(fd.Name(), partialSuffix)
+		}
+		out, err = grab.Compress(out, compressionFor(outName))
+		if err != nil {
+			fatalf("compress: %v", err)
+		}
+	}
+
+	resolved := watchdog("resolution", *resolveTimeout)
+	r, err := newResolver()
 	if err != nil {
-		log.Fatalf("resolveBinary %q: %v", filename, err)
+		fatal(err)
 	}
 
-	imports, err := recursiveImports(dc, filename)
+	var unitFiles []string
+	for _, name := range units {
+		unit, err := r.LoadUnit(name)
+		if err != nil {
+			fatalf("unit: %v", err)
+		}
+		log.Printf("Unit %s runs %s", name, strings.Join(unit.Binaries, ", "))
+		args = append(args, unit.Binaries...)
+		unitFiles = append(unitFiles, unit.Files...)
+		unitFiles = append(unitFiles, unit.EnvironmentFiles...)
+	}
+
+	var scanned []grab.File
+	placed := map[string]bool{}
+	if *recursive {
+		var expanded []string
+		for _, arg := range args {
+			dir := arg
+			if filepath.Clean(*root) == "/" {
+				dir, _ = filepath.Abs(arg)
+			}
+			if fi, err := os.Stat(filepath.Join(*root, dir)); err != nil || !fi.IsDir() {
+				expanded = append(expanded, arg)
+				continue
+			}
+			binaries, files, err := r.ScanDir(dir)
+			if err != nil {
+				fatalf("recursive: %v", err)
+			}
+			log.Printf("Found %d binaries among %d files in %s", len(binaries), len(files), arg)
+			expanded = append(expanded, binaries...)
+			scanned = append(scanned, files...)
+			for _, binary := range binaries {
+				placed[binary] = true
+			}
+		}
+		args = expanded
+	}
+
+	fetchDir, err := makeTempDir("grab-fetch-")
+	if err != nil {
+		fatal(err)
+	}
+	defer os.RemoveAll(fetchDir)
+	inputs, overlay, err := fetchInputs(fetchDir, args)
 	if err != nil {
-		log.Fatal(err)
+		fatalf("fetch: %v", err)
 	}
+	r.Overlay = overlay
 
-	paths := []string{filename}
-	for _, lib := range sortedSet(imports) {
-		path, ok := dc.Lookup(lib)
-		if ok {
-			log.Println(lib, "=>", path)
-			paths = append(paths, path)
-		} else {
-			log.Println(lib, "(not found)")
+	g, err := r.ResolveAll(inputs)
+	if err != nil {
+		fatalf("resolve: %v", err)
+	}
+	if len(pinned) > 0 {
+		if err := verifyInputs(g, args, pinned); err != nil {
+			fatalf("input-sha256: %v", err)
 		}
 	}
 
-	out := io.Writer(os.Stdout)
-	if isatty.IsTerminal(os.Stdout.Fd()) {
-		fmt.Fprintln(os.Stderr)
-		log.Printf("Not writing tar file to terminal.")
-		log.Printf("Use `| cat` if you really want it.")
-		fmt.Fprintln(os.Stderr)
-		out = ioutil.Discard
+	if mappedExe != "" {
+		added, err := r.AddMapped(g, mappedExe, mappedFiles)
+		if err != nil {
+			fatalf("mapped: %v", err)
+		}
+		if len(added) > 0 {
+			log.Printf("Adding %s, mapped by %s", strings.Join(added, ", "), mappedExe)
+		}
+		r.CheckBuildIDs(coreMappings)
 	}
 
-	total := writeTar(out, paths)
-	log.Printf("Total: %.2f MiB", float64(total)/1024/1024)
-}
+	if len(scanned) > 0 {
+		g.Extra = append(g.Extra, scanned...)
+		g.Placed = placed
+	}
 
-// writeTar writes `paths` to `out` as a tar stream and returns the total bytes
-// read from disk.
-func writeTar(out io.Writer, paths []string) int64 {
-	tf := tar.NewWriter(out)
-	defer tf.Close()
+	for _, path := range unitFiles {
+		added, err := r.AddPath(path, nil)
+		if err != nil {
+			fatalf("unit: %v", err)
+		}
+		g.Extra = append(g.Extra, added...)
+	}
 
-	var total int64
-	for _, path := range paths {
-		fi, err := os.Stat(path)
+	if *nss {
+		added, err := r.AddNSSModules(g)
+		if err != nil {
+			fatalf("nss: %v", err)
+		}
+		if len(added) > 0 {
+			log.Printf("Adding NSS modules %s", strings.Join(added, ", "))
+		}
+	}
+	if *nssConfig {
+		added, err := r.AddPath(grab.NSSConfig, nil)
+		if err != nil {
+			fatalf("nss-config: %v", err)
+		}
+		g.Extra = append(g.Extra, added...)
+	}
+
+	if (*withGconv || len(locales) > 0) && g.Libc() != grab.LibcGlibc {
+		log.Printf("Not adding gconv modules or locales, since glibc is not needed")
+	} else {
+		if *withGconv {
+			modules, err := r.GconvModules(g)
+			if err != nil {
+				fatalf("with-gconv: %v", err)
+			}
+			log.Printf("Adding %d gconv files", len(modules))
+			g.Extra = append(g.Extra, modules...)
+		}
+		seen := map[string]bool{}
+		for _, locale := range locales {
+			added, err := r.LocaleFiles(locale)
+			if err != nil {
+				fatalf("with-locale: %v", err)
+			}
+			for _, file := range added {
+				if !seen[file.Name] {
+					seen[file.Name] = true
+					g.Extra = append(g.Extra, file)
+				}
+			}
+		}
+	}
+
+	for _, profile := range profiles {
+		added, err := r.ProfileFiles(profile)
+		if err != nil {
+			fatalf("profile: %v", err)
+		}
+		log.Printf("Adding %d files for the %s profile", len(added), profile)
+		g.Extra = append(g.Extra, added...)
+	}
+
+	if *kernelModules != "" {
+		modules, err := r.KernelModules(*kernelModules, *kernelRelease)
+		if err != nil {
+			fatalf("kernel modules: %v", err)
+		}
+		g.Extra = append(g.Extra, modules...)
+	}
+
+	var rules []grab.FilterRule
+	for _, filter := range filters {
+		rule, err := grab.ParseFilterRule(filter)
+		if err != nil {
+			fatal(err)
+		}
+		rules = append(rules, rule)
+	}
+	for _, path := range addPaths {
+		added, err := r.AddPath(path, rules)
 		if err != nil {
-			log.Fatal(err)
+			fatalf("add: %v", err)
 		}
+		log.Printf("Adding %d files from %s", len(added), path)
+		g.Extra = append(g.Extra, added...)
+	}
 
-		hdr, err := tar.FileInfoHeader(fi, "")
+	if *basePath != "" {
+		base, err := grab.OpenBase(*basePath)
+		if err != nil {
+			fatalf("base: %v", err)
+		}
+		switch err := g.CheckLibc(base); {
+		case err == nil:
+			g.ApplyBase(base)
+		case *allowLibcMismatch:
+			log.Print(err)
+			log.Printf("base: ignoring the base, bundling every library")
+			if d, ok := err.(grab.Diagnostic); ok {
+				r.Diagnostics = append(r.Diagnostics, d)
+			}
+		default:
+			fatal(err)
+		}
+	}
+
+	if len(excludes) > 0 {
+		excluded, err := g.Exclude(excludes)
+		if err != nil {
+			fatalf("exclude: %v", err)
+		}
+		if len(excluded) > 0 {
+			log.Printf("Excluding %s", strings.Join(excluded, ", "))
+		}
+	}
+
+	var tooNew []grab.Diagnostic
+	if *targetGlibc != "" {
+		tooNew, err = g.CheckGlibc(*targetGlibc)
+		if err != nil {
+			fatalf("target-glibc: %v", err)
+		}
+		for _, d := range tooNew {
+			log.Print(d)
+		}
+		r.Diagnostics = append(r.Diagnostics, tooNew...)
+	}
+
+	var packages grab.PackageDB
+	if *packagesBackend != "" {
+		packages, err = r.Packages(*packagesBackend)
+		if err != nil {
+			fatalf("packages: %v", err)
+		}
+	}
+
+	if *format == "json" {
+		if err := writeReport(os.Stdout, g, r, packages); err != nil {
+			fatalf("report: %v", err)
+		}
+		if err := checkStrict(g, r); err != nil {
+			fatal(err)
+		}
+		if len(tooNew) > 0 {
+			fatalf("target-glibc: %d objects need a glibc newer than %s", len(tooNew), *targetGlibc)
+		}
+		return
+	}
+	if *reportOut != "" {
+		fd, err := os.Create(*reportOut)
 		if err != nil {
-			log.Fatal(err)
+			fatalf("report: %v", err)
 		}
+		err = writeReport(fd, g, r, packages)
+		if closeErr := fd.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			fatalf("report: %v", err)
+		}
+	}
 
-		err = tf.WriteHeader(hdr)
+	var required map[string][]string
+	if *showChecklist {
+		required, err = g.RequiredVersions()
 		if err != nil {
-			log.Fatal(err)
+			fatalf("versions: %v", err)
 		}
+	}
+	for _, lib := range grab.SortedSet(g.Libraries()) {
+		path, ok := g.Resolved[lib]
+		switch {
+		case g.Provided[lib]:
+			log.Println(lib, "(provided by the target)")
+		case ok && packages != nil:
+			if pkg, found := packages.Lookup(path); found {
+				log.Println(lib, "=>", path, "("+pkg.Name, pkg.Version+")")
+			} else {
+				log.Println(lib, "=>", path, "(no package)")
+			}
+		case ok:
+			log.Println(lib, "=>", path)
+		default:
+			log.Println(grab.NewDiagnostic(grab.DiagMissingLibrary, lib))
+		}
+		if versions := required[lib]; len(versions) > 0 {
+			log.Println("  needs", strings.Join(versions, ", "))
+		}
+	}
+	if err := checkStrict(g, r); err != nil {
+		fatal(err)
+	}
+	if len(tooNew) > 0 {
+		fatalf("target-glibc: %d objects need a glibc newer than %s", len(tooNew), *targetGlibc)
+	}
+
+	if *securityReport != "" {
+		if err := writeSecurityReport(g, *securityReport); err != nil {
+			fatalf("security-report: %v", err)
+		}
+	}
+	if traced != nil && traced.seccomp != "" {
+		if err := writeSeccomp(traced); err != nil {
+			fatalf("seccomp: %v", err)
+		}
+	}
+
+	for _, req := range g.DeviceRequirements() {
+		var needs []string
+		needs = append(needs, req.Devices...)
+		needs = append(needs, req.Groups...)
+		needs = append(needs, req.Capabilities...)
+		log.Printf("Devices: %s implies %s", req.Library, strings.Join(needs, ", "))
+	}
 
-		func() {
-			fd, err := os.Open(path)
+	files := g.Files()
+	if *dirs {
+		files = g.PathFiles()
+	}
+	if *includeLicenses {
+		db := packages
+		if db == nil {
+			db, err = r.Packages("auto")
 			if err != nil {
-				log.Fatal(err)
+				fatalf("packages: %v", err)
 			}
-			defer fd.Close()
+		}
+		licenses, err := r.LicenseFiles(g, db)
+		if err != nil {
+			fatalf("include-licenses: %v", err)
+		}
+		log.Printf("Adding %d license files", len(licenses))
+		files = append(files, licenses...)
+	}
+	opts := &grab.TarOptions{NoPAX: !*paxNames, Prefetch: *prefetch, Reproducible: *reproducible,
+		SELinux: *selinux, Dirs: *dirs}
+	if *owner != "" {
+		if opts.Owner, err = grab.ParseOwner(*owner); err != nil {
+			fatalf("owner: %v", err)
+		}
+	}
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); *reproducible && epoch != "" {
+		seconds, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			fatalf("SOURCE_DATE_EPOCH: %q is not a number of seconds", epoch)
+		}
+		opts.ModTime = time.Unix(seconds, 0)
+	}
+	trackProgress(opts, files)
 
-			n, err := io.Copy(tf, fd)
+	if *sbomOut != "" || *dbOut != "" || *withSources != "" {
+		if packages == nil {
+			packages, err = r.Packages("auto")
 			if err != nil {
-				log.Fatal(err)
+				fatalf("packages: %v", err)
 			}
-			total += n
-		}()
+		}
+	}
+	if *sbomOut != "" {
+		if err := writeSBOM(args, files, r, packages); err != nil {
+			fatalf("sbom: %v", err)
+		}
+	}
+	if *withSources != "" {
+		if err := writeSources(*withSources, g, packages); err != nil {
+			fatalf("with-sources: %v", err)
+		}
+	}
+	if *dbOut != "" {
+		name := filepath.Base(args[0])
+		if err := r.WriteSQLite(*dbOut, name, g, files, packages, time.Now()); err != nil {
+			fatalf("db: %v", err)
+		}
+	}
+
+	var recipe *grab.Recipe
+	audited := *ociAudit && (*ociArchive != "" || *ociDir != "" || *push != "" || *containerd != "")
+	if *recipeOut != "" || *cacheDir != "" || audited {
+		recipe, err = grab.MakeRecipe(args, r.CacheFile, files, opts, *checksum)
+		if err != nil {
+			fatalf("recipe: %v", err)
+		}
+		log.Printf("Recipe: %s", recipe.Digest())
+	}
+	if *recipeOut != "" {
+		if err := recipe.WriteFile(*recipeOut); err != nil {
+			fatalf("recipe: %v", err)
+		}
+	}
+
+	var checklist []grab.ChecklistItem
+	if *showChecklist {
+		checklist, err = g.Checklist(append(r.Diagnostics, g.MissingDiagnostics()...))
+		if err != nil {
+			fatalf("checklist: %v", err)
+		}
+	}
+	// done reports the bytes archived and then what to check before
+	// deploying the bundle.
+	done := func(total int64) {
+		log.Printf("Total: %.2f MiB", mib(total))
+		if len(checklist) > 0 {
+			log.Printf("Before deploying, check that the target provides:")
+			for _, item := range checklist {
+				log.Printf("  %s", item)
+			}
+		}
+	}
+
+	resolved()
+
+	defer watchdog("archiving", *archiveTimeout)()
+
+	if *ociArchive != "" || *ociDir != "" || *push != "" || *containerd != "" {
+		if *splitArch != "" || *cacheDir != "" || *destDir != "" || *appDir != "" || *squashfs != "" {
+			fatal("-oci, -push and -containerd cannot be used with -split-arch, -cache-dir, -dest, -appdir or -squashfs")
+		}
+		if *containerd != "" && (*ociArchive != "" || *ociDir != "" || *push != "") {
+			fatal("-containerd cannot be used with -oci, -oci-dir or -push")
+		}
+		if *containerd != "" && *containerdTag == "" {
+			fatal("-containerd needs -containerd-tag")
+		}
+		annotations := grab.DeviceAnnotations(g.DeviceRequirements())
+		audit, err := makeAudit(g, recipe, opts, audited)
+		if err != nil {
+			fatalf("oci-audit: %v", err)
+		}
+		for key, value := range audit.Annotations(g) {
+			annotations[key] = value
+		}
+		write, what := writeOCI, "oci"
+		if *containerd != "" {
+			write, what = appendContainerd, "containerd"
+		}
+		total, err := write(files, opts, annotations)
+		if err != nil {
+			fatalf("%s: %v", what, err)
+		}
+		if err := commitOutputs(); err != nil {
+			fatal(err)
+		}
+		done(total)
+		return
+	}
+
+	if *destDir != "" {
+		if *splitArch != "" || *cacheDir != "" {
+			fatal("-dest cannot be used with -split-arch or -cache-dir")
+		}
+		need := map[string]int64{*destDir: estimateSize(files)}
+		if err := preflight(need); err != nil {
+			fatalf("preflight: %v", err)
+		}
+		if err := markOutputDir(*destDir); err != nil {
+			fatalf("dest: %v", err)
+		}
+		total, err := grab.WriteDir(*destDir, files, opts)
+		if err != nil {
+			fatalf("dest: %v", err)
+		}
+		if err := commitOutputs(); err != nil {
+			fatal(err)
+		}
+		done(total)
+		return
+	}
+
+	if *appDir != "" {
+		if *splitArch != "" || *cacheDir != "" || *destDir != "" || *squashfs != "" {
+			fatal("-appdir cannot be used with -split-arch, -cache-dir, -dest or -squashfs")
+		}
+		need := map[string]int64{*appDir: estimateSize(files)}
+		if err := preflight(need); err != nil {
+			fatalf("preflight: %v", err)
+		}
+		if err := markOutputDir(*appDir); err != nil {
+			fatalf("appdir: %v", err)
+		}
+		total, err := g.WriteAppDir(*appDir, files, opts)
+		if err != nil {
+			fatalf("appdir: %v", err)
+		}
+		if err := commitOutputs(); err != nil {
+			fatal(err)
+		}
+		done(total)
+		return
+	}
+
+	if *squashfs != "" {
+		if *splitArch != "" || *cacheDir != "" || *destDir != "" {
+			fatal("-squashfs cannot be used with -split-arch, -cache-dir or -dest")
+		}
+		need := map[string]int64{}
+		addNeed(need, *squashfs, estimateSize(files))
+		addNeed(need, filepath.Join(os.TempDir(), "stage"), estimateSize(files))
+		if err := preflight(need); err != nil {
+			fatalf("preflight: %v", err)
+		}
+		fd, err := createOutput(*squashfs)
+		if err != nil {
+			fatalf("squashfs: %v", err)
+		}
+		fd.Close()
+		total, err := grab.WriteSquashFS(fd.Name(), files, opts)
+		if err != nil {
+			fatalf("squashfs: %v", err)
+		}
+		if err := commitOutputs(); err != nil {
+			fatal(err)
+		}
+		done(total)
+		return
+	}
+
+	if *splitArch != "" {
+		if *cacheDir != "" {
+			fatal("-cache-dir cannot be used with -split-arch")
+		}
+		groups, err := grab.SplitByArch(files)
+		if err != nil {
+			fatalf("split-arch: %v", err)
+		}
+		need := map[string]int64{}
+		for arch, group := range groups {
+			addNeed(need, fmt.Sprintf(*splitArch, arch), estimateSize(group))
+		}
+		if err := preflight(need); err != nil {
+			fatalf("preflight: %v", err)
+		}
+		total, err := writeSplitTars(*splitArch, groups, opts)
+		if err != nil {
+			fatalf("split-arch: %v", err)
+		}
+		if err := commitOutputs(); err != nil {
+			fatal(err)
+		}
+		done(total)
+		return
+	}
+
+	need := map[string]int64{}
+	if outName != "" && !grab.IsUploadURL(outName) {
+		addNeed(need, outName, estimateSize(files))
+	}
+	if fi, err := os.Stat(*appendTo); *appendTo != "" && err == nil {
+		addNeed(need, outName, fi.Size())
+	}
+	if *cacheDir != "" {
+		cached := recipe.CachePath(*cacheDir)
+		if _, err := os.Stat(cached); os.IsNotExist(err) {
+			addNeed(need, cached, estimateSize(files))
+		}
+	}
+	if err := preflight(need); err != nil {
+		fatalf("preflight: %v", err)
+	}
+
+	var total int64
+	if *format == "zip" && (*cacheDir != "" || *appendTo != "") {
+		fatal("-format zip cannot be used with -cache-dir or -append")
+	}
+	if *format == "zip" {
+		total, err = grab.WriteZip(out, files, opts)
+		if err != nil {
+			fatalf("zip: %v", err)
+		}
+	} else if *cacheDir != "" {
+		var hit bool
+		total, hit, err = grab.WriteCachedTar(out, *cacheDir, recipe, files, opts)
+		if err != nil {
+			fatalf("cache-dir: %v", err)
+		}
+		if hit {
+			log.Printf("Recipe hit in %s", *cacheDir)
+		}
+	} else if *appendTo != "" {
+		total, err = appendTar(out, *appendTo, files, opts)
+		if err != nil {
+			fatalf("append: %v", err)
+		}
+	} else {
+		total, err = grab.WriteFiles(out, files, opts)
+		if err != nil {
+			fatal(err)
+		}
+	}
+	uploaded := func() {}
+	if grab.IsUploadURL(*output) {
+		uploaded = watchdog("upload", *uploadTimeout)
+	}
+	if err := out.Close(); err != nil {
+		fatal(err)
+	}
+	uploaded()
+	if err := commitOutputs(); err != nil {
+		fatal(err)
+	}
+	done(total)
+
+	if discarded {
+		exitCode = 1
 	}
-	return total
 }
 
-// sortedSet takes a string set and returns it as a sorted slice.
-func sortedSet(set map[string]struct{}) []string {
-	var out []string
-	for element := range set {
-		out = append(out, element)
+// newResolver returns a resolver for -root, or -ssh, configured from the
+// command line flags.
+func newResolver() (*grab.Resolver, error) {
+	var r *grab.Resolver
+	var err error
+	if *sshDest != "" {
+		r, err = sshResolver(*sshDest)
+	} else {
+		r, err = grab.NewRootResolver(*root)
+	}
+	if err != nil {
+		return nil, err
 	}
-	sort.Strings(out)
-	return out
+	r.Ignore = nil
+	for _, pattern := range strings.Split(*ignore, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			r.Ignore = append(r.Ignore, pattern)
+		}
+	}
+	r.Logf = log.Printf
+	r.SpecialFiles = *specialFiles
+	if err := grab.CheckELFReader(*elfReader); err != nil {
+		return nil, err
+	}
+	r.ELFReader = *elfReader
+	r.Jobs = *jobs
+	if _, ok := dlcache.Arches[*arch]; !ok && *arch != "" {
+		return nil, fmt.Errorf("arch: unknown architecture %q", *arch)
+	}
+	r.Arch = *arch
+	if *renamesFile != "" {
+		r.Renames, err = grab.LoadRenames(*renamesFile)
+		if err != nil {
+			return nil, fmt.Errorf("renames: %v", err)
+		}
+	}
+	if *packageDeps != "" {
+		r.PackageNeeds, err = r.PackageDeps(*packageDeps)
+		if err != nil {
+			return nil, fmt.Errorf("package-deps: %v", err)
+		}
+	}
+	if *preload {
+		r.Preload, err = r.PreloadLibraries(os.Getenv("LD_PRELOAD"))
+		if err != nil {
+			return nil, fmt.Errorf("preload: %v", err)
+		}
+		if len(r.Preload) > 0 {
+			log.Printf("Preloading %s", strings.Join(r.Preload, ", "))
+		}
+	}
+	return r, nil
 }
 
-// recursiveImports returns the set of all imports for a given filename.
-func recursiveImports(
-	dc *dlcache.DLCache, filename string,
+// makeAudit records when and with which version of this tool g was grabbed,
+// with the given recipe and opts, and if audited, for -oci-audit, where and
+// from what.
+func makeAudit(g *grab.Graph, recipe *grab.Recipe, opts *grab.TarOptions, audited bool) (grab.Audit, error) {
+	audit := grab.Audit{Created: time.Now()}
+	if opts.Reproducible {
+		audit.Created = time.Unix(0, 0)
+		if !opts.ModTime.IsZero() {
+			audit.Created = opts.ModTime
+		}
+	}
+	audit.Version, audit.Revision = toolVersion()
+	if !audited {
+		return audit, nil
+	}
+	audit.Recipe = recipe.Digest()
+	host, err := os.Hostname()
+	if err != nil {
+		return audit, err
+	}
+	audit.Host = host
+	audit.Inputs, err = g.RootDigests()
+	return audit, err
+}
+
+// toolVersion returns the module version and VCS revision this binary was
+// built from, as far as the build recorded them.
+func toolVersion() (version, revision string) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown", ""
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			revision = setting.Value
+		}
+	}
+	return info.Main.Version, revision
+}
+
+// libraryPath returns the $LD_LIBRARY_PATH finding the libraries among
+// files: / where they are archived by base name, otherwise each directory
+// holding one, with -dirs.
+func libraryPath(files []grab.File) string {
+	if !*dirs {
+		return "/"
+	}
+	var dirs []string
+	seen := map[string]bool{}
+	for _, file := range files[1:] {
+		dir := "/" + path.Dir(file.Name)
+		if !file.Dir && strings.Contains(path.Base(file.Name), ".so") && !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return strings.Join(dirs, ":")
+}
+
+// writeOCI writes files as an OCI image to -oci-dir and/or -oci, and pushes
+// it to -push, running the first binary with the bundled libraries.
+func writeOCI(
+	files []grab.File, opts *grab.TarOptions, annotations map[string]string,
 ) (
-	map[string]struct{}, error,
+	int64, error,
 ) {
-	seen := map[string]struct{}{}
-	imports := map[string]struct{}{}
+	arch, err := grab.FileArch(files[0].Path)
+	if err != nil {
+		return 0, err
+	}
+	config := grab.OCIConfig{
+		Architecture: arch,
+		Entrypoint:   []string{"/" + files[0].Name},
+		Env:          []string{"LD_LIBRARY_PATH=" + libraryPath(files)},
+		Annotations:  annotations,
+	}
 
-	var depth int
+	dir := *ociDir
+	if dir == "" {
+		dir, err = os.MkdirTemp("", "grab-oci-")
+		if err != nil {
+			return 0, err
+		}
+		defer os.RemoveAll(dir)
+	}
+	total, err := grab.WriteOCI(dir, files, opts, config)
+	if err != nil {
+		return total, err
+	}
+	if *push != "" {
+		pushed := watchdog("upload", *uploadTimeout)
+		if err := grab.PushOCI(dir, *push); err != nil {
+			return total, err
+		}
+		pushed()
+		log.Printf("Pushed %s", *push)
+	}
+	if *ociArchive == "" {
+		return total, nil
+	}
 
-	var visit func(filename string) error
-	visit = func(filename string) error {
+	fd, err := createOutput(*ociArchive)
+	if err != nil {
+		return total, err
+	}
+	if err := grab.WriteOCIArchive(fd, dir); err != nil {
+		fd.Close()
+		return total, err
+	}
+	return total, fd.Close()
+}
 
-		// log.Println(strings.Repeat(" ", depth), "import", filename)
+// appendContainerd exports the -containerd image from the local containerd,
+// adds files to it as a new layer and imports the result as -containerd-tag.
+func appendContainerd(
+	files []grab.File, opts *grab.TarOptions, annotations map[string]string,
+) (
+	int64, error,
+) {
+	arch, err := grab.FileArch(files[0].Path)
+	if err != nil {
+		return 0, err
+	}
+	tmp, err := os.MkdirTemp("", "grab-containerd-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tmp)
 
-		if _, ok := seen[filename]; ok {
-			// Stop, since it has been seen before
-			return nil
-		}
-		seen[filename] = struct{}{}
+	exported := filepath.Join(tmp, "export.tar")
+	if err := grab.Ctr(*containerdNamespace, "images", "export",
+		"--platform", "linux/"+arch, exported, *containerd); err != nil {
+		return 0, err
+	}
+	layout := filepath.Join(tmp, "layout")
+	fd, err := os.Open(exported)
+	if err != nil {
+		return 0, err
+	}
+	err = grab.Extract(fd, layout, nil)
+	fd.Close()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", *containerd, err)
+	}
 
-		importedLibs, err := readImports(dc, filename)
-		if err != nil {
-			return err
-		}
+	total, err := grab.AppendOCILayer(layout, files, opts, arch, *containerdTag, annotations)
+	if err != nil {
+		return total, err
+	}
+	imported := filepath.Join(tmp, "import.tar")
+	out, err := os.Create(imported)
+	if err != nil {
+		return total, err
+	}
+	if err := grab.WriteOCIArchive(out, layout); err != nil {
+		out.Close()
+		return total, err
+	}
+	if err := out.Close(); err != nil {
+		return total, err
+	}
+	return total, grab.Ctr(*containerdNamespace, "images", "import", imported)
+}
 
-		for _, dep := range importedLibs {
+// writeSecurityReport writes the security analysis of g to filename as JSON,
+// and logs its recommendations.
+func writeSecurityReport(g *grab.Graph, filename string) error {
+	report, err := g.AnalyzeSecurity()
+	if err != nil {
+		return err
+	}
+	if len(report.NeededCapabilities) > 0 {
+		log.Printf("Security: needs %s", strings.Join(report.NeededCapabilities, ", "))
+	}
+	for _, note := range report.Notes {
+		log.Printf("Security: %s", note)
+	}
 
-			imports[dep] = struct{}{}
-			depth++
-			err := visit(dep)
-			depth--
-			if err != nil {
-				return err
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// verifyInputs checks the roots of g, which were resolved from inputs in
+// order, against the digests given to -input-sha256.
+func verifyInputs(g *grab.Graph, inputs []string, pinned []string) error {
+	want := map[string]string{}
+	for _, pin := range pinned {
+		input, digest, ok := strings.Cut(pin, "=")
+		if !ok {
+			if len(inputs) != 1 {
+				return fmt.Errorf("%s: name the input as INPUT=HEX when there are several", pin)
 			}
+			input, digest = inputs[0], pin
+		}
+		want[input] = strings.ToLower(strings.TrimPrefix(digest, "sha256:"))
+	}
+
+	digests, err := g.RootDigests()
+	if err != nil {
+		return err
+	}
+	for i, input := range inputs {
+		digest, ok := want[input]
+		if !ok {
+			continue
 		}
+		delete(want, input)
+		if got := digests[g.Roots[i]]; got != digest {
+			return fmt.Errorf("%s (%s) has sha256 %s, want %s", input, g.Roots[i], got, digest)
+		}
+		log.Printf("Verified %s", input)
+	}
+	for input := range want {
+		return fmt.Errorf("%s is not an input", input)
+	}
+	return nil
+}
+
+// checkStrict returns an error listing the libraries of g which could not
+// be found, and what needs each, or else the soname conflicts r found, if
+// -strict is set.
+func checkStrict(g *grab.Graph, r *grab.Resolver) error {
+	if !*strict {
 		return nil
 	}
+	if missing := g.Missing(); len(missing) > 0 {
+		var libs []string
+		for _, lib := range missing {
+			libs = append(libs, fmt.Sprintf("%s (needed by %s)",
+				lib, strings.Join(g.NeededBy(lib), ", ")))
+		}
+		return fmt.Errorf("strict: %d libraries not found: %s", len(missing), strings.Join(libs, ", "))
+	}
+	var conflicts []string
+	for _, d := range r.Diagnostics {
+		if d.ID == grab.DiagSonameConflict {
+			conflicts = append(conflicts, d.Subject)
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("strict: %d sonames resolve to different files: %s", len(conflicts), strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+// writeReport writes the JSON report of g, with the packages owning its
+// libraries if db is non-nil, and the diagnostics of r, to w.
+func writeReport(w io.Writer, g *grab.Graph, r *grab.Resolver, db grab.PackageDB) error {
+	report, err := g.Report()
+	if err != nil {
+		return err
+	}
+	if db != nil {
+		report.AddPackages(db)
+	}
+	report.Diagnostics = append(r.Diagnostics, report.Diagnostics...)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// writeSBOM writes the SBOM of files, resolved from inputs, to -sbom.
+// Packages are looked up in db.
+func writeSBOM(
+	inputs []string, files []grab.File, r *grab.Resolver, db grab.PackageDB,
+) error {
+	sbomFiles, err := r.SBOMFiles(files, db)
+	if err != nil {
+		return err
+	}
 
-	return imports, visit(filename)
+	fd, err := os.Create(*sbomOut)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(inputs[0])
+	switch *sbomFormat {
+	case "spdx":
+		err = grab.WriteSPDX(fd, name, sbomFiles, time.Now())
+	case "cyclonedx":
+		err = grab.WriteCycloneDX(fd, name, sbomFiles, r.OSRelease()["ID"], time.Now())
+	default:
+		err = fmt.Errorf("unknown -sbom-format %q, want spdx or cyclonedx", *sbomFormat)
+	}
+	if err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
 }
 
-// readImports returns the imports of one ELF file, with a lookup into the
-// ld.so.cache if needed.
-func readImports(dc *dlcache.DLCache, filename string) ([]string, error) {
+// writeSources writes the source packages of the files archived for g, as
+// found in db, to filename.
+func writeSources(filename string, g *grab.Graph, db grab.PackageDB) error {
+	fd, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(fd)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(grab.SourcePackages(g, db)); err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
 
-	fd, err := os.Open(filename)
-	if os.IsNotExist(err) {
-		// Lookup the path from the dl cache.
-		filename, ok := dc.Lookup(filename)
-		if ok {
-			fd, err = os.Open(filename)
+// compressionFor returns the -compress flag, or if it is unset, the
+// compression implied by the name of the output file (if any).
+func compressionFor(filename string) string {
+	if *compression != "" {
+		return *compression
+	}
+	return grab.CompressionFor(filename)
+}
+
+// readInputList reads filenames, one per line, from filename or from stdin if
+// it is "-". Blank lines are skipped.
+func readInputList(filename string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if filename != "-" {
+		fd, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer fd.Close()
+		r = fd
+	}
+
+	var filenames []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			filenames = append(filenames, line)
 		}
 	}
+	return filenames, scanner.Err()
+}
+
+// appendTar writes to out the tar at name followed by those of files it does
+// not hold. A missing tar is taken to be empty.
+func appendTar(out io.Writer, name string, files []grab.File, opts *grab.TarOptions) (int64, error) {
+	fd, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return grab.WriteFiles(out, files, opts)
+	}
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 	defer fd.Close()
 
-	elf, err := elf.NewFile(fd)
+	existing, err := grab.Decompress(fd)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	defer elf.Close()
+	defer existing.Close()
+	return grab.AppendFiles(out, existing, files, opts)
+}
 
-	importedLibs, err := elf.ImportedLibraries()
+// estimateSize returns the estimated tar size of files.
+func estimateSize(files []grab.File) int64 {
+	size, err := grab.EstimateTarSize(files)
 	if err != nil {
-		return nil, err
+		fatalf("preflight: %v", err)
 	}
-	return importedLibs, nil
+	return size
 }
 
-// resolveBinary looks up "filename" in the $PATH and in the ld.so.cache.
-func resolveBinary(dc *dlcache.DLCache, filename string) (string, error) {
-	var (
-		err error
-		fn  string
-	)
+// checkSplitPattern returns an error unless pattern, of -split-arch, holds
+// the architecture placeholder %s once and no other verb, so that each
+// architecture is written to a file of its own.
+func checkSplitPattern(pattern string) error {
+	unescaped := strings.ReplaceAll(pattern, "%%", "")
+	if strings.Count(unescaped, "%") != 1 || !strings.Contains(unescaped, "%s") {
+		return fmt.Errorf("%q needs %%s, once, for the architecture, such as bundle-%%s.tar", pattern)
+	}
+	return nil
+}
 
-	if _, err = os.Stat(filename); os.IsNotExist(err) {
-		// Try looking for executables in $PATH.
-		if fn, err = exec.LookPath(filename); err == nil {
-			filename = fn
-		} else if err != nil {
-			// Try looking in the ld.so.cache.
-			if fn, ok := dc.Lookup(filename); ok {
-				log.Printf("Resolved %q to %q", filename, fn)
-				filename = fn
-			} else {
-				err = fmt.Errorf("Unable to locate %q", filename)
-			}
+// writeSplitTars writes one tar per architecture group, named by substituting
+// the architecture into pattern, and returns the total bytes read from disk.
+func writeSplitTars(
+	pattern string, groups map[string][]grab.File, opts *grab.TarOptions,
+) (
+	int64, error,
+) {
+	var arches []string
+	for arch := range groups {
+		arches = append(arches, arch)
+	}
+	sort.Strings(arches)
+
+	var total int64
+	for _, arch := range arches {
+		filename := fmt.Sprintf(pattern, arch)
+		fd, err := createOutput(filename)
+		if err != nil {
+			return total, err
+		}
+		out, err := grab.Compress(fd, compressionFor(filename))
+		if err != nil {
+			fd.Close()
+			return total, err
+		}
+		log.Printf("Writing %d files for %s to %s", len(groups[arch]), arch, filename)
+		n, err := grab.WriteFiles(out, groups[arch], opts)
+		total += n
+		if err != nil {
+			out.Close()
+			return total, err
+		}
+		if err := out.Close(); err != nil {
+			return total, err
 		}
 	}
-	return filename, err
+	return total, nil
 }
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestCheckSplitPattern(t *testing.T) {
+	for pattern, ok := range map[string]bool{
+		"bundle-%s.tar":    true,
+		"%s/bundle.tar.gz": true,
+		"100%%-%s.tar":     true,
+		"bundle.tar":       false,
+		"bundle-%%s.tar":   false,
+		"bundle-%s-%s.tar": false,
+		"bundle-%d.tar":    false,
+		"bundle-%s-%d.tar": false,
+		"bundle-%v.tar":    false,
+	} {
+		if err := checkSplitPattern(pattern); (err == nil) != ok {
+			t.Errorf("checkSplitPattern(%q) = %v; want ok %t", pattern, err, ok)
+		}
+	}
+}
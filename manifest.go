@@ -0,0 +1,27 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/pwaller/grab-ld-binaries/grab"
+)
+
+// manifest implements `grab-ld-binaries manifest <rootfs>`, writing the base
+// manifest for -base to stdout. rootfs is a directory, a tarball of one, such
+// as the output of `docker export`, or an image to export, as -base takes.
+func manifest(args []string) {
+	if len(args) != 1 {
+		log.Fatal("usage: grab-binaries manifest <rootfs-dir|rootfs.tar|image>")
+	}
+
+	base, err := grab.OpenBase(args[0])
+	if err != nil {
+		log.Fatalf("manifest: %v", err)
+	}
+
+	log.Printf("%d paths, %d sonames", len(base.Paths), len(base.Sonames))
+	if _, err := base.WriteTo(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
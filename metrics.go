@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the grab latency
+// histogram: Prometheus' default buckets.
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metrics counts what serve has done, for Prometheus to scrape from
+// /metrics. It is safe for concurrent use.
+type metrics struct {
+	mu       sync.Mutex
+	grabs    int64
+	failures int64 // Grabs which failed to resolve
+	streamed int64 // Bytes of archives written to clients
+	reloads  int64 // Times the ld.so.cache changed and was loaded again
+	// latency counts the grabs taking at most each of latencyBuckets,
+	// cumulatively as Prometheus has it, with their total in seconds.
+	latency    []int64
+	latencySum float64
+}
+
+func newMetrics() *metrics {
+	return &metrics{latency: make([]int64, len(latencyBuckets))}
+}
+
+// grabbed records a grab which took d and streamed n bytes, or failed to
+// resolve if failed.
+func (m *metrics) grabbed(d time.Duration, n int64, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.grabs++
+	if failed {
+		m.failures++
+	}
+	m.streamed += n
+	for i, bound := range latencyBuckets {
+		if d.Seconds() <= bound {
+			m.latency[i]++
+		}
+	}
+	m.latencySum += d.Seconds()
+}
+
+// reloaded records that the ld.so.cache was loaded again.
+func (m *metrics) reloaded() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reloads++
+}
+
+// writeTo writes the metrics to w in the Prometheus text format.
+func (m *metrics) writeTo(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var err error
+	printf := func(format string, args ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+	counter := func(name, help string, value int64) {
+		printf("# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+	}
+	counter("grab_ld_binaries_grabs_total", "Grabs requested.", m.grabs)
+	counter("grab_ld_binaries_resolution_failures_total", "Grabs which failed to resolve.", m.failures)
+	counter("grab_ld_binaries_streamed_bytes_total", "Bytes of archives streamed to clients.", m.streamed)
+	counter("grab_ld_binaries_cache_reloads_total", "Times the changed ld.so.cache was loaded again.", m.reloads)
+
+	const latency = "grab_ld_binaries_grab_duration_seconds"
+	printf("# HELP %s Time taken by grabs.\n# TYPE %s histogram\n", latency, latency)
+	for i, bound := range latencyBuckets {
+		printf("%s_bucket{le=\"%g\"} %d\n", latency, bound, m.latency[i])
+	}
+	printf("%s_bucket{le=\"+Inf\"} %d\n", latency, m.grabs)
+	printf("%s_sum %g\n%s_count %d\n", latency, m.latencySum, latency, m.grabs)
+	return err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/pwaller/grab-ld-binaries/grab"
+)
+
+// ociIndex implements `grab-ld-binaries oci-index [-oci-dir dir] [-oci
+// file] [-push ref] image...`, combining images grabbed for different
+// architectures, such as by runs with a -root sysroot for each, into one
+// multi-architecture image: an image index with the platform of each. The
+// images are OCI image layouts, as -oci-dir writes, or archives of them,
+// as -oci writes.
+func ociIndex(args []string) {
+	fs := flag.NewFlagSet("oci-index", flag.ExitOnError)
+	dir := fs.String("oci-dir", "", "write the image index as an image layout in this directory")
+	archive := fs.String("oci", "", "write the image index as an OCI image archive to this file")
+	ref := fs.String("push", "", "push the image index to this registry reference (needs the oras program)")
+	fs.Parse(args)
+
+	if fs.NArg() < 2 || *dir == "" && *archive == "" && *ref == "" {
+		log.Fatal("usage: grab-binaries oci-index [-oci-dir dir] [-oci file] [-push ref] <image>...")
+	}
+	if err := writeOCIIndex(fs.Args(), *dir, *archive, *ref); err != nil {
+		log.Fatalf("oci-index: %v", err)
+	}
+}
+
+// writeOCIIndex writes the image index of images to the layout dir and
+// archive, and pushes it to ref, each if set.
+func writeOCIIndex(images []string, dir, archive, ref string) error {
+	if dir == "" {
+		var err error
+		if dir, err = os.MkdirTemp("", "grab-oci-"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+	}
+	if err := grab.WriteOCIIndex(dir, images); err != nil {
+		return err
+	}
+	if ref != "" {
+		if err := grab.PushOCI(dir, ref); err != nil {
+			return err
+		}
+		log.Printf("Pushed %s", ref)
+	}
+	if archive == "" {
+		return nil
+	}
+	fd, err := os.Create(archive)
+	if err != nil {
+		return err
+	}
+	if err := grab.WriteOCIArchive(fd, dir); err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
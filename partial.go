@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pwaller/grab-ld-binaries/grab"
+)
+
+// partialSuffix marks output files which are still being written. They are
+// renamed into place once complete, so a file without it is never truncated.
+const partialSuffix = ".partial"
+
+// partialMarker is created in output directories while they are written.
+const partialMarker = ".grab-ld-binaries.partial"
+
+// partials are the outputs of this run which are not yet complete.
+var partials struct {
+	sync.Mutex
+	files   []string // Final names; the files are at name+partialSuffix
+	markers []string // Markers within output directories
+	uploads []*grab.Upload
+	// tempDirs are removed by cleanupPartial, since fatal skips the
+	// deferred calls which would otherwise remove them.
+	tempDirs []string
+}
+
+// createOutput creates name+partialSuffix, to be renamed to name by
+// commitOutputs or removed by cleanupPartial.
+func createOutput(name string) (*os.File, error) {
+	fd, err := os.Create(name + partialSuffix)
+	if err != nil {
+		return nil, err
+	}
+	partials.Lock()
+	partials.files = append(partials.files, name)
+	partials.Unlock()
+	return fd, nil
+}
+
+// makeTempDir is os.MkdirTemp, for a directory which is also removed by
+// cleanupPartial.
+func makeTempDir(pattern string) (string, error) {
+	dir, err := os.MkdirTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	partials.Lock()
+	partials.tempDirs = append(partials.tempDirs, dir)
+	partials.Unlock()
+	return dir, nil
+}
+
+// startUpload starts streaming an output to dest, a URL, to be completed
+// by closing it or aborted by cleanupPartial.
+func startUpload(dest string) (*grab.Upload, error) {
+	u, err := grab.StartUpload(dest)
+	if err != nil {
+		return nil, err
+	}
+	partials.Lock()
+	partials.uploads = append(partials.uploads, u)
+	partials.Unlock()
+	return u, nil
+}
+
+// markOutputDir marks dir as partially written until commitOutputs.
+// Directories are not removed on failure, since they may have held files
+// before this run.
+func markOutputDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	marker := filepath.Join(dir, partialMarker)
+	if err := os.WriteFile(marker, []byte("incomplete output of grab-ld-binaries\n"), 0644); err != nil {
+		return err
+	}
+	partials.Lock()
+	partials.markers = append(partials.markers, marker)
+	partials.Unlock()
+	return nil
+}
+
+// commitOutputs moves the complete outputs into place and removes the
+// markers from output directories.
+func commitOutputs() error {
+	partials.Lock()
+	defer partials.Unlock()
+	for _, name := range partials.files {
+		if err := os.Rename(name+partialSuffix, name); err != nil {
+			return err
+		}
+	}
+	for _, marker := range partials.markers {
+		if err := os.Remove(marker); err != nil {
+			return err
+		}
+	}
+	partials.files, partials.markers, partials.uploads = nil, nil, nil
+	return nil
+}
+
+// cleanupPartial removes incomplete output files, or with -keep-partial
+// leaves them under their partial names, aborts uploads and removes
+// temporary directories. Output directories keep their markers either way.
+func cleanupPartial() {
+	partials.Lock()
+	defer partials.Unlock()
+	for _, name := range partials.files {
+		if *keepPartial {
+			log.Printf("Keeping incomplete output %s", name+partialSuffix)
+			continue
+		}
+		os.Remove(name + partialSuffix)
+	}
+	for _, marker := range partials.markers {
+		log.Printf("%s is incomplete, see %s", filepath.Dir(marker), marker)
+	}
+	for _, u := range partials.uploads {
+		log.Printf("Aborting upload to %s", u.Dest)
+		u.Abort()
+	}
+	for _, dir := range partials.tempDirs {
+		os.RemoveAll(dir)
+	}
+}
+
+// fatalf is log.Fatalf, first cleaning up incomplete outputs.
+func fatalf(format string, args ...interface{}) {
+	log.Output(2, fmt.Sprintf(format, args...))
+	cleanupPartial()
+	os.Exit(1)
+}
+
+// fatal is log.Fatal, first cleaning up incomplete outputs.
+func fatal(args ...interface{}) {
+	log.Output(2, fmt.Sprint(args...))
+	cleanupPartial()
+	os.Exit(1)
+}
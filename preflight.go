@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// preflight checks that each destination directory in need is writable and
+// has room for the bytes destined for it, so that a grab fails before it
+// starts writing rather than part way through an archive.
+func preflight(need map[string]int64) error {
+	for dir, size := range need {
+		dir = existingAncestor(dir)
+
+		const wOK = 2
+		if err := syscall.Access(dir, wOK); err != nil {
+			return fmt.Errorf("%s is not writable: %v", dir, err)
+		}
+
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(dir, &st); err != nil {
+			return fmt.Errorf("statfs %s: %v", dir, err)
+		}
+		free := int64(st.Bavail) * int64(st.Bsize)
+		if size > free {
+			return fmt.Errorf("%s: need %.2f MiB but only %.2f MiB is free",
+				dir, mib(size), mib(free))
+		}
+		log.Printf("Preflight: %s needs %.2f MiB, %.2f MiB free",
+			dir, mib(size), mib(free))
+	}
+	return nil
+}
+
+// existingAncestor returns dir, or its nearest ancestor which exists if dir
+// has yet to be created.
+func existingAncestor(dir string) string {
+	for {
+		if _, err := os.Stat(dir); err == nil || dir == filepath.Dir(dir) {
+			return dir
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// addNeed adds the size to the directory containing filename.
+func addNeed(need map[string]int64, filename string, size int64) {
+	need[filepath.Dir(filename)] += size
+}
+
+func mib(n int64) float64 {
+	return float64(n) / 1024 / 1024
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/pwaller/grab-ld-binaries/grab"
+)
+
+// progressEvent is one line of JSON written to -progress-fd.
+type progressEvent struct {
+	Event      string `json:"event"` // start, end or file
+	Phase      string `json:"phase,omitempty"`
+	File       string `json:"file,omitempty"`
+	Bytes      int64  `json:"bytes,omitempty"`
+	FilesDone  int    `json:"files_done,omitempty"`
+	FilesTotal int    `json:"files_total,omitempty"`
+	BytesDone  int64  `json:"bytes_done,omitempty"`
+	BytesTotal int64  `json:"bytes_total,omitempty"`
+}
+
+var progress struct {
+	sync.Mutex
+	enc *json.Encoder
+}
+
+// openProgress starts writing progress events to the file descriptor fd.
+func openProgress(fd int) {
+	progress.enc = json.NewEncoder(os.NewFile(uintptr(fd), "progress"))
+}
+
+// emitProgress writes ev to the progress descriptor, if there is one.
+// Failures to write are ignored: progress is advisory.
+func emitProgress(ev progressEvent) {
+	progress.Lock()
+	defer progress.Unlock()
+	if progress.enc != nil {
+		progress.enc.Encode(ev)
+	}
+}
+
+// trackProgress sets opts.Progress to emit a file event for each of files as
+// it is written, with running totals.
+func trackProgress(opts *grab.TarOptions, files []grab.File) {
+	if progress.enc == nil {
+		return
+	}
+
+	var bytesTotal int64
+	for _, file := range files {
+		if fi, err := os.Stat(file.Path); err == nil && file.Link == "" {
+			bytesTotal += fi.Size()
+		}
+	}
+
+	var (
+		filesDone int
+		bytesDone int64
+	)
+	opts.Progress = func(file grab.File, n int64) {
+		filesDone++
+		bytesDone += n
+		emitProgress(progressEvent{
+			Event:      "file",
+			Phase:      "archiving",
+			File:       file.Name,
+			Bytes:      n,
+			FilesDone:  filesDone,
+			FilesTotal: len(files),
+			BytesDone:  bytesDone,
+			BytesTotal: bytesTotal,
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/pwaller/grab-ld-binaries/grab"
+)
+
+// runChildEnv carries the runSpec to the re-executed child of run, which is
+// inside the new namespaces.
+const runChildEnv = "_GRAB_LD_BINARIES_RUN"
+
+// runSpec describes the sandbox for the child of run.
+type runSpec struct {
+	Dir   string     // Mounted over with a tmpfs and made the root
+	Binds [][]string // Host path, sandbox path
+	Argv  []string
+}
+
+// run implements `grab-ld-binaries run [-bind src[:dst]]... <binary> [--]
+// [args...]`. It runs binary with nothing but its closure (and the bind
+// mounts) visible: the closure is unpacked into a tmpfs in a fresh user and
+// mount namespace, which becomes the root.
+func run(args []string) {
+	var binds stringsFlag
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	fs.Var(&binds, "bind", "bind mount host path src at dst (default src) in the\n"+
+		"sandbox (repeatable)")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		log.Fatal("usage: grab-binaries run [-bind src[:dst]]... <binary> [--] [args...]")
+	}
+	filename, argv := fs.Arg(0), fs.Args()[1:]
+	if len(argv) > 0 && argv[0] == "--" {
+		argv = argv[1:]
+	}
+
+	r, err := newResolver()
+	if err != nil {
+		log.Fatal(err)
+	}
+	g, err := r.Resolve(filename)
+	if err != nil {
+		log.Fatalf("resolve %q: %v", filename, err)
+	}
+	if missing := g.Missing(); len(missing) > 0 {
+		log.Fatalf("run: missing libraries: %s", strings.Join(missing, ", "))
+	}
+
+	dir, err := os.MkdirTemp("", "grab-run-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(dir)
+
+	spec := runSpec{
+		Dir:  dir,
+		Argv: append([]string{"/" + filepath.Base(filename)}, argv...),
+	}
+	for _, bind := range binds {
+		src, dst, ok := strings.Cut(bind, ":")
+		if !ok {
+			dst = src
+		}
+		spec.Binds = append(spec.Binds, []string{src, dst})
+	}
+	encoded, err := json.Marshal(spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cmd := exec.Command("/proc/self/exe")
+	cmd.Env = append(os.Environ(), runChildEnv+"="+string(encoded))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = []*os.File{pr}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1},
+		},
+	}
+	if err := cmd.Start(); err != nil {
+		log.Fatalf("run: %v", err)
+	}
+	pr.Close()
+
+	_, err = grab.WriteFiles(pw, g.Files(), nil)
+	pw.Close()
+	if err != nil {
+		log.Printf("run: %v", err)
+	}
+
+	err = cmd.Wait()
+	if exit, ok := err.(*exec.ExitError); ok {
+		os.Remove(dir)
+		os.Exit(exit.ExitCode())
+	}
+	if err != nil {
+		log.Fatalf("run: %v", err)
+	}
+}
+
+// runChild is the part of run inside the new namespaces. It unpacks the tar
+// on fd 3 into a tmpfs, makes that the root and executes the binary.
+func runChild(encoded string) {
+	var spec runSpec
+	if err := json.Unmarshal([]byte(encoded), &spec); err != nil {
+		log.Fatal(err)
+	}
+	os.Unsetenv(runChildEnv)
+
+	if err := setupSandbox(&spec, os.NewFile(3, "bundle")); err != nil {
+		log.Fatalf("run: %v", err)
+	}
+
+	env := append(os.Environ(), "LD_LIBRARY_PATH=/")
+	err := syscall.Exec(spec.Argv[0], spec.Argv, env)
+	log.Fatalf("run: exec %s: %v", spec.Argv[0], err)
+}
+
+func setupSandbox(spec *runSpec, bundle io.ReadCloser) error {
+	// Keep our mounts from propagating back out of the namespace.
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("make mounts private: %v", err)
+	}
+	if err := syscall.Mount("tmpfs", spec.Dir, "tmpfs", 0, "mode=0755"); err != nil {
+		return fmt.Errorf("mount tmpfs: %v", err)
+	}
+
+	err := grab.Extract(bundle, spec.Dir, nil)
+	bundle.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, bind := range spec.Binds {
+		src, dst := bind[0], filepath.Join(spec.Dir, bind[1])
+		fi, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			err = os.MkdirAll(dst, 0755)
+		} else if err = os.MkdirAll(filepath.Dir(dst), 0755); err == nil {
+			err = os.WriteFile(dst, nil, 0644)
+		}
+		if err != nil {
+			return err
+		}
+		if err := syscall.Mount(src, dst, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("bind %s: %v", src, err)
+		}
+	}
+
+	if err := syscall.Chroot(spec.Dir); err != nil {
+		return fmt.Errorf("chroot: %v", err)
+	}
+	return os.Chdir("/")
+}
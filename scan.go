@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/pwaller/grab-ld-binaries/grab"
+)
+
+// scan resolves binaries again and again, recording each resolution in a
+// SQLite database as -db does and printing how each binary's files drifted
+// since the previous scan, so the database becomes an inventory of the
+// fleet's runtime dependencies over time. It needs the sqlite3 program.
+func scan(args []string) {
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	db := fs.String("db", "", "SQLite database to record the scans in, with the sqlite3 program (required)")
+	list := fs.String("list", "", "also scan the binaries listed in this file, one per line (- for stdin)")
+	interval := fs.Duration("interval", 0, "scan every interval until killed (default: scan once)")
+	fs.Parse(args)
+
+	binaries := fs.Args()
+	if *list != "" {
+		listed, err := readInputList(*list)
+		if err != nil {
+			log.Fatalf("list: %v", err)
+		}
+		binaries = append(binaries, listed...)
+	}
+	if *db == "" || len(binaries) == 0 {
+		log.Fatal("usage: grab-binaries scan -db <file> [-interval d] [-list file] <binary>...")
+	}
+	if err := grab.CheckSQLite(); err != nil {
+		log.Fatalf("scan: %v", err)
+	}
+
+	for {
+		// Each scan reads the -ssh host afresh, rather than what an
+		// earlier one cached and mirrored.
+		if remote != nil {
+			if err := remote.Forget(); err != nil {
+				log.Fatalf("scan: %v", err)
+			}
+		}
+		for _, binary := range binaries {
+			if err := scanOne(*db, binary); err != nil {
+				// One unreadable binary should not stop the fleet's scan.
+				log.Printf("scan %s: %v", binary, err)
+			}
+		}
+		if *interval <= 0 {
+			return
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// scanOne records binary's resolution in the database db, and prints its
+// drift since it was last scanned.
+func scanOne(db, binary string) error {
+	r, err := newResolver()
+	if err != nil {
+		return err
+	}
+	r.Logf = func(string, ...interface{}) {}
+	g, err := r.Resolve(binary)
+	if err != nil {
+		return err
+	}
+	files := g.Files()
+	packages, err := r.Packages("auto")
+	if err != nil {
+		packages = nil
+	}
+	if err := r.WriteSQLite(db, binary, g, files, packages, time.Now()); err != nil {
+		return err
+	}
+
+	drift, err := grab.ReadDrift(db, binary)
+	if err != nil {
+		return err
+	}
+	for _, d := range drift {
+		fmt.Printf("%s: %s\n", binary, d)
+	}
+	return nil
+}
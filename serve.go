@@ -0,0 +1,242 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pwaller/grab-ld-binaries/grab"
+)
+
+// serve implements `grab-ld-binaries serve [-listen addr] [-tokens file]
+// [-audit-log file]`, answering GET /grab?binary=<binary>[&binary=...] with
+// the archive of the binaries' closure within -root, as the command line
+// would write it, and exposing Prometheus metrics on /metrics. The
+// ld.so.cache is loaded again whenever it changes. As this exposes the
+// root's files, callers must present a token from -tokens, which lists the
+// binaries each may grab, unless serve only listens on the loopback
+// interface.
+func serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", "localhost:8080", "address to listen on")
+	tokens := fs.String("tokens", "", "file of the callers allowed to grab, one per line as\n"+
+		"\"name token path...\", where each path is a glob of binaries the caller\n"+
+		"may grab, or a directory ending in / to allow what is beneath it")
+	auditFile := fs.String("audit-log", "", "append a JSON line recording each grab, and who asked for it, to this file")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		log.Fatal("usage: grab-binaries serve [-listen addr] [-tokens file] [-audit-log file]")
+	}
+
+	s, err := newServer(newResolver)
+	if err != nil {
+		log.Fatalf("serve: %v", err)
+	}
+	if *tokens != "" {
+		if s.callers, err = loadCallers(*tokens); err != nil {
+			log.Fatalf("serve: %v", err)
+		}
+	} else if !isLoopback(*listen) {
+		log.Fatalf("serve: -tokens is needed to listen on %s, beyond this host", *listen)
+	}
+	if *auditFile != "" {
+		if s.audit, err = openAuditLog(*auditFile); err != nil {
+			log.Fatalf("serve: %v", err)
+		}
+	}
+	log.Printf("Serving on %s", *listen)
+	log.Fatalf("serve: %v", http.ListenAndServe(*listen, s.handler()))
+}
+
+// server grabs binaries for serve.
+type server struct {
+	newResolver func() (*grab.Resolver, error)
+	opts        *grab.TarOptions
+	metrics     *metrics
+	// callers, if non-nil, are who may grab what; see loadCallers.
+	callers []*caller
+	audit   *auditLog // Nil for none
+
+	mu        sync.Mutex
+	resolver  *grab.Resolver
+	cacheTime time.Time // Modification time of the resolver's ld.so.cache
+}
+
+// newServer returns a server resolving with the resolvers newResolver
+// returns, and which archives files as the command line flags ask.
+func newServer(newResolver func() (*grab.Resolver, error)) (*server, error) {
+	s := &server{
+		newResolver: newResolver,
+		opts:        &grab.TarOptions{NoPAX: !*paxNames, Reproducible: *reproducible, SELinux: *selinux},
+		metrics:     newMetrics(),
+	}
+	if _, err := s.currentResolver(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// handler returns the handler of the server's endpoints.
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/grab", s.serveGrab)
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := s.metrics.writeTo(w); err != nil {
+			log.Printf("metrics: %v", err)
+		}
+	})
+	return mux
+}
+
+// currentResolver returns a resolver for one grab, loading the ld.so.cache
+// again first if it has changed.
+func (s *server) currentResolver() (*grab.Resolver, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.resolver != nil {
+		fi, err := os.Stat(s.resolver.CacheFile)
+		if err == nil && !fi.ModTime().Equal(s.cacheTime) {
+			r, err := s.newResolver()
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("Reloaded %s", r.CacheFile)
+			s.metrics.reloaded()
+			s.resolver, s.cacheTime = r, fi.ModTime()
+		}
+	} else {
+		r, err := s.newResolver()
+		if err != nil {
+			return nil, err
+		}
+		s.resolver = r
+		if fi, err := os.Stat(r.CacheFile); err == nil {
+			s.cacheTime = fi.ModTime()
+		}
+	}
+
+	// Each grab has its own diagnostics.
+	r := *s.resolver
+	r.Diagnostics = nil
+	return &r, nil
+}
+
+func (s *server) serveGrab(w http.ResponseWriter, req *http.Request) {
+	binaries := req.URL.Query()["binary"]
+	rec := auditRecord{Time: time.Now(), Remote: req.RemoteAddr, Binaries: binaries}
+	defer func() {
+		if err := s.audit.record(rec); err != nil {
+			log.Printf("audit log: %v", err)
+		}
+	}()
+	fail := func(status int, err error) {
+		rec.Status, rec.Error = status, err.Error()
+		http.Error(w, err.Error(), status)
+	}
+
+	var c *caller
+	if s.callers != nil {
+		var ok bool
+		if c, ok = authenticate(s.callers, req); !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			fail(http.StatusUnauthorized, fmt.Errorf("a known token is needed"))
+			return
+		}
+		rec.Caller = c.Name
+	}
+	if req.Method != http.MethodGet {
+		fail(http.StatusMethodNotAllowed, fmt.Errorf("only GET is supported"))
+		return
+	}
+	if len(binaries) == 0 {
+		fail(http.StatusBadRequest, fmt.Errorf("no binary to grab"))
+		return
+	}
+	// Binaries are checked before they are resolved, so that nothing is
+	// read on behalf of a caller who may not have it.
+	for _, binary := range binaries {
+		if c != nil && !c.allows(binary) {
+			fail(http.StatusForbidden, forbiddenError{c.Name, binary})
+			return
+		}
+	}
+
+	start := time.Now()
+	files, err := s.resolve(binaries, c)
+	if _, ok := err.(forbiddenError); ok {
+		fail(http.StatusForbidden, err)
+		return
+	}
+	if err != nil {
+		s.metrics.grabbed(time.Since(start), 0, true)
+		log.Printf("grab %s: %v", strings.Join(binaries, " "), err)
+		fail(http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	cw := &countingWriter{w: w}
+	_, err = grab.WriteFiles(cw, files, s.opts)
+	s.metrics.grabbed(time.Since(start), cw.n, false)
+	rec.Status, rec.Bytes = http.StatusOK, cw.n
+	if err != nil {
+		// The status has been sent, so the client sees a truncated
+		// archive.
+		log.Printf("grab %s: %v", strings.Join(binaries, " "), err)
+		rec.Error = err.Error()
+	}
+}
+
+// forbiddenError is a binary which a caller may not grab.
+type forbiddenError struct {
+	caller, binary string
+}
+
+func (e forbiddenError) Error() string {
+	return fmt.Sprintf("%s may not grab %s", e.caller, e.binary)
+}
+
+// resolve returns the files to archive for binaries, on behalf of c if
+// non-nil.
+func (s *server) resolve(binaries []string, c *caller) ([]grab.File, error) {
+	r, err := s.currentResolver()
+	if err != nil {
+		return nil, err
+	}
+	g, err := r.ResolveAll(binaries)
+	if err != nil {
+		return nil, err
+	}
+	// An allowed path may be a symlink to one which is not.
+	for _, root := range g.Roots {
+		if real := r.RealPath(root); c != nil && !c.allows(real) {
+			return nil, forbiddenError{c.Name, real}
+		}
+	}
+	if missing := g.Missing(); len(missing) > 0 {
+		log.Printf("grab %s: missing libraries: %s",
+			strings.Join(binaries, " "), strings.Join(missing, ", "))
+	}
+	return g.Files(), nil
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
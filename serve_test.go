@@ -0,0 +1,245 @@
+package main
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pwaller/grab-ld-binaries/grab"
+	"github.com/pwaller/grab-ld-binaries/internal/cachetest"
+	"github.com/pwaller/grab-ld-binaries/internal/elftest"
+)
+
+// serveRoot builds a root holding /bin/app, which needs libfoo.so.1 from the
+// ld.so.cache.
+func serveRoot(t *testing.T) string {
+	t.Setenv("LD_LIBRARY_PATH", "")
+	root := t.TempDir()
+	for p, o := range map[string]elftest.Object{
+		"/bin/app":         {Needed: []string{"libfoo.so.1"}},
+		"/lib/libfoo.so.1": {Soname: "libfoo.so.1"},
+	} {
+		if err := o.Write(filepath.Join(root, p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cachetest.WriteRoot(root, "amd64", map[string]string{"libfoo.so.1": "/lib/libfoo.so.1"}); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestServe(t *testing.T) {
+	root := serveRoot(t)
+	s, err := newServer(func() (*grab.Resolver, error) {
+		r, err := grab.NewRootResolver(root)
+		if err != nil {
+			return nil, err
+		}
+		r.Logf = t.Logf
+		return r, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	get := func(path string) (*http.Response, []byte) {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, body
+	}
+
+	resp, body := get("/grab?binary=/bin/app")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("grab: %s: %s", resp.Status, body)
+	}
+	var names []string
+	tr := tar.NewReader(strings.NewReader(string(body)))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if want := []string{"app", "libfoo.so.1"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("archived %q; want %q", names, want)
+	}
+
+	if resp, _ := get("/grab?binary=/bin/nonexistent"); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("grab of a missing binary: %s", resp.Status)
+	}
+	if resp, _ := get("/grab"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("grab of nothing: %s", resp.Status)
+	}
+
+	// A changed ld.so.cache is loaded again.
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(root, "etc/ld.so.cache"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if resp, body := get("/grab?binary=/bin/app"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("grab after reload: %s: %s", resp.Status, body)
+	}
+
+	_, body = get("/metrics")
+	metrics := string(body)
+	for _, want := range []string{
+		"grab_ld_binaries_grabs_total 3\n",
+		"grab_ld_binaries_resolution_failures_total 1\n",
+		"grab_ld_binaries_cache_reloads_total 1\n",
+		`grab_ld_binaries_grab_duration_seconds_bucket{le="+Inf"} 3` + "\n",
+		"grab_ld_binaries_grab_duration_seconds_count 3\n",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics lack %q:\n%s", want, metrics)
+		}
+	}
+	if strings.Contains(metrics, "grab_ld_binaries_streamed_bytes_total 0\n") {
+		t.Errorf("no bytes streamed:\n%s", metrics)
+	}
+}
+
+func TestServeAccess(t *testing.T) {
+	root := serveRoot(t)
+	if err := os.Mkdir(filepath.Join(root, "opt"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/bin/app", filepath.Join(root, "opt/app")); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	tokens := filepath.Join(dir, "tokens")
+	if err := ioutil.WriteFile(tokens, []byte("# name token paths\n"+
+		"ci s3cret /bin/*\n"+
+		"ops t0ken /opt/\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	auditFile := filepath.Join(dir, "audit.log")
+
+	s, err := newServer(func() (*grab.Resolver, error) { return grab.NewRootResolver(root) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.callers, err = loadCallers(tokens); err != nil {
+		t.Fatal(err)
+	}
+	if s.audit, err = openAuditLog(auditFile); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	for _, test := range []struct {
+		token, binary string
+		want          int
+	}{
+		{"", "/bin/app", http.StatusUnauthorized},
+		{"wrong", "/bin/app", http.StatusUnauthorized},
+		{"s3cret", "/bin/app", http.StatusOK},
+		{"s3cret", "/lib/libfoo.so.1", http.StatusForbidden},
+		{"s3cret", "app", http.StatusForbidden},
+		// /opt/app is allowed, but it is a symlink to /bin/app, which is
+		// not.
+		{"t0ken", "/opt/app", http.StatusForbidden},
+	} {
+		req, err := http.NewRequest("GET", ts.URL+"/grab?binary="+test.binary, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != test.want {
+			t.Errorf("token %q grabbing %s: %s; want %d", test.token, test.binary, resp.Status, test.want)
+		}
+	}
+
+	data, err := ioutil.ReadFile(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("audit log has %d lines; want 6:\n%s", len(lines), data)
+	}
+	var rec auditRecord
+	if err := json.Unmarshal([]byte(lines[2]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Caller != "ci" || rec.Status != http.StatusOK || rec.Bytes == 0 ||
+		!reflect.DeepEqual(rec.Binaries, []string{"/bin/app"}) {
+		t.Errorf("audit record %+v", rec)
+	}
+}
+
+func TestLoadCallers(t *testing.T) {
+	for _, test := range []struct {
+		data string
+		err  string
+	}{
+		{"ci s3cret /bin/* /opt/\n\n# comment\n", ""},
+		{"ci s3cret\n", "want a name, a token and the paths"},
+		{"ci s3cret bin/*\n", "not an absolute path pattern"},
+		{"ci s3cret /bin/[\n", "not an absolute path pattern"},
+		{"ci s3cret /bin/*\nops s3cret /opt/\n", "shares its token"},
+	} {
+		filename := filepath.Join(t.TempDir(), "tokens")
+		if err := ioutil.WriteFile(filename, []byte(test.data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := loadCallers(filename)
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("loadCallers(%q) error %v; want %q", test.data, err, test.err)
+		}
+	}
+
+	c := &caller{Name: "ci", Allow: []string{"/bin/*", "/opt/app/"}}
+	for p, want := range map[string]bool{
+		"/bin/ls":                   true,
+		"/bin/sub/ls":               false,
+		"/opt/app/bin/tool":         true,
+		"/opt/app/../../etc/shadow": false,
+		"/opt/application":          false,
+	} {
+		if got := c.allows(p); got != want {
+			t.Errorf("allows(%s) = %t; want %t", p, got, want)
+		}
+	}
+
+	for addr, want := range map[string]bool{
+		"localhost:8080": true, "127.0.0.1:80": true, "[::1]:80": true,
+		":8080": false, "0.0.0.0:80": false, "example.com:80": false,
+	} {
+		if got := isLoopback(addr); got != want {
+			t.Errorf("isLoopback(%s) = %t; want %t", addr, got, want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/pwaller/grab-ld-binaries/grab"
+)
+
+// remote is the -ssh host's filesystem, once connected.
+var remote *grab.SSHFS
+
+// sshResolver returns a Resolver for the host dest, such as user@host,
+// whose files are mirrored into a temporary directory, its Root, as they
+// are read, so that the graph is archived from there.
+func sshResolver(dest string) (*grab.Resolver, error) {
+	if *root != "/" {
+		return nil, fmt.Errorf("-ssh cannot be used with -root")
+	}
+	if remote == nil {
+		mirror, err := os.MkdirTemp("", "grab-mirror-")
+		if err != nil {
+			return nil, err
+		}
+		if remote, err = grab.DialSSH(dest, mirror); err != nil {
+			os.RemoveAll(mirror)
+			return nil, fmt.Errorf("ssh: %v", err)
+		}
+		log.Printf("Resolving on %s", dest)
+	}
+	r, err := grab.NewFSResolver(remote)
+	if err != nil {
+		return nil, fmt.Errorf("ssh %s: %v", dest, err)
+	}
+	r.Root = remote.Mirror
+	r.CacheFile = remote.Mirror + "/etc/ld.so.cache"
+	return r, nil
+}
+
+// closeSSH disconnects from the -ssh host, if connected, and removes the
+// mirror of its files.
+func closeSSH() {
+	if remote == nil {
+		return
+	}
+	if err := remote.Close(); err != nil {
+		log.Printf("ssh: %v", err)
+	}
+	os.RemoveAll(remote.Mirror)
+}
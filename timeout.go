@@ -0,0 +1,31 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// timeoutExitCode is the exit status on a timeout, matching timeout(1).
+const timeoutExitCode = 124
+
+// watchdog exits the process if phase has not finished within d. Reads which
+// block on a hung network filesystem cannot be interrupted, so exiting is the
+// only way to bound them. The returned function marks the phase as finished.
+// A d of zero means no deadline. The start and end of the phase are also
+// reported as progress events.
+func watchdog(phase string, d time.Duration) (done func()) {
+	emitProgress(progressEvent{Event: "start", Phase: phase})
+	if d <= 0 {
+		return func() { emitProgress(progressEvent{Event: "end", Phase: phase}) }
+	}
+	t := time.AfterFunc(d, func() {
+		log.Printf("%s timed out after %v", phase, d)
+		cleanupPartial()
+		os.Exit(timeoutExitCode)
+	})
+	return func() {
+		t.Stop()
+		emitProgress(progressEvent{Event: "end", Phase: phase})
+	}
+}
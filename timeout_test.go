@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWatchdog runs a phase which stalls past its deadline in a subprocess,
+// which the watchdog must exit.
+func TestWatchdog(t *testing.T) {
+	if os.Getenv("GRAB_TEST_STALL") != "" {
+		// A phase finished in time does not fire.
+		watchdog("resolution", 10*time.Millisecond)()
+		watchdog("upload", 50*time.Millisecond)
+		time.Sleep(time.Minute)
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestWatchdog$")
+	cmd.Env = append(os.Environ(), "GRAB_TEST_STALL=1")
+	out, err := cmd.CombinedOutput()
+	exit, ok := err.(*exec.ExitError)
+	if !ok || exit.ExitCode() != timeoutExitCode {
+		t.Fatalf("stalled phase exited with %v; want status %d\n%s", err, timeoutExitCode, out)
+	}
+	if !strings.Contains(string(out), "upload timed out after 50ms") {
+		t.Errorf("output %q; want upload timed out after 50ms", out)
+	}
+	if strings.Contains(string(out), "resolution timed out") {
+		t.Errorf("finished phase timed out: %q", out)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/pwaller/grab-ld-binaries/grab"
+)
+
+// tracing is what the trace subcommand leaves for main to grab.
+type tracing struct {
+	*grab.Trace
+	seccomp string // File to write the seccomp profile to, if any
+}
+
+// trace implements `grab-ld-binaries [flags] trace [-seccomp file] <binary>
+// [--] [args...]`. It runs binary under strace, with its standard output
+// sent to standard error so as to leave standard output to the archive,
+// and then grabs it as the flags ask, with the libraries it loaded with
+// dlopen and the binaries it ran. With -seccomp, it also writes a seccomp
+// profile allowing only the system calls made, for the image to run with.
+func trace(args []string) *tracing {
+	fs := flag.NewFlagSet("trace", flag.ExitOnError)
+	seccomp := fs.String("seccomp", "", "write an OCI seccomp profile allowing only the system calls\n"+
+		"the binary made to this file")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		log.Fatal("usage: grab-binaries [flags] trace [-seccomp file] <binary> [--] [args...]")
+	}
+	filename, argv := fs.Arg(0), fs.Args()[1:]
+	if len(argv) > 0 && argv[0] == "--" {
+		argv = argv[1:]
+	}
+	if filepath.Clean(*root) != "/" || *sshDest != "" {
+		log.Fatal("trace: -root and -ssh cannot be used, as the binary runs here")
+	}
+	if *pid != 0 || *coreFile != "" {
+		log.Fatal("trace: -pid and -core cannot be used")
+	}
+	if err := grab.CheckStrace(); err != nil {
+		log.Fatalf("trace: %v", err)
+	}
+
+	t, err := grab.TraceCommand(append([]string{filename}, argv...), os.Stderr)
+	if err != nil {
+		log.Fatalf("trace: %v", err)
+	}
+	log.Printf("Traced %s: %d system calls, %d files used", t.Exe, len(t.Syscalls), len(t.Files))
+	return &tracing{Trace: t, seccomp: *seccomp}
+}
+
+// writeSeccomp writes the seccomp profile of t, for the architecture of its
+// binary, to its -seccomp file.
+func writeSeccomp(t *tracing) error {
+	arch, err := grab.FileArch(t.Exe)
+	if err != nil {
+		return err
+	}
+	profile, err := grab.NewSeccompProfile(arch, t.Syscalls)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(t.seccomp, append(data, '\n'), 0644)
+}
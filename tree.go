@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// tree implements `grab-ld-binaries tree [-a] [-why lib] <binary>...`,
+// printing the dependency tree like lddtree, or with -why only the chains
+// leading to lib.
+func tree(args []string) {
+	fs := flag.NewFlagSet("tree", flag.ExitOnError)
+	all := fs.Bool("a", false, "show the dependencies of libraries every time they appear")
+	whyLib := fs.String("why", "", "print every dependency chain leading to this library")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		log.Fatal("usage: grab-binaries tree [-a] [-why lib] <binary>...")
+	}
+
+	r, err := newResolver()
+	if err != nil {
+		log.Fatal(err)
+	}
+	g, err := r.ResolveAll(fs.Args())
+	if err != nil {
+		log.Fatalf("resolve: %v", err)
+	}
+
+	if *whyLib == "" {
+		if err := g.WriteTree(os.Stdout, *all); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	paths := g.Paths(*whyLib)
+	if len(paths) == 0 {
+		log.Printf("%s is not needed", *whyLib)
+		os.Exit(1)
+	}
+	for _, path := range paths {
+		fmt.Println(strings.Join(path, " -> "))
+	}
+}
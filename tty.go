@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+)
+
+// ttyPolicy is what to do with the tar when stdout is a terminal, as given by
+// -on-tty.
+type ttyPolicy struct {
+	Action string // "discard", "error" or "file"
+	Path   string // for "file"
+}
+
+func parseTTYPolicy(s string) (ttyPolicy, error) {
+	switch {
+	case s == "discard", s == "error":
+		return ttyPolicy{Action: s}, nil
+	case strings.HasPrefix(s, "file:") && len(s) > len("file:"):
+		return ttyPolicy{Action: "file", Path: strings.TrimPrefix(s, "file:")}, nil
+	}
+	return ttyPolicy{}, fmt.Errorf("-on-tty must be discard, error or file:PATH, not %q", s)
+}
+
+// openOutput returns where the tar should be written. When stdout is a
+// terminal (and -force-stdout is not given) the policy decides. discarded
+// reports whether the output is being thrown away, in which case the caller
+// should exit non-zero once done.
+func openOutput(policy ttyPolicy, forceStdout bool) (
+	out io.WriteCloser, discarded bool, err error,
+) {
+	if forceStdout || !isatty.IsTerminal(os.Stdout.Fd()) {
+		return os.Stdout, false, nil
+	}
+
+	switch policy.Action {
+	case "error":
+		return nil, false, fmt.Errorf(
+			"stdout is a terminal; redirect it, or use -force-stdout or -on-tty")
+	case "file":
+		log.Printf("Stdout is a terminal, writing tar to %s", policy.Path)
+		fd, err := createOutput(policy.Path)
+		return fd, false, err
+	}
+
+	fmt.Fprintln(os.Stderr)
+	log.Printf("Not writing tar file to terminal.")
+	log.Printf("Use `| cat` or -force-stdout if you really want it.")
+	fmt.Fprintln(os.Stderr)
+	return nopWriteCloser{ioutil.Discard}, true, nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// openPTY returns the terminal end of a new pseudo-terminal, skipping the
+// test if there is none to be had.
+func openPTY(t *testing.T) *os.File {
+	ptmx, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminals: %v", err)
+	}
+	t.Cleanup(func() { ptmx.Close() })
+	var n, unlock uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, ptmx.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		t.Skipf("unlock pseudo-terminal: %v", errno)
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, ptmx.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		t.Skipf("pseudo-terminal number: %v", errno)
+	}
+	tty, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminals: %v", err)
+	}
+	t.Cleanup(func() { tty.Close() })
+	return tty
+}
+
+// setStdout makes fd os.Stdout for the rest of the test.
+func setStdout(t *testing.T, fd *os.File) {
+	stdout := os.Stdout
+	os.Stdout = fd
+	t.Cleanup(func() { os.Stdout = stdout })
+}
+
+func TestOpenOutputTTY(t *testing.T) {
+	tty := openPTY(t)
+	setStdout(t, tty)
+
+	if _, _, err := openOutput(ttyPolicy{Action: "error"}, false); err == nil {
+		t.Error("error: wrote to a terminal")
+	}
+
+	out, discarded, err := openOutput(ttyPolicy{Action: "discard"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out == os.Stdout || !discarded {
+		t.Errorf("discard: wrote to the terminal")
+	}
+
+	name := filepath.Join(t.TempDir(), "bundle.tar")
+	out, discarded, err = openOutput(ttyPolicy{Action: "file", Path: name}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if fd, ok := out.(*os.File); !ok || fd.Name() != name+partialSuffix || discarded {
+		t.Errorf("file: wrote to %v, discarded %t; want %s", out, discarded, name+partialSuffix)
+	}
+	partials.Lock()
+	partials.files = nil
+	partials.Unlock()
+
+	for _, action := range []string{"discard", "error"} {
+		out, discarded, err := openOutput(ttyPolicy{Action: action}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != tty || discarded {
+			t.Errorf("%s with -force-stdout: did not write to the terminal", action)
+		}
+	}
+}
+
+func TestOpenOutputNotTTY(t *testing.T) {
+	fd, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	setStdout(t, fd)
+
+	for _, action := range []string{"discard", "error"} {
+		out, discarded, err := openOutput(ttyPolicy{Action: action}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != fd || discarded {
+			t.Errorf("%s: did not write to stdout", action)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// verify implements `grab-ld-binaries verify [-json] <binary>...`, checking
+// that every undefined dynamic symbol of the binaries and the libraries
+// they would be bundled with is defined by one of them, with the version it
+// needs, since a closure of DT_NEEDED alone can still fail to load with
+// "symbol lookup error". It exits 1 if any symbol is undefined.
+func verify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the undefined symbols as JSON diagnostics")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		log.Fatal("usage: grab-binaries verify [-json] <binary>...")
+	}
+
+	r, err := newResolver()
+	if err != nil {
+		log.Fatal(err)
+	}
+	g, err := r.ResolveAll(fs.Args())
+	if err != nil {
+		log.Fatalf("resolve: %v", err)
+	}
+	diags, err := g.VerifySymbols()
+	if err != nil {
+		log.Fatalf("verify: %v", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(diags); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		for _, d := range diags {
+			fmt.Println(d)
+		}
+	}
+	if len(diags) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/pwaller/grab-ld-binaries/grab"
+)
+
+// why implements `grab-ld-binaries why <library> <binary|report.json>`,
+// printing every chain by which binary, or the binaries of a -report, come
+// to need library.
+func why(args []string) {
+	if len(args) != 2 {
+		log.Fatal("usage: grab-binaries why <library> <binary|report.json>")
+	}
+	lib, filename := args[0], args[1]
+
+	g, err := grab.LoadReport(filename)
+	if err != nil {
+		log.Fatalf("report %q: %v", filename, err)
+	}
+	if g == nil {
+		r, err := newResolver()
+		if err != nil {
+			log.Fatal(err)
+		}
+		g, err = r.Resolve(filename)
+		if err != nil {
+			log.Fatalf("resolve %q: %v", filename, err)
+		}
+	}
+
+	paths := g.Paths(lib)
+	if len(paths) == 0 {
+		log.Printf("%s does not need %s", g.Roots[0], lib)
+		os.Exit(1)
+	}
+	for _, path := range paths {
+		fmt.Println(strings.Join(path, " -> "))
+	}
+}